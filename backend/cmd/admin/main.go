@@ -0,0 +1,306 @@
+package main
+
+import (
+	"backend/internal/bootstrap"
+	"backend/internal/config"
+	"backend/internal/pkg/jwtx"
+	"backend/internal/pkg/mailx"
+	"backend/internal/pkg/oidc"
+	"backend/internal/pkg/passwordhash"
+	"backend/internal/pkg/pwpolicy"
+	"backend/internal/pkg/reputation"
+	"backend/internal/pkg/secretbox"
+	"backend/internal/repos"
+	"backend/internal/services"
+	"backend/internal/settings"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `usage: admin [config flags] <command> [flags]
+
+commands:
+  user           -id N | -email E          show a user
+  logout         -id N | -email E          force logout everywhere (bump token_version)
+  revoke-device  -id N | -email E -device UUID
+  clear-throttle -email E                  clear login locks and OTP throttles
+  resend-code    -email E -scene S         clear the OTP throttle and send a new code
+  delete         -id N | -email E          soft delete, purged after ACCOUNT_DELETION_GRACE
+  restore        -id N | -email E          undo soft delete
+  lock           -id N | -email E          lock the account and log it out everywhere
+  unlock         -id N | -email E          undo lock
+  set-role       -id N | -email E -role R  user | support | admin
+  sessions       -id N | -email E          list sessions and devices
+
+every command accepts -o table|json (default table) and -reason TEXT;
+commands that change state require -reason, all are written to the audit trail`
+
+type cli struct {
+	svc   services.AdminService
+	actor services.Actor
+	out   string
+	id    uint64
+	email string
+}
+
+func main() {
+	// 1. Init Config
+	loader := config.NewLoader("admin")
+	cfg, err := loader.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal("❌ Invalid config: ", err)
+	}
+	args := loader.Args()
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	// 2. Init DB, Redis and services, same wiring as the server
+	db := bootstrap.NewDB(bootstrap.DBConfig{
+		DSN:             cfg.MySQL.DSN,
+		MaxOpenConn:     2,
+		MaxIdleConn:     1,
+		ConnMaxLifetime: cfg.MySQL.ConnectionMaxLifetime,
+		ConnMaxIdleTime: cfg.MySQL.ConnectionMaxIdleTime,
+	})
+	defer func() { _ = db.Close() }()
+	rdb := bootstrap.NewRedis(bootstrap.RedisConfig{
+		Addr:         cfg.Redis.Addr,
+		Password:     cfg.Redis.Password,
+		DB:           cfg.Redis.DB,
+		DialTimeout:  cfg.Redis.DialTimeout,
+		ReadTimeout:  cfg.Redis.ReadTimeout,
+		WriteTimeout: cfg.Redis.WriteTimeout,
+		PingTimeout:  cfg.Redis.PingTimeout,
+	})
+	defer func() { _ = rdb.Close() }()
+	keys, err := jwtx.LoadKeys(cfg.JWT)
+	if err != nil {
+		log.Fatal("❌ Load JWT keys: ", err)
+	}
+	keyring, err := jwtx.NewKeyring(keys)
+	if err != nil {
+		log.Fatal("❌ Load JWT keys: ", err)
+	}
+	mailer, err := mailx.New(cfg.Mail)
+	if err != nil {
+		log.Fatal("❌ Init mailer: ", err)
+	}
+	box, err := secretbox.FromBase64(cfg.MFA.EncryptionKey)
+	if err != nil {
+		log.Fatal("❌ Load MFA key: ", err)
+	}
+	policy, err := pwpolicy.New(cfg.PasswordPolicy)
+	if err != nil {
+		log.Fatal("❌ Load password policy: ", err)
+	}
+	static, err := reputation.LoadStatic(cfg.Reputation)
+	if err != nil {
+		log.Fatal("❌ Load reputation lists: ", err)
+	}
+	rt := settings.Static{S: settings.FromConfig(cfg)}
+	events := services.NewEventRecorder(repos.NewEventRepo(db), cfg.AuthEvents)
+	// Staff requests skip the managed entries, so the lists are never refreshed
+	rep := services.NewReputationService(repos.NewReputationRepo(db, rdb), static, cfg.Reputation, events)
+	// The CLI never verifies ID tokens, so no providers are loaded
+	authSvc := services.NewAuthService(repos.NewAuthRepo(db, rdb), repos.NewMFARepo(db, rdb), repos.NewPasskeyRepo(db, rdb),
+		repos.NewIdentityRepo(db, rdb), box, oidc.NewVerifier(nil, cfg.OIDC), passwordhash.New(cfg.PasswordHash), policy, rep, cfg, rt, jwtx.NewManager(cfg.JWT, keyring), events, mailer)
+	c := &cli{
+		svc:   services.NewAdminService(repos.NewAdminRepo(db, rdb), authSvc, events),
+		actor: services.Actor{Label: "cli:" + osUser()},
+	}
+
+	// 3. Run command, then flush the auth events it recorded
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	evCtx, stopEvents := context.WithCancel(context.Background())
+	flushed := make(chan struct{})
+	go func() {
+		events.Run(evCtx)
+		close(flushed)
+	}()
+	err = c.run(ctx, args[0], args[1:])
+	stopEvents()
+	<-flushed
+	if err != nil {
+		log.Fatalf("❌ %s: %v", args[0], err)
+	}
+}
+
+func (c *cli) run(ctx context.Context, cmd string, args []string) error {
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	fs.StringVar(&c.out, "o", "table", "output format: table | json")
+	fs.Uint64Var(&c.id, "id", 0, "user id")
+	fs.StringVar(&c.email, "email", "", "user email")
+	device := fs.String("device", "", "device id (uuid)")
+	scene := fs.String("scene", "signup", "otp scene")
+	role := fs.String("role", "", "role for set-role")
+	fs.StringVar(&c.actor.Reason, "reason", "", "why, recorded in the audit trail")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	reason := c.actor.Reason
+	if c.out != "table" && c.out != "json" {
+		return fmt.Errorf("-o must be table or json")
+	}
+
+	switch cmd {
+	case "user":
+		u, err := c.svc.LookupUser(ctx, c.actor, c.id, c.email)
+		if err != nil {
+			return err
+		}
+		return c.print(u, []string{"ID", "EMAIL", "USERNAME", "ROLE", "TOKEN_V", "DELETED", "LOCKED", "CREATED_AT"}, [][]string{{
+			fmt.Sprint(u.UserID), u.Email, u.Username, u.Role, fmt.Sprint(u.TokenVersion), fmt.Sprint(u.IsDeleted),
+			fmtTime(u.LockedAt), fmtTime(&u.CreatedAt),
+		}})
+
+	case "logout":
+		uid, err := c.userID(ctx)
+		if err != nil {
+			return err
+		}
+		tkv, err := c.svc.ForceLogout(ctx, c.actor, uid, reason)
+		if err != nil {
+			return err
+		}
+		return c.print(map[string]any{"user_id": uid, "token_version": tkv},
+			[]string{"ID", "TOKEN_V"}, [][]string{{fmt.Sprint(uid), fmt.Sprint(tkv)}})
+
+	case "revoke-device":
+		uid, err := c.userID(ctx)
+		if err != nil {
+			return err
+		}
+		if err := c.svc.RevokeDevice(ctx, c.actor, uid, *device, reason); err != nil {
+			return err
+		}
+		return c.print(map[string]any{"user_id": uid, "device_id": *device, "revoked": true},
+			[]string{"ID", "DEVICE", "REVOKED"}, [][]string{{fmt.Sprint(uid), *device, "true"}})
+
+	case "clear-throttle":
+		n, err := c.svc.ClearThrottles(ctx, c.actor, c.email, reason)
+		if err != nil {
+			return err
+		}
+		return c.print(map[string]any{"email": c.email, "keys_deleted": n},
+			[]string{"EMAIL", "KEYS_DELETED"}, [][]string{{c.email, fmt.Sprint(n)}})
+
+	case "resend-code":
+		codeID, err := c.svc.ResendCode(ctx, c.actor, c.email, *scene, reason)
+		if err != nil {
+			return err
+		}
+		return c.print(map[string]any{"email": c.email, "scene": *scene, "code_id": codeID},
+			[]string{"EMAIL", "SCENE", "CODE_ID"}, [][]string{{c.email, *scene, codeID}})
+
+	case "delete", "restore":
+		uid, err := c.userID(ctx)
+		if err != nil {
+			return err
+		}
+		if err := c.svc.SetDeleted(ctx, c.actor, uid, cmd == "delete", reason); err != nil {
+			return err
+		}
+		return c.print(map[string]any{"user_id": uid, "is_deleted": cmd == "delete"},
+			[]string{"ID", "DELETED"}, [][]string{{fmt.Sprint(uid), fmt.Sprint(cmd == "delete")}})
+
+	case "lock", "unlock":
+		uid, err := c.userID(ctx)
+		if err != nil {
+			return err
+		}
+		if err := c.svc.SetLocked(ctx, c.actor, uid, cmd == "lock", reason); err != nil {
+			return err
+		}
+		return c.print(map[string]any{"user_id": uid, "locked": cmd == "lock"},
+			[]string{"ID", "LOCKED"}, [][]string{{fmt.Sprint(uid), fmt.Sprint(cmd == "lock")}})
+
+	case "set-role":
+		uid, err := c.userID(ctx)
+		if err != nil {
+			return err
+		}
+		if err := c.svc.SetRole(ctx, c.actor, uid, *role, reason); err != nil {
+			return err
+		}
+		return c.print(map[string]any{"user_id": uid, "role": *role},
+			[]string{"ID", "ROLE"}, [][]string{{fmt.Sprint(uid), *role}})
+
+	case "sessions":
+		uid, err := c.userID(ctx)
+		if err != nil {
+			return err
+		}
+		sessions, err := c.svc.ListSessions(ctx, c.actor, uid)
+		if err != nil {
+			return err
+		}
+		rows := make([][]string, 0, len(sessions))
+		for _, s := range sessions {
+			rows = append(rows, []string{
+				fmt.Sprint(s.SessionID), s.DeviceID, fmt.Sprint(s.Active), fmt.Sprint(s.TokenVersion),
+				fmtTime(s.LastSeenAt), fmtTime(&s.ExpiresAt), fmtTime(s.RevokedAt),
+			})
+		}
+		return c.print(sessions, []string{"SESSION", "DEVICE", "ACTIVE", "TOKEN_V", "LAST_SEEN", "EXPIRES", "REVOKED"}, rows)
+
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	return nil
+}
+
+// userID Resolve -id or -email to a user id
+func (c *cli) userID(ctx context.Context) (uint64, error) {
+	if c.id != 0 {
+		return c.id, nil
+	}
+	u, err := c.svc.LookupUser(ctx, c.actor, 0, c.email)
+	if err != nil {
+		return 0, err
+	}
+	return u.UserID, nil
+}
+
+func (c *cli) print(v any, header []string, rows [][]string) error {
+	if c.out == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, r := range rows {
+		_, _ = fmt.Fprintln(w, strings.Join(r, "\t"))
+	}
+	return w.Flush()
+}
+
+// osUser Login name recorded as the CLI actor
+func osUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	if u := os.Getenv("USER"); u != "" {
+		return u
+	}
+	return "unknown"
+}
+
+func fmtTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
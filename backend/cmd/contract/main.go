@@ -0,0 +1,278 @@
+package main
+
+import (
+	"backend/docs"
+	"backend/internal/bootstrap"
+	"backend/internal/config"
+	"backend/internal/pkg/challenge"
+	"backend/internal/pkg/jwtx"
+	"backend/internal/pkg/mailx"
+	"backend/internal/pkg/oidc"
+	"backend/internal/pkg/openapi"
+	"backend/internal/pkg/passwordhash"
+	"backend/internal/pkg/pwpolicy"
+	"backend/internal/pkg/reputation"
+	"backend/internal/pkg/secretbox"
+	"backend/internal/repos"
+	"backend/internal/router"
+	"backend/internal/services"
+	"backend/internal/settings"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const usage = `usage: contract [config flags] [-- flags]
+
+Sends every operation of docs/api.yaml to the router, built as the server builds it with
+OPENAPI_MODE=strict, using the examples of the document, and fails when a response breaks
+the document. That routes and document list the same operations is checked by go test
+./internal/router, and handlers with stubbed services are checked against it by go test
+./internal/handlers. Needs the MySQL and Redis of the config; refuses APP_ENV=prod.
+
+flags:
+  -token ATK     access token for BearerAuth operations
+  -ott TOKEN     one-time token for OneTimeBearerAuth operations
+  -mutate        send -token with POST, PUT and DELETE too; use a throwaway account
+  -v             print every response body`
+
+type harness struct {
+	router  http.Handler
+	token   string
+	ott     string
+	mutate  bool
+	verbose bool
+}
+
+func main() {
+	// 1. Init Config, always strict
+	loader := config.NewLoader("contract")
+	cfg, err := loader.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal("❌ Invalid config: ", err)
+	}
+	if cfg.Server.Env == "prod" {
+		log.Fatal("❌ contract must not run with APP_ENV=prod")
+	}
+	cfg.OpenAPI.Mode = "strict"
+	h := &harness{}
+	fs := flag.NewFlagSet("contract", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, usage) }
+	fs.StringVar(&h.token, "token", "", "access token")
+	fs.StringVar(&h.ott, "ott", "", "one-time token")
+	fs.BoolVar(&h.mutate, "mutate", false, "authorize mutating operations")
+	fs.BoolVar(&h.verbose, "v", false, "print response bodies")
+	if err := fs.Parse(loader.Args()); err != nil {
+		os.Exit(2)
+	}
+	spec, err := openapi.Load(docs.OpenAPI)
+	if err != nil {
+		log.Fatal("❌ Load OpenAPI spec: ", err)
+	}
+
+	// 2. Init DB, Redis and the router, same wiring as the server
+	db := bootstrap.NewDB(bootstrap.DBConfig{
+		DSN:             cfg.MySQL.DSN,
+		MaxOpenConn:     4,
+		MaxIdleConn:     2,
+		ConnMaxLifetime: cfg.MySQL.ConnectionMaxLifetime,
+		ConnMaxIdleTime: cfg.MySQL.ConnectionMaxIdleTime,
+	})
+	defer func() { _ = db.Close() }()
+	rdb := bootstrap.NewRedis(bootstrap.RedisConfig{
+		Addr:         cfg.Redis.Addr,
+		Password:     cfg.Redis.Password,
+		DB:           cfg.Redis.DB,
+		DialTimeout:  cfg.Redis.DialTimeout,
+		ReadTimeout:  cfg.Redis.ReadTimeout,
+		WriteTimeout: cfg.Redis.WriteTimeout,
+		PingTimeout:  cfg.Redis.PingTimeout,
+	})
+	defer func() { _ = rdb.Close() }()
+	keys, err := jwtx.LoadKeys(cfg.JWT)
+	if err != nil {
+		log.Fatal("❌ Load JWT keys: ", err)
+	}
+	keyring, err := jwtx.NewKeyring(keys)
+	if err != nil {
+		log.Fatal("❌ Load JWT keys: ", err)
+	}
+	mailer, err := mailx.New(cfg.Mail)
+	if err != nil {
+		log.Fatal("❌ Init mailer: ", err)
+	}
+	box, err := secretbox.FromBase64(cfg.MFA.EncryptionKey)
+	if err != nil {
+		log.Fatal("❌ Load MFA key: ", err)
+	}
+	providers, err := oidc.LoadProviders(cfg.OIDC)
+	if err != nil {
+		log.Fatal("❌ Load OIDC providers: ", err)
+	}
+	policy, err := pwpolicy.New(cfg.PasswordPolicy)
+	if err != nil {
+		log.Fatal("❌ Load password policy: ", err)
+	}
+	static, err := reputation.LoadStatic(cfg.Reputation)
+	if err != nil {
+		log.Fatal("❌ Load reputation lists: ", err)
+	}
+	var captcha challenge.CAPTCHA
+	if cfg.Challenge.Mode == "captcha" {
+		if captcha, err = challenge.NewCAPTCHA(cfg.Challenge); err != nil {
+			log.Fatal("❌ Init CAPTCHA: ", err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	events := services.NewEventRecorder(repos.NewEventRepo(db), cfg.AuthEvents)
+	flushed := make(chan struct{})
+	go func() {
+		events.Run(ctx)
+		close(flushed)
+	}()
+	rep := services.NewReputationService(repos.NewReputationRepo(db, rdb), static, cfg.Reputation, events)
+	go rep.Run(ctx)
+	gin.SetMode(gin.TestMode)
+	r := router.SetupRouter(router.Deps{
+		Config:         cfg,
+		Settings:       settings.Static{S: settings.FromConfig(cfg)},
+		Keyring:        keyring,
+		DB:             db,
+		RDB:            rdb,
+		Events:         events,
+		Mailer:         mailer,
+		SecretBox:      box,
+		OIDC:           oidc.NewVerifier(providers, cfg.OIDC),
+		Hasher:         passwordhash.New(cfg.PasswordHash),
+		PasswordPolicy: policy,
+		Reputation:     rep,
+		CAPTCHA:        captcha,
+		AccessLogOut:   io.Discard,
+		OpenAPI:        spec,
+	})
+	h.router = r
+
+	// 3. Send every operation, as JSON and as problem details
+	failed := 0
+	for _, op := range spec.Operations() {
+		for _, accept := range []string{"application/json", "application/problem+json"} {
+			if !h.check(op, accept) {
+				failed++
+			}
+		}
+	}
+
+	// 4. Flush the auth events the requests recorded
+	cancel()
+	<-flushed
+	if failed > 0 {
+		fmt.Printf("%d failed\n", failed)
+		os.Exit(1)
+	}
+	fmt.Println("ok")
+}
+
+// check Send op built from its examples; false when it cannot be built or the router answers
+// with a CONTRACT_ error
+func (h *harness) check(op *openapi.Operation, accept string) bool {
+
+	// 1. Build the request
+	req, err := h.request(op)
+	if err != nil {
+		fmt.Printf("FAIL %s: %v\n", op, err)
+		return false
+	}
+	req.Header.Set("Accept", accept)
+
+	// 2. Send it
+	rec := httptest.NewRecorder()
+	h.router.ServeHTTP(rec, req)
+
+	// 3. Judge the answer
+	var body struct {
+		Code    string          `json:"code"`
+		Details json.RawMessage `json:"details"`
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &body)
+	if strings.HasPrefix(body.Code, "CONTRACT_") {
+		fmt.Printf("FAIL %s (%s) %d %s %s\n", op, accept, rec.Code, body.Code, body.Details)
+		return false
+	}
+	fmt.Printf("ok   %s (%s) %d %s\n", op, accept, rec.Code, body.Code)
+	if h.verbose {
+		fmt.Printf("     %s\n", bytes.TrimSpace(rec.Body.Bytes()))
+	}
+	return true
+}
+
+func (h *harness) request(op *openapi.Operation) (*http.Request, error) {
+
+	// 1. Path, query and headers from parameter examples
+	path := op.Template
+	query := make([]string, 0)
+	header := http.Header{}
+	for _, p := range op.Parameters {
+		if p.Example == nil {
+			if p.Required {
+				return nil, fmt.Errorf("required parameter %s.%s has no example", p.In, p.Name)
+			}
+			continue
+		}
+		v := fmt.Sprint(p.Example)
+		switch p.In {
+		case "path":
+			path = strings.ReplaceAll(path, "{"+p.Name+"}", v)
+		case "query":
+			query = append(query, p.Name+"="+v)
+		case "header":
+			header.Set(p.Name, v)
+		}
+	}
+	if len(query) > 0 {
+		sort.Strings(query)
+		path += "?" + strings.Join(query, "&")
+	}
+
+	// 2. Body from the JSON example
+	var body io.Reader
+	if rb := op.RequestBody; rb != nil {
+		mt := rb.Content["application/json"]
+		switch {
+		case mt != nil && mt.Example != nil:
+			b, err := json.Marshal(mt.Example)
+			if err != nil {
+				return nil, fmt.Errorf("request example: %w", err)
+			}
+			body = bytes.NewReader(b)
+			header.Set("Content-Type", "application/json")
+		case rb.Required:
+			return nil, fmt.Errorf("required body has no application/json example")
+		}
+	}
+	req := httptest.NewRequest(op.Method, path, body)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	// 3. Credentials the operation takes, when given
+	for _, scheme := range op.Schemes() {
+		switch {
+		case scheme == "OneTimeBearerAuth" && h.ott != "":
+			req.Header.Set("Authorization", "Bearer "+h.ott)
+		case scheme == "BearerAuth" && h.token != "" && (op.Method == http.MethodGet || h.mutate):
+			req.Header.Set("Authorization", "Bearer "+h.token)
+		}
+	}
+	return req, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"backend/internal/bootstrap"
+	"backend/internal/config"
+	"backend/internal/migrate"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `usage: migrate [config flags] <command>
+
+commands:
+  up                apply every pending migration
+  down [N]          roll back the last N migrations (default 1)
+  to VERSION        migrate up or down to VERSION (0 rolls back everything)
+  status            list migrations and their state
+  force VERSION     mark VERSION clean after fixing a failed migration by hand
+  create NAME       write empty NNNN_NAME.up.sql / .down.sql files
+
+create writes into $MIGRATIONS_DIR (default internal/migrate/migrations).`
+
+func main() {
+	// 1. Init Config
+	loader := config.NewLoader("migrate")
+	cfg, cfgErr := loader.Load(os.Args[1:])
+	args := loader.Args()
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	// 2. create needs no database
+	if args[0] == "create" {
+		if len(args) != 2 {
+			log.Fatal(usage)
+		}
+		dir := os.Getenv("MIGRATIONS_DIR")
+		if dir == "" {
+			dir = "internal/migrate/migrations"
+		}
+		up, down, err := migrate.Create(dir, args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("created %s\ncreated %s\n", up, down)
+		return
+	}
+	if cfgErr != nil {
+		log.Fatal("❌ Invalid config: ", cfgErr)
+	}
+
+	// 3. Init DB
+	db := bootstrap.NewDB(bootstrap.DBConfig{
+		DSN:             cfg.MySQL.DSN,
+		MaxOpenConn:     2,
+		MaxIdleConn:     1,
+		ConnMaxLifetime: cfg.MySQL.ConnectionMaxLifetime,
+		ConnMaxIdleTime: cfg.MySQL.ConnectionMaxIdleTime,
+	})
+	defer func() { _ = db.Close() }()
+	m, err := migrate.New(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 4. Run command
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		err = m.Up(ctx)
+	case "down":
+		n := 1
+		if len(args) > 1 {
+			if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+				log.Fatalf("down: N must be a positive integer, got %q", args[1])
+			}
+		}
+		err = m.Down(ctx, n)
+	case "to", "force":
+		if len(args) != 2 {
+			log.Fatal(usage)
+		}
+		v, perr := strconv.ParseUint(args[1], 10, 64)
+		if perr != nil {
+			log.Fatalf("%s: invalid version %q", args[0], args[1])
+		}
+		if args[0] == "to" {
+			err = m.To(ctx, v)
+		} else {
+			err = m.Force(ctx, v)
+		}
+	case "status":
+		err = printStatus(ctx, m)
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("❌ migrate %s: %v", args[0], err)
+	}
+	if args[0] != "status" {
+		_ = printStatus(ctx, m)
+	}
+}
+
+func printStatus(ctx context.Context, m *migrate.Migrator) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+	for _, s := range statuses {
+		at := "-"
+		if s.AppliedAt != nil {
+			at = s.AppliedAt.UTC().Format(time.RFC3339)
+		}
+		_, _ = fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", s.Version, s.Name, s.State, at)
+	}
+	return w.Flush()
+}
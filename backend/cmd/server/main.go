@@ -1,46 +1,176 @@
 package main
 
 import (
+	"backend/docs"
 	"backend/internal/bootstrap"
 	"backend/internal/config"
+	"backend/internal/migrate"
+	"backend/internal/pkg/challenge"
+	"backend/internal/pkg/jwtx"
+	"backend/internal/pkg/logx"
+	"backend/internal/pkg/mailx"
+	"backend/internal/pkg/oidc"
+	"backend/internal/pkg/openapi"
+	"backend/internal/pkg/passwordhash"
+	"backend/internal/pkg/pwpolicy"
+	"backend/internal/pkg/reputation"
+	"backend/internal/pkg/secretbox"
+	"backend/internal/repos"
 	"backend/internal/router"
+	"backend/internal/services"
+	"backend/internal/settings"
+	"context"
+	"database/sql"
+	"io"
 	"log"
+	"os"
+	"time"
 )
 
 func main() {
-	// 1. Init Config
-	config.Init()
+	// 1. Init Config: defaults < file < env < flags
+	loader := config.NewLoader("server")
+	cfg, err := loader.Load(os.Args[1:])
+	if loader.PrintConfig() && cfg != nil {
+		_ = cfg.WriteRedacted(os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err != nil {
+		log.Fatal("❌ Invalid config: ", err)
+	}
 
 	// 2. Init DB
 	db := bootstrap.NewDB(bootstrap.DBConfig{
-		DSN:             config.C.MySQL.DSN,
-		MaxOpenConn:     config.C.MySQL.MaxOpenConnections,
-		MaxIdleConn:     config.C.MySQL.MaxIdleConnections,
-		ConnMaxLifetime: config.C.MySQL.ConnectionMaxLifetime,
-		ConnMaxIdleTime: config.C.MySQL.ConnectionMaxIdleTime,
+		DSN:             cfg.MySQL.DSN,
+		MaxOpenConn:     cfg.MySQL.MaxOpenConnections,
+		MaxIdleConn:     cfg.MySQL.MaxIdleConnections,
+		ConnMaxLifetime: cfg.MySQL.ConnectionMaxLifetime,
+		ConnMaxIdleTime: cfg.MySQL.ConnectionMaxIdleTime,
 	})
 	defer func() { _ = db.Close() }()
+	if cfg.MySQL.AutoMigrate {
+		if err := autoMigrate(db); err != nil {
+			log.Fatal("❌ Auto migrate: ", err)
+		}
+	}
 	rdb := bootstrap.NewRedis(bootstrap.RedisConfig{
-		Addr:         config.C.Redis.Addr,
-		Password:     config.C.Redis.Password,
-		DB:           config.C.Redis.DB,
-		DialTimeout:  config.C.Redis.DialTimeout,
-		ReadTimeout:  config.C.Redis.ReadTimeout,
-		WriteTimeout: config.C.Redis.WriteTimeout,
-		PingTimeout:  config.C.Redis.PingTimeout,
+		Addr:         cfg.Redis.Addr,
+		Password:     cfg.Redis.Password,
+		DB:           cfg.Redis.DB,
+		DialTimeout:  cfg.Redis.DialTimeout,
+		ReadTimeout:  cfg.Redis.ReadTimeout,
+		WriteTimeout: cfg.Redis.WriteTimeout,
+		PingTimeout:  cfg.Redis.PingTimeout,
 	})
 	defer func() { _ = rdb.Close() }()
 
-	// 3. Setup Router: gin.SetMode(gin.ReleaseMode)
+	// 3. Runtime settings: reload on SIGHUP, file change or Redis hash change
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rt := settings.NewStore(loader, cfg, rdb)
+	if err := rt.Reload(ctx); err != nil {
+		log.Printf("runtime settings: %v", err)
+	}
+	go rt.Watch(ctx)
+
+	// 4. Load JWT keyring, staged keys are picked up by the watcher
+	keys, err := jwtx.LoadKeys(cfg.JWT)
+	if err != nil {
+		log.Fatal("❌ Load JWT keys: ", err)
+	}
+	keyring, err := jwtx.NewKeyring(keys)
+	if err != nil {
+		log.Fatal("❌ Load JWT keys: ", err)
+	}
+	go keyring.WatchKeyset(ctx, cfg.JWT)
+
+	// 5. Background jobs: auth event writer and purge of deleted accounts; mailer and MFA key
+	events := services.NewEventRecorder(repos.NewEventRepo(db), cfg.AuthEvents)
+	go events.Run(ctx)
+	go services.NewPurgeJob(repos.NewAccountRepo(db, rdb), cfg.Account).Run(ctx)
+
+	mailer, err := mailx.New(cfg.Mail)
+	if err != nil {
+		log.Fatal("❌ Init mailer: ", err)
+	}
+	box, err := secretbox.FromBase64(cfg.MFA.EncryptionKey)
+	if err != nil {
+		log.Fatal("❌ Load MFA key: ", err)
+	}
+	providers, err := oidc.LoadProviders(cfg.OIDC)
+	if err != nil {
+		log.Fatal("❌ Load OIDC providers: ", err)
+	}
+	policy, err := pwpolicy.New(cfg.PasswordPolicy)
+	if err != nil {
+		log.Fatal("❌ Load password policy: ", err)
+	}
+	static, err := reputation.LoadStatic(cfg.Reputation)
+	if err != nil {
+		log.Fatal("❌ Load reputation lists: ", err)
+	}
+	rep := services.NewReputationService(repos.NewReputationRepo(db, rdb), static, cfg.Reputation, events)
+	go rep.Run(ctx)
+	var captcha challenge.CAPTCHA
+	if cfg.Challenge.Mode == "captcha" {
+		if captcha, err = challenge.NewCAPTCHA(cfg.Challenge); err != nil {
+			log.Fatal("❌ Init CAPTCHA: ", err)
+		}
+	}
+	var spec *openapi.Spec
+	if cfg.OpenAPI.Mode != "off" {
+		if spec, err = openapi.Load(docs.OpenAPI); err != nil {
+			log.Fatal("❌ Load OpenAPI spec: ", err)
+		}
+	}
+
+	// 6. Open access log
+	var accessOut io.Writer
+	if cfg.AccessLog.File != "" {
+		rf, err := logx.NewRotatingFile(cfg.AccessLog.File, cfg.AccessLog.MaxSizeMB, cfg.AccessLog.MaxBackups)
+		if err != nil {
+			log.Fatal("open access log:", err)
+		}
+		defer func() { _ = rf.Close() }()
+		accessOut = rf
+	}
+
+	// 7. Setup Router: gin.SetMode(gin.ReleaseMode)
 	r := router.SetupRouter(router.Deps{
-		DB:  db,
-		RDB: rdb,
+		Config:         cfg,
+		Settings:       rt,
+		Keyring:        keyring,
+		DB:             db,
+		RDB:            rdb,
+		Events:         events,
+		Mailer:         mailer,
+		SecretBox:      box,
+		OIDC:           oidc.NewVerifier(providers, cfg.OIDC),
+		Hasher:         passwordhash.New(cfg.PasswordHash),
+		PasswordPolicy: policy,
+		Reputation:     rep,
+		CAPTCHA:        captcha,
+		AccessLogOut:   accessOut,
+		OpenAPI:        spec,
 	})
 
-	// 4. Start Server
-	addr := ":8080"
-	log.Printf("server running on %s", addr)
-	if err := r.Run(addr); err != nil {
+	// 8. Start Server
+	log.Printf("server running on %s", cfg.Server.Addr)
+	if err := r.Run(cfg.Server.Addr); err != nil {
 		log.Fatal("Error starting server", err)
 	}
 }
+
+// autoMigrate Apply pending migrations, only reachable in dev (see config validation)
+func autoMigrate(db *sql.DB) error {
+	m, err := migrate.New(db)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	return m.Up(ctx)
+}
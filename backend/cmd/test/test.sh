@@ -1,3 +1,5 @@
+# Run the server with OPENAPI_MODE=strict so a response that drifts from docs/api.yaml fails here
+
 # Request Code
 printf "\033[32m*** /auth/request-code ***\033[0m\n"
 curl -s -D /tmp/req_hdr.txt -o /tmp/req_body.json \
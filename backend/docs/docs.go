@@ -0,0 +1,9 @@
+// Package docs The API description, built into the binaries that check traffic against it
+package docs
+
+import _ "embed"
+
+// OpenAPI api.yaml as written
+//
+//go:embed api.yaml
+var OpenAPI []byte
@@ -1,132 +1,333 @@
 package config
 
 import (
-	"log"
-	"os"
-	"strconv"
 	"time"
-
-	"github.com/joho/godotenv"
 )
 
+// Struct tags used by the loader:
+//
+//	key:     dotted path in the config file, also the CLI flag name
+//	env:     environment variable
+//	default: value used when no layer sets the field
+//	secret:  redacted when the effective config is dumped; also read from the file named by <env>_FILE
+
+type Config struct {
+	Server         Server         `key:"server"`
+	Redis          Redis          `key:"redis"`
+	MySQL          MySQL          `key:"mysql"`
+	Timeouts       Timeouts       `key:"timeouts"`
+	RedisTTL       RedisTTL       `key:"redis_ttl"`
+	LoginThrottle  LoginThrottle  `key:"login_throttle"`
+	JWT            JWT            `key:"jwt"`
+	AccessLog      AccessLog      `key:"access_log"`
+	Log            Log            `key:"log"`
+	Runtime        Runtime        `key:"runtime"`
+	AuthEvents     AuthEvents     `key:"auth_events"`
+	Account        Account        `key:"account"`
+	Mail           Mail           `key:"mail"`
+	MFA            MFA            `key:"mfa"`
+	WebAuthn       WebAuthn       `key:"webauthn"`
+	OIDC           OIDC           `key:"oidc"`
+	PasswordHash   PasswordHash   `key:"password_hash"`
+	PasswordPolicy PasswordPolicy `key:"password_policy"`
+	Challenge      Challenge      `key:"challenge"`
+	RateLimit      RateLimit      `key:"rate_limit"`
+	Reputation     Reputation     `key:"reputation"`
+	Idempotency    Idempotency    `key:"idempotency"`
+	OpenAPI        OpenAPI        `key:"openapi"`
+}
+
+type Server struct {
+	Addr string `key:"addr" env:"SERVER_ADDR" default:":8080"`
+	// Env dev | staging | prod
+	Env string `key:"env" env:"APP_ENV" default:"dev"`
+	// PublicURL Base of links sent in emails
+	PublicURL string `key:"public_url" env:"PUBLIC_BASE_URL" default:"http://localhost:8080"`
+	// LinkBase Where emailed links open instead of the server's own pages, such as an app's
+	// universal link https://app.example.com or its scheme myapp://; the app reads token from
+	// the query of /revert-email or /magic-login and posts it to the API
+	LinkBase string `key:"link_base" env:"LINK_BASE_URL" default:""`
+	// TrustedProxies IPs or CIDRs of the proxies in front of the server; only their
+	// X-Forwarded-For is believed. Empty trusts none, the peer address is the client
+	TrustedProxies []string `key:"trusted_proxies" env:"TRUSTED_PROXIES" default:""`
+}
+
 type Timeouts struct {
-	Request       time.Duration
-	RequestCode   time.Duration
-	VerifyCode    time.Duration
-	CreateAccount time.Duration
-	Login         time.Duration
-	SetUsername   time.Duration
+	Request       time.Duration `key:"request" env:"REQUEST_TIMEOUT" default:"3s"`
+	RequestCode   time.Duration `key:"request_code" env:"REQUEST_CODE" default:"1s"`
+	VerifyCode    time.Duration `key:"verify_code" env:"VERIFY_CODE" default:"1s"`
+	CreateAccount time.Duration `key:"create_account" env:"CREATE_ACCOUNT" default:"1s"`
+	Login         time.Duration `key:"login" env:"LOGIN" default:"1s"`
+	SetUsername   time.Duration `key:"set_username" env:"SET_USERNAME" default:"1s"`
 }
 
 type RedisTTL struct {
-	OTP               int
-	OTPThrottle       int
-	VerifyWindow      int
-	VerifyWindowLimit int
+	OTP               int `key:"otp" env:"OTP_TTL" default:"180"`
+	OTPThrottle       int `key:"otp_throttle" env:"OTP_THROTTLE_TTL" default:"60"`
+	VerifyWindow      int `key:"verify_window" env:"VERIFY_THROTTLE_WINDOW" default:"60"`
+	VerifyWindowLimit int `key:"verify_window_limit" env:"VERIFY_THROTTLE_WINDOW_LIMIT" default:"4"`
 }
 
-type Config struct {
-	Redis    Redis
-	MySQL    MySQL
-	Timeouts Timeouts
-	RedisTTL RedisTTL
-	JWT      JWT
-}
-
-var C Config
-
-func Init() {
-	_ = godotenv.Load("dev.env")
-
-	C = Config{
-		Timeouts: Timeouts{
-			Request:       mustGetDur("REQUEST_TIMEOUT"),
-			RequestCode:   mustGetDur("REQUEST_CODE"),
-			VerifyCode:    mustGetDur("VERIFY_CODE"),
-			CreateAccount: mustGetDur("CREATE_ACCOUNT"),
-			SetUsername:   mustGetDur("SET_USERNAME"),
-			Login:         mustGetDur("LOGIN"),
-		},
-		RedisTTL: RedisTTL{
-			OTP:               mustGetInt("OTP_TTL"),
-			OTPThrottle:       mustGetInt("OTP_THROTTLE_TTL"),
-			VerifyWindow:      mustGetInt("VERIFY_THROTTLE_WINDOW"),
-			VerifyWindowLimit: mustGetInt("VERIFY_THROTTLE_WINDOW_LIMIT"),
-		},
-		JWT: JWT{
-			ISS: mustGet("JWT_ISS"),
-			OTT: mustGetDur("JWT_OTT"),
-			ATK: mustGetInt("JWT_ATK"),
-			RTK: mustGetDur("JWT_RTK"),
-			KEY: []byte(mustGet("JWT_KEY")),
-		},
-		Redis: Redis{
-			Addr:         mustGet("REDIS_ADDR"),
-			Password:     os.Getenv("REDIS_PASSWORD"),
-			DB:           mustGetInt("REDIS_DB"),
-			DialTimeout:  mustGetDur("REDIS_DIAL_TIMEOUT"),
-			ReadTimeout:  mustGetDur("REDIS_READ_TIMEOUT"),
-			WriteTimeout: mustGetDur("REDIS_WRITE_TIMEOUT"),
-			PingTimeout:  mustGetDur("REDIS_PING_TIMEOUT"),
-		},
-		MySQL: MySQL{
-			DSN:                   mustGet("MYSQL_DSN"),
-			MaxOpenConnections:    mustGetInt("MYSQL_MAX_OPEN_CONNECTION"),
-			MaxIdleConnections:    mustGetInt("MYSQL_MAX_IDLE_CONNECTION"),
-			ConnectionMaxLifetime: mustGetDur("MYSQL_CONNECTION_MAX_LIFE_TIME"),
-			ConnectionMaxIdleTime: mustGetDur("MYSQL_CONNECTION_MAX_IDLE_TIME"),
-		},
-	}
+// LoginThrottle Lockout after wrong passwords, on login and when changing the password
+type LoginThrottle struct {
+	// IPEmailAttempts Wrong passwords for an email from one IP within Window before that IP
+	// is locked out of it
+	IPEmailAttempts int `key:"ip_email_attempts" env:"LOGIN_IP_EMAIL_ATTEMPTS" default:"5"`
+	// EmailAttempts Wrong passwords for an email from any IP within Window before the email is
+	// locked out of password sign-in; passkeys and email codes still work
+	EmailAttempts int `key:"email_attempts" env:"LOGIN_EMAIL_ATTEMPTS" default:"20"`
+	// Window How long wrong passwords count, from the first one
+	Window time.Duration `key:"window" env:"LOGIN_WINDOW" default:"15m"`
+	// Lockout How long a lock lasts
+	Lockout time.Duration `key:"lockout" env:"LOGIN_LOCKOUT" default:"15m"`
 }
 
 type JWT struct {
-	ISS string
-	OTT time.Duration
-	ATK int
-	RTK time.Duration
-	KEY []byte
+	ISS string        `key:"iss" env:"JWT_ISS" default:"Common"`
+	OTT time.Duration `key:"ott" env:"JWT_OTT" default:"3m"`
+	ATK int           `key:"atk" env:"JWT_ATK" default:"900"`
+	RTK time.Duration `key:"rtk" env:"JWT_RTK" default:"4320h"`
+	// KEY Legacy shared HS256 secret, used for any token type without its own key
+	KEY    []byte `key:"key" env:"JWT_KEY" default:"" secret:"true"`
+	ATKKey []byte `key:"atk_key" env:"JWT_ATK_KEY" default:"" secret:"true"`
+	// ATKAlg HS256 | EdDSA | ES256, asymmetric algs publish their public key on the JWKS endpoint
+	ATKAlg string `key:"atk_alg" env:"JWT_ATK_ALG" default:"HS256"`
+	// ATKPrivateKey PEM (PKCS#8 or SEC 1) private key used when ATKAlg is asymmetric
+	ATKPrivateKey []byte `key:"atk_private_key" env:"JWT_ATK_PRIVATE_KEY" default:"" secret:"true"`
+	OTTKey        []byte `key:"ott_key" env:"JWT_OTT_KEY" default:"" secret:"true"`
+	// KeysetFile JSON keyset with kid, use and validity window per key; overrides the keys above
+	KeysetFile string `key:"keyset_file" env:"JWT_KEYSET_FILE" default:""`
+	// KeysetReload How often the keyset file is re-read to pick up rotated keys
+	KeysetReload time.Duration `key:"keyset_reload" env:"JWT_KEYSET_RELOAD" default:"1m"`
+}
+
+type AccessLog struct {
+	SkipPaths     []string      `key:"skip_paths" env:"ACCESS_LOG_SKIP_PATHS" default:"/api/ping"`
+	SlowThreshold time.Duration `key:"slow_threshold" env:"ACCESS_LOG_SLOW_THRESHOLD" default:"1s"`
+	File          string        `key:"file" env:"ACCESS_LOG_FILE" default:""`
+	MaxSizeMB     int           `key:"max_size_mb" env:"ACCESS_LOG_MAX_SIZE_MB" default:"100"`
+	MaxBackups    int           `key:"max_backups" env:"ACCESS_LOG_MAX_BACKUPS" default:"7"`
+}
+
+type Log struct {
+	// Level debug | info | warn | error, hot-reloadable
+	Level string `key:"level" env:"LOG_LEVEL" default:"info"`
+}
+
+// Runtime How hot-reloadable settings are refreshed
+type Runtime struct {
+	// ReloadInterval Poll period for config file changes and the Redis hash
+	ReloadInterval time.Duration `key:"reload_interval" env:"RUNTIME_RELOAD_INTERVAL" default:"10s"`
+	// RedisKey Hash shared by all instances overriding runtime settings, empty disables
+	RedisKey string `key:"redis_key" env:"RUNTIME_SETTINGS_REDIS_KEY" default:""`
+}
+
+// AuthEvents Background writer and retention of the auth_events table
+type AuthEvents struct {
+	// Buffer Events queued in memory before new ones are dropped
+	Buffer int `key:"buffer" env:"AUTH_EVENTS_BUFFER" default:"4096"`
+	// BatchSize Rows per INSERT
+	BatchSize     int           `key:"batch_size" env:"AUTH_EVENTS_BATCH_SIZE" default:"200"`
+	FlushInterval time.Duration `key:"flush_interval" env:"AUTH_EVENTS_FLUSH_INTERVAL" default:"1s"`
+	// Retention Events older than this are pruned
+	Retention     time.Duration `key:"retention" env:"AUTH_EVENTS_RETENTION" default:"2160h"`
+	PruneInterval time.Duration `key:"prune_interval" env:"AUTH_EVENTS_PRUNE_INTERVAL" default:"1h"`
+}
+
+// Account Self-service deletion
+type Account struct {
+	// DeletionGrace Deleted accounts can be restored for this long, then they are purged
+	DeletionGrace time.Duration `key:"deletion_grace" env:"ACCOUNT_DELETION_GRACE" default:"720h"`
+	PurgeInterval time.Duration `key:"purge_interval" env:"ACCOUNT_PURGE_INTERVAL" default:"1h"`
+	// EmailRevertTTL How long the old address can undo an email change
+	EmailRevertTTL time.Duration `key:"email_revert_ttl" env:"EMAIL_REVERT_TTL" default:"168h"`
+}
+
+// Mail Outgoing transactional email
+type Mail struct {
+	// Driver log | smtp
+	Driver       string `key:"driver" env:"MAIL_DRIVER" default:"log"`
+	From         string `key:"from" env:"MAIL_FROM" default:"Common <no-reply@localhost>"`
+	SMTPAddr     string `key:"smtp_addr" env:"MAIL_SMTP_ADDR" default:""`
+	SMTPUser     string `key:"smtp_user" env:"MAIL_SMTP_USER" default:""`
+	SMTPPassword string `key:"smtp_password" env:"MAIL_SMTP_PASSWORD" default:"" secret:"true"`
+}
+
+// MFA TOTP second factor
+type MFA struct {
+	// Issuer Account label prefix shown in authenticator apps
+	Issuer string `key:"issuer" env:"MFA_ISSUER" default:"Common"`
+	// EncryptionKey Base64 AES-256 key sealing TOTP secrets at rest
+	EncryptionKey string `key:"encryption_key" env:"MFA_ENCRYPTION_KEY" default:"" secret:"true"`
+	// ChallengeTTL How long a login that passed the password step may wait for the code
+	ChallengeTTL time.Duration `key:"challenge_ttl" env:"MFA_CHALLENGE_TTL" default:"5m"`
+	// ChallengeAttempts Wrong codes allowed per challenge before the login must start over
+	ChallengeAttempts int `key:"challenge_attempts" env:"MFA_CHALLENGE_ATTEMPTS" default:"5"`
+	// UserAttempts Wrong codes allowed per user across all challenges within LockoutWindow;
+	// after that every code is refused until the window ends
+	UserAttempts int `key:"user_attempts" env:"MFA_USER_ATTEMPTS" default:"10"`
+	// LockoutWindow How long wrong codes count against the user, from the first one
+	LockoutWindow time.Duration `key:"lockout_window" env:"MFA_LOCKOUT_WINDOW" default:"15m"`
+	// RecoveryCodes Codes issued on enrollment and regeneration
+	RecoveryCodes int `key:"recovery_codes" env:"MFA_RECOVERY_CODES" default:"10"`
+}
+
+// WebAuthn Passkeys
+type WebAuthn struct {
+	// RPID Domain passkeys are bound to: the site's host or a parent domain of it
+	RPID   string `key:"rp_id" env:"WEBAUTHN_RP_ID" default:"localhost"`
+	RPName string `key:"rp_name" env:"WEBAUTHN_RP_NAME" default:"Common"`
+	// Origins Web origins, and app origins such as android:apk-key-hash:..., allowed to run ceremonies
+	Origins      []string      `key:"origins" env:"WEBAUTHN_ORIGINS" default:"http://localhost:8080"`
+	ChallengeTTL time.Duration `key:"challenge_ttl" env:"WEBAUTHN_CHALLENGE_TTL" default:"5m"`
+	// UserVerification required | preferred; with preferred, a passkey login without
+	// verification still asks for TOTP when it is enabled
+	UserVerification string `key:"user_verification" env:"WEBAUTHN_USER_VERIFICATION" default:"required"`
+	// MaxCredentials Passkeys per user
+	MaxCredentials int `key:"max_credentials" env:"WEBAUTHN_MAX_CREDENTIALS" default:"10"`
 }
 
 type Redis struct {
-	Addr         string
-	Password     string
-	DB           int
-	DialTimeout  time.Duration
-	ReadTimeout  time.Duration
-	WriteTimeout time.Duration
-	PingTimeout  time.Duration
+	Addr         string        `key:"addr" env:"REDIS_ADDR" default:"127.0.0.1:6379"`
+	Password     string        `key:"password" env:"REDIS_PASSWORD" default:"" secret:"true"`
+	DB           int           `key:"db" env:"REDIS_DB" default:"0"`
+	DialTimeout  time.Duration `key:"dial_timeout" env:"REDIS_DIAL_TIMEOUT" default:"3s"`
+	ReadTimeout  time.Duration `key:"read_timeout" env:"REDIS_READ_TIMEOUT" default:"3s"`
+	WriteTimeout time.Duration `key:"write_timeout" env:"REDIS_WRITE_TIMEOUT" default:"3s"`
+	PingTimeout  time.Duration `key:"ping_timeout" env:"REDIS_PING_TIMEOUT" default:"2s"`
 }
 
 type MySQL struct {
-	DSN                   string
-	MaxOpenConnections    int
-	MaxIdleConnections    int
-	ConnectionMaxLifetime time.Duration
-	ConnectionMaxIdleTime time.Duration
-}
-
-func mustGet(key string) string {
-	val := os.Getenv(key)
-	if val == "" {
-		log.Fatalf("❌ Required environment variable %s is missing", key)
-	}
-	return val
-}
-
-func mustGetDur(key string) time.Duration {
-	val := mustGet(key)
-	d, err := time.ParseDuration(val)
-	if err != nil {
-		log.Fatalf("❌ Invalid duration for %s: %v", key, err)
-	}
-	return d
-}
-
-func mustGetInt(key string) int {
-	val := mustGet(key)
-	num, err := strconv.Atoi(val)
-	if err != nil {
-		log.Fatalf("❌ Invalid int for %s: %v", key, err)
-	}
-	return num
+	DSN                   string        `key:"dsn" env:"MYSQL_DSN" secret:"true"`
+	MaxOpenConnections    int           `key:"max_open_connections" env:"MYSQL_MAX_OPEN_CONNECTION" default:"30"`
+	MaxIdleConnections    int           `key:"max_idle_connections" env:"MYSQL_MAX_IDLE_CONNECTION" default:"10"`
+	ConnectionMaxLifetime time.Duration `key:"connection_max_lifetime" env:"MYSQL_CONNECTION_MAX_LIFE_TIME" default:"30m"`
+	ConnectionMaxIdleTime time.Duration `key:"connection_max_idle_time" env:"MYSQL_CONNECTION_MAX_IDLE_TIME" default:"10m"`
+	// AutoMigrate Apply pending migrations on server start, dev only
+	AutoMigrate bool `key:"auto_migrate" env:"MIGRATE_ON_START" default:"false"`
+}
+
+// IsDev Report whether the service runs in the development environment
+func (c *Config) IsDev() bool {
+	return c.Server.Env == "dev"
+}
+
+// OIDC Sign in with Apple and other OpenID Connect identity-token logins
+type OIDC struct {
+	// AppleClientIDs Bundle ids and Services ids Apple issues ID tokens to; empty disables Apple
+	AppleClientIDs []string `key:"apple_client_ids" env:"OIDC_APPLE_CLIENT_IDS" default:""`
+	// ProvidersFile JSON list of further providers, each with name, issuer, jwks_url and audiences
+	ProvidersFile string `key:"providers_file" env:"OIDC_PROVIDERS_FILE" default:""`
+	// JWKSRefresh How long fetched signing keys are trusted before they are fetched again;
+	// an unknown kid triggers an earlier fetch
+	JWKSRefresh time.Duration `key:"jwks_refresh" env:"OIDC_JWKS_REFRESH" default:"1h"`
+	JWKSTimeout time.Duration `key:"jwks_timeout" env:"OIDC_JWKS_TIMEOUT" default:"3s"`
+	// Leeway Clock skew allowed on exp, iat and nbf
+	Leeway time.Duration `key:"leeway" env:"OIDC_LEEWAY" default:"1m"`
+	// RequireNonce Reject ID tokens that carry no nonce
+	RequireNonce bool `key:"require_nonce" env:"OIDC_REQUIRE_NONCE" default:"true"`
+}
+
+// PasswordHash Argon2id cost of new hashes; a login with an older hash, bcrypt included,
+// stores a new one
+type PasswordHash struct {
+	MemoryKiB   int `key:"memory_kib" env:"PASSWORD_HASH_MEMORY_KIB" default:"19456"`
+	Iterations  int `key:"iterations" env:"PASSWORD_HASH_ITERATIONS" default:"2"`
+	Parallelism int `key:"parallelism" env:"PASSWORD_HASH_PARALLELISM" default:"1"`
+	// Workers Hashes computed at once, 0 means one per CPU; each holds MemoryKiB while it runs
+	Workers int `key:"workers" env:"PASSWORD_HASH_WORKERS" default:"0"`
+	// MaxQueue Requests allowed to wait for a worker, further ones fail right away
+	MaxQueue int `key:"max_queue" env:"PASSWORD_HASH_MAX_QUEUE" default:"64"`
+}
+
+// PasswordPolicy Rules a new password must pass at signup and on change; passwords already
+// stored keep working
+type PasswordPolicy struct {
+	MinLength int `key:"min_length" env:"PASSWORD_POLICY_MIN_LENGTH" default:"8"`
+	MaxLength int `key:"max_length" env:"PASSWORD_POLICY_MAX_LENGTH" default:"128"`
+	// MinClasses Character classes required, of lower, upper, digit and symbol
+	MinClasses int `key:"min_classes" env:"PASSWORD_POLICY_MIN_CLASSES" default:"0"`
+	// MinScore Estimated strength required, 0 (guessable in seconds) to 4 (very strong)
+	MinScore int `key:"min_score" env:"PASSWORD_POLICY_MIN_SCORE" default:"2"`
+	// RejectIdentity Refuse passwords containing the email local part or the username
+	RejectIdentity bool `key:"reject_identity" env:"PASSWORD_POLICY_REJECT_IDENTITY" default:"true"`
+	// CommonFile Extra common passwords, one per line, on top of the built-in list
+	CommonFile string `key:"common_file" env:"PASSWORD_POLICY_COMMON_FILE" default:""`
+	// BreachedDir Breached-password dataset split by SHA-1 prefix: one file per 5 hex digit
+	// prefix, named after it, holding "SUFFIX:COUNT" lines as served by the HIBP range API.
+	// Empty disables the check
+	BreachedDir string `key:"breached_dir" env:"PASSWORD_POLICY_BREACHED_DIR" default:""`
+	// BreachedMinCount Breaches a password must appear in to be refused
+	BreachedMinCount int `key:"breached_min_count" env:"PASSWORD_POLICY_BREACHED_MIN_COUNT" default:"1"`
+}
+
+// Challenge Proof of work or CAPTCHA asked of unauthenticated requests that send email
+type Challenge struct {
+	// Mode pow | captcha | off
+	Mode string `key:"mode" env:"CHALLENGE_MODE" default:"pow"`
+	// Key HMAC key signing proof-of-work challenges, shared by all instances
+	Key []byte        `key:"key" env:"CHALLENGE_KEY" default:"" secret:"true"`
+	TTL time.Duration `key:"ttl" env:"CHALLENGE_TTL" default:"2m"`
+	// Difficulty Leading zero bits of SHA-256 asked at rest; each further bit doubles the work
+	Difficulty    int `key:"difficulty" env:"CHALLENGE_DIFFICULTY" default:"16"`
+	MaxDifficulty int `key:"max_difficulty" env:"CHALLENGE_MAX_DIFFICULTY" default:"24"`
+	// Window Period over which challenges are counted, per IP and in total
+	Window time.Duration `key:"window" env:"CHALLENGE_WINDOW" default:"1m"`
+	// IPFree Challenges an IP gets per window before its difficulty rises; a wrong solution
+	// counts as IPFree challenges
+	IPFree int `key:"ip_free" env:"CHALLENGE_IP_FREE" default:"5"`
+	// LoadFree Challenges all clients get per window before every difficulty rises
+	LoadFree int `key:"load_free" env:"CHALLENGE_LOAD_FREE" default:"500"`
+	// CaptchaProvider siteverify | stub; siteverify speaks the API shared by hCaptcha,
+	// Turnstile and reCAPTCHA, the stub accepts CaptchaStubToken and is refused in prod
+	CaptchaProvider  string        `key:"captcha_provider" env:"CHALLENGE_CAPTCHA_PROVIDER" default:"siteverify"`
+	CaptchaVerifyURL string        `key:"captcha_verify_url" env:"CHALLENGE_CAPTCHA_VERIFY_URL" default:""`
+	CaptchaSiteKey   string        `key:"captcha_site_key" env:"CHALLENGE_CAPTCHA_SITE_KEY" default:""`
+	CaptchaSecret    string        `key:"captcha_secret" env:"CHALLENGE_CAPTCHA_SECRET" default:"" secret:"true"`
+	CaptchaStubToken string        `key:"captcha_stub_token" env:"CHALLENGE_CAPTCHA_STUB_TOKEN" default:""`
+	CaptchaTimeout   time.Duration `key:"captcha_timeout" env:"CHALLENGE_CAPTCHA_TIMEOUT" default:"3s"`
+}
+
+// RateLimit Per-route limits declared in the router
+type RateLimit struct {
+	Enabled bool `key:"enabled" env:"RATE_LIMIT_ENABLED" default:"true"`
+	// FailOpen Let requests through when Redis cannot be asked, instead of answering 503
+	FailOpen bool `key:"fail_open" env:"RATE_LIMIT_FAIL_OPEN" default:"true"`
+}
+
+// Reputation IP and email-domain allow and deny lists, and temporary bans of IPs that keep
+// hitting throttles; entries managed through the admin API are layered on top
+type Reputation struct {
+	// AllowCIDRs Trusted ranges, never denied or banned
+	AllowCIDRs    []string `key:"allow_cidrs" env:"REPUTATION_ALLOW_CIDRS" default:""`
+	DenyCIDRs     []string `key:"deny_cidrs" env:"REPUTATION_DENY_CIDRS" default:""`
+	DenyCIDRsFile string   `key:"deny_cidrs_file" env:"REPUTATION_DENY_CIDRS_FILE" default:""`
+	// DisposableDomainFiles Disposable email domains, one per line; refused for new addresses
+	DisposableDomainFiles []string `key:"disposable_domain_files" env:"REPUTATION_DISPOSABLE_DOMAIN_FILES" default:""`
+	// BanThreshold Throttles an IP may hit within BanWindow before it is banned, 0 disables bans
+	BanThreshold int           `key:"ban_threshold" env:"REPUTATION_BAN_THRESHOLD" default:"20"`
+	BanWindow    time.Duration `key:"ban_window" env:"REPUTATION_BAN_WINDOW" default:"1h"`
+	BanDuration  time.Duration `key:"ban_duration" env:"REPUTATION_BAN_DURATION" default:"24h"`
+	// Refresh How often managed entries are re-read; a change made on another instance takes
+	// up to this long to apply here
+	Refresh time.Duration `key:"refresh" env:"REPUTATION_REFRESH" default:"30s"`
+}
+
+// Idempotency Replay of mutating requests sent again with the same Idempotency-Key, on the
+// route groups the router opts in
+type Idempotency struct {
+	Enabled bool `key:"enabled" env:"IDEMPOTENCY_ENABLED" default:"true"`
+	// TTL How long a finished response is replayed
+	TTL time.Duration `key:"ttl" env:"IDEMPOTENCY_TTL" default:"24h"`
+	// LockTTL How long a request in flight holds its key; must outlast REQUEST_TIMEOUT
+	LockTTL time.Duration `key:"lock_ttl" env:"IDEMPOTENCY_LOCK_TTL" default:"1m"`
+	// MaxBodyBytes Largest request body fingerprinted, larger ones are refused
+	MaxBodyBytes int `key:"max_body_bytes" env:"IDEMPOTENCY_MAX_BODY_BYTES" default:"65536"`
+}
+
+// OpenAPI Checking of requests and responses against docs/api.yaml
+type OpenAPI struct {
+	// Mode off | log | strict. log reports mismatches and lets traffic through; strict answers
+	// them with 400 for a request and 500 for a response, for tests and cmd/contract
+	Mode string `key:"mode" env:"OPENAPI_MODE" default:"log"`
 }
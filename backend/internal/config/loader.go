@@ -0,0 +1,417 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+const defaultEnvFile = "dev.env"
+
+// Loader Build Config from layers: defaults < YAML/TOML file < env < CLI flags
+type Loader struct {
+	// LookupEnv Source of environment variables, defaults to os.LookupEnv
+	LookupEnv func(key string) (string, bool)
+
+	name        string
+	file        string
+	envFile     string
+	envFileSet  bool
+	printConfig bool
+	flags       map[string]string
+	args        []string
+}
+
+func NewLoader(name string) *Loader {
+	return &Loader{name: name, LookupEnv: os.LookupEnv}
+}
+
+// Load Parse args and build a validated Config
+func (l *Loader) Load(args []string) (*Config, error) {
+	if err := l.parseFlags(args); err != nil {
+		return nil, err
+	}
+	return l.build(nil)
+}
+
+// Reload Rebuild Config from the same file, env and flags given to Load
+func (l *Loader) Reload() (*Config, error) {
+	return l.build(nil)
+}
+
+// ReloadWith Reload and apply overrides keyed by env name on top of every layer
+func (l *Loader) ReloadWith(overrides map[string]string) (*Config, error) {
+	return l.build(overrides)
+}
+
+// PrintConfig Report whether --print-config was given
+func (l *Loader) PrintConfig() bool {
+	return l.printConfig
+}
+
+// File Path of the config file in use, empty if none
+func (l *Loader) File() string {
+	return l.file
+}
+
+// EnvFile Path of the dotenv file in use, empty if none
+func (l *Loader) EnvFile() string {
+	return l.envFile
+}
+
+// Args Positional arguments left after flag parsing
+func (l *Loader) Args() []string {
+	return l.args
+}
+
+func (l *Loader) parseFlags(args []string) error {
+
+	// 1. Loader flags
+	fs := flag.NewFlagSet(l.name, flag.ContinueOnError)
+	fs.StringVar(&l.file, "config", "", "path to a YAML or TOML config file (env CONFIG_FILE)")
+	fs.StringVar(&l.envFile, "env-file", defaultEnvFile, "dotenv file merged under the process environment, the default one is skipped when APP_ENV=prod")
+	fs.BoolVar(&l.printConfig, "print-config", false, "print the effective config with secrets redacted and exit")
+
+	// 2. One flag per config field
+	for _, f := range fieldsOf(&Config{}) {
+		usage := "env " + f.env
+		if f.def != "" {
+			usage += " (default " + f.def + ")"
+		}
+		fs.String(f.key, "", usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// 3. Keep only explicitly set flags
+	l.flags = map[string]string{}
+	fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "config", "print-config":
+		case "env-file":
+			l.envFileSet = true
+		default:
+			l.flags[fl.Name] = fl.Value.String()
+		}
+	})
+	if l.file == "" {
+		l.file, _ = l.LookupEnv("CONFIG_FILE")
+	}
+	l.args = fs.Args()
+	return nil
+}
+
+func (l *Loader) build(overrides map[string]string) (*Config, error) {
+	var problems []string
+
+	// 1. Read file and env layers
+	fileVals, err := readConfigFile(l.file)
+	if err != nil {
+		return nil, err
+	}
+	envVals, err := l.readEnvFile(l.appEnv(fileVals))
+	if err != nil {
+		return nil, err
+	}
+	lookupEnv := func(key string) (string, bool) {
+		if v, ok := l.LookupEnv(key); ok {
+			return v, true
+		}
+		v, ok := envVals[key]
+		return v, ok
+	}
+
+	// 2. Resolve each field, later layers win
+	cfg := &Config{}
+	known := map[string]struct{}{}
+	for _, f := range fieldsOf(cfg) {
+		known[f.key] = struct{}{}
+
+		raw, src, ok := f.def, "default", f.hasDef
+		if v, has := fileVals[f.key]; has {
+			raw, src, ok = v, "file", true
+		}
+		if v, has := lookupEnv(f.env); has && v != "" {
+			raw, src, ok = v, "env "+f.env, true
+		}
+		if path, has := lookupEnv(f.env + "_FILE"); has && path != "" && f.secret {
+			v, err := readSecretFile(path)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s from env %s_FILE: %v", f.key, f.env, err))
+				continue
+			}
+			raw, src, ok = v, "env "+f.env+"_FILE", true
+		}
+		if v, has := l.flags[f.key]; has {
+			raw, src, ok = v, "flag -"+f.key, true
+		}
+		if v, has := overrides[f.env]; has {
+			raw, src, ok = v, "override "+f.env, true
+		}
+
+		if !ok || (raw == "" && f.required()) {
+			problems = append(problems, fmt.Sprintf("%s (%s) is required", f.key, f.env))
+			continue
+		}
+		if err := setValue(f.v, raw); err != nil {
+			problems = append(problems, fmt.Sprintf("%s from %s: %v", f.key, src, err))
+		}
+	}
+
+	// 3. Reject unknown file keys, typos otherwise go unnoticed
+	for k := range fileVals {
+		if _, ok := known[k]; !ok {
+			problems = append(problems, fmt.Sprintf("%s: unknown key in %s", k, l.file))
+		}
+	}
+
+	// 4. Validate
+	problems = append(problems, cfg.problems()...)
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return cfg, &ValidationError{Problems: problems}
+	}
+	return cfg, nil
+}
+
+// readEnvFile The dotenv layer. The default file holds dev secrets, so it is left out when
+// APP_ENV=prod; a file named with -env-file is always read
+func (l *Loader) readEnvFile(appEnv string) (map[string]string, error) {
+	if l.envFile == "" || (!l.envFileSet && appEnv == "prod") {
+		return nil, nil
+	}
+	if _, err := os.Stat(l.envFile); err != nil {
+		if errors.Is(err, os.ErrNotExist) && !l.envFileSet {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("env file: %w", err)
+	}
+	vals, err := godotenv.Read(l.envFile)
+	if err != nil {
+		return nil, fmt.Errorf("env file %s: %w", l.envFile, err)
+	}
+	return vals, nil
+}
+
+// readSecretFile Read a mounted secret (Docker/K8s), dropping the trailing newline
+// appEnv APP_ENV as the layers above the env file set it: flag, then process env, then file
+func (l *Loader) appEnv(fileVals map[string]string) string {
+	if v, ok := l.flags["server.env"]; ok {
+		return v
+	}
+	if v, ok := l.LookupEnv("APP_ENV"); ok && v != "" {
+		return v
+	}
+	return fileVals["server.env"]
+}
+
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// readConfigFile Read a YAML or TOML file into dotted keys
+func readConfigFile(path string) (map[string]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config file: %w", err)
+	}
+
+	tree := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	case ".toml":
+		err = toml.Unmarshal(data, &tree)
+	default:
+		return nil, fmt.Errorf("config file %s: unsupported extension, want .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	out := map[string]string{}
+	flatten("", tree, out)
+	return out, nil
+}
+
+func flatten(prefix string, node any, out map[string]string) {
+	switch v := node.(type) {
+	case map[string]any:
+		for k, child := range v {
+			key := k
+			if prefix != "" {
+				key = prefix + "." + k
+			}
+			flatten(key, child, out)
+		}
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, fmt.Sprint(item))
+		}
+		out[prefix] = strings.Join(items, ",")
+	case nil:
+		out[prefix] = ""
+	default:
+		out[prefix] = fmt.Sprint(v)
+	}
+}
+
+// ValidationError Every problem found while loading, reported at once
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%d config problem(s):\n  - %s", len(e.Problems), strings.Join(e.Problems, "\n  - "))
+}
+
+// WriteRedacted Write the effective config as YAML with secrets masked
+func (c *Config) WriteRedacted(w io.Writer) error {
+	tree := map[string]any{}
+	for _, f := range fieldsOf(c) {
+		val := formatValue(f.v)
+		if f.secret {
+			if s, ok := val.(string); !ok || s != "" {
+				val = "<redacted>"
+			}
+		}
+		node := tree
+		parts := strings.Split(f.key, ".")
+		for _, p := range parts[:len(parts)-1] {
+			child, ok := node[p].(map[string]any)
+			if !ok {
+				child = map[string]any{}
+				node[p] = child
+			}
+			node = child
+		}
+		node[parts[len(parts)-1]] = val
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(tree); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// field A settable leaf of Config with its tags
+type field struct {
+	key    string
+	env    string
+	def    string
+	hasDef bool
+	secret bool
+	v      reflect.Value
+}
+
+func (f field) required() bool {
+	return !f.hasDef
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func fieldsOf(c *Config) []field {
+	var out []field
+	walk("", reflect.ValueOf(c).Elem(), &out)
+	return out
+}
+
+func walk(prefix string, v reflect.Value, out *[]field) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		key := sf.Tag.Get("key")
+		if key == "" {
+			continue
+		}
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct && fv.Type() != durationType {
+			walk(key, fv, out)
+			continue
+		}
+		def, hasDef := sf.Tag.Lookup("default")
+		*out = append(*out, field{
+			key:    key,
+			env:    sf.Tag.Get("env"),
+			def:    def,
+			hasDef: hasDef,
+			secret: sf.Tag.Get("secret") == "true",
+			v:      fv,
+		})
+	}
+}
+
+func setValue(v reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", raw)
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(raw)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid int %q", raw)
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid bool %q", raw)
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		v.SetBytes([]byte(raw))
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported field type %s", v.Type())
+	}
+	return nil
+}
+
+func formatValue(v reflect.Value) any {
+	switch {
+	case v.Type() == durationType:
+		return time.Duration(v.Int()).String()
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		return string(v.Bytes())
+	default:
+		return v.Interface()
+	}
+}
@@ -43,3 +43,88 @@ func RedisKeyOTP(email, scene, codeID string) string {
 func RedisKeyThrottle(email, scene string) string {
 	return fmt.Sprintf("otp:throttle:%s:%s", email, scene)
 }
+
+// RedisKeyEmailChange email:change:<userID>:<codeID>
+func RedisKeyEmailChange(userID uint64, codeID string) string {
+	return fmt.Sprintf("email:change:%d:%s", userID, codeID)
+}
+
+// RedisKeyEmailRevert email:revert:<sha256 of token, hex>
+func RedisKeyEmailRevert(tokenHash string) string {
+	return fmt.Sprintf("email:revert:%s", tokenHash)
+}
+
+// RedisKeyTOTPUsed mfa:totp:used:<userID>:<step>
+func RedisKeyTOTPUsed(userID uint64, step int64) string {
+	return fmt.Sprintf("mfa:totp:used:%d:%d", userID, step)
+}
+
+// RedisKeyMFAChallenge mfa:challenge:<sha256 of token, hex>
+func RedisKeyMFAChallenge(tokenHash string) string {
+	return fmt.Sprintf("mfa:challenge:%s", tokenHash)
+}
+
+// RedisKeyMFAChallengeFails mfa:challenge:fails:<sha256 of token, hex>
+func RedisKeyMFAChallengeFails(tokenHash string) string {
+	return fmt.Sprintf("mfa:challenge:fails:%s", tokenHash)
+}
+
+// RedisKeyMFAUserFails mfa:fails:user:<userID>
+func RedisKeyMFAUserFails(userID uint64) string {
+	return fmt.Sprintf("mfa:fails:user:%d", userID)
+}
+
+// RedisKeyWebAuthnChallenge webauthn:challenge:<challengeID>
+func RedisKeyWebAuthnChallenge(challengeID string) string {
+	return fmt.Sprintf("webauthn:challenge:%s", challengeID)
+}
+
+// RedisKeyOIDCTokenUsed oidc:used:<sha256 of ID token, hex>
+func RedisKeyOIDCTokenUsed(tokenHash string) string {
+	return fmt.Sprintf("oidc:used:%s", tokenHash)
+}
+
+// RedisKeyLoginRequest login:request:<codeID>
+func RedisKeyLoginRequest(codeID string) string {
+	return fmt.Sprintf("login:request:%s", codeID)
+}
+
+// RedisKeyChallengeIP challenge:ip:<ip>
+func RedisKeyChallengeIP(ip string) string {
+	return fmt.Sprintf("challenge:ip:%s", ip)
+}
+
+// RedisKeyChallengeLoad challenge:load
+func RedisKeyChallengeLoad() string {
+	return "challenge:load"
+}
+
+// RedisKeyChallengeUsed challenge:used:<challengeID>
+func RedisKeyChallengeUsed(challengeID string) string {
+	return fmt.Sprintf("challenge:used:%s", challengeID)
+}
+
+// RedisKeyRateLimit ratelimit:<policy>:<subject>
+func RedisKeyRateLimit(policy, subject string) string {
+	return fmt.Sprintf("ratelimit:%s:%s", policy, subject)
+}
+
+// RedisKeyReputationEntries reputation:entries
+func RedisKeyReputationEntries() string {
+	return "reputation:entries"
+}
+
+// RedisKeyReputationTrips reputation:trips:<ip>
+func RedisKeyReputationTrips(ip string) string {
+	return fmt.Sprintf("reputation:trips:%s", ip)
+}
+
+// RedisKeyReputationBan reputation:ban:<ip>
+func RedisKeyReputationBan(ip string) string {
+	return fmt.Sprintf("reputation:ban:%s", ip)
+}
+
+// RedisKeyIdempotency idempotency:<subject>:<key>
+func RedisKeyIdempotency(subject, key string) string {
+	return fmt.Sprintf("idempotency:%s:%s", subject, key)
+}
@@ -0,0 +1,391 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// minJWTKeyLen HS256 keys shorter than the hash output weaken the MAC
+const minJWTKeyLen = 32
+
+// Validate Check cross-field rules, every problem is reported at once
+func (c *Config) Validate() error {
+	if problems := c.problems(); len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func (c *Config) problems() []string {
+	var p []string
+	add := func(format string, args ...any) {
+		p = append(p, fmt.Sprintf(format, args...))
+	}
+
+	// 1. Server
+	switch c.Server.Env {
+	case "dev", "staging", "prod":
+	default:
+		add("server.env (APP_ENV) must be one of dev, staging, prod, got %q", c.Server.Env)
+	}
+	for _, p := range c.Server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(p); err != nil && net.ParseIP(p) == nil {
+			add("server.trusted_proxies (TRUSTED_PROXIES) must list IPs or CIDRs, got %q", p)
+		}
+	}
+
+	// 2. Timeouts: each endpoint must finish inside the request timeout
+	if c.Timeouts.Request <= 0 {
+		add("timeouts.request (REQUEST_TIMEOUT) must be > 0")
+	}
+	for name, d := range map[string]time.Duration{
+		"timeouts.request_code (REQUEST_CODE)":     c.Timeouts.RequestCode,
+		"timeouts.verify_code (VERIFY_CODE)":       c.Timeouts.VerifyCode,
+		"timeouts.create_account (CREATE_ACCOUNT)": c.Timeouts.CreateAccount,
+		"timeouts.login (LOGIN)":                   c.Timeouts.Login,
+		"timeouts.set_username (SET_USERNAME)":     c.Timeouts.SetUsername,
+	} {
+		if d <= 0 {
+			add("%s must be > 0", name)
+		} else if d >= c.Timeouts.Request {
+			add("%s=%s must be less than REQUEST_TIMEOUT=%s", name, d, c.Timeouts.Request)
+		}
+	}
+
+	// 3. Redis TTL
+	if c.RedisTTL.OTP <= 0 {
+		add("redis_ttl.otp (OTP_TTL) must be > 0")
+	}
+	if c.RedisTTL.OTPThrottle <= 0 {
+		add("redis_ttl.otp_throttle (OTP_THROTTLE_TTL) must be > 0")
+	}
+	if c.RedisTTL.VerifyWindow <= 0 {
+		add("redis_ttl.verify_window (VERIFY_THROTTLE_WINDOW) must be > 0")
+	}
+	if c.RedisTTL.VerifyWindowLimit <= 0 {
+		add("redis_ttl.verify_window_limit (VERIFY_THROTTLE_WINDOW_LIMIT) must be > 0")
+	}
+
+	// 4. JWT
+	for name, key := range map[string][]byte{
+		"jwt.key (JWT_KEY)":         c.JWT.KEY,
+		"jwt.atk_key (JWT_ATK_KEY)": c.JWT.ATKKey,
+		"jwt.ott_key (JWT_OTT_KEY)": c.JWT.OTTKey,
+	} {
+		if len(key) > 0 && len(key) < minJWTKeyLen {
+			add("%s must be at least %d bytes, got %d", name, minJWTKeyLen, len(key))
+		}
+	}
+	switch c.JWT.ATKAlg {
+	case "HS256", "EdDSA", "ES256":
+	default:
+		add("jwt.atk_alg (JWT_ATK_ALG) must be one of HS256, EdDSA, ES256, got %q", c.JWT.ATKAlg)
+	}
+	if c.JWT.KeysetFile == "" && c.JWT.ATKAlg != "HS256" && len(c.JWT.ATKPrivateKey) == 0 {
+		add("jwt.atk_private_key (JWT_ATK_PRIVATE_KEY) is required when JWT_ATK_ALG=%s", c.JWT.ATKAlg)
+	}
+	if c.JWT.KeysetFile == "" && c.JWT.ATKAlg == "HS256" {
+		if len(c.JWT.KEY) == 0 && (len(c.JWT.ATKKey) == 0 || len(c.JWT.OTTKey) == 0) {
+			add("jwt: set JWT_KEYSET_FILE, or both JWT_ATK_KEY and JWT_OTT_KEY, or the legacy JWT_KEY")
+		}
+		if c.Server.Env == "prod" && (len(c.JWT.ATKKey) == 0 || len(c.JWT.OTTKey) == 0) {
+			add("jwt: prod must not share JWT_KEY between token types, set JWT_KEYSET_FILE or JWT_ATK_KEY and JWT_OTT_KEY")
+		}
+	}
+	if c.JWT.KeysetFile == "" && c.JWT.ATKAlg != "HS256" && len(c.JWT.KEY) == 0 && len(c.JWT.OTTKey) == 0 {
+		add("jwt: one-time tokens need JWT_OTT_KEY or the legacy JWT_KEY")
+	}
+	if c.JWT.KeysetReload <= 0 {
+		add("jwt.keyset_reload (JWT_KEYSET_RELOAD) must be > 0")
+	}
+	if c.JWT.OTT <= 0 {
+		add("jwt.ott (JWT_OTT) must be > 0")
+	}
+	if c.JWT.ATK <= 0 {
+		add("jwt.atk (JWT_ATK) must be > 0")
+	}
+	if c.JWT.RTK <= 0 {
+		add("jwt.rtk (JWT_RTK) must be > 0")
+	}
+
+	// 5. MySQL
+	if c.MySQL.MaxOpenConnections <= 0 {
+		add("mysql.max_open_connections (MYSQL_MAX_OPEN_CONNECTION) must be > 0")
+	}
+	if c.MySQL.MaxIdleConnections < 0 || c.MySQL.MaxIdleConnections > c.MySQL.MaxOpenConnections {
+		add("mysql.max_idle_connections (MYSQL_MAX_IDLE_CONNECTION) must be between 0 and MYSQL_MAX_OPEN_CONNECTION")
+	}
+	if c.MySQL.AutoMigrate && !c.IsDev() {
+		add("mysql.auto_migrate (MIGRATE_ON_START) is only allowed when APP_ENV=dev, run cmd/migrate instead")
+	}
+
+	// 6. Redis
+	if c.Redis.DB < 0 {
+		add("redis.db (REDIS_DB) must be >= 0")
+	}
+	for name, d := range map[string]time.Duration{
+		"redis.dial_timeout (REDIS_DIAL_TIMEOUT)":   c.Redis.DialTimeout,
+		"redis.read_timeout (REDIS_READ_TIMEOUT)":   c.Redis.ReadTimeout,
+		"redis.write_timeout (REDIS_WRITE_TIMEOUT)": c.Redis.WriteTimeout,
+		"redis.ping_timeout (REDIS_PING_TIMEOUT)":   c.Redis.PingTimeout,
+	} {
+		if d <= 0 {
+			add("%s must be > 0", name)
+		}
+	}
+
+	// 7. Access log
+	if c.AccessLog.File != "" && c.AccessLog.MaxSizeMB <= 0 {
+		add("access_log.max_size_mb (ACCESS_LOG_MAX_SIZE_MB) must be > 0")
+	}
+
+	// 8. Log and runtime settings
+	switch c.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		add("log.level (LOG_LEVEL) must be one of debug, info, warn, error, got %q", c.Log.Level)
+	}
+	if c.Runtime.ReloadInterval <= 0 {
+		add("runtime.reload_interval (RUNTIME_RELOAD_INTERVAL) must be > 0")
+	}
+
+	// 9. Auth events and account deletion
+	if c.AuthEvents.Buffer <= 0 {
+		add("auth_events.buffer (AUTH_EVENTS_BUFFER) must be > 0")
+	}
+	if c.AuthEvents.BatchSize <= 0 {
+		add("auth_events.batch_size (AUTH_EVENTS_BATCH_SIZE) must be > 0")
+	}
+	for name, d := range map[string]time.Duration{
+		"auth_events.flush_interval (AUTH_EVENTS_FLUSH_INTERVAL)": c.AuthEvents.FlushInterval,
+		"auth_events.retention (AUTH_EVENTS_RETENTION)":           c.AuthEvents.Retention,
+		"auth_events.prune_interval (AUTH_EVENTS_PRUNE_INTERVAL)": c.AuthEvents.PruneInterval,
+		"account.deletion_grace (ACCOUNT_DELETION_GRACE)":         c.Account.DeletionGrace,
+		"account.purge_interval (ACCOUNT_PURGE_INTERVAL)":         c.Account.PurgeInterval,
+		"account.email_revert_ttl (EMAIL_REVERT_TTL)":             c.Account.EmailRevertTTL,
+	} {
+		if d <= 0 {
+			add("%s must be > 0", name)
+		}
+	}
+
+	// 10. Mail
+	switch c.Mail.Driver {
+	case "log":
+		if c.Server.Env == "prod" {
+			add("mail.driver (MAIL_DRIVER) must not be log when APP_ENV=prod")
+		}
+	case "smtp":
+		if c.Mail.SMTPAddr == "" {
+			add("mail.smtp_addr (MAIL_SMTP_ADDR) is required when MAIL_DRIVER=smtp")
+		}
+	default:
+		add("mail.driver (MAIL_DRIVER) must be one of log, smtp, got %q", c.Mail.Driver)
+	}
+	if !strings.HasPrefix(c.Server.PublicURL, "http://") && !strings.HasPrefix(c.Server.PublicURL, "https://") {
+		add("server.public_url (PUBLIC_BASE_URL) must be an http(s) URL, got %q", c.Server.PublicURL)
+	}
+	if lb := c.Server.LinkBase; lb != "" {
+		if u, err := url.Parse(lb); err != nil || u.Scheme == "" {
+			add("server.link_base (LINK_BASE_URL) must be a URL with a scheme, got %q", lb)
+		} else if c.Server.Env == "prod" && u.Scheme == "http" {
+			add("server.link_base (LINK_BASE_URL) must not use http when APP_ENV=prod")
+		}
+	}
+
+	// 11. MFA
+	if key, err := base64.StdEncoding.DecodeString(c.MFA.EncryptionKey); err != nil || len(key) != 32 {
+		add("mfa.encryption_key (MFA_ENCRYPTION_KEY) must be 32 bytes, base64 encoded")
+	}
+	if c.MFA.Issuer == "" || strings.Contains(c.MFA.Issuer, ":") {
+		add("mfa.issuer (MFA_ISSUER) must be set and must not contain ':'")
+	}
+	if c.MFA.ChallengeTTL <= 0 {
+		add("mfa.challenge_ttl (MFA_CHALLENGE_TTL) must be > 0")
+	}
+	if c.MFA.ChallengeAttempts <= 0 {
+		add("mfa.challenge_attempts (MFA_CHALLENGE_ATTEMPTS) must be > 0")
+	}
+	if c.MFA.UserAttempts < c.MFA.ChallengeAttempts {
+		add("mfa.user_attempts (MFA_USER_ATTEMPTS) must be >= mfa.challenge_attempts")
+	}
+	if c.MFA.LockoutWindow <= 0 {
+		add("mfa.lockout_window (MFA_LOCKOUT_WINDOW) must be > 0")
+	}
+	if c.MFA.RecoveryCodes <= 0 || c.MFA.RecoveryCodes > 20 {
+		add("mfa.recovery_codes (MFA_RECOVERY_CODES) must be between 1 and 20")
+	}
+
+	// 12. WebAuthn
+	if c.WebAuthn.RPID == "" || strings.Contains(c.WebAuthn.RPID, "/") || strings.Contains(c.WebAuthn.RPID, ":") {
+		add("webauthn.rp_id (WEBAUTHN_RP_ID) must be a bare domain, got %q", c.WebAuthn.RPID)
+	}
+	if len(c.WebAuthn.Origins) == 0 {
+		add("webauthn.origins (WEBAUTHN_ORIGINS) must not be empty")
+	}
+	for _, o := range c.WebAuthn.Origins {
+		if c.Server.Env == "prod" && strings.HasPrefix(o, "http://") {
+			add("webauthn.origins (WEBAUTHN_ORIGINS) must not use http when APP_ENV=prod, got %q", o)
+		}
+	}
+	switch c.WebAuthn.UserVerification {
+	case "required", "preferred":
+	default:
+		add("webauthn.user_verification (WEBAUTHN_USER_VERIFICATION) must be one of required, preferred, got %q", c.WebAuthn.UserVerification)
+	}
+	if c.WebAuthn.ChallengeTTL <= 0 {
+		add("webauthn.challenge_ttl (WEBAUTHN_CHALLENGE_TTL) must be > 0")
+	}
+	if c.WebAuthn.MaxCredentials <= 0 {
+		add("webauthn.max_credentials (WEBAUTHN_MAX_CREDENTIALS) must be > 0")
+	}
+
+	// 13. OIDC
+	if c.OIDC.JWKSRefresh < time.Minute {
+		add("oidc.jwks_refresh (OIDC_JWKS_REFRESH) must be >= 1m")
+	}
+	if c.OIDC.JWKSTimeout <= 0 {
+		add("oidc.jwks_timeout (OIDC_JWKS_TIMEOUT) must be > 0")
+	}
+	if c.OIDC.Leeway < 0 || c.OIDC.Leeway > 5*time.Minute {
+		add("oidc.leeway (OIDC_LEEWAY) must be between 0 and 5m")
+	}
+
+	// 14. Password hashing
+	if c.PasswordHash.MemoryKiB < 8*1024 || c.PasswordHash.MemoryKiB > 1024*1024 {
+		add("password_hash.memory_kib (PASSWORD_HASH_MEMORY_KIB) must be between 8192 and 1048576")
+	}
+	if c.PasswordHash.Iterations < 1 || c.PasswordHash.Iterations > 20 {
+		add("password_hash.iterations (PASSWORD_HASH_ITERATIONS) must be between 1 and 20")
+	}
+	if c.PasswordHash.Parallelism < 1 || c.PasswordHash.Parallelism > 16 {
+		add("password_hash.parallelism (PASSWORD_HASH_PARALLELISM) must be between 1 and 16")
+	}
+	if c.PasswordHash.Workers < 0 {
+		add("password_hash.workers (PASSWORD_HASH_WORKERS) must be >= 0")
+	}
+	if c.PasswordHash.MaxQueue < 0 {
+		add("password_hash.max_queue (PASSWORD_HASH_MAX_QUEUE) must be >= 0")
+	}
+
+	// 15. Password policy: prod may tighten the defaults, not loosen them
+	pp := c.PasswordPolicy
+	if pp.MinLength < 1 || pp.MaxLength < pp.MinLength || pp.MaxLength > 256 {
+		add("password_policy: need 1 <= min_length (PASSWORD_POLICY_MIN_LENGTH) <= max_length (PASSWORD_POLICY_MAX_LENGTH) <= 256")
+	}
+	if pp.MinClasses < 0 || pp.MinClasses > 4 {
+		add("password_policy.min_classes (PASSWORD_POLICY_MIN_CLASSES) must be between 0 and 4")
+	}
+	if pp.MinScore < 0 || pp.MinScore > 4 {
+		add("password_policy.min_score (PASSWORD_POLICY_MIN_SCORE) must be between 0 and 4")
+	}
+	if pp.BreachedMinCount < 1 {
+		add("password_policy.breached_min_count (PASSWORD_POLICY_BREACHED_MIN_COUNT) must be >= 1")
+	}
+	if c.Server.Env == "prod" {
+		if pp.MinLength < 8 || pp.MinScore < 2 || !pp.RejectIdentity {
+			add("password_policy: prod needs min_length >= 8, min_score >= 2 and reject_identity")
+		}
+		if pp.BreachedDir == "" {
+			add("password_policy.breached_dir (PASSWORD_POLICY_BREACHED_DIR) must be set when APP_ENV=prod")
+		}
+	}
+
+	// 16. Challenge
+	ch := c.Challenge
+	switch ch.Mode {
+	case "pow":
+		if len(ch.Key) < minJWTKeyLen {
+			add("challenge.key (CHALLENGE_KEY) must be at least %d bytes when CHALLENGE_MODE=pow", minJWTKeyLen)
+		}
+		if ch.Difficulty < 1 || ch.MaxDifficulty < ch.Difficulty || ch.MaxDifficulty > 32 {
+			add("challenge: need 1 <= difficulty (CHALLENGE_DIFFICULTY) <= max_difficulty (CHALLENGE_MAX_DIFFICULTY) <= 32")
+		}
+		if ch.TTL <= 0 || ch.Window <= 0 {
+			add("challenge.ttl (CHALLENGE_TTL) and challenge.window (CHALLENGE_WINDOW) must be > 0")
+		}
+		if ch.IPFree < 1 || ch.LoadFree < 1 {
+			add("challenge.ip_free (CHALLENGE_IP_FREE) and challenge.load_free (CHALLENGE_LOAD_FREE) must be >= 1")
+		}
+	case "captcha":
+		switch ch.CaptchaProvider {
+		case "siteverify":
+			if ch.CaptchaVerifyURL == "" || ch.CaptchaSecret == "" {
+				add("challenge: captcha_verify_url (CHALLENGE_CAPTCHA_VERIFY_URL) and captcha_secret (CHALLENGE_CAPTCHA_SECRET) are required for siteverify")
+			}
+		case "stub":
+			if c.Server.Env == "prod" {
+				add("challenge.captcha_provider (CHALLENGE_CAPTCHA_PROVIDER) must not be stub when APP_ENV=prod")
+			}
+			if ch.CaptchaStubToken == "" {
+				add("challenge.captcha_stub_token (CHALLENGE_CAPTCHA_STUB_TOKEN) is required for the stub")
+			}
+		default:
+			add("challenge.captcha_provider (CHALLENGE_CAPTCHA_PROVIDER) must be one of siteverify, stub, got %q", ch.CaptchaProvider)
+		}
+		if ch.CaptchaTimeout <= 0 {
+			add("challenge.captcha_timeout (CHALLENGE_CAPTCHA_TIMEOUT) must be > 0")
+		}
+	case "off":
+		if c.Server.Env == "prod" {
+			add("challenge.mode (CHALLENGE_MODE) must not be off when APP_ENV=prod")
+		}
+	default:
+		add("challenge.mode (CHALLENGE_MODE) must be one of pow, captcha, off, got %q", ch.Mode)
+	}
+
+	// 17. Reputation
+	if c.Reputation.BanThreshold < 0 {
+		add("reputation.ban_threshold (REPUTATION_BAN_THRESHOLD) must be >= 0")
+	}
+	if c.Reputation.BanThreshold > 0 && (c.Reputation.BanWindow <= 0 || c.Reputation.BanDuration <= 0) {
+		add("reputation.ban_window (REPUTATION_BAN_WINDOW) and reputation.ban_duration (REPUTATION_BAN_DURATION) must be > 0")
+	}
+	if c.Reputation.Refresh < time.Second {
+		add("reputation.refresh (REPUTATION_REFRESH) must be >= 1s")
+	}
+
+	// 18. Idempotency
+	if id := c.Idempotency; id.Enabled {
+		if id.TTL <= 0 {
+			add("idempotency.ttl (IDEMPOTENCY_TTL) must be > 0")
+		}
+		if id.LockTTL <= c.Timeouts.Request {
+			add("idempotency.lock_ttl (IDEMPOTENCY_LOCK_TTL)=%s must be greater than REQUEST_TIMEOUT=%s", id.LockTTL, c.Timeouts.Request)
+		}
+		if id.MaxBodyBytes <= 0 {
+			add("idempotency.max_body_bytes (IDEMPOTENCY_MAX_BODY_BYTES) must be > 0")
+		}
+	}
+
+	// 19. OpenAPI
+	switch c.OpenAPI.Mode {
+	case "off", "log":
+	case "strict":
+		if c.Server.Env == "prod" {
+			add("openapi.mode (OPENAPI_MODE) must not be strict when APP_ENV=prod")
+		}
+	default:
+		add("openapi.mode (OPENAPI_MODE) must be one of off, log, strict, got %q", c.OpenAPI.Mode)
+	}
+
+	// 20. Login throttle
+	if c.LoginThrottle.IPEmailAttempts <= 0 {
+		add("login_throttle.ip_email_attempts (LOGIN_IP_EMAIL_ATTEMPTS) must be > 0")
+	}
+	if c.LoginThrottle.EmailAttempts < c.LoginThrottle.IPEmailAttempts {
+		add("login_throttle.email_attempts (LOGIN_EMAIL_ATTEMPTS) must be >= login_throttle.ip_email_attempts")
+	}
+	if c.LoginThrottle.Window <= 0 {
+		add("login_throttle.window (LOGIN_WINDOW) must be > 0")
+	}
+	if c.LoginThrottle.Lockout <= 0 {
+		add("login_throttle.lockout (LOGIN_LOCKOUT) must be > 0")
+	}
+
+	return p
+}
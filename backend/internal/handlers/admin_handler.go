@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"backend/internal/models"
+	"backend/internal/pkg/httpx"
+	"backend/internal/services"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// headerAdminReason Optional justification attached to read-only admin requests
+const headerAdminReason = "X-Admin-Reason"
+
+type AdminHandler interface {
+	HandleSearchUsers(c *gin.Context)
+	HandleGetUser(c *gin.Context)
+	HandleListSessions(c *gin.Context)
+	HandleLockUser(c *gin.Context)
+	HandleUnlockUser(c *gin.Context)
+	HandleAuthEvents(c *gin.Context)
+	HandleListAudit(c *gin.Context)
+	HandleListReputation(c *gin.Context)
+	HandleAddReputation(c *gin.Context)
+	HandleRemoveReputation(c *gin.Context)
+}
+
+func (h *adminHandler) HandleSearchUsers(c *gin.Context) {
+
+	// 0. Get context
+	ctx := c.Request.Context()
+
+	// 1. Bind query
+	var req struct {
+		Q        string `form:"q" binding:"max=255"`
+		Page     int    `form:"page" binding:"min=0"`
+		PageSize int    `form:"page_size" binding:"min=0,max=100"`
+	}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		writeBindError(c, err, "BAD_REQUEST.search")
+		return
+	}
+
+	// 2. Call service
+	page, err := h.svc.SearchUsers(ctx, actorFrom(c), req.Q, req.Page, req.PageSize)
+	if err != nil {
+		writeError(c, err, adminMessages)
+		return
+	}
+
+	// 3. Write JSON
+	httpx.TryWriteJSON(c, ctx, 200, page)
+}
+
+func (h *adminHandler) HandleGetUser(c *gin.Context) {
+	ctx := c.Request.Context()
+	uid, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+	u, err := h.svc.LookupUser(ctx, actorFrom(c), uid, "")
+	if err != nil {
+		writeError(c, err, adminMessages)
+		return
+	}
+	httpx.TryWriteJSON(c, ctx, 200, u)
+}
+
+func (h *adminHandler) HandleListSessions(c *gin.Context) {
+	ctx := c.Request.Context()
+	uid, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+	sessions, err := h.svc.ListSessions(ctx, actorFrom(c), uid)
+	if err != nil {
+		writeError(c, err, adminMessages)
+		return
+	}
+	httpx.TryWriteJSON(c, ctx, 200, gin.H{"items": sessions})
+}
+
+func (h *adminHandler) HandleLockUser(c *gin.Context) {
+	h.setLocked(c, true)
+}
+
+func (h *adminHandler) HandleUnlockUser(c *gin.Context) {
+	h.setLocked(c, false)
+}
+
+func (h *adminHandler) setLocked(c *gin.Context, locked bool) {
+
+	// 0. Get context
+	ctx := c.Request.Context()
+	uid, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+
+	// 1. Bind JSON
+	var req struct {
+		Reason string `json:"reason" binding:"required,max=512"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err, "BAD_REQUEST.reason")
+		return
+	}
+
+	// 2. Call service
+	if err := h.svc.SetLocked(ctx, actorFrom(c), uid, locked, req.Reason); err != nil {
+		writeError(c, err, adminMessages)
+		return
+	}
+
+	// 3. Write JSON
+	httpx.TryWriteJSON(c, ctx, 200, gin.H{
+		"user_id": uid,
+		"locked":  locked,
+	})
+}
+
+func (h *adminHandler) HandleAuthEvents(c *gin.Context) {
+	ctx := c.Request.Context()
+	uid, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	events, err := h.svc.RecentAuthEvents(ctx, actorFrom(c), uid, limit)
+	if err != nil {
+		writeError(c, err, adminMessages)
+		return
+	}
+	httpx.TryWriteJSON(c, ctx, 200, gin.H{"items": events})
+}
+
+func (h *adminHandler) HandleListAudit(c *gin.Context) {
+
+	// 0. Get context
+	ctx := c.Request.Context()
+
+	// 1. Bind query
+	var req struct {
+		UserID   uint64 `form:"user_id"`
+		Page     int    `form:"page" binding:"min=0"`
+		PageSize int    `form:"page_size" binding:"min=0,max=100"`
+	}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		writeBindError(c, err, "BAD_REQUEST.audit")
+		return
+	}
+
+	// 2. Call service
+	page, err := h.svc.ListAudit(ctx, actorFrom(c), req.UserID, req.Page, req.PageSize)
+	if err != nil {
+		writeError(c, err, adminMessages)
+		return
+	}
+
+	// 3. Write JSON
+	httpx.TryWriteJSON(c, ctx, 200, page)
+}
+
+func (h *adminHandler) HandleListReputation(c *gin.Context) {
+	ctx := c.Request.Context()
+	entries, err := h.reputation.ListEntries(ctx)
+	if err != nil {
+		writeError(c, err, adminMessages)
+		return
+	}
+	httpx.TryWriteJSON(c, ctx, 200, gin.H{"items": entries})
+}
+
+func (h *adminHandler) HandleAddReputation(c *gin.Context) {
+
+	// 0. Get context
+	ctx := c.Request.Context()
+
+	// 1. Bind JSON
+	var req struct {
+		Kind      string     `json:"kind" binding:"required,oneof=ip domain"`
+		Value     string     `json:"value" binding:"required,max=255"`
+		Action    string     `json:"action" binding:"required,oneof=allow deny"`
+		ExpiresAt *time.Time `json:"expires_at"`
+		Reason    string     `json:"reason" binding:"required,max=512"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err, "BAD_REQUEST.entry")
+		return
+	}
+
+	// 2. Call service
+	e := models.ReputationEntry{Kind: req.Kind, Value: req.Value, Action: req.Action, ExpiresAt: req.ExpiresAt}
+	e, err := h.reputation.AddEntry(ctx, actorFrom(c), e, req.Reason)
+	if err != nil {
+		writeError(c, err, adminMessages.With(services.ErrConflict, "CONFLICT.entry_exists"))
+		return
+	}
+
+	// 3. Write JSON
+	httpx.TryWriteJSON(c, ctx, 201, e)
+}
+
+func (h *adminHandler) HandleRemoveReputation(c *gin.Context) {
+
+	// 0. Get context
+	ctx := c.Request.Context()
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		httpx.WriteBadReq(c, "BAD_REQUEST.entry_id")
+		return
+	}
+
+	// 1. Bind JSON
+	var req struct {
+		Reason string `json:"reason" binding:"required,max=512"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err, "BAD_REQUEST.reason")
+		return
+	}
+
+	// 2. Call service
+	if err := h.reputation.RemoveEntry(ctx, actorFrom(c), id, req.Reason); err != nil {
+		writeError(c, err, adminMessages.With(services.ErrNotFound, "NOT_FOUND.entry"))
+		return
+	}
+
+	// 3. Write JSON
+	httpx.TryWriteJSON(c, ctx, 200, gin.H{"id": id, "removed": true})
+}
+
+// actorFrom Staff identity set by the AccessToken middleware
+func actorFrom(c *gin.Context) services.Actor {
+	p := principalFrom(c)
+	return services.Actor{
+		UserID: p.UserID,
+		Label:  p.Email,
+		IP:     c.ClientIP(),
+		Reason: c.GetHeader(headerAdminReason),
+	}
+}
+
+// userIDParam Parse :id, writing 400 on failure
+func userIDParam(c *gin.Context) (uint64, bool) {
+	uid, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || uid == 0 {
+		httpx.WriteBadReq(c, "BAD_REQUEST.user_id")
+		return 0, false
+	}
+	return uid, true
+}
+
+// adminMessages Wording of the admin endpoints
+var adminMessages = services.Messages{
+	services.ErrBadRequest: "BAD_REQUEST",
+	services.ErrNotFound:   "NOT_FOUND.user",
+}
+
+type adminHandler struct {
+	svc        services.AdminService
+	reputation services.ReputationService
+}
+
+func NewAdminHandler(adminSvc services.AdminService, reputationSvc services.ReputationService) AdminHandler {
+	return &adminHandler{svc: adminSvc, reputation: reputationSvc}
+}
@@ -3,8 +3,6 @@ package handlers
 import (
 	"backend/internal/pkg/httpx"
 	"backend/internal/services"
-	"errors"
-	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
@@ -14,6 +12,15 @@ type AuthHandler interface {
 	HandleCreateAccount(c *gin.Context)
 	HandleVerifyCode(c *gin.Context)
 	HandleRequestCode(c *gin.Context)
+	HandleRestoreAccount(c *gin.Context)
+	HandleVerifyMFA(c *gin.Context)
+	HandleBeginPasskeyLogin(c *gin.Context)
+	HandleFinishPasskeyLogin(c *gin.Context)
+	HandleOIDCLogin(c *gin.Context)
+	HandleRequestLoginCode(c *gin.Context)
+	HandleLoginWithCode(c *gin.Context)
+	HandleLoginWithMagicLink(c *gin.Context)
+	HandleIssueChallenge(c *gin.Context)
 }
 
 func (h *authHandler) HandleLogin(c *gin.Context) {
@@ -24,22 +31,27 @@ func (h *authHandler) HandleLogin(c *gin.Context) {
 	// 1. Bind JSON
 	var req struct {
 		Email    string `json:"email" binding:"required,email,max=255"`
-		Password string `json:"password" binding:"required,max=20,min=8"`
+		Password string `json:"password" binding:"required,max=256"`
 		DeviceID string `json:"device_id" binding:"required,uuid4"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		httpx.WriteBadReq(c, "Invalid email or password")
+		writeBindError(c, err, "UNAUTHORIZED.credentials")
 		return
 	}
 
 	// 2. Call service
-	resp, err := h.svc.Login(ctx, "ip", req.Email, req.Password, req.DeviceID)
+	resp, err := h.svc.Login(ctx, c.ClientIP(), req.Email, req.Password, req.DeviceID)
 	if err != nil {
-		c.JSON(401, gin.H{"code": http.StatusUnauthorized, "message": err.Error()})
+		writeError(c, err, services.Messages{
+			services.ErrBadRequest:   "UNAUTHORIZED.credentials",
+			services.ErrUnauthorized: "UNAUTHORIZED.credentials",
+			services.ErrForbidden:    "FORBIDDEN.login",
+		})
+		return
 	}
 
-	// 3. Write JSON
-	c.JSON(200, resp)
+	// 3. Write JSON: tokens, or the MFA challenge
+	httpx.TryWriteJSON(c, ctx, 200, loginBody(resp))
 }
 
 func (h *authHandler) HandleCreateAccount(c *gin.Context) {
@@ -52,29 +64,23 @@ func (h *authHandler) HandleCreateAccount(c *gin.Context) {
 
 	// 1. Bind JSON
 	var req struct {
-		Password string `json:"password" binding:"required,max=20,min=8"`
+		Password string `json:"password" binding:"required,max=256"`
 		DeviceID string `json:"device_id" binding:"required,uuid4"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		httpx.WriteBadReq(c, "The length of password should be between 8 and 20.")
+		writeBindError(c, err, "BAD_REQUEST.password_and_device")
 		return
 	}
 
 	// 2  Call service: Create Account
 	resp, err := h.svc.CreateAccount(ctx, email, scene, jti, req.Password, req.DeviceID)
 	if err != nil {
-		switch {
-		case errors.Is(err, services.ErrBadRequest):
-			httpx.WriteBadReq(c, "Invalid email or password.")
-		case errors.Is(err, services.ErrUnauthorized):
-			httpx.WriteUnauthorized(c, "Try to signup one more time.")
-		case errors.Is(err, services.ErrConflict):
-			httpx.WriteConflict(c, "Email already exists. Please login.")
-		case errors.Is(err, services.ErrCtxError):
-			httpx.WriteCtxError(c, err)
-		default:
-			httpx.WriteInternal(c)
-		}
+		writeError(c, err, services.Messages{
+			services.ErrBadRequest:   "UNAUTHORIZED.credentials",
+			services.ErrUnauthorized: "UNAUTHORIZED.signup_expired",
+			services.ErrConflict:     "CONFLICT.email_exists_login",
+			services.ErrForbidden:    "FORBIDDEN.signup_network",
+		})
 		return
 	}
 
@@ -95,25 +101,17 @@ func (h *authHandler) HandleVerifyCode(c *gin.Context) {
 		CodeID string `json:"code_id" binding:"required,uuid4"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		httpx.WriteBadReq(c, "Please enter a valid code")
+		writeBindError(c, err, "BAD_REQUEST.code_format")
 		return
 	}
 
 	// 2. Call service
 	token, err := h.svc.VerifyCodeAndGenToken(ctx, req.Email, req.Scene, req.CodeID, req.Code)
 	if err != nil {
-		switch {
-		case errors.Is(err, services.ErrBadRequest):
-			httpx.WriteBadReq(c, "Please enter a valid code.")
-		case errors.Is(err, services.ErrUnauthorized):
-			httpx.WriteUnauthorized(c, "Verification code is invalid or expired.")
-		case errors.Is(err, services.ErrTooManyRequest):
-			httpx.WriteTooManyReq(c)
-		case errors.Is(err, services.ErrCtxError):
-			httpx.WriteCtxError(c, err)
-		default:
-			httpx.WriteInternal(c)
-		}
+		writeError(c, err, services.Messages{
+			services.ErrBadRequest:   "BAD_REQUEST.code_format",
+			services.ErrUnauthorized: "UNAUTHORIZED.verification_code",
+		})
 		return
 	}
 
@@ -130,40 +128,138 @@ func (h *authHandler) HandleRequestCode(c *gin.Context) {
 
 	// 1. Bind JSON
 	var req struct {
-		Email string `json:"email" binding:"required,email,max=255"`
-		Scene string `json:"scene" binding:"required,oneof=signup reset_password"`
+		Email     string `json:"email" binding:"required,email,max=255"`
+		Scene     string `json:"scene" binding:"required,oneof=signup reset_password restore_account"`
+		Challenge string `json:"challenge" binding:"max=1024"`
+		Solution  string `json:"solution" binding:"max=4096"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		httpx.WriteBadReq(c, "Please check your email.")
+		writeBindError(c, err, "BAD_REQUEST.email")
 		return
 	}
 
-	// 2. Call service
-	codeID, err := h.svc.RequestCode(ctx, req.Email, req.Scene)
+	// 2. Check challenge
+	if err := h.challenge.Verify(ctx, c.ClientIP(), req.Challenge, req.Solution); err != nil {
+		writeError(c, err, nil)
+		return
+	}
+
+	// 3. Call service
+	codeID, err := h.svc.RequestCode(ctx, c.ClientIP(), req.Email, req.Scene)
 	if err != nil {
-		switch {
-		case errors.Is(err, services.ErrBadRequest):
-			httpx.WriteBadReq(c, "Please check your email.")
-		case errors.Is(err, services.ErrTooManyRequest):
-			httpx.WriteTooManyReq(c)
-		case errors.Is(err, services.ErrCtxError):
-			httpx.WriteCtxError(c, err)
-		default:
-			httpx.WriteInternal(c)
-		}
+		writeError(c, err, services.Messages{
+			services.ErrBadRequest: "BAD_REQUEST.email",
+		})
 		return
 	}
 
-	// 3. Write JSON
+	// 4. Write JSON
 	httpx.TryWriteJSON(c, ctx, 200, gin.H{
 		"code_id": codeID,
 	})
 }
 
+// HandleRestoreAccount Proof is one of password, provider with id_token, or code_id with a
+// restore_account code
+func (h *authHandler) HandleRestoreAccount(c *gin.Context) {
+
+	// 0. Get context
+	ctx := c.Request.Context()
+
+	// 1. Bind JSON
+	var req struct {
+		Email    string `json:"email" binding:"required,email,max=255"`
+		Password string `json:"password" binding:"omitempty,max=256"`
+		Provider string `json:"provider" binding:"max=32"`
+		IDToken  string `json:"id_token"`
+		Nonce    string `json:"nonce" binding:"max=256"`
+		CodeID   string `json:"code_id" binding:"omitempty,uuid4"`
+		Code     string `json:"code" binding:"omitempty,len=6"`
+		DeviceID string `json:"device_id" binding:"required,uuid4"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err, "UNAUTHORIZED.credentials")
+		return
+	}
+
+	// 2. Call service
+	proof := services.RestoreProof{Password: req.Password, Provider: req.Provider, IDToken: req.IDToken,
+		Nonce: req.Nonce, CodeID: req.CodeID, Code: req.Code}
+	resp, err := h.svc.RestoreAccount(ctx, req.Email, proof, req.DeviceID)
+	if err != nil {
+		writeError(c, err, services.Messages{
+			services.ErrBadRequest:   "UNAUTHORIZED.credentials",
+			services.ErrUnauthorized: "UNAUTHORIZED.restore",
+			services.ErrForbidden:    "FORBIDDEN.locked",
+		})
+		return
+	}
+
+	// 3. Write JSON
+	httpx.TryWriteJSON(c, ctx, 200, loginBody(resp))
+}
+
+// HandleVerifyMFA Second step of a login that answered mfa_required
+func (h *authHandler) HandleVerifyMFA(c *gin.Context) {
+
+	// 0. Get context
+	ctx := c.Request.Context()
+
+	// 1. Bind JSON
+	var req struct {
+		MFAToken string `json:"mfa_token" binding:"required,max=128"`
+		Code     string `json:"code" binding:"required,max=32"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err, "BAD_REQUEST.code")
+		return
+	}
+
+	// 2. Call service
+	resp, err := h.svc.VerifyMFA(ctx, c.ClientIP(), req.MFAToken, req.Code)
+	if err != nil {
+		writeError(c, err, services.Messages{
+			services.ErrBadRequest:   "BAD_REQUEST.code",
+			services.ErrUnauthorized: "UNAUTHORIZED.mfa",
+			services.ErrForbidden:    "FORBIDDEN.locked",
+		})
+		return
+	}
+
+	// 3. Write JSON
+	httpx.TryWriteJSON(c, ctx, 200, resp)
+}
+
+// loginBody Tokens, or the challenge when a second factor is required
+func loginBody(r services.LoginResult) any {
+	if r.Challenge != nil {
+		return r.Challenge
+	}
+	return r.Tokens
+}
+
+// HandleIssueChallenge What to solve before requesting a code
+func (h *authHandler) HandleIssueChallenge(c *gin.Context) {
+
+	// 0. Get context
+	ctx := c.Request.Context()
+
+	// 1. Call service
+	resp, err := h.challenge.Issue(ctx, c.ClientIP())
+	if err != nil {
+		writeError(c, err, nil)
+		return
+	}
+
+	// 2. Write JSON
+	httpx.TryWriteJSON(c, ctx, 200, resp)
+}
+
 type authHandler struct {
-	svc services.AuthService
+	svc       services.AuthService
+	challenge services.ChallengeService
 }
 
-func NewAuthHandler(authSvc services.AuthService) AuthHandler {
-	return &authHandler{svc: authSvc}
+func NewAuthHandler(authSvc services.AuthService, challengeSvc services.ChallengeService) AuthHandler {
+	return &authHandler{svc: authSvc, challenge: challengeSvc}
 }
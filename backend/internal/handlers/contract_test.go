@@ -0,0 +1,169 @@
+package handlers_test
+
+import (
+	"backend/docs"
+	"backend/internal/handlers"
+	"backend/internal/middlewares"
+	"backend/internal/pkg/openapi"
+	"backend/internal/services"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// stubAuth Answers with what a test sets; methods a test does not expect panic on the nil AuthService
+type stubAuth struct {
+	services.AuthService
+	login func() (services.LoginResult, error)
+	calls int
+}
+
+func (s *stubAuth) Login(ctx context.Context, ip, email, password, deviceID string) (services.LoginResult, error) {
+	s.calls++
+	return s.login()
+}
+
+func (s *stubAuth) RequestCode(ctx context.Context, ip, email, scene string) (string, error) {
+	s.calls++
+	return "5b0e1f6c-7d4a-4e2b-9c3d-1a2b3c4d5e6f", nil
+}
+
+func (s *stubAuth) Authenticate(ctx context.Context, atk string) (services.Principal, error) {
+	return services.Principal{UserID: 7, Email: "a@example.com", IssuedAt: time.Now()}, nil
+}
+
+type stubAccount struct {
+	services.AccountService
+	calls int
+}
+
+func (s *stubAccount) DeletePasskey(ctx context.Context, p services.Principal, id uint64, password string) error {
+	s.calls++
+	return nil
+}
+
+type stubChallenge struct {
+	services.ChallengeService
+}
+
+func (stubChallenge) Verify(ctx context.Context, ip, token, solution string) error {
+	return nil
+}
+
+var tokens = &services.AuthResponse{ATK: "atk", TokenType: "Bearer", ExpiresIn: 900, RTK: "rtk", UserID: 7}
+
+// TestContract Handlers behind the strict OpenAPI middleware with docs/api.yaml: requests
+// that match the document reach the handler and their responses go out, requests that do
+// not are refused before it, and a response that does not match is replaced
+func TestContract(t *testing.T) {
+	spec, err := openapi.Load(docs.OpenAPI)
+	if err != nil {
+		t.Fatalf("load docs/api.yaml: %v", err)
+	}
+	gin.SetMode(gin.TestMode)
+
+	const login = `{"email":"a@example.com","password":"Contract-Check-1","device_id":"9c2d6f1e-3a4b-4c5d-8e7f-6a5b4c3d2e1f"}`
+	tests := []struct {
+		name   string
+		login  func() (services.LoginResult, error)
+		method string
+		path   string
+		body   string
+		status int
+		code   string
+		calls  int
+	}{
+		{
+			name:   "login",
+			login:  func() (services.LoginResult, error) { return services.LoginResult{Tokens: tokens}, nil },
+			method: http.MethodPost, path: "/api/auth/login", body: login,
+			status: 200, calls: 1,
+		},
+		{
+			name:   "login with a wrong password",
+			login:  func() (services.LoginResult, error) { return services.LoginResult{}, services.ErrUnauthorized },
+			method: http.MethodPost, path: "/api/auth/login", body: login,
+			status: 401, code: "UNAUTHORIZED", calls: 1,
+		},
+		{
+			name:   "login without a device id",
+			method: http.MethodPost, path: "/api/auth/login", body: `{"email":"a@example.com","password":"Contract-Check-1"}`,
+			status: 400, code: "CONTRACT_REQUEST_INVALID",
+		},
+		{
+			name:   "login answering neither tokens nor a challenge",
+			login:  func() (services.LoginResult, error) { return services.LoginResult{}, nil },
+			method: http.MethodPost, path: "/api/auth/login", body: login,
+			status: 500, code: "CONTRACT_RESPONSE_INVALID", calls: 1,
+		},
+		{
+			name:   "request code",
+			method: http.MethodPost, path: "/api/auth/request-code", body: `{"email":"a@example.com","scene":"signup"}`,
+			status: 200, calls: 1,
+		},
+		{
+			name:   "request code for an unknown scene",
+			method: http.MethodPost, path: "/api/auth/request-code", body: `{"email":"a@example.com","scene":"login"}`,
+			status: 400, code: "CONTRACT_REQUEST_INVALID",
+		},
+		{
+			name:   "delete passkey",
+			method: http.MethodDelete, path: "/api/me/passkeys/1", body: `{"password":"Contract-Check-1"}`,
+			status: 204, calls: 1,
+		},
+		{
+			name:   "delete passkey with a numeric password",
+			method: http.MethodDelete, path: "/api/me/passkeys/1", body: `{"password":1}`,
+			status: 400, code: "CONTRACT_REQUEST_INVALID",
+		},
+		{
+			name:   "undocumented route",
+			method: http.MethodGet, path: "/api/undocumented",
+			status: 500, code: "CONTRACT_UNDOCUMENTED",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := &stubAuth{login: tt.login}
+			account := &stubAccount{}
+			authH := handlers.NewAuthHandler(auth, stubChallenge{})
+			meH := handlers.NewMeHandler(account, auth)
+
+			r := gin.New()
+			r.Use(middlewares.OpenAPI(spec, true))
+			r.POST("/api/auth/login", authH.HandleLogin)
+			r.POST("/api/auth/request-code", authH.HandleRequestCode)
+			r.DELETE("/api/me/passkeys/:id", middlewares.AccessToken(auth), meH.HandleDeletePasskey)
+			r.GET("/api/undocumented", func(c *gin.Context) { c.Status(204) })
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			req.Header.Set("Authorization", "Bearer atk")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.code != "" {
+				var body struct {
+					Code string `json:"code"`
+				}
+				if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Code != tt.code {
+					t.Fatalf("code = %q, want %q: %s", body.Code, tt.code, w.Body)
+				}
+			}
+			if calls := auth.calls + account.calls; calls != tt.calls {
+				t.Fatalf("service calls = %d, want %d", calls, tt.calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"backend/internal/pkg/httpx"
+	"backend/internal/services"
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// writeError Answer with the AppError of err; msgs words the sentinels this endpoint returns
+func writeError(c *gin.Context, err error, msgs services.Messages) {
+	httpx.WriteError(c, services.AsAppError(c.Request.Context(), err, msgs))
+}
+
+// writeBindError 400 worded by key, listing the fields that failed validation
+func writeBindError(c *gin.Context, err error, key string) {
+	httpx.WriteError(c, services.Invalid(key, fieldErrors(err)))
+}
+
+func fieldErrors(err error) []services.FieldError {
+	var ve validator.ValidationErrors
+	if !errors.As(err, &ve) {
+		return nil
+	}
+	out := make([]services.FieldError, 0, len(ve))
+	for _, fe := range ve {
+		out = append(out, services.FieldError{Field: fe.Field(), Rule: fe.Tag(), Param: fe.Param()})
+	}
+	return out
+}
+
+// UseJSONFieldNames Name fields in binding errors by their json or form tag instead of the Go
+// name; call once before serving
+func UseJSONFieldNames() {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		for _, tag := range []string{"json", "form"} {
+			name, _, _ := strings.Cut(f.Tag.Get(tag), ",")
+			if name == "-" {
+				return ""
+			}
+			if name != "" {
+				return name
+			}
+		}
+		return f.Name
+	})
+}
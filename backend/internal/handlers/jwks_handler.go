@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"backend/internal/pkg/jwtx"
+
+	"github.com/gin-gonic/gin"
+)
+
+type JWKSHandler interface {
+	HandleJWKS(c *gin.Context)
+}
+
+func (h *jwksHandler) HandleJWKS(c *gin.Context) {
+	// Short cache so rotated keys propagate, staged keys are published ahead of use
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(200, h.tokens.JWKS())
+}
+
+type jwksHandler struct {
+	tokens *jwtx.Manager
+}
+
+func NewJWKSHandler(tokens *jwtx.Manager) JWKSHandler {
+	return &jwksHandler{tokens: tokens}
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"backend/internal/pkg/i18n"
+	"backend/internal/pkg/logx"
+	"bytes"
+	"embed"
+	"html/template"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed pages/*.html
+var pages embed.FS
+
+var linkPage = template.Must(template.ParseFS(pages, "pages/link.html"))
+
+// LinkHandler Pages behind the links in emails, for when LINK_BASE_URL does not send them
+// to an app. A page only posts the token of its URL to the API once the user asks, since
+// mail scanners open links as well
+type LinkHandler interface {
+	HandleRevertEmailPage(c *gin.Context)
+	HandleMagicLoginPage(c *gin.Context)
+}
+
+func (h *linkHandler) HandleRevertEmailPage(c *gin.Context) {
+	writeLinkPage(c, "page.revert_email", "/api/auth/revert-email", false)
+}
+
+// HandleMagicLoginPage For a web app on PUBLIC_BASE_URL: the page sends the device id the app
+// keeps in localStorage "device_id", so the link only works in the browser that asked for it,
+// and leaves the login response (tokens or an MFA challenge) in localStorage "login_result"
+func (h *linkHandler) HandleMagicLoginPage(c *gin.Context) {
+	writeLinkPage(c, "page.magic_login", "/api/auth/login/magic", true)
+}
+
+// linkPageData device adds the browser's device id to the request and keeps the response
+type linkPageData struct {
+	Lang, Title, Text, Button, Done, Failed, NoDevice string
+	Endpoint                                          string
+	Device                                            bool
+}
+
+// writeLinkPage Render the page worded by the catalog keys under prefix
+func writeLinkPage(c *gin.Context, prefix, endpoint string, device bool) {
+	loc := i18n.From(c.Request.Context())
+	var buf bytes.Buffer
+	if err := linkPage.Execute(&buf, linkPageData{
+		Lang:     loc,
+		Title:    i18n.T(loc, prefix+".title"),
+		Text:     i18n.T(loc, prefix+".text"),
+		Button:   i18n.T(loc, prefix+".button"),
+		Done:     i18n.T(loc, prefix+".done"),
+		Failed:   i18n.T(loc, "page.failed"),
+		NoDevice: i18n.T(loc, "page.no_device"),
+		Endpoint: endpoint,
+		Device:   device,
+	}); err != nil {
+		logx.LogError(c.Request.Context(), "LinkHandler.Render", err)
+		c.Status(500)
+		return
+	}
+
+	// The token is in the URL: keep it out of Referer headers and caches
+	c.Header("Cache-Control", "no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Header("X-Frame-Options", "DENY")
+	c.Data(200, "text/html; charset=utf-8", buf.Bytes())
+}
+
+type linkHandler struct{}
+
+func NewLinkHandler() LinkHandler {
+	return &linkHandler{}
+}
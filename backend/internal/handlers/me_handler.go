@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"archive/zip"
+	"backend/internal/pkg/httpx"
+	"backend/internal/pkg/logx"
+	"backend/internal/services"
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MeHandler interface {
+	HandleSecurityEvents(c *gin.Context)
+	HandleDeleteAccount(c *gin.Context)
+	HandleExport(c *gin.Context)
+	HandleRequestEmailChange(c *gin.Context)
+	HandleConfirmEmailChange(c *gin.Context)
+	HandleRevertEmail(c *gin.Context)
+	HandleSetLocale(c *gin.Context)
+	HandleChangePassword(c *gin.Context)
+	HandleMFAStatus(c *gin.Context)
+	HandleSetupTOTP(c *gin.Context)
+	HandleConfirmTOTP(c *gin.Context)
+	HandleDisableTOTP(c *gin.Context)
+	HandleRegenerateRecoveryCodes(c *gin.Context)
+	HandleBeginPasskeyRegistration(c *gin.Context)
+	HandleFinishPasskeyRegistration(c *gin.Context)
+	HandleListPasskeys(c *gin.Context)
+	HandleDeletePasskey(c *gin.Context)
+	HandleListIdentities(c *gin.Context)
+	HandleLinkIdentity(c *gin.Context)
+	HandleUnlinkIdentity(c *gin.Context)
+}
+
+func (h *meHandler) HandleSecurityEvents(c *gin.Context) {
+
+	// 0. Get context
+	ctx := c.Request.Context()
+
+	// 1. Bind query
+	var req struct {
+		BeforeID uint64 `form:"before_id"`
+		Limit    int    `form:"limit" binding:"min=0,max=200"`
+	}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		writeBindError(c, err, "BAD_REQUEST.paging")
+		return
+	}
+
+	// 2. Call service
+	events, err := h.svc.SecurityEvents(ctx, principalFrom(c), req.BeforeID, req.Limit)
+	if err != nil {
+		writeError(c, err, nil)
+		return
+	}
+
+	// 3. Write JSON
+	httpx.TryWriteJSON(c, ctx, 200, gin.H{"items": events})
+}
+
+// HandleDeleteAccount Password may be omitted right after login on an account without one
+func (h *meHandler) HandleDeleteAccount(c *gin.Context) {
+
+	// 0. Get context
+	ctx := c.Request.Context()
+
+	// 1. Bind JSON
+	var req struct {
+		Password string `json:"password" binding:"omitempty,max=256"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err, "BAD_REQUEST.password_required")
+		return
+	}
+
+	// 2. Call service
+	info, err := h.svc.DeleteAccount(ctx, principalFrom(c), req.Password)
+	if err != nil {
+		writeError(c, err, services.Messages{
+			services.ErrUnauthorized: "UNAUTHORIZED.confirm_password",
+			services.ErrNotFound:     "NOT_FOUND.account",
+		})
+		return
+	}
+
+	// 3. Write JSON
+	httpx.TryWriteJSON(c, ctx, 200, info)
+}
+
+// HandleExport ?format=zip (default) or json
+func (h *meHandler) HandleExport(c *gin.Context) {
+
+	// 0. Get context
+	ctx := c.Request.Context()
+	format := c.DefaultQuery("format", "zip")
+	if format != "zip" && format != "json" {
+		httpx.WriteBadReq(c, "BAD_REQUEST.export_format")
+		return
+	}
+
+	// 1. Call service
+	export, err := h.svc.Export(ctx, principalFrom(c))
+	if err != nil {
+		writeError(c, err, services.Messages{
+			services.ErrNotFound: "NOT_FOUND.account",
+		})
+		return
+	}
+
+	// 2. Write JSON
+	name := fmt.Sprintf("account-%d-%s", export.Profile.UserID, export.ExportedAt.Format("20060102T150405Z"))
+	if format == "json" {
+		c.Header("Content-Disposition", `attachment; filename="`+name+`.json"`)
+		httpx.TryWriteJSON(c, ctx, 200, export)
+		return
+	}
+
+	// 3. Or a ZIP with one file per section
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range []struct {
+		name string
+		v    any
+	}{
+		{"profile.json", export.Profile},
+		{"devices.json", export.Devices},
+		{"sessions.json", export.Sessions},
+		{"identities.json", export.Identities},
+		{"passkeys.json", export.Passkeys},
+		{"mfa.json", export.MFA},
+		{"security_events.json", export.SecurityEvents},
+		{"admin_actions.json", export.AdminActions},
+	} {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: export.ExportedAt})
+		if err == nil {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			err = enc.Encode(f.v)
+		}
+		if err != nil {
+			logx.LogError(ctx, "MeHandler.HandleExport", err)
+			httpx.WriteInternal(c)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		logx.LogError(ctx, "MeHandler.HandleExport", err)
+		httpx.WriteInternal(c)
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="`+name+`.zip"`)
+	c.Data(200, "application/zip", buf.Bytes())
+}
+
+// HandleRequestEmailChange Send a code to the new address; password may be omitted right
+// after login on an account without one
+func (h *meHandler) HandleRequestEmailChange(c *gin.Context) {
+
+	// 0. Get context
+	ctx := c.Request.Context()
+
+	// 1. Bind JSON
+	var req struct {
+		NewEmail string `json:"new_email" binding:"required,email,max=255"`
+		Password string `json:"password" binding:"omitempty,max=256"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err, "BAD_REQUEST.new_email")
+		return
+	}
+
+	// 2. Call service
+	codeID, err := h.svc.RequestEmailChange(ctx, principalFrom(c), req.NewEmail, req.Password)
+	if err != nil {
+		writeError(c, err, services.Messages{
+			services.ErrBadRequest:   "BAD_REQUEST.new_email",
+			services.ErrUnauthorized: "UNAUTHORIZED.confirm_password",
+			services.ErrConflict:     "CONFLICT.email_exists",
+		})
+		return
+	}
+
+	// 3. Write JSON
+	httpx.TryWriteJSON(c, ctx, 200, gin.H{"code_id": codeID})
+}
+
+func (h *meHandler) HandleConfirmEmailChange(c *gin.Context) {
+
+	// 0. Get context
+	ctx := c.Request.Context()
+
+	// 1. Bind JSON
+	var req struct {
+		CodeID string `json:"code_id" binding:"required,uuid4"`
+		Code   string `json:"code" binding:"required,len=6,numeric"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err, "BAD_REQUEST.code")
+		return
+	}
+
+	// 2. Call service
+	email, err := h.svc.ConfirmEmailChange(ctx, principalFrom(c), req.CodeID, req.Code)
+	if err != nil {
+		writeError(c, err, services.Messages{
+			services.ErrBadRequest:   "UNAUTHORIZED.code",
+			services.ErrUnauthorized: "UNAUTHORIZED.code",
+			services.ErrConflict:     "CONFLICT.email_exists",
+		})
+		return
+	}
+
+	// 3. Write JSON
+	httpx.TryWriteJSON(c, ctx, 200, gin.H{"email": email})
+}
+
+// HandleRevertEmail Token from the link mailed to the old address, no sign-in needed
+func (h *meHandler) HandleRevertEmail(c *gin.Context) {
+
+	// 0. Get context
+	ctx := c.Request.Context()
+
+	// 1. Bind JSON
+	var req struct {
+		Token string `json:"token" binding:"required,max=128"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err, "BAD_REQUEST.link")
+		return
+	}
+
+	// 2. Call service
+	if err := h.svc.RevertEmailChange(ctx, req.Token); err != nil {
+		writeError(c, err, services.Messages{
+			services.ErrBadRequest:   "UNAUTHORIZED.link",
+			services.ErrUnauthorized: "UNAUTHORIZED.link",
+			services.ErrConflict:     "CONFLICT.email_revert",
+		})
+		return
+	}
+
+	// 3. Write JSON
+	httpx.TryWriteJSON(c, ctx, 200, gin.H{"reverted": true})
+}
+
+// HandleChangePassword Returns new tokens when other sessions were revoked
+// HandleSetLocale {"locale": "zh"} picks the language of messages and emails, "" follows
+// Accept-Language again
+func (h *meHandler) HandleSetLocale(c *gin.Context) {
+
+	// 0. Get context
+	ctx := c.Request.Context()
+
+	// 1. Bind JSON
+	var req struct {
+		Locale *string `json:"locale" binding:"required,max=35"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err, "BAD_REQUEST.locale")
+		return
+	}
+
+	// 2. Call service
+	locale, err := h.svc.SetLocale(ctx, principalFrom(c), *req.Locale)
+	if err != nil {
+		writeError(c, err, services.Messages{
+			services.ErrBadRequest: "BAD_REQUEST.locale",
+		})
+		return
+	}
+
+	// 3. Write JSON
+	httpx.TryWriteJSON(c, ctx, 200, gin.H{"locale": locale})
+}
+
+func (h *meHandler) HandleChangePassword(c *gin.Context) {
+
+	// 0. Get context
+	ctx := c.Request.Context()
+
+	// 1. Bind JSON
+	var req struct {
+		CurrentPassword string `json:"current_password" binding:"required,max=256"`
+		NewPassword     string `json:"new_password" binding:"required,max=256"`
+		RevokeOthers    bool   `json:"revoke_other_sessions"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err, "BAD_REQUEST.password")
+		return
+	}
+
+	// 2. Call service
+	resp, err := h.auth.ChangePassword(ctx, c.ClientIP(), principalFrom(c), req.CurrentPassword, req.NewPassword, req.RevokeOthers)
+	if err != nil {
+		writeError(c, err, services.Messages{
+			services.ErrBadRequest:   "BAD_REQUEST.new_password",
+			services.ErrUnauthorized: "UNAUTHORIZED.wrong_password",
+		})
+		return
+	}
+
+	// 3. Write JSON
+	if resp != nil {
+		httpx.TryWriteJSON(c, ctx, 200, resp)
+		return
+	}
+	httpx.TryWriteJSON(c, ctx, 200, gin.H{"changed": true})
+}
+
+// principalFrom Caller set by the AccessToken middleware
+func principalFrom(c *gin.Context) services.Principal {
+	p, _ := c.MustGet("principal").(services.Principal)
+	return p
+}
+
+type meHandler struct {
+	svc  services.AccountService
+	auth services.AuthService
+}
+
+func NewMeHandler(accountSvc services.AccountService, authSvc services.AuthService) MeHandler {
+	return &meHandler{svc: accountSvc, auth: authSvc}
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"backend/internal/pkg/httpx"
+	"backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *meHandler) HandleMFAStatus(c *gin.Context) {
+	ctx := c.Request.Context()
+	status, err := h.svc.MFAStatus(ctx, principalFrom(c))
+	if err != nil {
+		writeError(c, err, mfaMessages)
+		return
+	}
+	httpx.TryWriteJSON(c, ctx, 200, status)
+}
+
+// HandleSetupTOTP Password may be omitted right after login on an account without one
+func (h *meHandler) HandleSetupTOTP(c *gin.Context) {
+
+	// 0. Get context
+	ctx := c.Request.Context()
+
+	// 1. Bind JSON
+	var req struct {
+		Password string `json:"password" binding:"omitempty,max=256"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err, "BAD_REQUEST.password")
+		return
+	}
+
+	// 2. Call service
+	setup, err := h.svc.SetupTOTP(ctx, principalFrom(c), req.Password)
+	if err != nil {
+		writeError(c, err, mfaMessages)
+		return
+	}
+
+	// 3. Write JSON
+	httpx.TryWriteJSON(c, ctx, 200, setup)
+}
+
+func (h *meHandler) HandleConfirmTOTP(c *gin.Context) {
+
+	// 0. Get context
+	ctx := c.Request.Context()
+
+	// 1. Bind JSON
+	var req struct {
+		Code string `json:"code" binding:"required,len=6,numeric"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err, "BAD_REQUEST.code")
+		return
+	}
+
+	// 2. Call service
+	codes, err := h.svc.ConfirmTOTP(ctx, principalFrom(c), req.Code)
+	if err != nil {
+		writeError(c, err, mfaMessages)
+		return
+	}
+
+	// 3. Write JSON
+	httpx.TryWriteJSON(c, ctx, 200, gin.H{"recovery_codes": codes})
+}
+
+// HandleDisableTOTP Password may be omitted right after login on an account without one
+func (h *meHandler) HandleDisableTOTP(c *gin.Context) {
+
+	// 0. Get context
+	ctx := c.Request.Context()
+
+	// 1. Bind JSON
+	var req struct {
+		Password string `json:"password" binding:"omitempty,max=256"`
+		Code     string `json:"code" binding:"required,max=32"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err, "BAD_REQUEST.code")
+		return
+	}
+
+	// 2. Call service
+	if err := h.svc.DisableTOTP(ctx, principalFrom(c), req.Password, req.Code); err != nil {
+		writeError(c, err, mfaMessages)
+		return
+	}
+
+	// 3. Write JSON
+	httpx.TryWriteJSON(c, ctx, 200, gin.H{"totp_enabled": false})
+}
+
+// HandleRegenerateRecoveryCodes Password may be omitted right after login on an account
+// without one
+func (h *meHandler) HandleRegenerateRecoveryCodes(c *gin.Context) {
+
+	// 0. Get context
+	ctx := c.Request.Context()
+
+	// 1. Bind JSON
+	var req struct {
+		Password string `json:"password" binding:"omitempty,max=256"`
+		Code     string `json:"code" binding:"required,max=32"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err, "BAD_REQUEST.code")
+		return
+	}
+
+	// 2. Call service
+	codes, err := h.svc.RegenerateRecoveryCodes(ctx, principalFrom(c), req.Password, req.Code)
+	if err != nil {
+		writeError(c, err, mfaMessages)
+		return
+	}
+
+	// 3. Write JSON
+	httpx.TryWriteJSON(c, ctx, 200, gin.H{"recovery_codes": codes})
+}
+
+// mfaMessages Wording of the two-factor endpoints
+var mfaMessages = services.Messages{
+	services.ErrBadRequest:   "BAD_REQUEST",
+	services.ErrUnauthorized: "UNAUTHORIZED.password_or_code",
+	services.ErrConflict:     "CONFLICT.mfa_enabled",
+	services.ErrNotFound:     "NOT_FOUND.mfa_setup",
+}
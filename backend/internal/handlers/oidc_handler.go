@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"backend/internal/pkg/httpx"
+	"backend/internal/services"
+	"errors"
+	"io"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleOIDCLogin Sign in with an ID token, e.g. from Sign in with Apple
+func (h *authHandler) HandleOIDCLogin(c *gin.Context) {
+
+	// 0. Get context
+	ctx := c.Request.Context()
+
+	// 1. Bind JSON
+	var req struct {
+		IDToken  string `json:"id_token" binding:"required"`
+		Nonce    string `json:"nonce" binding:"max=256"`
+		DeviceID string `json:"device_id" binding:"required,uuid4"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err, "BAD_REQUEST.sign_in")
+		return
+	}
+
+	// 2. Call service
+	resp, err := h.svc.LoginWithOIDC(ctx, c.Param("provider"), req.IDToken, req.Nonce, req.DeviceID)
+	if err != nil {
+		writeError(c, err, identityMessages.
+			With(services.ErrForbidden, "FORBIDDEN.identity").
+			With(services.ErrConflict, "CONFLICT.identity_email_exists"))
+		return
+	}
+
+	// 3. Write JSON: tokens, or the MFA challenge
+	httpx.TryWriteJSON(c, ctx, 200, loginBody(resp))
+}
+
+func (h *meHandler) HandleListIdentities(c *gin.Context) {
+	ctx := c.Request.Context()
+	items, err := h.svc.ListIdentities(ctx, principalFrom(c))
+	if err != nil {
+		writeError(c, err, identityMessages)
+		return
+	}
+	httpx.TryWriteJSON(c, ctx, 200, gin.H{"items": items})
+}
+
+// HandleLinkIdentity Password may be omitted right after login on an account without one
+func (h *meHandler) HandleLinkIdentity(c *gin.Context) {
+
+	// 0. Get context
+	ctx := c.Request.Context()
+
+	// 1. Bind JSON
+	var req struct {
+		IDToken  string `json:"id_token" binding:"required"`
+		Nonce    string `json:"nonce" binding:"max=256"`
+		Password string `json:"password" binding:"omitempty,max=256"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err, "BAD_REQUEST.sign_in")
+		return
+	}
+
+	// 2. Call service
+	info, err := h.svc.LinkIdentity(ctx, principalFrom(c), c.Param("provider"), req.IDToken, req.Nonce, req.Password)
+	if err != nil {
+		writeError(c, err, identityMessages.With(services.ErrConflict, "CONFLICT.identity_linked"))
+		return
+	}
+
+	// 3. Write JSON
+	httpx.TryWriteJSON(c, ctx, 201, info)
+}
+
+// HandleUnlinkIdentity Password may be omitted right after login on an account without one,
+// the body with it
+func (h *meHandler) HandleUnlinkIdentity(c *gin.Context) {
+
+	// 0. Get context
+	ctx := c.Request.Context()
+
+	// 1. Bind JSON
+	var req struct {
+		Password string `json:"password" binding:"omitempty,max=256"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeBindError(c, err, "BAD_REQUEST.password")
+		return
+	}
+
+	// 2. Call service
+	if err := h.svc.UnlinkIdentity(ctx, principalFrom(c), c.Param("provider"), req.Password); err != nil {
+		writeError(c, err, identityMessages.With(services.ErrConflict, "CONFLICT.last_sign_in_method"))
+		return
+	}
+	c.Status(204)
+}
+
+// identityMessages Wording of the sign-in provider endpoints
+var identityMessages = services.Messages{
+	services.ErrBadRequest:   "BAD_REQUEST.sign_in",
+	services.ErrUnauthorized: "UNAUTHORIZED.sign_in",
+	services.ErrNotFound:     "NOT_FOUND.provider",
+}
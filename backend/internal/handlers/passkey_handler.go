@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"backend/internal/pkg/httpx"
+	"backend/internal/services"
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleBeginPasskeyRegistration Password may be omitted right after login on an account
+// without one
+func (h *meHandler) HandleBeginPasskeyRegistration(c *gin.Context) {
+
+	// 0. Get context
+	ctx := c.Request.Context()
+
+	// 1. Bind JSON
+	var req struct {
+		Password string `json:"password" binding:"omitempty,max=256"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err, "BAD_REQUEST.password")
+		return
+	}
+
+	// 2. Call service
+	opts, err := h.svc.BeginPasskeyRegistration(ctx, principalFrom(c), req.Password)
+	if err != nil {
+		writeError(c, err, passkeyMessages)
+		return
+	}
+
+	// 3. Write JSON
+	httpx.TryWriteJSON(c, ctx, 200, opts)
+}
+
+func (h *meHandler) HandleFinishPasskeyRegistration(c *gin.Context) {
+
+	// 0. Get context
+	ctx := c.Request.Context()
+
+	// 1. Bind JSON
+	var req struct {
+		ChallengeID string                     `json:"challenge_id" binding:"required,uuid4"`
+		Name        string                     `json:"name" binding:"max=64"`
+		Credential  services.PasskeyCredential `json:"credential" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err, "BAD_REQUEST.passkey")
+		return
+	}
+
+	// 2. Call service
+	info, err := h.svc.FinishPasskeyRegistration(ctx, principalFrom(c), req.ChallengeID, req.Name, req.Credential)
+	if err != nil {
+		writeError(c, err, passkeyMessages)
+		return
+	}
+
+	// 3. Write JSON
+	httpx.TryWriteJSON(c, ctx, 201, info)
+}
+
+func (h *meHandler) HandleListPasskeys(c *gin.Context) {
+	ctx := c.Request.Context()
+	items, err := h.svc.ListPasskeys(ctx, principalFrom(c))
+	if err != nil {
+		writeError(c, err, passkeyMessages)
+		return
+	}
+	httpx.TryWriteJSON(c, ctx, 200, gin.H{"items": items})
+}
+
+// HandleDeletePasskey Password may be omitted right after login on an account without one,
+// the body with it
+func (h *meHandler) HandleDeletePasskey(c *gin.Context) {
+
+	// 0. Get context
+	ctx := c.Request.Context()
+
+	// 1. Parse id and bind JSON
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		httpx.WriteBadReq(c, "BAD_REQUEST.passkey_id")
+		return
+	}
+	var req struct {
+		Password string `json:"password" binding:"omitempty,max=256"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeBindError(c, err, "BAD_REQUEST.password")
+		return
+	}
+
+	// 2. Call service
+	if err := h.svc.DeletePasskey(ctx, principalFrom(c), id, req.Password); err != nil {
+		writeError(c, err, passkeyMessages.With(services.ErrUnauthorized, "UNAUTHORIZED.confirm_password"))
+		return
+	}
+	c.Status(204)
+}
+
+func (h *authHandler) HandleBeginPasskeyLogin(c *gin.Context) {
+	ctx := c.Request.Context()
+	opts, err := h.svc.BeginPasskeyLogin(ctx)
+	if err != nil {
+		writeError(c, err, passkeyMessages)
+		return
+	}
+	httpx.TryWriteJSON(c, ctx, 200, opts)
+}
+
+func (h *authHandler) HandleFinishPasskeyLogin(c *gin.Context) {
+
+	// 0. Get context
+	ctx := c.Request.Context()
+
+	// 1. Bind JSON
+	var req struct {
+		ChallengeID string                     `json:"challenge_id" binding:"required,uuid4"`
+		DeviceID    string                     `json:"device_id" binding:"required,uuid4"`
+		Credential  services.PasskeyCredential `json:"credential" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err, "BAD_REQUEST.passkey")
+		return
+	}
+
+	// 2. Call service
+	resp, err := h.svc.FinishPasskeyLogin(ctx, req.ChallengeID, req.DeviceID, req.Credential)
+	if err != nil {
+		writeError(c, err, passkeyMessages.With(services.ErrForbidden, "FORBIDDEN.locked"))
+		return
+	}
+
+	// 3. Write JSON: tokens, or the MFA challenge
+	httpx.TryWriteJSON(c, ctx, 200, loginBody(resp))
+}
+
+// passkeyMessages Wording of the passkey endpoints
+var passkeyMessages = services.Messages{
+	services.ErrBadRequest:   "BAD_REQUEST.passkey",
+	services.ErrUnauthorized: "UNAUTHORIZED.passkey",
+	services.ErrConflict:     "CONFLICT.passkey",
+	services.ErrNotFound:     "NOT_FOUND.passkey",
+}
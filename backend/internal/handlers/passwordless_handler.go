@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"backend/internal/pkg/httpx"
+	"backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleRequestLoginCode Email a sign-in code and magic link; answers the same for unknown emails
+func (h *authHandler) HandleRequestLoginCode(c *gin.Context) {
+
+	// 0. Get context
+	ctx := c.Request.Context()
+
+	// 1. Bind JSON
+	var req struct {
+		Email     string `json:"email" binding:"required,email,max=255"`
+		DeviceID  string `json:"device_id" binding:"required,uuid4"`
+		Challenge string `json:"challenge" binding:"max=1024"`
+		Solution  string `json:"solution" binding:"max=4096"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err, "BAD_REQUEST.email")
+		return
+	}
+
+	// 2. Check challenge
+	if err := h.challenge.Verify(ctx, c.ClientIP(), req.Challenge, req.Solution); err != nil {
+		writeError(c, err, nil)
+		return
+	}
+
+	// 3. Call service
+	codeID, err := h.svc.RequestLoginCode(ctx, req.Email, req.DeviceID)
+	if err != nil {
+		writeError(c, err, services.Messages{
+			services.ErrBadRequest: "BAD_REQUEST.email",
+		})
+		return
+	}
+
+	// 4. Write JSON
+	httpx.TryWriteJSON(c, ctx, 200, gin.H{
+		"code_id": codeID,
+	})
+}
+
+func (h *authHandler) HandleLoginWithCode(c *gin.Context) {
+
+	// 0. Get context
+	ctx := c.Request.Context()
+
+	// 1. Bind JSON
+	var req struct {
+		Email    string `json:"email" binding:"required,email,max=255"`
+		CodeID   string `json:"code_id" binding:"required,uuid4"`
+		Code     string `json:"code" binding:"required,len=6"`
+		DeviceID string `json:"device_id" binding:"required,uuid4"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err, "BAD_REQUEST.code_format")
+		return
+	}
+
+	// 2. Call service
+	resp, err := h.svc.LoginWithCode(ctx, req.Email, req.CodeID, req.Code, req.DeviceID)
+	if err != nil {
+		writeError(c, err, passwordlessMessages)
+		return
+	}
+
+	// 3. Write JSON: tokens, or the MFA challenge
+	httpx.TryWriteJSON(c, ctx, 200, loginBody(resp))
+}
+
+// HandleLoginWithMagicLink The app posts the token of the link it was opened with
+func (h *authHandler) HandleLoginWithMagicLink(c *gin.Context) {
+
+	// 0. Get context
+	ctx := c.Request.Context()
+
+	// 1. Bind JSON
+	var req struct {
+		Token    string `json:"token" binding:"required,max=2048"`
+		DeviceID string `json:"device_id" binding:"required,uuid4"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err, "BAD_REQUEST.sign_in_link")
+		return
+	}
+
+	// 2. Call service
+	resp, err := h.svc.LoginWithMagicLink(ctx, req.Token, req.DeviceID)
+	if err != nil {
+		writeError(c, err, passwordlessMessages)
+		return
+	}
+
+	// 3. Write JSON: tokens, or the MFA challenge
+	httpx.TryWriteJSON(c, ctx, 200, loginBody(resp))
+}
+
+// passwordlessMessages Wording of code and magic link sign-in
+var passwordlessMessages = services.Messages{
+	services.ErrBadRequest:   "BAD_REQUEST.sign_in",
+	services.ErrUnauthorized: "UNAUTHORIZED.login_link",
+	services.ErrForbidden:    "FORBIDDEN.locked",
+}
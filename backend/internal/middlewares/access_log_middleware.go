@@ -1,9 +1,116 @@
 package middlewares
 
-import "github.com/gin-gonic/gin"
+import (
+	"io"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ctxKeyUserID gin.Context key holding the authenticated user id
+const ctxKeyUserID = "user_id"
+
+type AccessLogConfig struct {
+	// Out Destination of access lines, defaults to stdout
+	Out io.Writer
+	// SkipPaths Route templates or raw paths that are never logged (health checks, metrics)
+	SkipPaths []string
+	// SlowThreshold Requests slower than this are logged as WARN, 0 disables
+	SlowThreshold time.Duration
+}
+
+func AccessLog(cfg AccessLogConfig) gin.HandlerFunc {
+	out := cfg.Out
+	if out == nil {
+		out = os.Stdout
+	}
+	logger := log.New(out, "", log.LstdFlags)
+	slowLevel := "[WARN] [SLOW]"
+	if isTerminal(out) {
+		slowLevel = "\033[33m[WARN]\033[0m [SLOW]"
+	}
+	skip := make(map[string]struct{}, len(cfg.SkipPaths))
+	for _, p := range cfg.SkipPaths {
+		skip[p] = struct{}{}
+	}
 
-func AccessLog() gin.HandlerFunc {
 	return func(c *gin.Context) {
+
+		// 1. Count request body
+		start := time.Now()
+		body := &countingReader{ReadCloser: c.Request.Body}
+		if c.Request.Body != nil {
+			c.Request.Body = body
+		}
+
 		c.Next()
+
+		// 2. Check skip list
+		route := c.FullPath()
+		if _, ok := skip[route]; ok {
+			return
+		}
+		if _, ok := skip[c.Request.URL.Path]; ok {
+			return
+		}
+		if route == "" {
+			route = "NOT_FOUND"
+		}
+
+		// 3. Write line
+		latency := time.Since(start)
+		level := "[ACCESS]"
+		if cfg.SlowThreshold > 0 && latency > cfg.SlowThreshold {
+			level = slowLevel
+		}
+		bytesIn := body.n.Load()
+		if bytesIn == 0 && c.Request.ContentLength > 0 {
+			bytesIn = c.Request.ContentLength
+		}
+		bytesOut := c.Writer.Size()
+		if bytesOut < 0 {
+			bytesOut = 0
+		}
+		uid, _ := c.Get(ctxKeyUserID)
+		if uid == nil {
+			uid = "-"
+		}
+		logger.Printf("%s request_id=%s method=%s route=%s status=%d latency=%s bytes_in=%d bytes_out=%d ip=%s ua=%q user_id=%v",
+			level,
+			c.GetString(headerXRequestID),
+			c.Request.Method,
+			route,
+			c.Writer.Status(),
+			latency,
+			bytesIn,
+			bytesOut,
+			c.ClientIP(),
+			c.Request.UserAgent(),
+			uid,
+		)
+	}
+}
+
+// isTerminal Color only goes to a terminal, never into a log file
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
 	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n atomic.Int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n.Add(int64(n))
+	return n, err
 }
@@ -0,0 +1,20 @@
+package middlewares
+
+import (
+	"backend/internal/pkg/client_info"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClientInfo Put the client IP and User-Agent into the request context for services
+func ClientInfo() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := client_info.With(c.Request.Context(), client_info.Info{
+			IP:        c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+		})
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+	}
+}
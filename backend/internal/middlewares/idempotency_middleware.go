@@ -0,0 +1,207 @@
+package middlewares
+
+import (
+	"backend/internal/config"
+	"backend/internal/pkg/ctx_util"
+	"backend/internal/pkg/httpx"
+	"backend/internal/pkg/idempotency"
+	"backend/internal/pkg/logx"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	headerIdempotencyKey = "Idempotency-Key"
+	// headerIdempotentReplayed Marks a response served from the store
+	headerIdempotentReplayed = "Idempotent-Replayed"
+)
+
+// Idempotency Builds the Idempotency-Key middleware of each route group, sharing one store
+type Idempotency struct {
+	store *idempotency.Store
+	cfg   config.Idempotency
+}
+
+func NewIdempotency(store *idempotency.Store, cfg config.Idempotency) *Idempotency {
+	return &Idempotency{store: store, cfg: cfg}
+}
+
+// Handle Honor Idempotency-Key on POST, PUT, PATCH and DELETE. The first request with a key
+// runs and its response is stored; a retry with the same body gets that response again, a
+// different body gets 409, and one sent while the first is running gets 409 with Retry-After.
+// 5xx, 429 and canceled requests are not stored, so they can be retried. Access and refresh
+// tokens are left out of the stored response, so a replay never hands out a session; routes
+// that answer with other credentials must not use it. Keys belong to the caller when signed
+// in; must run after AccessToken there. When Redis cannot be asked the request runs without
+// the key
+func (i *Idempotency) Handle() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(headerIdempotencyKey)
+		if !i.cfg.Enabled || key == "" || !isMutating(c.Request.Method) {
+			c.Next()
+			return
+		}
+		ctx := c.Request.Context()
+
+		// 1. Check key
+		if !isValidIdempotencyKey(key) {
+			httpx.WriteBadReq(c, "BAD_REQUEST.idempotency_key")
+			return
+		}
+
+		// 2. Read body, then put it back for the handler
+		var body []byte
+		if c.Request.Body != nil {
+			b, err := io.ReadAll(io.LimitReader(c.Request.Body, int64(i.cfg.MaxBodyBytes)+1))
+			if err != nil {
+				httpx.WriteBadReq(c, "BAD_REQUEST.body_unreadable")
+				return
+			}
+			if len(b) > i.cfg.MaxBodyBytes {
+				httpx.WriteBadReq(c, "BAD_REQUEST.idempotency_body_too_large")
+				return
+			}
+			body = b
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		// 3. Take the key
+		subject, fingerprint := idempotencyScope(c, body)
+		owner := uuid.NewString()
+		rec, ok, err := i.store.Begin(ctx, subject, key, fingerprint, owner, i.cfg.LockTTL)
+		if err != nil {
+			if ctx_util.IsCtxDone(ctx, err) {
+				httpx.WriteCtxError(c, ctx.Err())
+				return
+			}
+			logx.LogError(ctx, "Idempotency.Begin", err)
+			c.Next()
+			return
+		}
+
+		// 4. Taken already: replay, or tell why not
+		if !ok {
+			switch {
+			case rec.Fingerprint != fingerprint:
+				httpx.WriteProblem(c, http.StatusConflict, "IDEMPOTENCY_KEY_REUSED", "IDEMPOTENCY_KEY_REUSED", nil)
+			case !rec.Done():
+				c.Header("Retry-After", "1")
+				httpx.WriteProblem(c, http.StatusConflict, "IDEMPOTENCY_IN_PROGRESS", "IDEMPOTENCY_IN_PROGRESS", nil)
+			default:
+				c.Header(headerIdempotentReplayed, "true")
+				c.Data(rec.Status, rec.ContentType, rec.Body)
+				c.Abort()
+			}
+			return
+		}
+
+		// 5. Run the request, capturing its response
+		w := &captureWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+
+		// 6. Store it, or free the key for a retry; the request context may be over already
+		sctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 2*time.Second)
+		defer cancel()
+		status := w.Status()
+		if status >= 500 || status == http.StatusTooManyRequests || status == 499 {
+			err = i.store.Release(sctx, subject, key, owner)
+		} else {
+			contentType := w.Header().Get("Content-Type")
+			err = i.store.Complete(sctx, subject, key, owner, idempotency.Record{
+				Status:      status,
+				ContentType: contentType,
+				Body:        withoutTokens(contentType, w.body.Bytes()),
+			}, i.cfg.TTL)
+		}
+		if err != nil {
+			logx.LogError(ctx, "Idempotency.Store", err)
+		}
+	}
+}
+
+// idempotencyScope A signed-in caller's keys are their own. Anonymous keys are shared, so
+// the credentials sent, such as the one-time token of create-account, join the fingerprint
+func idempotencyScope(c *gin.Context, body []byte) (subject, fingerprint string) {
+	h := sha256.New()
+	h.Write([]byte(c.Request.Method + " " + c.FullPath() + "\n"))
+	subject = ByUser(c)
+	if subject == "" {
+		subject = "anon"
+		h.Write([]byte(c.GetHeader("Authorization") + "\n"))
+	}
+	h.Write(body)
+	return subject, hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotencyTokenFields Top-level response fields never stored
+var idempotencyTokenFields = []string{"access_token", "refresh_token"}
+
+// withoutTokens body minus idempotencyTokenFields when it is a JSON object holding any
+func withoutTokens(contentType string, body []byte) []byte {
+	if !strings.HasPrefix(contentType, "application/json") {
+		return body
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return body
+	}
+	n := len(fields)
+	for _, f := range idempotencyTokenFields {
+		delete(fields, f)
+	}
+	if len(fields) == n {
+		return body
+	}
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return body
+	}
+	return b
+}
+
+func isMutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func isValidIdempotencyKey(key string) bool {
+	if len(key) > 255 {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x21 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// captureWriter Copy of the body written through it
+type captureWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *captureWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -1,53 +1,34 @@
 package middlewares
 
 import (
-	"backend/internal/config"
 	"backend/internal/pkg/httpx"
 	"backend/internal/pkg/jwtx"
+	"backend/internal/services"
+	"context"
 	"errors"
-	"fmt"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 )
 
-func OneTimeToken() gin.HandlerFunc {
+func OneTimeToken(tokens *jwtx.Manager) gin.HandlerFunc {
 	return func(c *gin.Context) {
 
 		// 1. Extract Bearer
 		tokenStr, err := extractBearer(c)
 		if err != nil {
-			httpx.WriteUnauthorized(c, "Cannot find token")
+			httpx.WriteUnauthorized(c, "UNAUTHORIZED.token_missing")
 			return
 		}
 
-		// 2. Parse token
-		var claims jwtx.OTTClaims
-		token, err := jwt.ParseWithClaims(
-			tokenStr,
-			&claims,
-			func(t *jwt.Token) (any, error) {
-				if t.Method != jwt.SigningMethodHS256 {
-					return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
-				}
-				return config.C.JWT.KEY, nil
-			},
-		)
-		if err != nil || !token.Valid {
-			httpx.WriteUnauthorized(c, "Token is invalid or expired")
-			return
-		}
-
-		// 3. Verify Issuer and ExpireAt
-		now := time.Now()
-		if !verifyIssuer(&claims.RegisteredClaims, config.C.JWT.ISS) {
-			httpx.WriteUnauthorized(c, "Token is invalid")
-			return
-		}
-		if !verifyExpiresAt(&claims.RegisteredClaims, now) {
-			httpx.WriteUnauthorized(c, "Token expired")
+		// 2. Parse token: signature, issuer and expiry
+		claims, err := tokens.ParseOTT(tokenStr)
+		if err != nil {
+			if errors.Is(err, jwtx.ErrTokenExpired) {
+				httpx.WriteUnauthorized(c, "UNAUTHORIZED.token_expired")
+				return
+			}
+			httpx.WriteUnauthorized(c, "UNAUTHORIZED.token_invalid")
 			return
 		}
 
@@ -59,18 +40,43 @@ func OneTimeToken() gin.HandlerFunc {
 	}
 }
 
-func verifyIssuer(rc *jwt.RegisteredClaims, expected string) bool {
-	if rc == nil {
-		return false
-	}
-	return rc.Issuer == expected
+// ctxKeyPrincipal gin.Context key holding the services.Principal of the caller
+const ctxKeyPrincipal = "principal"
+
+// Authenticator Verifies an access token against the current user state
+type Authenticator interface {
+	Authenticate(ctx context.Context, atk string) (services.Principal, error)
 }
 
-func verifyExpiresAt(rc *jwt.RegisteredClaims, now time.Time) bool {
-	if rc == nil || rc.ExpiresAt == nil {
-		return false
+// AccessToken Require a valid access token whose user is neither deleted nor locked; the
+// user's chosen language, if any, replaces the negotiated one
+func AccessToken(auth Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		// 1. Extract Bearer
+		tokenStr, err := extractBearer(c)
+		if err != nil {
+			httpx.WriteUnauthorized(c, "UNAUTHORIZED.token_missing")
+			return
+		}
+
+		// 2. Verify token and user state
+		p, err := auth.Authenticate(c.Request.Context(), tokenStr)
+		if err != nil {
+			httpx.WriteError(c, services.AsAppError(c.Request.Context(), err, services.Messages{
+				services.ErrUnauthorized: "UNAUTHORIZED.token_invalid",
+			}))
+			return
+		}
+
+		c.Set(ctxKeyPrincipal, p)
+		c.Set(ctxKeyUserID, p.UserID)
+		if p.Locale != "" {
+			setLocale(c, p.Locale)
+		}
+
+		c.Next()
 	}
-	return rc.ExpiresAt.After(now)
 }
 
 func extractBearer(c *gin.Context) (string, error) {
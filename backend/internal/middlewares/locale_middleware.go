@@ -0,0 +1,23 @@
+package middlewares
+
+import (
+	"backend/internal/pkg/i18n"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Locale Answer in the language Accept-Language prefers among those we have, English
+// otherwise; a signed-in user's own choice replaces it in AccessToken
+func Locale() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Writer.Header().Add("Vary", "Accept-Language")
+		setLocale(c, i18n.Negotiate(c.GetHeader("Accept-Language")))
+
+		c.Next()
+	}
+}
+
+func setLocale(c *gin.Context, locale string) {
+	c.Request = c.Request.WithContext(i18n.With(c.Request.Context(), locale))
+	c.Header("Content-Language", locale)
+}
@@ -0,0 +1,191 @@
+package middlewares
+
+import (
+	"backend/internal/pkg/httpx"
+	"backend/internal/pkg/i18n"
+	"backend/internal/pkg/logx"
+	"backend/internal/pkg/openapi"
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openAPIMaxBody Largest request body checked; a larger one goes through unchecked
+const openAPIMaxBody = 1 << 20
+
+// OpenAPI Check each request and response of a documented route against spec. Mismatches
+// are logged; when strict, a request that does not match gets 400 CONTRACT_REQUEST_INVALID,
+// a response that does not match is replaced by 500 CONTRACT_RESPONSE_INVALID and a route
+// the document lacks gets 500 CONTRACT_UNDOCUMENTED. Server errors and canceled requests are
+// not part of the document and are let through. Strict buffers every response, so it is
+// meant for tests and cmd/contract
+func OpenAPI(spec *openapi.Spec, strict bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		route := c.FullPath()
+		if route == "" {
+			c.Next()
+			return
+		}
+
+		// 1. Find the operation
+		op := spec.Lookup(c.Request.Method, route)
+		if op == nil {
+			logx.LogWarn(ctx, "OpenAPI", c.Request.Method+" "+route+" is not documented")
+			if strict {
+				httpx.WriteProblem(c, http.StatusInternalServerError, "CONTRACT_UNDOCUMENTED", "CONTRACT_UNDOCUMENTED", nil)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		// 2. Check the request, putting the body back for the handler
+		body, complete, err := peekBody(c.Request)
+		if err != nil {
+			httpx.WriteBadReq(c, "BAD_REQUEST.body_unreadable")
+			return
+		}
+		if complete {
+			params := make(map[string]string, len(c.Params))
+			for _, p := range c.Params {
+				params[p.Key] = p.Value
+			}
+			if issues := op.ValidateRequest(c.Request, params, body); len(issues) > 0 {
+				logx.LogWarn(ctx, "OpenAPI.Request", op.String()+": "+joinIssues(issues))
+				if strict {
+					httpx.WriteProblem(c, http.StatusBadRequest, "CONTRACT_REQUEST_INVALID", "CONTRACT_REQUEST_INVALID", issues)
+					return
+				}
+			}
+		}
+
+		// 3. Run the request. Log mode passes the response through and looks at a copy;
+		//    strict holds it back until it is checked
+		if !strict {
+			w := &captureWriter{ResponseWriter: c.Writer}
+			c.Writer = w
+			c.Next()
+			if issues := checkResponse(op, w.Status(), w.Header(), w.body.Bytes()); len(issues) > 0 {
+				logx.LogWarn(ctx, "OpenAPI.Response", op.String()+": "+joinIssues(issues))
+			}
+			return
+		}
+		w := &bufferWriter{ResponseWriter: c.Writer, status: http.StatusOK}
+		c.Writer = w
+		func() {
+			// Hand the real writer back on a panic too, Recovery answers with it
+			defer func() { c.Writer = w.ResponseWriter }()
+			c.Next()
+		}()
+
+		// 4. Send it, or the violation in its place
+		issues := checkResponse(op, w.status, w.Header(), w.body.Bytes())
+		if len(issues) == 0 {
+			w.flush()
+			return
+		}
+		logx.LogWarn(ctx, "OpenAPI.Response", op.String()+": "+joinIssues(issues))
+		for _, h := range []string{"Content-Type", "Content-Disposition", "Content-Length"} {
+			c.Writer.Header().Del(h)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":    "CONTRACT_RESPONSE_INVALID",
+			"error":   i18n.T(i18n.From(ctx), "CONTRACT_RESPONSE_INVALID"),
+			"details": issues,
+		})
+	}
+}
+
+// peekBody The request body, read up to openAPIMaxBody and put back in place; complete is
+// false when there was more
+func peekBody(r *http.Request) (body []byte, complete bool, err error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true, nil
+	}
+	b, err := io.ReadAll(io.LimitReader(r.Body, openAPIMaxBody+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if len(b) > openAPIMaxBody {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(b), r.Body), r.Body}
+		return nil, false, nil
+	}
+	r.Body = io.NopCloser(bytes.NewReader(b))
+	return b, true, nil
+}
+
+func checkResponse(op *openapi.Operation, status int, header http.Header, body []byte) []openapi.Issue {
+	if status >= 500 || status == 499 {
+		return nil
+	}
+	return op.ValidateResponse(status, header, body)
+}
+
+func joinIssues(issues []openapi.Issue) string {
+	parts := make([]string, len(issues))
+	for i, is := range issues {
+		parts[i] = is.String()
+	}
+	return strings.Join(parts, "; ")
+}
+
+// bufferWriter Holds status and body back from the client until flush; headers go to the
+// real writer's map, which is not sent before then
+type bufferWriter struct {
+	gin.ResponseWriter
+	status  int
+	written bool
+	body    bytes.Buffer
+}
+
+func (w *bufferWriter) WriteHeader(code int) {
+	if code > 0 && !w.written {
+		w.status = code
+	}
+}
+
+func (w *bufferWriter) WriteHeaderNow() {
+	w.written = true
+}
+
+func (w *bufferWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return w.body.Write(b)
+}
+
+func (w *bufferWriter) WriteString(s string) (int, error) {
+	w.written = true
+	return w.body.WriteString(s)
+}
+
+func (w *bufferWriter) Status() int {
+	return w.status
+}
+
+func (w *bufferWriter) Size() int {
+	if !w.written {
+		return -1
+	}
+	return w.body.Len()
+}
+
+func (w *bufferWriter) Written() bool {
+	return w.written
+}
+
+// Flush Nothing reaches the client before the check
+func (w *bufferWriter) Flush() {}
+
+func (w *bufferWriter) flush() {
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.body.Len() > 0 {
+		w.ResponseWriter.Write(w.body.Bytes())
+	}
+}
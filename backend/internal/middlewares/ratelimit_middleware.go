@@ -0,0 +1,139 @@
+package middlewares
+
+import (
+	"backend/internal/config"
+	"backend/internal/pkg/ctx_util"
+	"backend/internal/pkg/httpx"
+	"backend/internal/pkg/logx"
+	"backend/internal/pkg/ratelimit"
+	"backend/internal/services"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimitKey Subject a request is counted under; "" leaves the request out of the policy
+type RateLimitKey func(c *gin.Context) string
+
+// ByIP Client address, read from X-Forwarded-For only behind TRUSTED_PROXIES
+func ByIP(c *gin.Context) string {
+	return c.ClientIP()
+}
+
+// ByUser Caller's user id, must run after AccessToken
+func ByUser(c *gin.Context) string {
+	v, ok := c.Get(ctxKeyPrincipal)
+	if !ok {
+		return ""
+	}
+	p, _ := v.(services.Principal)
+	if p.UserID == 0 {
+		return ""
+	}
+	return strconv.FormatUint(p.UserID, 10)
+}
+
+// ByRoute Method and route pattern, so /users/1 and /users/2 count together
+func ByRoute(c *gin.Context) string {
+	return c.Request.Method + " " + c.FullPath()
+}
+
+// KeyBy Count by all of keys at once, e.g. per IP and route
+func KeyBy(keys ...RateLimitKey) RateLimitKey {
+	return func(c *gin.Context) string {
+		parts := make([]string, 0, len(keys))
+		for _, k := range keys {
+			s := k(c)
+			if s == "" {
+				return ""
+			}
+			parts = append(parts, s)
+		}
+		return strings.Join(parts, "|")
+	}
+}
+
+// RateLimitRule A policy and whom it counts
+type RateLimitRule struct {
+	ratelimit.Policy
+	Key RateLimitKey
+}
+
+// RateLimiter Builds the rate limit middleware of each route, sharing one limiter
+type RateLimiter struct {
+	limiter   *ratelimit.Limiter
+	enabled   bool
+	failOpen  bool
+	onLimited func(c *gin.Context)
+}
+
+// NewRateLimiter onLimited, if set, runs on every 429, e.g. to count it against the client IP
+func NewRateLimiter(l *ratelimit.Limiter, cfg config.RateLimit, onLimited func(c *gin.Context)) *RateLimiter {
+	return &RateLimiter{limiter: l, enabled: cfg.Enabled, failOpen: cfg.FailOpen, onLimited: onLimited}
+}
+
+// Limit Reject with 429 once any rule is exhausted. RateLimit-* headers describe the rule
+// closest to its limit, Retry-After comes with a 429. When Redis cannot be asked the request
+// passes if the limiter fails open, and gets a 503 otherwise
+func (r *RateLimiter) Limit(rules ...RateLimitRule) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !r.enabled {
+			c.Next()
+			return
+		}
+		ctx := c.Request.Context()
+
+		var tightest *ratelimit.Result
+		var tightestRule RateLimitRule
+		for _, rule := range rules {
+			subject := rule.Key(c)
+			if subject == "" {
+				continue
+			}
+			res, err := r.limiter.Allow(ctx, rule.Policy, subject)
+			if err != nil {
+				if ctx_util.IsCtxDone(ctx, err) {
+					httpx.WriteCtxError(c, ctx.Err())
+					return
+				}
+				logx.LogError(ctx, "RateLimit."+rule.Name, err)
+				if r.failOpen {
+					continue
+				}
+				httpx.WriteUnavailable(c)
+				return
+			}
+			if !res.Allowed {
+				setRateLimitHeaders(c, rule.Policy, res)
+				c.Header("Retry-After", strconv.Itoa(max(1, ceilSeconds(res.RetryAfter))))
+				if r.onLimited != nil {
+					r.onLimited(c)
+				}
+				httpx.WriteTooManyReq(c)
+				return
+			}
+			if tightest == nil || res.Remaining < tightest.Remaining {
+				tightest, tightestRule = &res, rule
+			}
+		}
+		if tightest != nil {
+			setRateLimitHeaders(c, tightestRule.Policy, *tightest)
+		}
+		c.Next()
+	}
+}
+
+// setRateLimitHeaders Fields of the IETF RateLimit header draft, in seconds
+func setRateLimitHeaders(c *gin.Context, p ratelimit.Policy, res ratelimit.Result) {
+	c.Header("RateLimit-Limit", strconv.Itoa(res.Limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+	c.Header("RateLimit-Policy", strconv.Itoa(p.Limit)+";w="+strconv.Itoa(ceilSeconds(p.Window)))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
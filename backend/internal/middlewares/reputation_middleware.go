@@ -0,0 +1,23 @@
+package middlewares
+
+import (
+	"backend/internal/pkg/httpx"
+	"backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IPReputation Refuse a denied or banned client address with 403 before anything is done
+// for it; goes on every group an unauthenticated caller can reach
+func IPReputation(rep services.ReputationService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if err := rep.CheckIP(ctx, c.ClientIP()); err != nil {
+			httpx.WriteError(c, services.AsAppError(ctx, err, services.Messages{
+				services.ErrForbidden: "FORBIDDEN.network",
+			}))
+			return
+		}
+		c.Next()
+	}
+}
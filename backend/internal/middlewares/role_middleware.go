@@ -0,0 +1,26 @@
+package middlewares
+
+import (
+	"backend/internal/models"
+	"backend/internal/pkg/httpx"
+	"backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireScope Only let callers whose role grants scope through, must run after AccessToken
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		v, ok := c.Get(ctxKeyPrincipal)
+		if !ok {
+			httpx.WriteUnauthorized(c, "UNAUTHORIZED.token_missing")
+			return
+		}
+		p, _ := v.(services.Principal)
+		if !models.HasScope(p.Role, scope) {
+			httpx.WriteForbidden(c, "FORBIDDEN")
+			return
+		}
+		c.Next()
+	}
+}
@@ -7,9 +7,10 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func Timeout(d time.Duration) gin.HandlerFunc {
+// Timeout d is evaluated per request so the limit can be reloaded at runtime
+func Timeout(d func() time.Duration) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(c.Request.Context(), d)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), d())
 		defer cancel()
 		c.Request = c.Request.WithContext(ctx)
 		c.Next()
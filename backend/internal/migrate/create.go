@@ -0,0 +1,38 @@
+package migrate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var nameRe = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// Create Write an empty up/down pair numbered after the newest file in dir
+func Create(dir, name string) (string, string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !nameRe.MatchString(name) {
+		return "", "", fmt.Errorf("migration name %q must match %s", name, nameRe)
+	}
+	existing, err := Load(os.DirFS(dir), ".")
+	if err != nil {
+		return "", "", err
+	}
+	next := uint64(1)
+	if n := len(existing); n > 0 {
+		next = existing[n-1].Version + 1
+	}
+
+	base := fmt.Sprintf("%04d_%s", next, name)
+	up := filepath.Join(dir, base+".up.sql")
+	down := filepath.Join(dir, base+".down.sql")
+	if err := os.WriteFile(up, []byte("-- "+name+"\n"), 0o644); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(down, []byte("-- revert "+name+"\n"), 0o644); err != nil {
+		return "", "", err
+	}
+	return up, down, nil
+}
@@ -0,0 +1,440 @@
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var embedded embed.FS
+
+const (
+	lockName    = "schema_migrations"
+	lockTimeout = 30 // seconds
+)
+
+var (
+	ErrLocked  = errors.New("another migration holds the lock")
+	ErrDirty   = errors.New("database is dirty, a previous migration failed halfway")
+	ErrDrift   = errors.New("applied migrations differ from embedded ones")
+	ErrUnknown = errors.New("unknown migration version")
+)
+
+var fileRe = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration A numbered up/down pair
+type Migration struct {
+	Version  uint64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// Status State of one version, as shown by `migrate status`
+type Status struct {
+	Version   uint64
+	Name      string
+	State     string // applied | pending | drift | missing | dirty
+	AppliedAt *time.Time
+}
+
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// New Migrator over the migrations embedded in the binary
+func New(db *sql.DB) (*Migrator, error) {
+	ms, err := Load(embedded, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: ms}, nil
+}
+
+// Load Read <version>_<name>.(up|down).sql pairs from dir
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[uint64]*Migration{}
+	for _, e := range entries {
+		m := fileRe.FindStringSubmatch(e.Name())
+		if m == nil {
+			continue
+		}
+		v, _ := strconv.ParseUint(m[1], 10, 64)
+		body, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		mig, ok := byVersion[v]
+		if !ok {
+			mig = &Migration{Version: v, Name: m[2]}
+			byVersion[v] = mig
+		}
+		if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", v, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(body)
+			sum := sha256.Sum256(body)
+			mig.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			mig.Down = string(body)
+		}
+	}
+
+	out := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", mig.Version, mig.Name)
+		}
+		out = append(out, *mig)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// Migrations Embedded migrations in version order
+func (m *Migrator) Migrations() []Migration {
+	return m.migrations
+}
+
+// Latest Highest embedded version, 0 if none
+func (m *Migrator) Latest() uint64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Up Apply every pending migration
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.To(ctx, m.Latest())
+}
+
+// Down Roll back the last n applied migrations
+func (m *Migrator) Down(ctx context.Context, n int) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.check(ctx, conn)
+		if err != nil {
+			return err
+		}
+		versions := sortedVersions(applied)
+		for i := 0; i < n && i < len(versions); i++ {
+			if err := m.down(ctx, conn, versions[len(versions)-1-i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// To Migrate up or down until target is the newest applied version
+func (m *Migrator) To(ctx context.Context, target uint64) error {
+	if target != 0 && m.find(target) == nil {
+		return fmt.Errorf("%w: %d", ErrUnknown, target)
+	}
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.check(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		// 1. Roll back anything newer than target, newest first
+		versions := sortedVersions(applied)
+		for i := len(versions) - 1; i >= 0; i-- {
+			if versions[i] > target {
+				if err := m.down(ctx, conn, versions[i]); err != nil {
+					return err
+				}
+			}
+		}
+
+		// 2. Apply pending versions up to target, oldest first
+		for _, mig := range m.migrations {
+			if mig.Version > target {
+				break
+			}
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			if err := m.up(ctx, conn, mig); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Status Compare the embedded migrations with schema_migrations
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	if err := m.ensureTable(ctx, m.db); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(ctx, m.db)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Status
+	for _, mig := range m.migrations {
+		st := Status{Version: mig.Version, Name: mig.Name, State: "pending"}
+		if a, ok := applied[mig.Version]; ok {
+			st.AppliedAt = &a.appliedAt
+			switch {
+			case a.dirty:
+				st.State = "dirty"
+			case a.checksum != mig.Checksum:
+				st.State = "drift"
+			default:
+				st.State = "applied"
+			}
+			delete(applied, mig.Version)
+		}
+		out = append(out, st)
+	}
+	for v, a := range applied {
+		at := a.appliedAt
+		out = append(out, Status{Version: v, Name: a.name, State: "missing", AppliedAt: &at})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// Force Mark version as cleanly applied after the schema was fixed by hand
+func (m *Migrator) Force(ctx context.Context, version uint64) error {
+	mig := m.find(version)
+	if mig == nil {
+		return fmt.Errorf("%w: %d", ErrUnknown, version)
+	}
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		_, err := conn.ExecContext(ctx, `
+			INSERT INTO schema_migrations (version, name, checksum, dirty) VALUES (?, ?, ?, 0)
+			ON DUPLICATE KEY UPDATE name = VALUES(name), checksum = VALUES(checksum), dirty = 0`,
+			mig.Version, mig.Name, mig.Checksum)
+		return err
+	})
+}
+
+type appliedRow struct {
+	name      string
+	checksum  string
+	dirty     bool
+	appliedAt time.Time
+}
+
+// check Refuse to move while dirty or drifted
+func (m *Migrator) check(ctx context.Context, conn *sql.Conn) (map[uint64]appliedRow, error) {
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	for v, a := range applied {
+		if a.dirty {
+			return nil, fmt.Errorf("%w: version %d, fix the schema by hand then run `migrate force %d`", ErrDirty, v, v)
+		}
+		mig := m.find(v)
+		if mig == nil {
+			return nil, fmt.Errorf("%w: version %d_%s is applied but not embedded", ErrDrift, v, a.name)
+		}
+		if mig.Checksum != a.checksum {
+			return nil, fmt.Errorf("%w: version %d_%s checksum changed since it was applied", ErrDrift, v, a.name)
+		}
+	}
+	return applied, nil
+}
+
+func (m *Migrator) up(ctx context.Context, conn *sql.Conn, mig Migration) error {
+	if _, err := conn.ExecContext(ctx,
+		`INSERT INTO schema_migrations (version, name, checksum, dirty) VALUES (?, ?, ?, 1)`,
+		mig.Version, mig.Name, mig.Checksum); err != nil {
+		return fmt.Errorf("record %d_%s: %w", mig.Version, mig.Name, err)
+	}
+	if err := execScript(ctx, conn, mig.Up); err != nil {
+		return fmt.Errorf("apply %d_%s: %w", mig.Version, mig.Name, err)
+	}
+	if _, err := conn.ExecContext(ctx,
+		`UPDATE schema_migrations SET dirty = 0, applied_at = CURRENT_TIMESTAMP WHERE version = ?`, mig.Version); err != nil {
+		return fmt.Errorf("record %d_%s: %w", mig.Version, mig.Name, err)
+	}
+	return nil
+}
+
+func (m *Migrator) down(ctx context.Context, conn *sql.Conn, version uint64) error {
+	mig := m.find(version)
+	if mig == nil || mig.Down == "" {
+		return fmt.Errorf("migration %d has no down file", version)
+	}
+	if _, err := conn.ExecContext(ctx, `UPDATE schema_migrations SET dirty = 1 WHERE version = ?`, version); err != nil {
+		return fmt.Errorf("record %d_%s: %w", mig.Version, mig.Name, err)
+	}
+	if err := execScript(ctx, conn, mig.Down); err != nil {
+		return fmt.Errorf("revert %d_%s: %w", mig.Version, mig.Name, err)
+	}
+	if _, err := conn.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = ?`, version); err != nil {
+		return fmt.Errorf("record %d_%s: %w", mig.Version, mig.Name, err)
+	}
+	return nil
+}
+
+// withLock Run fn on one connection holding a MySQL advisory lock, so concurrent deploys queue up
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	var got sql.NullInt64
+	if err := conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, ?)`, lockName, lockTimeout).Scan(&got); err != nil {
+		return fmt.Errorf("acquire migration lock: %w", err)
+	}
+	if !got.Valid || got.Int64 != 1 {
+		return ErrLocked
+	}
+	defer func() {
+		_, _ = conn.ExecContext(context.Background(), `SELECT RELEASE_LOCK(?)`, lockName)
+	}()
+
+	if err := m.ensureTable(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func (m *Migrator) ensureTable(ctx context.Context, db execer) error {
+	const ddl = `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    BIGINT UNSIGNED PRIMARY KEY,
+			name       VARCHAR(255) NOT NULL,
+			checksum   CHAR(64)     NOT NULL,
+			dirty      TINYINT(1)   NOT NULL DEFAULT 0,
+			applied_at TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP
+		) ENGINE=InnoDB`
+	if _, err := db.ExecContext(ctx, ddl); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+	return nil
+}
+
+func (m *Migrator) applied(ctx context.Context, db execer) (map[uint64]appliedRow, error) {
+	rows, err := db.QueryContext(ctx, `SELECT version, name, checksum, dirty, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("read schema_migrations: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	out := map[uint64]appliedRow{}
+	for rows.Next() {
+		var v uint64
+		var a appliedRow
+		if err := rows.Scan(&v, &a.name, &a.checksum, &a.dirty, &a.appliedAt); err != nil {
+			return nil, fmt.Errorf("read schema_migrations: %w", err)
+		}
+		out[v] = a
+	}
+	return out, rows.Err()
+}
+
+func (m *Migrator) find(version uint64) *Migration {
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			return &m.migrations[i]
+		}
+	}
+	return nil
+}
+
+func sortedVersions(applied map[uint64]appliedRow) []uint64 {
+	out := make([]uint64, 0, len(applied))
+	for v := range applied {
+		out = append(out, v)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+// execScript Run each statement separately, the driver has multiStatements off
+func execScript(ctx context.Context, conn *sql.Conn, script string) error {
+	for _, stmt := range SplitStatements(script) {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("%w\n--- statement ---\n%s", err, stmt)
+		}
+	}
+	return nil
+}
+
+// SplitStatements Split on ';' outside quotes and comments, dropping empty statements
+func SplitStatements(script string) []string {
+	var (
+		out   []string
+		cur   strings.Builder
+		quote rune
+	)
+	runes := []rune(script)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote != 0:
+			cur.WriteRune(r)
+			if r == '\\' && i+1 < len(runes) {
+				i++
+				cur.WriteRune(runes[i])
+			} else if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+			cur.WriteRune(r)
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+			cur.WriteRune('\n')
+		case r == '#':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+			cur.WriteRune('\n')
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			i += 2
+			for i+1 < len(runes) && !(runes[i] == '*' && runes[i+1] == '/') {
+				i++
+			}
+			i++
+		case r == ';':
+			if s := strings.TrimSpace(cur.String()); s != "" {
+				out = append(out, s)
+			}
+			cur.Reset()
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if s := strings.TrimSpace(cur.String()); s != "" {
+		out = append(out, s)
+	}
+	return out
+}
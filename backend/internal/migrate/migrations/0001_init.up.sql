@@ -1,13 +1,5 @@
--- Basic Settings
-SET NAMES utf8mb4;
-SET time_zone = '+00:00';
+-- Initial schema: users, devices and refresh-token sessions
 
--- Database
-DROP DATABASE IF EXISTS app;
-CREATE DATABASE app CHARACTER SET utf8mb4 COLLATE utf8mb4_0900_ai_ci;
-USE app;
-
--- Tables
 CREATE TABLE users (
     -- Basics
     id              BIGINT UNSIGNED AUTO_INCREMENT,
@@ -24,7 +16,7 @@ CREATE TABLE users (
     -- Constraints
     PRIMARY KEY (id),
     CONSTRAINT uk_users_email UNIQUE (email)
-) ENGINE=InnoDB;
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
 
 CREATE TABLE user_devices (
     -- Basics
@@ -40,7 +32,7 @@ CREATE TABLE user_devices (
     UNIQUE KEY uk_user_device (user_id, device_id),
     CONSTRAINT fk_ud_user FOREIGN KEY (user_id) REFERENCES users(id)
         ON DELETE CASCADE
-) ENGINE=InnoDB;
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
 
 CREATE INDEX idx_ud_user ON user_devices(user_id);
 CREATE INDEX idx_ud_last_seen ON user_devices(last_seen_at);
@@ -64,4 +56,4 @@ CREATE TABLE sessions (
     REFERENCES user_devices (user_id, device_id)
         ON DELETE CASCADE
         ON UPDATE CASCADE
-) ENGINE=InnoDB;
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS auth_events;
+DROP TABLE IF EXISTS admin_audit;
+
+ALTER TABLE users
+    DROP COLUMN locked_reason,
+    DROP COLUMN locked_at,
+    DROP COLUMN role;
@@ -0,0 +1,47 @@
+-- Roles and account locking for the admin API
+
+ALTER TABLE users
+    ADD COLUMN role          ENUM('user', 'support', 'admin') NOT NULL DEFAULT 'user' AFTER profile_photo,
+    ADD COLUMN locked_at     TIMESTAMP NULL AFTER is_deleted,
+    ADD COLUMN locked_reason VARCHAR(512) NULL AFTER locked_at;
+
+-- Every admin action: who did what to whom and why
+CREATE TABLE admin_audit (
+    -- Basics
+    id             BIGINT UNSIGNED AUTO_INCREMENT,
+    actor_id       BIGINT UNSIGNED NULL,
+    actor          VARCHAR(255) NOT NULL,
+    action         VARCHAR(64)  NOT NULL,
+    target_user_id BIGINT UNSIGNED NULL,
+    reason         VARCHAR(512) NOT NULL DEFAULT '',
+    detail         VARCHAR(255) NULL,
+    ip             VARCHAR(45)  NULL,
+    -- Auto
+    created_at     TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+    -- Constraints
+    PRIMARY KEY (id),
+    KEY idx_audit_target (target_user_id, created_at),
+    KEY idx_audit_actor (actor_id, created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+-- Append-only authentication events, no FK so rows outlive the user until purged
+CREATE TABLE auth_events (
+    -- Basics
+    id          BIGINT UNSIGNED AUTO_INCREMENT,
+    user_id     BIGINT UNSIGNED NULL,
+    email       VARCHAR(255) NULL,
+    event_type  VARCHAR(64)  NOT NULL,
+    success     TINYINT(1)   NOT NULL DEFAULT 1,
+    -- Record
+    ip          VARCHAR(45)  NULL,
+    device_id   BINARY(16)   NULL,
+    user_agent  VARCHAR(512) NULL,
+    request_id  VARCHAR(64)  NULL,
+    detail      VARCHAR(255) NULL,
+    -- Auto
+    created_at  TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+    -- Constraints
+    PRIMARY KEY (id),
+    KEY idx_ae_user_created (user_id, created_at),
+    KEY idx_ae_created (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
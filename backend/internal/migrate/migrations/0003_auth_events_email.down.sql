@@ -0,0 +1 @@
+DROP INDEX idx_ae_email_created ON auth_events;
@@ -0,0 +1,2 @@
+-- Events recorded before the user is known (code requests) are found by email
+CREATE INDEX idx_ae_email_created ON auth_events (email, created_at);
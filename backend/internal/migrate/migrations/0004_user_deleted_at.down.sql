@@ -0,0 +1,3 @@
+ALTER TABLE users
+    DROP INDEX idx_users_deleted,
+    DROP COLUMN deleted_at;
@@ -0,0 +1,7 @@
+-- Soft deletion with a grace period: purge runs once deleted_at is old enough
+
+ALTER TABLE users
+    ADD COLUMN deleted_at TIMESTAMP NULL AFTER is_deleted,
+    ADD INDEX idx_users_deleted (is_deleted, deleted_at);
+
+UPDATE users SET deleted_at = updated_at WHERE is_deleted = 1 AND deleted_at IS NULL;
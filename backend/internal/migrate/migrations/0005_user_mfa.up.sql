@@ -0,0 +1,33 @@
+-- TOTP second factor: the sealed secret per user and single-use recovery codes
+
+CREATE TABLE user_mfa (
+    -- Basics
+    user_id      BIGINT UNSIGNED NOT NULL,
+    -- AES-GCM sealed base32 secret, nonce first
+    secret_enc   VARBINARY(255) NOT NULL,
+    -- NULL while enrollment waits for the first code
+    enabled_at   TIMESTAMP NULL,
+    -- Auto
+    created_at   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    -- Constraints
+    PRIMARY KEY (user_id),
+    CONSTRAINT fk_mfa_user FOREIGN KEY (user_id) REFERENCES users(id)
+        ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+CREATE TABLE mfa_recovery_codes (
+    -- Basics
+    id           BIGINT UNSIGNED AUTO_INCREMENT,
+    user_id      BIGINT UNSIGNED NOT NULL,
+    -- SHA-256 of the normalized code
+    code_hash    BINARY(32) NOT NULL,
+    used_at      TIMESTAMP NULL,
+    -- Auto
+    created_at   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    -- Constraints
+    PRIMARY KEY (id),
+    UNIQUE KEY uk_recovery_user_code (user_id, code_hash),
+    CONSTRAINT fk_recovery_user FOREIGN KEY (user_id) REFERENCES users(id)
+        ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
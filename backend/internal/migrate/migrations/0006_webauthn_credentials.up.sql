@@ -0,0 +1,28 @@
+-- Passkeys: one row per WebAuthn credential
+
+CREATE TABLE webauthn_credentials (
+    -- Basics
+    id               BIGINT UNSIGNED AUTO_INCREMENT,
+    user_id          BIGINT UNSIGNED NOT NULL,
+    credential_id    VARBINARY(1023) NOT NULL,
+    -- COSE_Key as sent by the authenticator
+    public_key       VARBINARY(2048) NOT NULL,
+    alg              INT NOT NULL,
+    aaguid           BINARY(16) NULL,
+    name             VARCHAR(64) NOT NULL,
+    transports       VARCHAR(255) NOT NULL DEFAULT '',
+    -- Record
+    sign_count       INT UNSIGNED NOT NULL DEFAULT 0,
+    backup_eligible  TINYINT(1) NOT NULL DEFAULT 0,
+    backed_up        TINYINT(1) NOT NULL DEFAULT 0,
+    last_used_at     TIMESTAMP NULL,
+    -- Auto
+    created_at       TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    -- Constraints
+    PRIMARY KEY (id),
+    CONSTRAINT uk_webauthn_credential UNIQUE (credential_id),
+    CONSTRAINT fk_webauthn_user FOREIGN KEY (user_id) REFERENCES users(id)
+        ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+CREATE INDEX idx_webauthn_user ON webauthn_credentials(user_id);
@@ -0,0 +1,23 @@
+-- Federated sign-in: one row per (issuer, subject) linked to a user
+
+CREATE TABLE user_identities (
+    -- Basics
+    id               BIGINT UNSIGNED AUTO_INCREMENT,
+    user_id          BIGINT UNSIGNED NOT NULL,
+    provider         VARCHAR(32)  NOT NULL,
+    issuer           VARCHAR(255) NOT NULL,
+    subject          VARCHAR(255) NOT NULL,
+    -- Email the provider last reported, may be an Apple private relay address
+    email            VARCHAR(255) NULL,
+    is_private_email TINYINT(1) NOT NULL DEFAULT 0,
+    -- Record
+    last_login_at    TIMESTAMP NULL,
+    -- Auto
+    created_at       TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    -- Constraints
+    PRIMARY KEY (id),
+    CONSTRAINT uk_identity_subject UNIQUE (issuer, subject),
+    CONSTRAINT uk_identity_user_provider UNIQUE (user_id, provider),
+    CONSTRAINT fk_identity_user FOREIGN KEY (user_id) REFERENCES users(id)
+        ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
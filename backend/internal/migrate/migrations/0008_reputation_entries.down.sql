@@ -0,0 +1 @@
+DROP TABLE IF EXISTS reputation_entries;
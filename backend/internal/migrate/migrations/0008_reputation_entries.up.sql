@@ -0,0 +1,20 @@
+-- Admin-managed allow and deny entries for IP ranges and email domains, layered over the
+-- lists loaded from config files
+
+CREATE TABLE reputation_entries (
+    -- Basics
+    id          BIGINT UNSIGNED AUTO_INCREMENT,
+    kind        ENUM('ip', 'domain') NOT NULL,
+    -- CIDR for ip, a domain for domain; a domain entry covers its subdomains
+    value       VARCHAR(255) NOT NULL,
+    action      ENUM('allow', 'deny') NOT NULL,
+    reason      VARCHAR(512) NOT NULL DEFAULT '',
+    created_by  VARCHAR(255) NOT NULL,
+    -- Record
+    expires_at  TIMESTAMP NULL,
+    -- Auto
+    created_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    -- Constraints
+    PRIMARY KEY (id),
+    CONSTRAINT uk_reputation_value UNIQUE (kind, value)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
@@ -0,0 +1,4 @@
+-- Preferred language of a user, a BCP 47 tag such as zh-CN; NULL follows Accept-Language
+
+ALTER TABLE users
+    ADD COLUMN locale VARCHAR(16) NULL AFTER role;
@@ -0,0 +1,59 @@
+package models
+
+import "time"
+
+// AdminAudit One row of the admin audit trail
+type AdminAudit struct {
+	ID           uint64
+	ActorID      *uint64
+	Actor        string
+	Action       string
+	TargetUserID *uint64
+	Reason       string
+	// Detail Action parameters such as a search query or device id
+	Detail    *string
+	IP        *string
+	CreatedAt time.Time
+}
+
+// AuthEvent One row of auth_events
+type AuthEvent struct {
+	ID        uint64
+	UserID    *uint64
+	Email     *string
+	Type      string
+	Success   bool
+	IP        *string
+	DeviceID  []byte
+	UserAgent *string
+	RequestID *string
+	Detail    *string
+	CreatedAt time.Time
+}
+
+// Auth event types
+const (
+	EventLoginSuccess     = "login.success"
+	EventLoginFailure     = "login.failure"
+	EventCodeRequested    = "code.requested"
+	EventCodeVerifyFailed = "code.verify_failed"
+	EventAccountCreated   = "account.created"
+	EventAccountDeleted   = "account.deleted"
+	EventAccountRestored  = "account.restored"
+	EventEmailChanged     = "email.changed"
+	EventEmailReverted    = "email.reverted"
+	EventPasswordChanged  = "password.changed"
+	EventMFAEnabled       = "mfa.enabled"
+	EventMFADisabled      = "mfa.disabled"
+	EventMFAFailed        = "mfa.verify_failed"
+	EventMFARecoveryUsed  = "mfa.recovery_used"
+	EventMFARecoveryReset = "mfa.recovery_regenerated"
+	EventPasskeyAdded     = "passkey.added"
+	EventPasskeyRemoved   = "passkey.removed"
+	EventIdentityLinked   = "identity.linked"
+	EventIdentityUnlinked = "identity.unlinked"
+	EventSessionCreated   = "session.created"
+	EventSessionRevoked   = "session.revoked"
+	EventThrottled        = "throttle.tripped"
+	EventIPBanned         = "ip.banned"
+)
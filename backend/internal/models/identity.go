@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// UserIdentity One row of user_identities, an external account that can sign in as the user
+type UserIdentity struct {
+	ID             uint64
+	UserID         uint64
+	Provider       string
+	Issuer         string
+	Subject        string
+	Email          *string
+	IsPrivateEmail bool
+	LastLoginAt    *time.Time
+	CreatedAt      time.Time
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// UserMFA One row of user_mfa
+type UserMFA struct {
+	UserID    uint64
+	SecretEnc []byte
+	EnabledAt *time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// MFAChallenge What a login that passed the password step is waiting to finish, kept in Redis
+type MFAChallenge struct {
+	UserID   uint64 `json:"uid"`
+	Email    string `json:"email"`
+	DeviceID string `json:"did"`
+	// Via login | restore
+	Via string `json:"via"`
+}
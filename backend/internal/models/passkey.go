@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// PasskeyCredential One row of webauthn_credentials
+type PasskeyCredential struct {
+	ID             uint64
+	UserID         uint64
+	CredentialID   []byte
+	PublicKey      []byte
+	Alg            int64
+	AAGUID         []byte
+	Name           string
+	Transports     string
+	SignCount      uint32
+	BackupEligible bool
+	BackedUp       bool
+	LastUsedAt     *time.Time
+	CreatedAt      time.Time
+}
+
+// WebAuthnChallenge A pending ceremony, kept in Redis until it is finished or expires
+type WebAuthnChallenge struct {
+	Challenge []byte `json:"challenge"`
+	// UserID Set for registration, 0 for a passkey login
+	UserID uint64 `json:"uid,omitempty"`
+	// Type register | login
+	Type string `json:"type"`
+}
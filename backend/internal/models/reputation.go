@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// ReputationEntry One row of reputation_entries
+type ReputationEntry struct {
+	ID uint64 `json:"id"`
+	// Kind ip | domain
+	Kind string `json:"kind"`
+	// Value CIDR, or a domain covering its subdomains
+	Value string `json:"value"`
+	// Action allow | deny
+	Action    string     `json:"action"`
+	Reason    string     `json:"reason"`
+	CreatedBy string     `json:"created_by"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
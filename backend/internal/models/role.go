@@ -0,0 +1,37 @@
+package models
+
+const (
+	RoleUser    = "user"
+	RoleSupport = "support"
+	RoleAdmin   = "admin"
+)
+
+// Scopes granted to staff roles on the admin API
+const (
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+	ScopeEventsRead = "events:read"
+	ScopeAuditRead  = "audit:read"
+	// ScopeReputationWrite Manage IP and email-domain allow and deny entries
+	ScopeReputationWrite = "reputation:write"
+)
+
+var roleScopes = map[string][]string{
+	RoleSupport: {ScopeUsersRead, ScopeEventsRead},
+	RoleAdmin:   {ScopeUsersRead, ScopeUsersWrite, ScopeEventsRead, ScopeAuditRead, ScopeReputationWrite},
+}
+
+// IsRole Report whether role is one of the roles above
+func IsRole(role string) bool {
+	return role == RoleUser || role == RoleSupport || role == RoleAdmin
+}
+
+// HasScope Report whether role grants scope
+func HasScope(role, scope string) bool {
+	for _, s := range roleScopes[role] {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
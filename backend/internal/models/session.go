@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// Session A refresh-token session joined with its device
+type Session struct {
+	SessionID  uint64
+	UserID     uint64
+	DeviceID   []byte
+	TokenV     uint
+	ExpiresAt  time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	LastSeenAt *time.Time
+	PushToken  *string
+	// DeviceRevokedAt user_devices.revoked_at
+	DeviceRevokedAt *time.Time
+}
+
+// Device One row of user_devices
+type Device struct {
+	DeviceID   []byte
+	UserID     uint64
+	PushToken  *string
+	LastSeenAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+}
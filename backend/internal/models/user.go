@@ -1,8 +1,34 @@
 package models
 
+import "time"
+
 type User struct {
-	UserID  uint64
-	Email   string
-	PwdHash string
-	TokenV  uint
+	UserID   uint64
+	Email    string
+	PwdHash  string
+	TokenV   uint
+	Username string
+	Role     string
+	// Locale Preferred language, nil to follow Accept-Language
+	Locale       *string
+	IsDeleted    bool
+	DeletedAt    *time.Time
+	LockedAt     *time.Time
+	LockedReason *string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// EmailRevert Pending undo of an email change, kept in Redis until used or expired
+type EmailRevert struct {
+	UserID   uint64 `json:"user_id"`
+	OldEmail string `json:"old_email"`
+	NewEmail string `json:"new_email"`
+}
+
+// LoginRequest A pending passwordless login, kept in Redis under its code id; the code and
+// the magic link both redeem it, on the device that asked for it
+type LoginRequest struct {
+	Email    string `json:"email"`
+	DeviceID string `json:"did"`
 }
@@ -0,0 +1,134 @@
+// Package challenge Work a client does before an unauthenticated request that costs us
+// something: a hashcash-style proof of work signed by the server, or a third-party CAPTCHA
+package challenge
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalid Token not ours, tampered with or expired, or a solution that misses the target
+	ErrInvalid = errors.New("challenge: invalid")
+	// ErrRejected The CAPTCHA provider did not accept the response
+	ErrRejected = errors.New("challenge: captcha rejected")
+)
+
+// maxNonceLen Longer nonces are refused before hashing
+const maxNonceLen = 64
+
+var b64 = base64.RawURLEncoding
+
+// Puzzle An issued proof-of-work challenge: find a nonce such that
+// SHA-256(Token + ":" + nonce) starts with Difficulty zero bits
+type Puzzle struct {
+	Token      string
+	Difficulty int
+	ExpiresAt  time.Time
+}
+
+// claims Signed body of a token
+type claims struct {
+	ID         string `json:"id"`
+	Difficulty int    `json:"d"`
+	Exp        int64  `json:"exp"`
+}
+
+// PoW Issues and checks proof-of-work challenges; the token carries its own difficulty and
+// expiry under an HMAC, so any instance sharing the key can check it
+type PoW struct {
+	key []byte
+	ttl time.Duration
+}
+
+func NewPoW(key []byte, ttl time.Duration) *PoW {
+	return &PoW{key: key, ttl: ttl}
+}
+
+// Issue A fresh challenge at difficulty
+func (p *PoW) Issue(difficulty int, now time.Time) (Puzzle, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return Puzzle{}, err
+	}
+	exp := now.Add(p.ttl)
+	body, err := json.Marshal(claims{ID: hex.EncodeToString(id), Difficulty: difficulty, Exp: exp.Unix()})
+	if err != nil {
+		return Puzzle{}, err
+	}
+	payload := b64.EncodeToString(body)
+	return Puzzle{Token: payload + "." + b64.EncodeToString(p.sign(payload)), Difficulty: difficulty, ExpiresAt: exp}, nil
+}
+
+// Check Verify token and nonce. Returns the challenge id, which the caller marks used, and
+// its expiry, until when the mark has to be kept
+func (p *PoW) Check(token, nonce string, now time.Time) (string, time.Time, error) {
+
+	// 1. Signature
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok || nonce == "" || len(nonce) > maxNonceLen {
+		return "", time.Time{}, ErrInvalid
+	}
+	mac, err := b64.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, p.sign(payload)) {
+		return "", time.Time{}, ErrInvalid
+	}
+	body, err := b64.DecodeString(payload)
+	if err != nil {
+		return "", time.Time{}, ErrInvalid
+	}
+	var c claims
+	if err := json.Unmarshal(body, &c); err != nil || c.ID == "" {
+		return "", time.Time{}, ErrInvalid
+	}
+
+	// 2. Lifetime
+	exp := time.Unix(c.Exp, 0)
+	if !now.Before(exp) {
+		return "", time.Time{}, ErrInvalid
+	}
+
+	// 3. Work
+	if LeadingZeroBits(token, nonce) < c.Difficulty {
+		return "", time.Time{}, ErrInvalid
+	}
+	return c.ID, exp, nil
+}
+
+func (p *PoW) sign(payload string) []byte {
+	m := hmac.New(sha256.New, p.key)
+	m.Write([]byte(payload))
+	return m.Sum(nil)
+}
+
+// LeadingZeroBits Of SHA-256(token + ":" + nonce)
+func LeadingZeroBits(token, nonce string) int {
+	sum := sha256.Sum256([]byte(token + ":" + nonce))
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// Solve Brute-force a nonce the way a client does, counting up in decimal; for tools and stubs
+func Solve(token string, difficulty int) string {
+	for i := 0; ; i++ {
+		nonce := strconv.Itoa(i)
+		if LeadingZeroBits(token, nonce) >= difficulty {
+			return nonce
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package client_info
+
+import "context"
+
+// Info Where a request came from, as seen by the server
+type Info struct {
+	IP        string
+	UserAgent string
+}
+
+type key struct{}
+
+func With(ctx context.Context, info Info) context.Context {
+	return context.WithValue(ctx, key{}, info)
+}
+func From(ctx context.Context) (Info, bool) {
+	v := ctx.Value(key{})
+	i, ok := v.(Info)
+	return i, ok
+}
@@ -0,0 +1,83 @@
+package httpx
+
+import (
+	"backend/internal/pkg/i18n"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// contentTypeProblem RFC 9457 problem details
+const contentTypeProblem = "application/problem+json"
+
+// Coded An error that knows how it is answered; services.AppError is one
+type Coded interface {
+	error
+	HTTPStatus() int
+	ErrorCode() string
+	// MessageKey i18n catalog key of the message, MessageArgs its placeholders
+	MessageKey() string
+	MessageArgs() []any
+	ErrorDetails() any
+}
+
+// WriteError The one mapping from errors to responses: a Coded error answers for itself,
+// context errors become 504 or 499, anything else a 500 that tells nothing
+func WriteError(c *gin.Context, err error) {
+	var ce Coded
+	switch {
+	case errors.As(err, &ce):
+		writeProblem(c, ce.HTTPStatus(), ce.ErrorCode(), ce.MessageKey(), ce.MessageArgs(), ce.ErrorDetails())
+	case errors.Is(err, context.DeadlineExceeded):
+		WriteProblem(c, http.StatusGatewayTimeout, "REQUEST_TIMEOUT", "REQUEST_TIMEOUT", nil)
+	case errors.Is(err, context.Canceled):
+		WriteProblem(c, 499, "REQUEST_CANCELED", "REQUEST_CANCELED", nil)
+	default:
+		WriteInternal(c)
+	}
+}
+
+// WriteProblem Every error body goes through here: {"code", "error", "details"} by default,
+// RFC 9457 problem details with the same code when the client accepts application/problem+json.
+// The message is the i18n catalog entry of key in the language of the request
+func WriteProblem(c *gin.Context, status int, code, key string, details any) {
+	writeProblem(c, status, code, key, nil, details)
+}
+
+func writeProblem(c *gin.Context, status int, code, key string, args []any, details any) {
+	if c.IsAborted() || c.Writer.Written() {
+		return
+	}
+	msg := i18n.T(i18n.From(c.Request.Context()), key, args...)
+	body := gin.H{"code": code, "error": msg}
+	if acceptsProblem(c) {
+		body = gin.H{
+			"type":     "about:blank",
+			"title":    statusTitle(status),
+			"status":   status,
+			"detail":   msg,
+			"instance": c.Request.URL.Path,
+			"code":     code,
+		}
+		// gin keeps a Content-Type that is set already
+		c.Header("Content-Type", contentTypeProblem)
+	}
+	if details != nil {
+		body["details"] = details
+	}
+	c.AbortWithStatusJSON(status, body)
+}
+
+func acceptsProblem(c *gin.Context) bool {
+	return strings.Contains(c.GetHeader("Accept"), contentTypeProblem)
+}
+
+func statusTitle(status int) string {
+	if status == 499 {
+		return "Client Closed Request"
+	}
+	return http.StatusText(status)
+}
@@ -2,7 +2,6 @@ package httpx
 
 import (
 	"context"
-	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -20,51 +19,33 @@ func TryWriteJSON(c *gin.Context, ctx context.Context, code int, data interface{
 	c.JSON(code, data)
 }
 
-func WriteConflict(c *gin.Context, msg string) {
-	WriteJSON(c, http.StatusConflict, gin.H{
-		"code":  "CONFLICT",
-		"error": msg,
-	})
+func WriteConflict(c *gin.Context, key string) {
+	WriteProblem(c, http.StatusConflict, "CONFLICT", key, nil)
 }
 
-func WriteBadReq(c *gin.Context, msg string) {
-	WriteJSON(c, http.StatusBadRequest, gin.H{
-		"code":  "BAD_REQUEST",
-		"error": msg,
-	})
+func WriteBadReq(c *gin.Context, key string) {
+	WriteProblem(c, http.StatusBadRequest, "BAD_REQUEST", key, nil)
 }
 
-func WriteUnauthorized(c *gin.Context, msg string) {
-	WriteJSON(c, http.StatusUnauthorized, gin.H{
-		"code":  "UNAUTHORIZED",
-		"error": msg,
-	})
+func WriteUnauthorized(c *gin.Context, key string) {
+	WriteProblem(c, http.StatusUnauthorized, "UNAUTHORIZED", key, nil)
 }
 
 func WriteTooManyReq(c *gin.Context) {
-	WriteJSON(c, http.StatusTooManyRequests, gin.H{
-		"code":  "TOO_MANY_REQUEST",
-		"error": "The request was too frequent. Please try again later.",
-	})
+	WriteProblem(c, http.StatusTooManyRequests, "TOO_MANY_REQUEST", "TOO_MANY_REQUEST", nil)
+}
+
+func WriteUnavailable(c *gin.Context) {
+	WriteProblem(c, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "SERVICE_UNAVAILABLE", nil)
 }
 
 func WriteInternal(c *gin.Context) {
-	WriteJSON(c, http.StatusInternalServerError, gin.H{
-		"code":  "INTERNAL_SERVER_ERROR",
-		"error": "Internal server error. Please try again later.",
-	})
+	WriteProblem(c, http.StatusInternalServerError, "INTERNAL_SERVER_ERROR", "INTERNAL_SERVER_ERROR", nil)
 }
 
 // WriteCtxError Write 499/504, default 500
 func WriteCtxError(c *gin.Context, err error) {
-	switch {
-	case errors.Is(err, context.DeadlineExceeded):
-		c.AbortWithStatusJSON(http.StatusGatewayTimeout, gin.H{"code": "REQUEST_TIMEOUT", "error": "Request timed out."})
-	case errors.Is(err, context.Canceled):
-		c.AbortWithStatusJSON(499, gin.H{"code": "REQUEST_CANCELED", "error": "You canceled request."})
-	default:
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"code": "INTERNAL_ERROR", "error": "Internal server error. Please try again."})
-	}
+	WriteError(c, err)
 }
 
 // WriteJSON Force to write
@@ -74,3 +55,11 @@ func WriteJSON(c *gin.Context, code int, data any) {
 	}
 	c.AbortWithStatusJSON(code, data)
 }
+
+func WriteForbidden(c *gin.Context, key string) {
+	WriteProblem(c, http.StatusForbidden, "FORBIDDEN", key, nil)
+}
+
+func WriteNotFound(c *gin.Context, key string) {
+	WriteProblem(c, http.StatusNotFound, "NOT_FOUND", key, nil)
+}
@@ -0,0 +1,170 @@
+// Package i18n Messages in the languages we support, keyed by error code or a dotted key such
+// as CONFLICT.email_exists, and the language chosen for a request
+package i18n
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Default Language of last resort; its catalog has every key
+const Default = "en"
+
+//go:embed locales/*.json
+var files embed.FS
+
+// catalogs Messages per language tag, as named by the file under locales
+var catalogs = load()
+
+func load() map[string]map[string]string {
+	entries, err := files.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	out := make(map[string]map[string]string, len(entries))
+	for _, e := range entries {
+		b, err := files.ReadFile(path.Join("locales", e.Name()))
+		if err != nil {
+			panic(err)
+		}
+		m := map[string]string{}
+		if err := json.Unmarshal(b, &m); err != nil {
+			panic(fmt.Sprintf("i18n: %s: %v", e.Name(), err))
+		}
+		out[strings.TrimSuffix(e.Name(), ".json")] = m
+	}
+	if _, ok := out[Default]; !ok {
+		panic("i18n: no catalog for " + Default)
+	}
+	return out
+}
+
+// Supported Tags with a catalog, sorted
+func Supported() []string {
+	out := make([]string, 0, len(catalogs))
+	for tag := range catalogs {
+		out = append(out, tag)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Match The first tag served by a catalog, itself or by its base language, such as zh for
+// zh-TW; "" when none is
+func Match(tags ...string) string {
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		for _, t := range fallbacks(tag) {
+			if _, ok := catalogs[t]; ok {
+				return t
+			}
+		}
+	}
+	return ""
+}
+
+// Negotiate The language to answer an Accept-Language header in, Default when it names none
+// we support
+func Negotiate(acceptLanguage string) string {
+	if loc := Match(ParseAcceptLanguage(acceptLanguage)...); loc != "" {
+		return loc
+	}
+	return Default
+}
+
+// ParseAcceptLanguage Tags of the header by descending q, leaving out * and q=0
+func ParseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var ws []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = f
+		}
+		if q <= 0 {
+			continue
+		}
+		ws = append(ws, weighted{tag, q})
+	}
+	sort.SliceStable(ws, func(i, j int) bool { return ws[i].q > ws[j].q })
+	out := make([]string, len(ws))
+	for i, w := range ws {
+		out[i] = w.tag
+	}
+	return out
+}
+
+// T The message of key in locale, with {name} placeholders filled from args given as name,
+// value pairs. Looked up in locale, its base language, then Default; a dotted key missing
+// everywhere falls back to the code before the dot, and an unknown key is returned as is
+func T(locale, key string, args ...any) string {
+	msg, ok := lookup(locale, key)
+	if !ok {
+		if code, _, dotted := strings.Cut(key, "."); dotted {
+			msg, ok = lookup(locale, code)
+		}
+	}
+	if !ok {
+		return key
+	}
+	for i := 0; i+1 < len(args); i += 2 {
+		msg = strings.ReplaceAll(msg, "{"+fmt.Sprint(args[i])+"}", fmt.Sprint(args[i+1]))
+	}
+	return msg
+}
+
+func lookup(locale, key string) (string, bool) {
+	for _, tag := range append(fallbacks(strings.ToLower(locale)), Default) {
+		if msg, ok := catalogs[tag][key]; ok {
+			return msg, true
+		}
+	}
+	return "", false
+}
+
+// fallbacks tag, then each shorter prefix: zh-hant-tw, zh-hant, zh
+func fallbacks(tag string) []string {
+	var out []string
+	for tag != "" {
+		out = append(out, tag)
+		i := strings.LastIndexAny(tag, "-_")
+		if i < 0 {
+			break
+		}
+		tag = tag[:i]
+	}
+	return out
+}
+
+type key struct{}
+
+// With ctx answering in locale
+func With(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, key{}, locale)
+}
+
+// From Language of ctx, Default when none was chosen
+func From(ctx context.Context) string {
+	if loc, ok := ctx.Value(key{}).(string); ok && loc != "" {
+		return loc
+	}
+	return Default
+}
@@ -0,0 +1,13 @@
+-- KEYS[1]=record hash {fp, owner, status, ctype, body}
+-- ARGV[1]=fingerprint, ARGV[2]=owner, ARGV[3]=lock ttl(ms)
+-- Returns {} when the key is now held by owner, otherwise {fp, status, ctype, body}
+
+-- 1. Taken: hand back what is stored, status is empty while the first request runs
+if redis.call("EXISTS", KEYS[1]) == 1 then
+    return redis.call("HMGET", KEYS[1], "fp", "status", "ctype", "body")
+end
+
+-- 2. Free: hold it until the response is stored or the lock runs out
+redis.call("HSET", KEYS[1], "fp", ARGV[1], "owner", ARGV[2])
+redis.call("PEXPIRE", KEYS[1], ARGV[3])
+return {}
@@ -0,0 +1,11 @@
+-- KEYS[1]=record hash {fp, owner, status, ctype, body}
+-- ARGV[1]=owner, ARGV[2]=ttl(ms), ARGV[3]=status, ARGV[4]=content type, ARGV[5]=body
+-- Returns 1 when stored, 0 when owner no longer holds the key
+
+if redis.call("HGET", KEYS[1], "owner") ~= ARGV[1] then
+    return 0
+end
+redis.call("HSET", KEYS[1], "status", ARGV[3], "ctype", ARGV[4], "body", ARGV[5])
+redis.call("HDEL", KEYS[1], "owner")
+redis.call("PEXPIRE", KEYS[1], ARGV[2])
+return 1
@@ -0,0 +1,93 @@
+// Package idempotency Responses stored in Redis under a client-chosen key, so a request sent
+// again is answered with the first response instead of running twice
+package idempotency
+
+import (
+	"backend/internal/config"
+	"context"
+	_ "embed"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Record What is stored under a key
+type Record struct {
+	// Fingerprint Hash of the request that took the key
+	Fingerprint string
+	// Status 0 while that request is still running
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// Done The response is stored and can be replayed
+func (r Record) Done() bool {
+	return r.Status != 0
+}
+
+//go:embed begin.lua
+var beginLua string
+
+//go:embed complete.lua
+var completeLua string
+
+//go:embed release.lua
+var releaseLua string
+
+// Store Keys and responses, each step one atomic Lua call
+type Store struct {
+	rdb      *redis.Client
+	begin    *redis.Script
+	complete *redis.Script
+	release  *redis.Script
+}
+
+// Begin Take key for a request with fingerprint, held by owner for lockTTL. When the key is
+// taken already, false and the stored record are returned
+func (s *Store) Begin(ctx context.Context, subject, key, fingerprint, owner string, lockTTL time.Duration) (Record, bool, error) {
+	v, err := s.begin.Run(ctx, s.rdb, []string{config.RedisKeyIdempotency(subject, key)},
+		fingerprint, owner, lockTTL.Milliseconds()).Slice()
+	if err != nil {
+		return Record{}, false, err
+	}
+	if len(v) == 0 {
+		return Record{}, true, nil
+	}
+	if len(v) != 4 {
+		return Record{}, false, fmt.Errorf("idempotency: unexpected reply %v", v)
+	}
+	field := func(i int) string {
+		s, _ := v[i].(string)
+		return s
+	}
+	rec := Record{Fingerprint: field(0), ContentType: field(2), Body: []byte(field(3))}
+	if st := field(1); st != "" {
+		if rec.Status, err = strconv.Atoi(st); err != nil {
+			return Record{}, false, fmt.Errorf("idempotency: bad status %q", st)
+		}
+	}
+	return rec, false, nil
+}
+
+// Complete Store the response of owner's request, replayed for ttl
+func (s *Store) Complete(ctx context.Context, subject, key, owner string, rec Record, ttl time.Duration) error {
+	return s.complete.Run(ctx, s.rdb, []string{config.RedisKeyIdempotency(subject, key)},
+		owner, ttl.Milliseconds(), rec.Status, rec.ContentType, rec.Body).Err()
+}
+
+// Release Free the key without a response, so the next attempt runs again
+func (s *Store) Release(ctx context.Context, subject, key, owner string) error {
+	return s.release.Run(ctx, s.rdb, []string{config.RedisKeyIdempotency(subject, key)}, owner).Err()
+}
+
+func New(rdb *redis.Client) *Store {
+	return &Store{
+		rdb:      rdb,
+		begin:    redis.NewScript(beginLua),
+		complete: redis.NewScript(completeLua),
+		release:  redis.NewScript(releaseLua),
+	}
+}
@@ -0,0 +1,8 @@
+-- KEYS[1]=record hash {fp, owner, status, ctype, body}
+-- ARGV[1]=owner
+-- Returns 1 when the key was freed, 0 when owner no longer holds it
+
+if redis.call("HGET", KEYS[1], "owner") ~= ARGV[1] then
+    return 0
+end
+return redis.call("DEL", KEYS[1])
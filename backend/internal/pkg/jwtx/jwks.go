@@ -0,0 +1,89 @@
+package jwtx
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// JWK Public key in RFC 7517 form
+type JWK struct {
+	KID string `json:"kid"`
+	KTY string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	CRV string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicJWKS Asymmetric access-token keys that are not retired, staged keys included so caches warm up before rotation
+func (kr *Keyring) PublicJWKS(now time.Time) JWKS {
+	out := JWKS{Keys: []JWK{}}
+	for _, k := range kr.Keys() {
+		if k.Use != UseATK || k.Alg == "HS256" {
+			continue
+		}
+		if !k.NotAfter.IsZero() && !now.Before(k.NotAfter) {
+			continue
+		}
+		jwk, err := publicJWK(k)
+		if err != nil {
+			continue
+		}
+		out.Keys = append(out.Keys, jwk)
+	}
+	return out
+}
+
+func publicJWK(k *Key) (JWK, error) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch pub := k.Private.Public().(type) {
+	case ed25519.PublicKey:
+		return JWK{KID: k.KID, KTY: "OKP", Alg: "EdDSA", Use: "sig", CRV: "Ed25519", X: b64(pub)}, nil
+	case *ecdsa.PublicKey:
+		// Coordinates are fixed-width big-endian for P-256
+		x := pub.X.FillBytes(make([]byte, 32))
+		y := pub.Y.FillBytes(make([]byte, 32))
+		return JWK{KID: k.KID, KTY: "EC", Alg: "ES256", Use: "sig", CRV: "P-256", X: b64(x), Y: b64(y)}, nil
+	default:
+		return JWK{}, errors.New("jwks: unsupported public key")
+	}
+}
+
+// thumbprint RFC 7638 JWK thumbprint, members in lexicographic order
+func thumbprint(k *Key) (string, error) {
+	jwk, err := publicJWK(k)
+	if err != nil {
+		return "", err
+	}
+	var members any
+	if jwk.KTY == "OKP" {
+		members = struct {
+			CRV string `json:"crv"`
+			KTY string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.CRV, jwk.KTY, jwk.X}
+	} else {
+		members = struct {
+			CRV string `json:"crv"`
+			KTY string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.CRV, jwk.KTY, jwk.X, jwk.Y}
+	}
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
@@ -2,43 +2,146 @@ package jwtx
 
 import (
 	"backend/internal/config"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func SignATK(uid uint64, tokenV uint, ttl time.Duration) (string, error) {
+var (
+	ErrTokenInvalid = errors.New("token is invalid")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// Manager Sign and parse tokens with the configured issuer and keyring
+type Manager struct {
+	iss  string
+	keys *Keyring
+}
+
+func NewManager(cfg config.JWT, keys *Keyring) *Manager {
+	return &Manager{iss: cfg.ISS, keys: keys}
+}
+
+func (m *Manager) SignATK(uid uint64, tokenV uint, deviceID string, ttl time.Duration) (string, error) {
 	now := time.Now()
 	claims := ATKClaims{
-		UserID: uid,
-		TokenV: tokenV,
+		UserID:   uid,
+		TokenV:   tokenV,
+		DeviceID: deviceID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			Issuer:    config.C.JWT.ISS,
+			Issuer:    m.iss,
+			IssuedAt:  jwt.NewNumericDate(now),
 			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
 		},
 	}
-	tok := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return tok.SignedString(config.C.JWT.KEY)
+	return m.sign(UseATK, claims)
 }
 
-func SignOTT(email, scene, jti string, ttl time.Duration) (string, error) {
+func (m *Manager) SignOTT(email, scene, jti string, ttl time.Duration) (string, error) {
 	now := time.Now()
 	claims := OTTClaims{
 		Email: email,
 		Scene: scene,
 		RegisteredClaims: jwt.RegisteredClaims{
-			Issuer:    config.C.JWT.ISS,
+			Issuer:    m.iss,
 			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
 			ID:        jti,
 		},
 	}
-	tok := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return tok.SignedString(config.C.JWT.KEY)
+	return m.sign(UseOTT, claims)
+}
+
+// ParseOTT Verify signature, issuer and expiry of a one-time token
+func (m *Manager) ParseOTT(tokenStr string) (*OTTClaims, error) {
+	var claims OTTClaims
+	if err := m.parse(tokenStr, UseOTT, &claims); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+// ParseATK Verify signature, issuer and expiry of an access token
+func (m *Manager) ParseATK(tokenStr string) (*ATKClaims, error) {
+	var claims ATKClaims
+	if err := m.parse(tokenStr, UseATK, &claims); err != nil {
+		return nil, err
+	}
+	if claims.UserID == 0 {
+		return nil, ErrTokenInvalid
+	}
+	return &claims, nil
+}
+
+// sign Sign with the active key of use and stamp its kid
+func (m *Manager) sign(use Use, claims jwt.Claims) (string, error) {
+	key, err := m.keys.Signer(use, time.Now())
+	if err != nil {
+		return "", err
+	}
+	tok := jwt.NewWithClaims(jwt.GetSigningMethod(key.Alg), claims)
+	tok.Header["kid"] = key.KID
+	return tok.SignedString(key.signKey())
+}
+
+func (m *Manager) parse(tokenStr string, use Use, claims jwt.Claims) error {
+
+	// 1. Parse token, key is looked up by kid and must match the alg it was issued with,
+	//    so a public key can never be used as an HMAC secret
+	token, err := jwt.ParseWithClaims(
+		tokenStr,
+		claims,
+		func(t *jwt.Token) (any, error) {
+			key, err := m.lookup(t, use)
+			if err != nil {
+				return nil, err
+			}
+			if t.Method.Alg() != key.Alg {
+				return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+			}
+			return key.verifyKey(), nil
+		},
+		jwt.WithValidMethods([]string{"HS256", "EdDSA", "ES256"}),
+	)
+	if err != nil || !token.Valid {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return ErrTokenExpired
+		}
+		return ErrTokenInvalid
+	}
+
+	// 2. Verify Issuer and ExpireAt
+	iss, _ := claims.GetIssuer()
+	if iss != m.iss {
+		return ErrTokenInvalid
+	}
+	exp, _ := claims.GetExpirationTime()
+	if exp == nil || !exp.After(time.Now()) {
+		return ErrTokenExpired
+	}
+	return nil
+}
+
+// JWKS Public keys other services use to verify access tokens
+func (m *Manager) JWKS() JWKS {
+	return m.keys.PublicJWKS(time.Now())
+}
+
+// lookup Resolve the verifying key; tokens issued before kids existed use the active signer
+func (m *Manager) lookup(t *jwt.Token, use Use) (*Key, error) {
+	now := time.Now()
+	kid, _ := t.Header["kid"].(string)
+	if kid == "" {
+		return m.keys.Signer(use, now)
+	}
+	return m.keys.Verifier(kid, use, now)
 }
 
 type ATKClaims struct {
-	UserID uint64 `json:"email"`
-	TokenV uint   `json:"token_version"`
+	UserID   uint64 `json:"uid"`
+	TokenV   uint   `json:"token_version"`
+	DeviceID string `json:"did,omitempty"`
 	jwt.RegisteredClaims
 }
 
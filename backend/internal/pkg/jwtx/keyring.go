@@ -0,0 +1,338 @@
+package jwtx
+
+import (
+	"backend/internal/config"
+	"backend/internal/pkg/logx"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Use Token type a key is bound to, keys never cross types
+type Use string
+
+const (
+	UseATK Use = "atk"
+	UseOTT Use = "ott"
+)
+
+const minSecretLen = 32
+
+var (
+	ErrNoSigningKey = errors.New("no active signing key")
+	ErrUnknownKID   = errors.New("unknown kid")
+)
+
+// Key One entry of the keyring
+type Key struct {
+	KID string
+	Use Use
+	// Alg HS256 | EdDSA | ES256
+	Alg string
+	// Secret HMAC secret, HS256 only
+	Secret []byte
+	// Private Ed25519 or P-256 private key, asymmetric algs only
+	Private crypto.Signer
+	// NotBefore Key starts signing at this time, zero means immediately
+	NotBefore time.Time
+	// NotAfter Key stops verifying at this time, zero means never
+	NotAfter time.Time
+}
+
+// Keyring One key signs per use, every unexpired key of that use verifies
+type Keyring struct {
+	mu   sync.RWMutex
+	keys map[string]*Key
+}
+
+func NewKeyring(keys []*Key) (*Keyring, error) {
+	kr := &Keyring{}
+	if err := kr.Replace(keys); err != nil {
+		return nil, err
+	}
+	return kr, nil
+}
+
+// Replace Swap in a validated key set
+func (kr *Keyring) Replace(keys []*Key) error {
+
+	// 1. Validate every key
+	next := make(map[string]*Key, len(keys))
+	for _, k := range keys {
+		if k.KID == "" {
+			return errors.New("keyring: key without kid")
+		}
+		if _, dup := next[k.KID]; dup {
+			return fmt.Errorf("keyring: duplicate kid %q", k.KID)
+		}
+		if k.Use != UseATK && k.Use != UseOTT {
+			return fmt.Errorf("keyring: kid %q has unknown use %q", k.KID, k.Use)
+		}
+		if err := k.check(); err != nil {
+			return fmt.Errorf("keyring: kid %q: %w", k.KID, err)
+		}
+		next[k.KID] = k
+	}
+
+	// 2. Every use must be able to sign right now
+	now := time.Now()
+	for _, use := range []Use{UseATK, UseOTT} {
+		if signer(next, use, now) == nil {
+			return fmt.Errorf("keyring: %w for %s", ErrNoSigningKey, use)
+		}
+	}
+
+	kr.mu.Lock()
+	kr.keys = next
+	kr.mu.Unlock()
+	return nil
+}
+
+// Signer Newest key of use whose NotBefore has passed
+func (kr *Keyring) Signer(use Use, now time.Time) (*Key, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	k := signer(kr.keys, use, now)
+	if k == nil {
+		return nil, ErrNoSigningKey
+	}
+	return k, nil
+}
+
+// Verifier Key for kid, only if bound to use and not retired
+func (kr *Keyring) Verifier(kid string, use Use, now time.Time) (*Key, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	k, ok := kr.keys[kid]
+	if !ok || k.Use != use {
+		return nil, ErrUnknownKID
+	}
+	if !k.NotAfter.IsZero() && !now.Before(k.NotAfter) {
+		return nil, ErrUnknownKID
+	}
+	return k, nil
+}
+
+// Keys Snapshot of every key sorted by kid
+func (kr *Keyring) Keys() []*Key {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	out := make([]*Key, 0, len(kr.keys))
+	for _, k := range kr.keys {
+		out = append(out, k)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].KID < out[j].KID })
+	return out
+}
+
+// check Key material must match the declared alg
+func (k *Key) check() error {
+	switch k.Alg {
+	case "HS256":
+		if len(k.Secret) < minSecretLen {
+			return fmt.Errorf("secret must be at least %d bytes", minSecretLen)
+		}
+	case "EdDSA":
+		if _, ok := k.Private.(ed25519.PrivateKey); !ok {
+			return errors.New("EdDSA needs an Ed25519 private key")
+		}
+	case "ES256":
+		pk, ok := k.Private.(*ecdsa.PrivateKey)
+		if !ok || pk.Curve != elliptic.P256() {
+			return errors.New("ES256 needs a P-256 private key")
+		}
+	default:
+		return fmt.Errorf("unsupported alg %q", k.Alg)
+	}
+	return nil
+}
+
+// signKey Material passed to jwt SignedString
+func (k *Key) signKey() any {
+	if k.Alg == "HS256" {
+		return k.Secret
+	}
+	return k.Private
+}
+
+// verifyKey Material returned from the jwt Keyfunc
+func (k *Key) verifyKey() any {
+	if k.Alg == "HS256" {
+		return k.Secret
+	}
+	return k.Private.Public()
+}
+
+func signer(keys map[string]*Key, use Use, now time.Time) *Key {
+	var best *Key
+	for _, k := range keys {
+		if k.Use != use || k.NotBefore.After(now) {
+			continue
+		}
+		if !k.NotAfter.IsZero() && !now.Before(k.NotAfter) {
+			continue
+		}
+		if best == nil || k.NotBefore.After(best.NotBefore) || (k.NotBefore.Equal(best.NotBefore) && k.KID > best.KID) {
+			best = k
+		}
+	}
+	return best
+}
+
+// LoadKeys Read keys from the keyset file, or fall back to the per-type and legacy secrets
+func LoadKeys(cfg config.JWT) ([]*Key, error) {
+	if cfg.KeysetFile != "" {
+		return readKeyset(cfg.KeysetFile)
+	}
+	ott := cfg.OTTKey
+	if len(ott) == 0 {
+		ott = cfg.KEY
+	}
+	ottKey := &Key{KID: "ott-0", Use: UseOTT, Alg: "HS256", Secret: ott}
+
+	// HS256 access tokens
+	if cfg.ATKAlg == "" || cfg.ATKAlg == "HS256" {
+		atk := cfg.ATKKey
+		if len(atk) == 0 {
+			atk = cfg.KEY
+		}
+		return []*Key{{KID: "atk-0", Use: UseATK, Alg: "HS256", Secret: atk}, ottKey}, nil
+	}
+
+	// Asymmetric access tokens, kid is the JWK thumbprint so it changes with the key
+	priv, err := parsePrivateKey(cfg.ATKPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("JWT_ATK_PRIVATE_KEY: %w", err)
+	}
+	atkKey := &Key{Use: UseATK, Alg: cfg.ATKAlg, Private: priv}
+	if err := atkKey.check(); err != nil {
+		return nil, fmt.Errorf("JWT_ATK_PRIVATE_KEY: %w", err)
+	}
+	atkKey.KID, err = thumbprint(atkKey)
+	if err != nil {
+		return nil, err
+	}
+	return []*Key{atkKey, ottKey}, nil
+}
+
+// parsePrivateKey Decode a PEM PKCS#8 or SEC 1 private key
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.New("private key is neither PKCS#8 nor SEC 1")
+	}
+	return key, nil
+}
+
+// WatchKeyset Re-read the keyset file every interval so staged keys become active without a restart
+func (kr *Keyring) WatchKeyset(ctx context.Context, cfg config.JWT) {
+	if cfg.KeysetFile == "" {
+		return
+	}
+	ticker := time.NewTicker(cfg.KeysetReload)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			keys, err := readKeyset(cfg.KeysetFile)
+			if err == nil {
+				err = kr.Replace(keys)
+			}
+			if err != nil {
+				logx.LogError(ctx, "Keyring.Reload", err)
+			}
+		}
+	}
+}
+
+// keysetFile On-disk format, secrets inline (base64) or in their own mounted files
+type keysetFile struct {
+	Keys []struct {
+		KID        string `json:"kid"`
+		Use        Use    `json:"use"`
+		Alg        string `json:"alg"`
+		Secret     string `json:"secret"`
+		SecretFile string `json:"secret_file"`
+		// PrivateKey, PrivateKeyFile PEM private key for EdDSA and ES256
+		PrivateKey     string    `json:"private_key"`
+		PrivateKeyFile string    `json:"private_key_file"`
+		NotBefore      time.Time `json:"not_before"`
+		NotAfter       time.Time `json:"not_after"`
+	} `json:"keys"`
+}
+
+func readKeyset(path string) ([]*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("keyset: %w", err)
+	}
+	var f keysetFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("keyset %s: %w", path, err)
+	}
+
+	keys := make([]*Key, 0, len(f.Keys))
+	for _, e := range f.Keys {
+		k := &Key{
+			KID:       e.KID,
+			Use:       e.Use,
+			Alg:       e.Alg,
+			NotBefore: e.NotBefore,
+			NotAfter:  e.NotAfter,
+		}
+		if k.Alg == "" {
+			k.Alg = "HS256"
+		}
+		switch {
+		case e.PrivateKeyFile != "" || e.PrivateKey != "":
+			pemData := []byte(e.PrivateKey)
+			if e.PrivateKeyFile != "" {
+				if pemData, err = os.ReadFile(e.PrivateKeyFile); err != nil {
+					return nil, fmt.Errorf("keyset kid %q: %w", e.KID, err)
+				}
+			}
+			if k.Private, err = parsePrivateKey(pemData); err != nil {
+				return nil, fmt.Errorf("keyset kid %q: %w", e.KID, err)
+			}
+		case e.SecretFile != "":
+			raw, err := os.ReadFile(e.SecretFile)
+			if err != nil {
+				return nil, fmt.Errorf("keyset kid %q: %w", e.KID, err)
+			}
+			k.Secret = []byte(strings.TrimRight(string(raw), "\r\n"))
+		case e.Secret != "":
+			k.Secret, err = base64.StdEncoding.DecodeString(e.Secret)
+			if err != nil {
+				return nil, fmt.Errorf("keyset kid %q: secret is not base64: %w", e.KID, err)
+			}
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
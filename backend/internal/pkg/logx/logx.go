@@ -3,17 +3,84 @@ package logx
 import (
 	"backend/internal/pkg/request_id"
 	"context"
+	"fmt"
 	"log"
+	"strings"
+	"sync/atomic"
 )
 
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var level atomic.Int32
+
+func init() {
+	level.Store(int32(LevelInfo))
+}
+
+// ParseLevel Parse debug | info | warn | error
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return LevelDebug, nil
+	case "info", "":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return LevelInfo, fmt.Errorf("unknown log level %q", s)
+	}
+}
+
+// SetLevel Change the minimum level at runtime
+func SetLevel(l Level) {
+	level.Store(int32(l))
+}
+
+func enabled(l Level) bool {
+	return Level(level.Load()) <= l
+}
+
 // LogError Print error log
 func LogError(c context.Context, op string, err error) {
+	if !enabled(LevelError) {
+		return
+	}
 	rid, _ := request_id.From(c)
 	log.Printf("\033[31m[ERROR]\033[0m request_id=%s op=%s err=%v", rid, op, err)
 }
 
+// LogWarn Print warning log
+func LogWarn(c context.Context, op, msg string) {
+	if !enabled(LevelWarn) {
+		return
+	}
+	rid, _ := request_id.From(c)
+	log.Printf("\033[33m[WARN]\033[0m request_id=%s op=%s msg=%s", rid, op, msg)
+}
+
 // LogInfo Print information log
 func LogInfo(c context.Context, op, msg string) {
+	if !enabled(LevelInfo) {
+		return
+	}
 	rid, _ := request_id.From(c)
 	log.Printf("[INFO] request_id=%s op=%s msg=%s", rid, op, msg)
 }
+
+// LogDebug Print debug log
+func LogDebug(c context.Context, op, msg string) {
+	if !enabled(LevelDebug) {
+		return
+	}
+	rid, _ := request_id.From(c)
+	log.Printf("[DEBUG] request_id=%s op=%s msg=%s", rid, op, msg)
+}
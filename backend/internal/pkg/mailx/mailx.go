@@ -0,0 +1,87 @@
+package mailx
+
+import (
+	"backend/internal/config"
+	"backend/internal/pkg/logx"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Message A plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer Deliver transactional email
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New Pick the driver from config: log (dev, prints the message) or smtp
+func New(cfg config.Mail) (Mailer, error) {
+	switch cfg.Driver {
+	case "log":
+		return logMailer{}, nil
+	case "smtp":
+		return &smtpMailer{cfg: cfg}, nil
+	default:
+		return nil, fmt.Errorf("mailx: unknown driver %q", cfg.Driver)
+	}
+}
+
+// logMailer Write messages to the log instead of sending them
+type logMailer struct{}
+
+func (logMailer) Send(ctx context.Context, msg Message) error {
+	logx.LogInfo(ctx, "Mailer.Send", fmt.Sprintf("[DEV] to=%s subject=%q\n%s", msg.To, msg.Subject, msg.Body))
+	return nil
+}
+
+type smtpMailer struct {
+	cfg config.Mail
+}
+
+func (m *smtpMailer) Send(ctx context.Context, msg Message) error {
+	if strings.ContainsAny(msg.To, "\r\n") {
+		return errors.New("mailx: invalid recipient")
+	}
+
+	// 1. Build message
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", m.cfg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	// 2. Send, smtp.SendMail has no context so bound it with a goroutine
+	var auth smtp.Auth
+	if m.cfg.SMTPUser != "" {
+		host, _, _ := net.SplitHostPort(m.cfg.SMTPAddr)
+		auth = smtp.PlainAuth("", m.cfg.SMTPUser, m.cfg.SMTPPassword, host)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(m.cfg.SMTPAddr, auth, m.cfg.From, []string{msg.To}, []byte(b.String()))
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("mailx: send to %s: %w", msg.To, err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,162 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// minRefetch Floor between fetches triggered by unknown kids, so junk tokens cannot hammer the provider
+const minRefetch = 30 * time.Second
+
+// maxJWKSSize Upper bound on a JWKS response body
+const maxJWKSSize = 1 << 20
+
+// publicKey A verifying key and the alg it is published for ("" when the JWK does not say)
+type publicKey struct {
+	alg string
+	key any
+}
+
+// keySet Cached JWKS of one provider
+type keySet struct {
+	url     string
+	client  *http.Client
+	refresh time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]publicKey
+	fetchedAt time.Time
+	triedAt   time.Time
+}
+
+// lookup Key for kid, fetching when the cache is stale or the kid is new. A failed fetch
+// falls back to the keys already held so a provider outage does not stop logins at once
+func (ks *keySet) lookup(ctx context.Context, kid string) (publicKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	now := time.Now()
+	k, ok := ks.keys[kid]
+	stale := now.Sub(ks.fetchedAt) > ks.refresh
+	if ok && !stale {
+		return k, nil
+	}
+	if now.Sub(ks.triedAt) < minRefetch {
+		if ok {
+			return k, nil
+		}
+		return publicKey{}, fmt.Errorf("%w: unknown kid %q", ErrInvalidToken, kid)
+	}
+
+	ks.triedAt = now
+	keys, err := ks.fetch(ctx)
+	if err != nil {
+		if ok {
+			return k, nil
+		}
+		return publicKey{}, err
+	}
+	ks.keys, ks.fetchedAt = keys, now
+	if k, ok = keys[kid]; !ok {
+		return publicKey{}, fmt.Errorf("%w: unknown kid %q", ErrInvalidToken, kid)
+	}
+	return k, nil
+}
+
+type jwk struct {
+	KID string `json:"kid"`
+	KTY string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	CRV string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (ks *keySet) fetch(ctx context.Context) (map[string]publicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ks.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKeysUnavailable, err)
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := ks.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKeysUnavailable, err)
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s returned %d", ErrKeysUnavailable, ks.url, res.StatusCode)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxJWKSSize)).Decode(&set); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKeysUnavailable, err)
+	}
+
+	// Keys of unsupported types are skipped, providers publish several
+	keys := make(map[string]publicKey, len(set.Keys))
+	for _, j := range set.Keys {
+		if j.Use != "" && j.Use != "sig" {
+			continue
+		}
+		key, err := parseJWK(j)
+		if err != nil {
+			continue
+		}
+		keys[j.KID] = publicKey{alg: j.Alg, key: key}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: %s has no usable keys", ErrKeysUnavailable, ks.url)
+	}
+	return keys, nil
+}
+
+func parseJWK(j jwk) (any, error) {
+	b64 := base64.RawURLEncoding.DecodeString
+	switch j.KTY {
+	case "RSA":
+		n, err1 := b64(j.N)
+		e, err2 := b64(j.E)
+		if err1 != nil || err2 != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("jwk: bad RSA key")
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if pub.N.BitLen() < 2048 {
+			return nil, errors.New("jwk: RSA key shorter than 2048 bits")
+		}
+		return pub, nil
+	case "EC":
+		if j.CRV != "P-256" {
+			return nil, errors.New("jwk: unsupported curve")
+		}
+		x, err1 := b64(j.X)
+		y, err2 := b64(j.Y)
+		if err1 != nil || err2 != nil || len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("jwk: bad EC key")
+		}
+		// ecdh rejects points that are not on the curve
+		point := append(append([]byte{4}, x...), y...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	default:
+		return nil, errors.New("jwk: unsupported key type")
+	}
+}
@@ -0,0 +1,170 @@
+package oidc
+
+import (
+	"backend/internal/config"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrUnknownProvider = errors.New("oidc: unknown provider")
+	ErrInvalidToken    = errors.New("oidc: invalid id token")
+	// ErrKeysUnavailable The provider's JWKS could not be fetched and nothing is cached
+	ErrKeysUnavailable = errors.New("oidc: signing keys unavailable")
+)
+
+// Identity The verified facts of an ID token
+type Identity struct {
+	Provider      string
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	// PrivateEmail Apple private relay address (…@privaterelay.appleid.com), still deliverable
+	PrivateEmail bool
+	ExpiresAt    time.Time
+}
+
+// flexBool Apple sends email_verified and is_private_email as "true"/"false" strings
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch t := v.(type) {
+	case bool:
+		*b = flexBool(t)
+	case string:
+		*b = flexBool(t == "true")
+	default:
+		*b = false
+	}
+	return nil
+}
+
+type claims struct {
+	jwt.RegisteredClaims
+	Email          string   `json:"email"`
+	EmailVerified  flexBool `json:"email_verified"`
+	IsPrivateEmail flexBool `json:"is_private_email"`
+	Nonce          string   `json:"nonce"`
+	AZP            string   `json:"azp"`
+}
+
+type provider struct {
+	Provider
+	keys *keySet
+}
+
+// Verifier Checks ID tokens of the configured providers; signing keys are cached per provider
+type Verifier struct {
+	providers    map[string]*provider
+	leeway       time.Duration
+	requireNonce bool
+}
+
+// Has Whether name is a configured provider
+func (v *Verifier) Has(name string) bool {
+	_, ok := v.providers[name]
+	return ok
+}
+
+// Verify Check signature, issuer, audience, lifetime and nonce of an ID token. The client
+// may hash its nonce before handing it to the provider, as Apple's SDK samples do, so the
+// claim is compared with the nonce and with its SHA-256 hex
+func (v *Verifier) Verify(ctx context.Context, name, rawToken, nonce string) (*Identity, error) {
+
+	// 1. Provider
+	p, ok := v.providers[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	// 2. Signature, key looked up by kid; a JWK that names its alg only verifies that alg
+	var keyErr error
+	var c claims
+	_, err := jwt.ParseWithClaims(rawToken, &c,
+		func(t *jwt.Token) (any, error) {
+			kid, _ := t.Header["kid"].(string)
+			k, err := p.keys.lookup(ctx, kid)
+			if err != nil {
+				keyErr = err
+				return nil, err
+			}
+			if k.alg != "" && k.alg != t.Method.Alg() {
+				return nil, fmt.Errorf("alg %s does not match key", t.Method.Alg())
+			}
+			return k.key, nil
+		},
+		jwt.WithValidMethods([]string{"RS256", "ES256"}),
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(v.leeway),
+	)
+	if err != nil {
+		if errors.Is(keyErr, ErrKeysUnavailable) {
+			return nil, keyErr
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	// 3. Audience, any configured client id; azp must be ours when several audiences are named
+	if !slices.ContainsFunc(c.Audience, func(aud string) bool { return slices.Contains(p.Audiences, aud) }) {
+		return nil, fmt.Errorf("%w: audience", ErrInvalidToken)
+	}
+	if len(c.Audience) > 1 && !slices.Contains(p.Audiences, c.AZP) {
+		return nil, fmt.Errorf("%w: azp", ErrInvalidToken)
+	}
+	if c.Subject == "" {
+		return nil, fmt.Errorf("%w: sub", ErrInvalidToken)
+	}
+
+	// 4. Nonce
+	if c.Nonce != "" || nonce != "" || v.requireNonce {
+		if nonce == "" || c.Nonce == "" || !nonceMatches(c.Nonce, nonce) {
+			return nil, fmt.Errorf("%w: nonce", ErrInvalidToken)
+		}
+	}
+
+	return &Identity{
+		Provider:      p.Name,
+		Issuer:        p.Issuer,
+		Subject:       c.Subject,
+		Email:         c.Email,
+		EmailVerified: bool(c.EmailVerified),
+		PrivateEmail:  bool(c.IsPrivateEmail),
+		ExpiresAt:     c.ExpiresAt.Time,
+	}, nil
+}
+
+func nonceMatches(claim, nonce string) bool {
+	sum := sha256.Sum256([]byte(nonce))
+	hashed := hex.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(claim), []byte(nonce)) == 1 ||
+		subtle.ConstantTimeCompare([]byte(claim), []byte(hashed)) == 1
+}
+
+func NewVerifier(providers []Provider, cfg config.OIDC) *Verifier {
+	client := &http.Client{Timeout: cfg.JWKSTimeout}
+	v := &Verifier{providers: map[string]*provider{}, leeway: cfg.Leeway, requireNonce: cfg.RequireNonce}
+	for _, p := range providers {
+		v.providers[p.Name] = &provider{
+			Provider: p,
+			keys:     &keySet{url: p.JWKSURL, client: client, refresh: cfg.JWKSRefresh},
+		}
+	}
+	return v
+}
@@ -0,0 +1,65 @@
+package oidc
+
+import (
+	"backend/internal/config"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+)
+
+const (
+	AppleIssuer  = "https://appleid.apple.com"
+	AppleJWKSURL = "https://appleid.apple.com/auth/keys"
+)
+
+// Provider One identity provider whose ID tokens are accepted
+type Provider struct {
+	// Name Path segment of /api/auth/oidc/:provider and the provider column of linked identities
+	Name    string `json:"name"`
+	Issuer  string `json:"issuer"`
+	JWKSURL string `json:"jwks_url"`
+	// Audiences Client ids the token must be issued to, any one of them
+	Audiences []string `json:"audiences"`
+}
+
+var providerNameRe = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// LoadProviders Apple when client ids are configured, then every provider in the providers file
+func LoadProviders(cfg config.OIDC) ([]Provider, error) {
+	var out []Provider
+	if len(cfg.AppleClientIDs) > 0 {
+		out = append(out, Provider{Name: "apple", Issuer: AppleIssuer, JWKSURL: AppleJWKSURL, Audiences: cfg.AppleClientIDs})
+	}
+	if cfg.ProvidersFile != "" {
+		data, err := os.ReadFile(cfg.ProvidersFile)
+		if err != nil {
+			return nil, fmt.Errorf("oidc providers: %w", err)
+		}
+		var file []Provider
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("oidc providers %s: %w", cfg.ProvidersFile, err)
+		}
+		out = append(out, file...)
+	}
+
+	seen := map[string]bool{}
+	for _, p := range out {
+		if !providerNameRe.MatchString(p.Name) {
+			return nil, fmt.Errorf("oidc provider %q: name must match %s", p.Name, providerNameRe)
+		}
+		if seen[p.Name] {
+			return nil, fmt.Errorf("oidc provider %q: defined twice", p.Name)
+		}
+		seen[p.Name] = true
+		if p.Issuer == "" || len(p.Audiences) == 0 {
+			return nil, fmt.Errorf("oidc provider %q: issuer and audiences are required", p.Name)
+		}
+		u, err := url.Parse(p.JWKSURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("oidc provider %q: jwks_url must be an http(s) URL", p.Name)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,243 @@
+// Package openapi The parts of an OpenAPI 3.1 document needed to check requests and responses
+// against it: operations by method and route, parameters, JSON bodies and their schemas
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Spec A loaded document, operations keyed by method and gin route such as /api/users/:id
+type Spec struct {
+	ops     map[string]*Operation
+	schemas map[string]*Schema
+}
+
+// Operation One method of one path
+type Operation struct {
+	OperationID string       `yaml:"operationId"`
+	Summary     string       `yaml:"summary"`
+	Parameters  []*Parameter `yaml:"parameters"`
+	RequestBody *RequestBody `yaml:"requestBody"`
+	// Responses By status code, a range such as 4XX, or default
+	Responses map[string]*Response `yaml:"responses"`
+	// Security nil inherits the document's; an empty list means no credentials
+	Security *[]map[string][]string `yaml:"security"`
+
+	// Method and Path as routed, Template as written in the document
+	Method   string `yaml:"-"`
+	Path     string `yaml:"-"`
+	Template string `yaml:"-"`
+	spec     *Spec
+}
+
+func (o *Operation) String() string {
+	return o.Method + " " + o.Template
+}
+
+// Schemes Names of the security schemes that may authorize the operation
+func (o *Operation) Schemes() []string {
+	var out []string
+	for _, req := range *o.Security {
+		for name := range req {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+type Parameter struct {
+	Ref      string  `yaml:"$ref"`
+	Name     string  `yaml:"name"`
+	In       string  `yaml:"in"`
+	Required bool    `yaml:"required"`
+	Schema   *Schema `yaml:"schema"`
+	Example  any     `yaml:"example"`
+}
+
+type RequestBody struct {
+	Required bool                  `yaml:"required"`
+	Content  map[string]*MediaType `yaml:"content"`
+}
+
+type Response struct {
+	Ref         string                `yaml:"$ref"`
+	Description string                `yaml:"description"`
+	Content     map[string]*MediaType `yaml:"content"`
+}
+
+type MediaType struct {
+	Schema  *Schema `yaml:"schema"`
+	Example any     `yaml:"example"`
+}
+
+type document struct {
+	OpenAPI    string                `yaml:"openapi"`
+	Security   []map[string][]string `yaml:"security"`
+	Paths      map[string]*pathItem  `yaml:"paths"`
+	Components struct {
+		Schemas    map[string]*Schema    `yaml:"schemas"`
+		Parameters map[string]*Parameter `yaml:"parameters"`
+		Responses  map[string]*Response  `yaml:"responses"`
+	} `yaml:"components"`
+}
+
+type pathItem struct {
+	Parameters []*Parameter `yaml:"parameters"`
+	Get        *Operation   `yaml:"get"`
+	Put        *Operation   `yaml:"put"`
+	Post       *Operation   `yaml:"post"`
+	Delete     *Operation   `yaml:"delete"`
+	Patch      *Operation   `yaml:"patch"`
+}
+
+var templateParam = regexp.MustCompile(`\{([^{}/]+)\}`)
+
+// Load Parse a document, resolving $ref to components and compiling patterns; a reference
+// that goes nowhere or a pattern that does not compile is an error
+func Load(data []byte) (*Spec, error) {
+
+	// 1. Parse
+	var doc document
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("openapi: %w", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return nil, fmt.Errorf("openapi: unsupported version %q", doc.OpenAPI)
+	}
+	s := &Spec{ops: map[string]*Operation{}, schemas: doc.Components.Schemas}
+	if s.schemas == nil {
+		s.schemas = map[string]*Schema{}
+	}
+
+	// 2. Schemas of components first, operations point into them
+	for name, schema := range s.schemas {
+		if err := s.prepare(schema); err != nil {
+			return nil, fmt.Errorf("openapi: components.schemas.%s: %w", name, err)
+		}
+	}
+
+	// 3. Operations
+	for tmpl, item := range doc.Paths {
+		for method, op := range map[string]*Operation{
+			http.MethodGet: item.Get, http.MethodPut: item.Put, http.MethodPost: item.Post,
+			http.MethodDelete: item.Delete, http.MethodPatch: item.Patch,
+		} {
+			if op == nil {
+				continue
+			}
+			op.Method, op.Template, op.spec = method, tmpl, s
+			op.Path = templateParam.ReplaceAllString(tmpl, ":$1")
+			if op.Security == nil {
+				op.Security = &doc.Security
+			}
+			if err := s.prepareOperation(&doc, op, item.Parameters); err != nil {
+				return nil, fmt.Errorf("openapi: %s: %w", op, err)
+			}
+			s.ops[method+" "+op.Path] = op
+		}
+	}
+	return s, nil
+}
+
+// Lookup The operation of method on a gin route, nil when the document has none
+func (s *Spec) Lookup(method, route string) *Operation {
+	return s.ops[method+" "+route]
+}
+
+// Operations Every operation, by path then method
+func (s *Spec) Operations() []*Operation {
+	out := make([]*Operation, 0, len(s.ops))
+	for _, op := range s.ops {
+		out = append(out, op)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Path != out[j].Path {
+			return out[i].Path < out[j].Path
+		}
+		return out[i].Method < out[j].Method
+	})
+	return out
+}
+
+func (s *Spec) prepareOperation(doc *document, op *Operation, shared []*Parameter) error {
+
+	// 1. Parameters, the path item's first, then the operation's by name and location
+	var params []*Parameter
+	for _, list := range [][]*Parameter{shared, op.Parameters} {
+		for _, p := range list {
+			if p.Ref != "" {
+				ref, ok := doc.Components.Parameters[strings.TrimPrefix(p.Ref, "#/components/parameters/")]
+				if !ok {
+					return fmt.Errorf("unknown parameter %s", p.Ref)
+				}
+				p = ref
+			}
+			if err := s.prepare(p.Schema); err != nil {
+				return fmt.Errorf("parameter %s: %w", p.Name, err)
+			}
+			params = replaceParam(params, p)
+		}
+	}
+	op.Parameters = params
+	for _, name := range templateParam.FindAllStringSubmatch(op.Template, -1) {
+		if p := op.param("path", name[1]); p == nil || !p.Required {
+			return fmt.Errorf("path parameter %s is not declared as required", name[1])
+		}
+	}
+
+	// 2. Request body
+	if op.RequestBody != nil {
+		for mt, m := range op.RequestBody.Content {
+			if err := s.prepare(m.Schema); err != nil {
+				return fmt.Errorf("request %s: %w", mt, err)
+			}
+		}
+	}
+
+	// 3. Responses
+	if len(op.Responses) == 0 {
+		return fmt.Errorf("no responses")
+	}
+	for code, r := range op.Responses {
+		if r.Ref != "" {
+			ref, ok := doc.Components.Responses[strings.TrimPrefix(r.Ref, "#/components/responses/")]
+			if !ok {
+				return fmt.Errorf("unknown response %s", r.Ref)
+			}
+			r = ref
+			op.Responses[code] = r
+		}
+		for mt, m := range r.Content {
+			if err := s.prepare(m.Schema); err != nil {
+				return fmt.Errorf("response %s %s: %w", code, mt, err)
+			}
+		}
+	}
+	return nil
+}
+
+func replaceParam(params []*Parameter, p *Parameter) []*Parameter {
+	for i, q := range params {
+		if q.Name == p.Name && q.In == p.In {
+			params[i] = p
+			return params
+		}
+	}
+	return append(params, p)
+}
+
+func (o *Operation) param(in, name string) *Parameter {
+	for _, p := range o.Parameters {
+		if p.In == in && p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
@@ -0,0 +1,283 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+// Schema The JSON Schema keywords the document uses; others are ignored
+type Schema struct {
+	Ref    string   `yaml:"$ref"`
+	Type   typeList `yaml:"type"`
+	Format string   `yaml:"format"`
+	Enum   []any    `yaml:"enum"`
+	// Properties and Required of an object; AdditionalProperties false refuses the rest
+	Properties           map[string]*Schema `yaml:"properties"`
+	Required             []string           `yaml:"required"`
+	AdditionalProperties *additional        `yaml:"additionalProperties"`
+	Items                *Schema            `yaml:"items"`
+	OneOf                []*Schema          `yaml:"oneOf"`
+	AnyOf                []*Schema          `yaml:"anyOf"`
+	AllOf                []*Schema          `yaml:"allOf"`
+	MinLength            *int               `yaml:"minLength"`
+	MaxLength            *int               `yaml:"maxLength"`
+	Minimum              *float64           `yaml:"minimum"`
+	Maximum              *float64           `yaml:"maximum"`
+	MinItems             *int               `yaml:"minItems"`
+	MaxItems             *int               `yaml:"maxItems"`
+	Pattern              string             `yaml:"pattern"`
+
+	pattern  *regexp.Regexp
+	prepared bool
+}
+
+// typeList type as a single name or, in 3.1, a list such as [string, "null"]
+type typeList []string
+
+func (t *typeList) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		*t = typeList{n.Value}
+		return nil
+	}
+	var list []string
+	if err := n.Decode(&list); err != nil {
+		return err
+	}
+	*t = list
+	return nil
+}
+
+// additional additionalProperties: a boolean, or the schema of every other property
+type additional struct {
+	Allowed bool
+	Schema  *Schema
+}
+
+func (a *additional) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		return n.Decode(&a.Allowed)
+	}
+	a.Allowed = true
+	return n.Decode(&a.Schema)
+}
+
+// prepare Check references and compile patterns, once per schema
+func (s *Spec) prepare(schema *Schema) error {
+	if schema == nil || schema.prepared {
+		return nil
+	}
+	schema.prepared = true
+	if schema.Ref != "" {
+		if _, err := s.resolve(schema); err != nil {
+			return err
+		}
+	}
+	if schema.Pattern != "" {
+		re, err := regexp.Compile(schema.Pattern)
+		if err != nil {
+			return fmt.Errorf("pattern %q: %w", schema.Pattern, err)
+		}
+		schema.pattern = re
+	}
+	children := []*Schema{schema.Items}
+	for _, p := range schema.Properties {
+		children = append(children, p)
+	}
+	if schema.AdditionalProperties != nil {
+		children = append(children, schema.AdditionalProperties.Schema)
+	}
+	children = append(children, schema.OneOf...)
+	children = append(children, schema.AnyOf...)
+	children = append(children, schema.AllOf...)
+	for _, c := range children {
+		if err := s.prepare(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Spec) resolve(schema *Schema) (*Schema, error) {
+	for i := 0; schema.Ref != ""; i++ {
+		name, ok := strings.CutPrefix(schema.Ref, "#/components/schemas/")
+		next := s.schemas[name]
+		if !ok || next == nil || i > 32 {
+			return nil, fmt.Errorf("unknown schema %s", schema.Ref)
+		}
+		schema = next
+	}
+	return schema, nil
+}
+
+// validate Issues of v, a value decoded by encoding/json with UseNumber, under schema; at
+// names where v sits, such as body.items[0].id
+func (s *Spec) validate(schema *Schema, v any, at string) []Issue {
+	if schema == nil {
+		return nil
+	}
+	schema, err := s.resolve(schema)
+	if err != nil {
+		return []Issue{{at, err.Error()}}
+	}
+
+	// 1. Type
+	if len(schema.Type) > 0 && !typeMatches(schema.Type, v) {
+		return []Issue{{at, fmt.Sprintf("must be %s, got %s", strings.Join(schema.Type, " or "), typeOf(v))}}
+	}
+	var issues []Issue
+	add := func(format string, args ...any) {
+		issues = append(issues, Issue{at, fmt.Sprintf(format, args...)})
+	}
+
+	// 2. Enum
+	if len(schema.Enum) > 0 && !inEnum(schema.Enum, v) {
+		add("must be one of %v", schema.Enum)
+	}
+
+	// 3. Keywords of the value's own type
+	switch x := v.(type) {
+	case string:
+		n := utf8.RuneCountInString(x)
+		if schema.MinLength != nil && n < *schema.MinLength {
+			add("must be at least %d characters", *schema.MinLength)
+		}
+		if schema.MaxLength != nil && n > *schema.MaxLength {
+			add("must be at most %d characters", *schema.MaxLength)
+		}
+		if schema.pattern != nil && !schema.pattern.MatchString(x) {
+			add("must match %s", schema.Pattern)
+		}
+		if !formatMatches(schema.Format, x) {
+			add("must be a valid %s", schema.Format)
+		}
+	case json.Number:
+		f, _ := x.Float64()
+		if schema.Minimum != nil && f < *schema.Minimum {
+			add("must be >= %v", *schema.Minimum)
+		}
+		if schema.Maximum != nil && f > *schema.Maximum {
+			add("must be <= %v", *schema.Maximum)
+		}
+	case []any:
+		if schema.MinItems != nil && len(x) < *schema.MinItems {
+			add("must have at least %d items", *schema.MinItems)
+		}
+		if schema.MaxItems != nil && len(x) > *schema.MaxItems {
+			add("must have at most %d items", *schema.MaxItems)
+		}
+		for i, item := range x {
+			issues = append(issues, s.validate(schema.Items, item, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+	case map[string]any:
+		for _, name := range schema.Required {
+			if _, ok := x[name]; !ok {
+				issues = append(issues, Issue{at + "." + name, "is required"})
+			}
+		}
+		for name, val := range x {
+			if p, ok := schema.Properties[name]; ok {
+				issues = append(issues, s.validate(p, val, at+"."+name)...)
+				continue
+			}
+			if a := schema.AdditionalProperties; a != nil {
+				if !a.Allowed {
+					issues = append(issues, Issue{at + "." + name, "is not allowed"})
+				} else {
+					issues = append(issues, s.validate(a.Schema, val, at+"."+name)...)
+				}
+			}
+		}
+	}
+
+	// 4. Composition
+	for _, sub := range schema.AllOf {
+		issues = append(issues, s.validate(sub, v, at)...)
+	}
+	if len(schema.AnyOf) > 0 && s.matching(schema.AnyOf, v, at) == 0 {
+		add("must match at least one of %d schemas", len(schema.AnyOf))
+	}
+	if len(schema.OneOf) > 0 {
+		if n := s.matching(schema.OneOf, v, at); n != 1 {
+			add("must match exactly one of %d schemas, matches %d", len(schema.OneOf), n)
+		}
+	}
+	return issues
+}
+
+func (s *Spec) matching(schemas []*Schema, v any, at string) int {
+	n := 0
+	for _, sub := range schemas {
+		if len(s.validate(sub, v, at)) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func typeMatches(types []string, v any) bool {
+	got := typeOf(v)
+	for _, t := range types {
+		if t == got || (t == "number" && got == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func typeOf(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if f, err := x.Float64(); err == nil && f == math.Trunc(f) && !strings.ContainsAny(x.String(), ".eE") {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// inEnum Compare as JSON, so the document's YAML ints match decoded json.Numbers
+func inEnum(enum []any, v any) bool {
+	got, _ := json.Marshal(v)
+	for _, e := range enum {
+		want, _ := json.Marshal(e)
+		if bytes.Equal(got, want) {
+			return true
+		}
+	}
+	return false
+}
+
+func formatMatches(format, v string) bool {
+	switch format {
+	case "date-time":
+		_, err := time.Parse(time.RFC3339, v)
+		return err == nil
+	case "uuid":
+		_, err := uuid.Parse(v)
+		return err == nil && len(v) == 36
+	case "email":
+		a, err := mail.ParseAddress(v)
+		return err == nil && a.Address == v
+	}
+	return true
+}
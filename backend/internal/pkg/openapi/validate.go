@@ -0,0 +1,153 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Issue One way a request or response differs from the document
+type Issue struct {
+	// At Where: path.id, query.limit, header.X-Admin-Reason, body.email, status
+	At      string `json:"at"`
+	Message string `json:"message"`
+}
+
+func (i Issue) String() string {
+	return i.At + ": " + i.Message
+}
+
+// ValidateRequest Issues of a request with its path parameters and body, read already.
+// Credentials are left to the handlers, a request without them is answered with 401
+func (o *Operation) ValidateRequest(r *http.Request, pathParams map[string]string, body []byte) []Issue {
+	var issues []Issue
+
+	// 1. Parameters
+	query := r.URL.Query()
+	for _, p := range o.Parameters {
+		var (
+			raw     string
+			present bool
+		)
+		switch p.In {
+		case "path":
+			raw, present = pathParams[p.Name]
+		case "query":
+			present = query.Has(p.Name)
+			raw = query.Get(p.Name)
+		case "header":
+			raw = r.Header.Get(p.Name)
+			present = raw != ""
+		default:
+			continue
+		}
+		at := p.In + "." + p.Name
+		if !present {
+			if p.Required {
+				issues = append(issues, Issue{at, "is required"})
+			}
+			continue
+		}
+		issues = append(issues, o.spec.validate(p.Schema, o.spec.coerce(p.Schema, raw), at)...)
+	}
+
+	// 2. Body
+	if o.RequestBody == nil {
+		if len(body) > 0 {
+			issues = append(issues, Issue{"body", "is not documented"})
+		}
+		return issues
+	}
+	if len(body) == 0 {
+		if o.RequestBody.Required {
+			issues = append(issues, Issue{"body", "is required"})
+		}
+		return issues
+	}
+	return append(issues, o.spec.validateContent(o.RequestBody.Content, r.Header.Get("Content-Type"), body, "body")...)
+}
+
+// ValidateResponse Issues of a response: its status must be documented, and so must its body
+func (o *Operation) ValidateResponse(status int, header http.Header, body []byte) []Issue {
+	code := strconv.Itoa(status)
+	r, ok := o.Responses[code]
+	if !ok {
+		r, ok = o.Responses[code[:1]+"XX"]
+	}
+	if !ok {
+		r, ok = o.Responses["default"]
+	}
+	if !ok {
+		return []Issue{{"status", code + " is not documented"}}
+	}
+	if len(body) == 0 {
+		if len(r.Content) > 0 {
+			return []Issue{{"response", "body is missing"}}
+		}
+		return nil
+	}
+	if len(r.Content) == 0 {
+		return []Issue{{"response", "body is not documented"}}
+	}
+	return o.spec.validateContent(r.Content, header.Get("Content-Type"), body, "response")
+}
+
+// validateContent The media type must be documented, a JSON one also matches its schema
+func (s *Spec) validateContent(content map[string]*MediaType, contentType string, body []byte, at string) []Issue {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return []Issue{{at, fmt.Sprintf("content type %q is not valid", contentType)}}
+	}
+	m, ok := content[mt]
+	if !ok {
+		m, ok = content[strings.SplitN(mt, "/", 2)[0]+"/*"]
+	}
+	if !ok {
+		m, ok = content["*/*"]
+	}
+	if !ok {
+		return []Issue{{at, fmt.Sprintf("content type %s is not documented", mt)}}
+	}
+	if m.Schema == nil || !isJSON(mt) {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return []Issue{{at, "is not valid JSON"}}
+	}
+	return s.validate(m.Schema, v, at)
+}
+
+func isJSON(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// coerce A parameter's text as the type its schema wants, left as is when it is not one
+func (s *Spec) coerce(schema *Schema, raw string) any {
+	if schema == nil {
+		return raw
+	}
+	schema, err := s.resolve(schema)
+	if err != nil {
+		return raw
+	}
+	for _, t := range schema.Type {
+		switch t {
+		case "integer", "number":
+			if _, err := strconv.ParseFloat(raw, 64); err == nil {
+				return json.Number(raw)
+			}
+		case "boolean":
+			if b, err := strconv.ParseBool(raw); err == nil {
+				return b
+			}
+		}
+	}
+	return raw
+}
@@ -0,0 +1,171 @@
+package passwordhash
+
+import (
+	"backend/internal/config"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	saltLen = 16
+	keyLen  = 32
+)
+
+var (
+	// ErrBusy Too many hashes queued; shed load rather than let every caller time out
+	ErrBusy = errors.New("passwordhash: busy")
+	// ErrMalformed Stored hash is not a format this package knows
+	ErrMalformed = errors.New("passwordhash: malformed hash")
+)
+
+// Params Argon2id cost, encoded into every hash so old hashes still verify after a change
+type Params struct {
+	// Memory KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// Hasher Argon2id hashing and verification, at most a fixed number at a time
+type Hasher struct {
+	params Params
+	slots  chan struct{}
+	queued atomic.Int64
+	// maxQueue Callers allowed to wait for a slot before ErrBusy
+	maxQueue int64
+}
+
+// Hash Argon2id PHC string: $argon2id$v=19$m=<KiB>,t=<iterations>,p=<lanes>$<salt>$<hash>
+func (h *Hasher) Hash(ctx context.Context, password string) (string, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	if err := h.acquire(ctx); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.params.Iterations, h.params.Memory, h.params.Parallelism, keyLen)
+	h.release()
+	return encode(h.params, salt, key), nil
+}
+
+// Verify Check password against a stored Argon2id or legacy bcrypt hash. rehash reports
+// a match whose hash is bcrypt or uses other params, the caller should store a new Hash.
+// An empty stored hash, an account without a password, never matches
+func (h *Hasher) Verify(ctx context.Context, password, stored string) (ok, rehash bool, err error) {
+	switch {
+	case stored == "":
+		return false, false, nil
+	case strings.HasPrefix(stored, "$argon2id$"):
+		p, salt, key, err := decode(stored)
+		if err != nil {
+			return false, false, err
+		}
+		if err := h.acquire(ctx); err != nil {
+			return false, false, err
+		}
+		got := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, uint32(len(key)))
+		h.release()
+		if subtle.ConstantTimeCompare(got, key) != 1 {
+			return false, false, nil
+		}
+		return true, p != h.params || len(salt) != saltLen || len(key) != keyLen, nil
+	case strings.HasPrefix(stored, "$2a$"), strings.HasPrefix(stored, "$2b$"), strings.HasPrefix(stored, "$2y$"):
+		if err := h.acquire(ctx); err != nil {
+			return false, false, err
+		}
+		err := bcrypt.CompareHashAndPassword([]byte(stored), []byte(password))
+		h.release()
+		if err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return false, false, nil
+			}
+			return false, false, fmt.Errorf("%w: %v", ErrMalformed, err)
+		}
+		return true, true, nil
+	default:
+		return false, false, ErrMalformed
+	}
+}
+
+// acquire Wait for a slot until ctx ends; fail fast when the queue is already full
+func (h *Hasher) acquire(ctx context.Context) error {
+	select {
+	case h.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if h.queued.Add(1) > h.maxQueue {
+		h.queued.Add(-1)
+		return ErrBusy
+	}
+	defer h.queued.Add(-1)
+	select {
+	case h.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h *Hasher) release() {
+	<-h.slots
+}
+
+func encode(p Params, salt, key []byte) string {
+	b64 := base64.RawStdEncoding.EncodeToString
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Iterations, p.Parallelism, b64(salt), b64(key))
+}
+
+func decode(s string) (Params, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, hash
+	parts := strings.Split(s, "$")
+	if len(parts) != 6 {
+		return Params{}, nil, nil, ErrMalformed
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Params{}, nil, nil, ErrMalformed
+	}
+	var p Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return Params{}, nil, nil, ErrMalformed
+	}
+	if p.Memory == 0 || p.Iterations == 0 || p.Parallelism == 0 {
+		return Params{}, nil, nil, ErrMalformed
+	}
+	salt, err1 := base64.RawStdEncoding.DecodeString(parts[4])
+	key, err2 := base64.RawStdEncoding.DecodeString(parts[5])
+	if err1 != nil || err2 != nil || len(salt) == 0 || len(key) == 0 {
+		return Params{}, nil, nil, ErrMalformed
+	}
+	return p, salt, key, nil
+}
+
+// New Workers 0 means one per CPU the runtime may use
+func New(cfg config.PasswordHash) *Hasher {
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	return &Hasher{
+		params: Params{
+			Memory:      uint32(cfg.MemoryKiB),
+			Iterations:  uint32(cfg.Iterations),
+			Parallelism: uint8(cfg.Parallelism),
+		},
+		slots:    make(chan struct{}, workers),
+		maxQueue: int64(cfg.MaxQueue),
+	}
+}
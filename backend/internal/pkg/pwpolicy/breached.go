@@ -0,0 +1,71 @@
+package pwpolicy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// breachedSet k-anonymity dataset on disk: the 5 hex digit SHA-1 prefix names a file, which
+// lists the remaining 35 digits of every breached hash with that prefix and a count. A check
+// reads only its own prefix file, nothing is held in memory
+type breachedSet struct {
+	dir string
+}
+
+func openBreached(dir string) (*breachedSet, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("password policy breached dir: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("password policy breached dir %s: not a directory", dir)
+	}
+	return &breachedSet{dir: dir}, nil
+}
+
+// count Times password appears in the dataset, 0 when its prefix file does not exist
+func (b *breachedSet) count(password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	h := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := h[:5], h[5:]
+
+	var f *os.File
+	var err error
+	for _, name := range []string{prefix, prefix + ".txt"} {
+		if f, err = os.Open(filepath.Join(b.dir, name)); !errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("password policy breached %s: %w", prefix, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		s, n, ok := strings.Cut(strings.TrimSpace(sc.Text()), ":")
+		if !ok || !strings.EqualFold(s, suffix) {
+			continue
+		}
+		count, err := strconv.Atoi(n)
+		if err != nil {
+			return 0, fmt.Errorf("password policy breached %s: bad count %q", prefix, n)
+		}
+		return count, nil
+	}
+	if err := sc.Err(); err != nil {
+		return 0, fmt.Errorf("password policy breached %s: %w", prefix, err)
+	}
+	return 0, nil
+}
@@ -0,0 +1,74 @@
+package pwpolicy
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// commonList Most used passwords of public breach compilations, lowercase, one per line
+//
+//go:embed common.txt
+var commonList []byte
+
+var leet = map[rune]rune{'@': 'a', '4': 'a', '8': 'b', '3': 'e', '6': 'g', '1': 'i', '!': 'i', '0': 'o', '$': 's', '5': 's', '7': 't', '+': 't', '2': 'z'}
+
+// unleet Undo the usual letter-to-digit and letter-to-symbol swaps, rune for rune
+func unleet(s string) string {
+	return strings.Map(unleetRune, s)
+}
+
+func unleetRune(r rune) rune {
+	if l, ok := leet[r]; ok {
+		return l
+	}
+	return r
+}
+
+// isCommon The password, or the word left after dropping capitals, leetspeak and digits or
+// symbols around it, is on the list
+func (p *Policy) isCommon(password string) bool {
+	lower := strings.ToLower(password)
+	core := strings.TrimFunc(lower, func(r rune) bool { return !unicode.IsLetter(r) })
+	for _, c := range []string{lower, unleet(lower), core, unleet(core)} {
+		if _, ok := p.common[c]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// loadCommon The built-in list plus the lines of file, if any
+func loadCommon(file string) (map[string]struct{}, error) {
+	set := map[string]struct{}{}
+	if err := readList(bytes.NewReader(commonList), set); err != nil {
+		return nil, err
+	}
+	if file == "" {
+		return set, nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("password policy common file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	if err := readList(f, set); err != nil {
+		return nil, fmt.Errorf("password policy common file %s: %w", file, err)
+	}
+	return set, nil
+}
+
+func readList(r io.Reader, set map[string]struct{}) error {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		if w := strings.ToLower(strings.TrimSpace(sc.Text())); w != "" && !strings.HasPrefix(w, "#") {
+			set[w] = struct{}{}
+		}
+	}
+	return sc.Err()
+}
@@ -0,0 +1,145 @@
+// Package pwpolicy Decides whether a password may be set: length and character classes, the
+// user's own email or username, common and breached passwords, and an estimate of strength
+package pwpolicy
+
+import (
+	"backend/internal/config"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Codes of a Violation, stable for clients to show their own message
+const (
+	CodeTooShort         = "PASSWORD_TOO_SHORT"
+	CodeTooLong          = "PASSWORD_TOO_LONG"
+	CodeMissingClasses   = "PASSWORD_MISSING_CLASSES"
+	CodeContainsIdentity = "PASSWORD_CONTAINS_IDENTITY"
+	CodeCommon           = "PASSWORD_COMMON"
+	CodeBreached         = "PASSWORD_BREACHED"
+	CodeTooWeak          = "PASSWORD_TOO_WEAK"
+)
+
+// MaxLength Upper bound of any configured max length, also what a login accepts
+const MaxLength = 256
+
+// Violation The first rule a password broke
+type Violation struct {
+	Code    string
+	Message string
+	// Args Name and value pairs of the limit broken, to word Code in other languages
+	Args []any
+}
+
+func (v *Violation) Error() string {
+	return v.Message
+}
+
+// Policy Rules of config.PasswordPolicy, with the common list and breached dataset loaded
+type Policy struct {
+	cfg      config.PasswordPolicy
+	common   map[string]struct{}
+	breached *breachedSet
+}
+
+// Check nil when password is acceptable, a *Violation when it is not. Other errors come from
+// reading the breached dataset. email and username may be empty
+func (p *Policy) Check(password, email, username string) error {
+
+	// 1. Length, in characters
+	n := utf8.RuneCountInString(password)
+	if n < p.cfg.MinLength {
+		return &Violation{CodeTooShort, fmt.Sprintf("Password must be at least %d characters.", p.cfg.MinLength), []any{"min", p.cfg.MinLength}}
+	}
+	if n > p.cfg.MaxLength {
+		return &Violation{CodeTooLong, fmt.Sprintf("Password must be at most %d characters.", p.cfg.MaxLength), []any{"max", p.cfg.MaxLength}}
+	}
+
+	// 2. Character classes
+	if classes(password) < p.cfg.MinClasses {
+		return &Violation{CodeMissingClasses, fmt.Sprintf("Password must mix at least %d of lowercase, uppercase, digits and symbols.", p.cfg.MinClasses), []any{"min", p.cfg.MinClasses}}
+	}
+
+	// 3. The user's own email or username
+	if p.cfg.RejectIdentity && containsIdentity(password, email, username) {
+		return &Violation{CodeContainsIdentity, "Password must not contain your email or username.", nil}
+	}
+
+	// 4. Common passwords, also behind capitals, leetspeak and a trailing number or symbol
+	if p.isCommon(password) {
+		return &Violation{CodeCommon, "This password is too common.", nil}
+	}
+
+	// 5. Strength
+	if p.Score(password) < p.cfg.MinScore {
+		return &Violation{CodeTooWeak, "Password is too easy to guess. Try a longer one or a few unrelated words.", nil}
+	}
+
+	// 6. Breached, last as it reads from disk
+	if p.breached != nil {
+		count, err := p.breached.count(password)
+		if err != nil {
+			return err
+		}
+		if count >= p.cfg.BreachedMinCount {
+			return &Violation{CodeBreached, "This password has appeared in a data breach. Please choose another.", nil}
+		}
+	}
+	return nil
+}
+
+func classes(password string) int {
+	var lower, upper, digit, symbol int
+	for _, c := range password {
+		switch {
+		case unicode.IsLower(c):
+			lower = 1
+		case unicode.IsUpper(c):
+			upper = 1
+		case unicode.IsDigit(c):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
+
+// containsIdentity The email local part, its parts split at separators, or the username,
+// when long enough to mean something
+func containsIdentity(password, email, username string) bool {
+	pw := strings.ToLower(password)
+	plain := unleet(pw)
+	var parts []string
+	if local, _, ok := strings.Cut(strings.ToLower(email), "@"); ok {
+		parts = append(parts, local)
+		parts = append(parts, strings.FieldsFunc(local, func(r rune) bool { return strings.ContainsRune("._-+", r) })...)
+	}
+	if username != "" {
+		parts = append(parts, strings.ToLower(username))
+	}
+	for _, part := range parts {
+		if utf8.RuneCountInString(part) < 3 {
+			continue
+		}
+		if strings.Contains(pw, part) || strings.Contains(plain, part) {
+			return true
+		}
+	}
+	return false
+}
+
+func New(cfg config.PasswordPolicy) (*Policy, error) {
+	common, err := loadCommon(cfg.CommonFile)
+	if err != nil {
+		return nil, err
+	}
+	p := &Policy{cfg: cfg, common: common}
+	if cfg.BreachedDir != "" {
+		if p.breached, err = openBreached(cfg.BreachedDir); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,162 @@
+package pwpolicy
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// keyboardRows Runs along these are as easy to guess as sequences
+var keyboardRows = []string{"1234567890", "qwertyuiop", "asdfghjkl", "zxcvbnm", "qwertzuiop", "azertyuiop", "qsdfghjklm", "wxcvbn"}
+
+// Score Strength from 0 (guessable in seconds) to 4 (very strong), on the bits an attacker
+// needs when trying repeats, sequences, keyboard runs and common words before brute force
+func (p *Policy) Score(password string) int {
+	bits := p.entropy(password)
+	switch {
+	case bits < 20:
+		return 0
+	case bits < 30:
+		return 1
+	case bits < 45:
+		return 2
+	case bits < 60:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// entropy Greedy left to right: each position starts the cheapest pattern found there, or
+// costs one brute-force character
+func (p *Policy) entropy(password string) float64 {
+	raw := []rune(password)
+	lower := []rune(strings.ToLower(password))
+	if len(lower) != len(raw) {
+		lower = raw
+	}
+	plain := []rune(unleet(string(lower)))
+	perChar := math.Log2(float64(pool(raw)))
+
+	var bits float64
+	for i := 0; i < len(raw); {
+		n, cost := 1, perChar
+		if l := repeatLen(lower, i); l >= 3 {
+			n, cost = l, perChar+math.Log2(float64(l))
+		}
+		if l := sequenceLen(lower, i); l >= 3 && l >= n {
+			n, cost = l, math.Log2(26)+math.Log2(float64(l))+1
+		}
+		if l := keyboardLen(lower, i); l >= 4 && l >= n {
+			n, cost = l, math.Log2(float64(len(keyboardRows)*10))+math.Log2(float64(l))+1
+		}
+		if l := p.wordLen(plain, i); l >= 4 && l >= n {
+			n, cost = l, math.Log2(float64(len(p.common)))+variants(raw[i:i+l], plain[i:i+l])
+		}
+		bits += cost
+		i += n
+	}
+	return bits
+}
+
+// pool Size of the alphabet the password draws from
+func pool(s []rune) int {
+	var lower, upper, digit, symbol, other bool
+	for _, c := range s {
+		switch {
+		case c > unicode.MaxASCII:
+			other = true
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsDigit(c):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	n := 0
+	for _, c := range []struct {
+		set  bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if c.set {
+			n += c.size
+		}
+	}
+	return max(n, 2)
+}
+
+func repeatLen(s []rune, i int) int {
+	j := i + 1
+	for j < len(s) && s[j] == s[i] {
+		j++
+	}
+	return j - i
+}
+
+// sequenceLen abc, 987 and the like
+func sequenceLen(s []rune, i int) int {
+	if i+1 >= len(s) {
+		return 1
+	}
+	step := s[i+1] - s[i]
+	if step != 1 && step != -1 {
+		return 1
+	}
+	j := i + 1
+	for j < len(s) && s[j]-s[j-1] == step {
+		j++
+	}
+	return j - i
+}
+
+// keyboardLen Longest run from i along a keyboard row, either direction
+func keyboardLen(s []rune, i int) int {
+	best := 1
+	for _, row := range keyboardRows {
+		for _, r := range []string{row, reverse(row)} {
+			k := strings.IndexRune(r, s[i])
+			if k < 0 {
+				continue
+			}
+			n := 1
+			for i+n < len(s) && k+n < len(r) && rune(r[k+n]) == s[i+n] {
+				n++
+			}
+			best = max(best, n)
+		}
+	}
+	return best
+}
+
+// wordLen Longest common-list word starting at i
+func (p *Policy) wordLen(plain []rune, i int) int {
+	for j := min(len(plain), i+32); j >= i+4; j-- {
+		if _, ok := p.common[string(plain[i:j])]; ok {
+			return j - i
+		}
+	}
+	return 0
+}
+
+// variants Bits for capitals and leetspeak in a matched word
+func variants(raw, plain []rune) float64 {
+	var bits float64
+	if strings.ToLower(string(raw)) != string(raw) {
+		bits++
+	}
+	if string(plain) != strings.ToLower(string(raw)) {
+		bits++
+	}
+	return bits
+}
+
+func reverse(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
@@ -0,0 +1,98 @@
+// Package ratelimit Sliding-window and token-bucket limits kept in Redis, each decision one
+// atomic Lua call so every instance shares the same counts
+package ratelimit
+
+import (
+	"backend/internal/config"
+	"context"
+	_ "embed"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Algorithm How a Policy counts
+type Algorithm string
+
+const (
+	// SlidingWindow At most Limit requests in any Window, estimated from the current and the
+	// previous fixed window
+	SlidingWindow Algorithm = "sliding_window"
+	// TokenBucket Limit tokens refilled per Window, up to Burst at once
+	TokenBucket Algorithm = "token_bucket"
+)
+
+// Policy A named limit; the name is part of the Redis key, so two policies never share counts
+type Policy struct {
+	Name      string
+	Algorithm Algorithm
+	Limit     int
+	Window    time.Duration
+	// Burst Token bucket capacity, 0 means Limit
+	Burst int
+}
+
+// Result The decision and what to tell the client about it
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset Until the window frees up, or the bucket is full again
+	Reset time.Duration
+	// RetryAfter Until a denied request would pass, 0 when allowed
+	RetryAfter time.Duration
+}
+
+//go:embed sliding_window.lua
+var slidingWindowLua string
+
+//go:embed token_bucket.lua
+var tokenBucketLua string
+
+// Limiter Applies policies to subjects such as an IP or a user id
+type Limiter struct {
+	rdb           *redis.Client
+	slidingWindow *redis.Script
+	tokenBucket   *redis.Script
+}
+
+// Allow Count one request of subject against p
+func (l *Limiter) Allow(ctx context.Context, p Policy, subject string) (Result, error) {
+	key := config.RedisKeyRateLimit(p.Name, subject)
+	var cmd *redis.Cmd
+	switch p.Algorithm {
+	case SlidingWindow:
+		cmd = l.slidingWindow.Run(ctx, l.rdb, []string{key}, p.Limit, p.Window.Milliseconds(), 1)
+	case TokenBucket:
+		burst := p.Burst
+		if burst <= 0 {
+			burst = p.Limit
+		}
+		cmd = l.tokenBucket.Run(ctx, l.rdb, []string{key}, p.Limit, p.Window.Milliseconds(), burst, 1)
+	default:
+		return Result{}, fmt.Errorf("ratelimit %s: unknown algorithm %q", p.Name, p.Algorithm)
+	}
+	v, err := cmd.Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	if len(v) != 4 {
+		return Result{}, fmt.Errorf("ratelimit %s: unexpected reply %v", p.Name, v)
+	}
+	return Result{
+		Allowed:    v[0] == 1,
+		Limit:      p.Limit,
+		Remaining:  int(v[1]),
+		Reset:      time.Duration(v[2]) * time.Millisecond,
+		RetryAfter: time.Duration(v[3]) * time.Millisecond,
+	}, nil
+}
+
+func New(rdb *redis.Client) *Limiter {
+	return &Limiter{
+		rdb:           rdb,
+		slidingWindow: redis.NewScript(slidingWindowLua),
+		tokenBucket:   redis.NewScript(tokenBucketLua),
+	}
+}
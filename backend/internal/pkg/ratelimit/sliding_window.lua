@@ -0,0 +1,48 @@
+-- KEYS[1]=bucket hash {start, cur, prev}
+-- ARGV[1]=limit, ARGV[2]=window(ms), ARGV[3]=cost
+-- Returns {allowed, remaining, reset(ms), retry_after(ms)}
+
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+
+-- 1. Server clock, the same for every instance
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local start = now - (now % window)
+
+-- 2. Roll the window: the current count becomes the previous one, or both age out
+local h = redis.call("HMGET", KEYS[1], "start", "cur", "prev")
+local cur = tonumber(h[2]) or 0
+local prev = tonumber(h[3]) or 0
+local last = tonumber(h[1])
+if last ~= start then
+    if last == start - window then
+        prev = cur
+    else
+        prev = 0
+    end
+    cur = 0
+end
+
+-- 3. Estimate: the previous window weighted by how much of it still overlaps
+local elapsed = now - start
+local estimate = prev * (window - elapsed) / window + cur
+local reset = window - elapsed
+
+if estimate + cost > limit then
+    -- Wait until enough of the previous window slid out, or for the next window
+    local retry = reset
+    local room = limit - cur - cost
+    if prev > 0 and room >= 0 then
+        retry = math.max(1, math.ceil((window - elapsed) - room * window / prev))
+    end
+    redis.call("HSET", KEYS[1], "start", start, "cur", cur, "prev", prev)
+    redis.call("PEXPIRE", KEYS[1], window * 2)
+    return {0, math.max(0, math.floor(limit - estimate)), reset, retry}
+end
+
+cur = cur + cost
+redis.call("HSET", KEYS[1], "start", start, "cur", cur, "prev", prev)
+redis.call("PEXPIRE", KEYS[1], window * 2)
+return {1, math.max(0, math.floor(limit - estimate - cost)), reset, 0}
@@ -0,0 +1,39 @@
+-- KEYS[1]=bucket hash {tokens, ts}
+-- ARGV[1]=rate(tokens per window), ARGV[2]=window(ms), ARGV[3]=burst, ARGV[4]=cost
+-- Returns {allowed, remaining, reset(ms), retry_after(ms)}
+
+local rate = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+local cost = tonumber(ARGV[4])
+
+-- 1. Server clock, the same for every instance
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+-- 2. Refill since the last request, a new bucket starts full
+local h = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(h[1])
+local ts = tonumber(h[2])
+if tokens == nil or ts == nil then
+    tokens = burst
+else
+    tokens = math.min(burst, tokens + (now - ts) * rate / window)
+end
+
+-- 3. Take
+local allowed = 0
+local retry = 0
+if tokens >= cost then
+    tokens = tokens - cost
+    allowed = 1
+else
+    retry = math.ceil((cost - tokens) * window / rate)
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+-- Kept until it would be full again
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst * window / rate) + 1000)
+
+local reset = math.ceil((burst - tokens) * window / rate)
+return {allowed, math.floor(tokens), reset, retry}
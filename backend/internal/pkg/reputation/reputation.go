@@ -0,0 +1,243 @@
+// Package reputation Allow and deny decisions for client IPs and email domains, from CIDR
+// lists, disposable-domain lists and entries managed at runtime
+package reputation
+
+import (
+	"backend/internal/config"
+	"bufio"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+)
+
+// Verdict What the lists say about an IP or a domain
+type Verdict int
+
+const (
+	// Neutral Not listed
+	Neutral Verdict = iota
+	// Allow Listed as trusted; beats any deny, disposable or ban
+	Allow
+	// Deny Listed as abusive
+	Deny
+	// Disposable Throwaway mailbox provider: fine for an existing account, refused for a new address
+	Disposable
+)
+
+// Entry One managed allow or deny entry
+type Entry struct {
+	// Kind ip | domain
+	Kind   string
+	Value  string
+	Action string
+	// ExpiresAt Zero means never
+	ExpiresAt time.Time
+}
+
+// Static Lists loaded once from config and files
+type Static struct {
+	allowNets  []netip.Prefix
+	denyNets   []netip.Prefix
+	disposable map[string]struct{}
+}
+
+// Lists Static lists with the managed entries on top, immutable once compiled
+type Lists struct {
+	allowNets    []netip.Prefix
+	denyNets     []netip.Prefix
+	allowDomains map[string]struct{}
+	denyDomains  map[string]struct{}
+	disposable   map[string]struct{}
+}
+
+// IP Allow beats Deny. A nil Lists or an unparsable ip is Neutral
+func (l *Lists) IP(ip string) Verdict {
+	if l == nil {
+		return Neutral
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return Neutral
+	}
+	addr = addr.Unmap()
+	if containsAddr(l.allowNets, addr) {
+		return Allow
+	}
+	if containsAddr(l.denyNets, addr) {
+		return Deny
+	}
+	return Neutral
+}
+
+// Domain Verdict for the domain of an email address or the domain itself; a listed domain
+// covers its subdomains, so mail.example.com follows example.com
+func (l *Lists) Domain(domain string) Verdict {
+	if l == nil {
+		return Neutral
+	}
+	d := NormalizeDomain(domain)
+	if _, after, ok := strings.Cut(d, "@"); ok {
+		d = after
+	}
+	for _, suffix := range suffixes(d) {
+		if _, ok := l.allowDomains[suffix]; ok {
+			return Allow
+		}
+	}
+	for _, suffix := range suffixes(d) {
+		if _, ok := l.denyDomains[suffix]; ok {
+			return Deny
+		}
+	}
+	for _, suffix := range suffixes(d) {
+		if _, ok := l.disposable[suffix]; ok {
+			return Disposable
+		}
+	}
+	return Neutral
+}
+
+// Compile Layer entries over s; expired entries and ones that do not parse are left out
+func Compile(s *Static, entries []Entry, now time.Time) *Lists {
+	l := &Lists{
+		allowNets:    append([]netip.Prefix(nil), s.allowNets...),
+		denyNets:     append([]netip.Prefix(nil), s.denyNets...),
+		allowDomains: map[string]struct{}{},
+		denyDomains:  map[string]struct{}{},
+		disposable:   s.disposable,
+	}
+	for _, e := range entries {
+		if !e.ExpiresAt.IsZero() && !now.Before(e.ExpiresAt) {
+			continue
+		}
+		switch e.Kind {
+		case "ip":
+			p, err := ParsePrefix(e.Value)
+			if err != nil {
+				continue
+			}
+			if e.Action == "allow" {
+				l.allowNets = append(l.allowNets, p)
+			} else {
+				l.denyNets = append(l.denyNets, p)
+			}
+		case "domain":
+			if e.Action == "allow" {
+				l.allowDomains[NormalizeDomain(e.Value)] = struct{}{}
+			} else {
+				l.denyDomains[NormalizeDomain(e.Value)] = struct{}{}
+			}
+		}
+	}
+	return l
+}
+
+// ParsePrefix A CIDR, or a bare address as a single-host prefix
+func ParsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// NormalizeDomain Lowercase, without surrounding dots and spaces
+func NormalizeDomain(d string) string {
+	return strings.Trim(strings.ToLower(strings.TrimSpace(d)), ".")
+}
+
+// LoadStatic Parse the configured CIDRs and read the disposable-domain files
+func LoadStatic(cfg config.Reputation) (*Static, error) {
+	s := &Static{disposable: map[string]struct{}{}}
+	for _, c := range cfg.AllowCIDRs {
+		p, err := ParsePrefix(c)
+		if err != nil {
+			return nil, fmt.Errorf("reputation allow cidr %q: %w", c, err)
+		}
+		s.allowNets = append(s.allowNets, p)
+	}
+	for _, c := range cfg.DenyCIDRs {
+		p, err := ParsePrefix(c)
+		if err != nil {
+			return nil, fmt.Errorf("reputation deny cidr %q: %w", c, err)
+		}
+		s.denyNets = append(s.denyNets, p)
+	}
+	if cfg.DenyCIDRsFile != "" {
+		lines, err := readLines(cfg.DenyCIDRsFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range lines {
+			p, err := ParsePrefix(c)
+			if err != nil {
+				return nil, fmt.Errorf("reputation deny cidr file %s: %q: %w", cfg.DenyCIDRsFile, c, err)
+			}
+			s.denyNets = append(s.denyNets, p)
+		}
+	}
+	for _, file := range cfg.DisposableDomainFiles {
+		lines, err := readLines(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range lines {
+			s.disposable[NormalizeDomain(d)] = struct{}{}
+		}
+	}
+	return s, nil
+}
+
+// readLines Non-empty lines that are not # comments
+func readLines(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("reputation list: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	var out []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			out = append(out, line)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("reputation list %s: %w", file, err)
+	}
+	return out, nil
+}
+
+func containsAddr(nets []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range nets {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// suffixes a.b.c -> a.b.c, b.c, c
+func suffixes(d string) []string {
+	var out []string
+	for d != "" {
+		out = append(out, d)
+		_, rest, ok := strings.Cut(d, ".")
+		if !ok {
+			break
+		}
+		d = rest
+	}
+	return out
+}
@@ -0,0 +1,65 @@
+// Package secretbox Seal small secrets at rest with AES-256-GCM
+package secretbox
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+var ErrOpen = errors.New("secretbox: message authentication failed")
+
+// Box AES-GCM with a random nonce prepended to each sealed message
+type Box struct {
+	aead cipher.AEAD
+}
+
+// New Key must be 32 bytes
+func New(key []byte) (*Box, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("secretbox: key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Box{aead: aead}, nil
+}
+
+// FromBase64 New with a standard base64 key, as stored in config
+func FromBase64(key string) (*Box, error) {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("secretbox: key is not base64: %w", err)
+	}
+	return New(raw)
+}
+
+// Seal Encrypt plaintext; ad is authenticated but not stored, pass the owner's id so a
+// sealed value cannot be moved to another row
+func (b *Box) Seal(plaintext, ad []byte) ([]byte, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return b.aead.Seal(nonce, nonce, plaintext, ad), nil
+}
+
+func (b *Box) Open(sealed, ad []byte) ([]byte, error) {
+	n := b.aead.NonceSize()
+	if len(sealed) < n {
+		return nil, ErrOpen
+	}
+	out, err := b.aead.Open(nil, sealed[:n], sealed[n:], ad)
+	if err != nil {
+		return nil, ErrOpen
+	}
+	return out, nil
+}
@@ -0,0 +1,194 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// maxCBORDepth Nesting allowed in authenticator output; COSE keys and attestation
+// objects are two or three levels deep
+const maxCBORDepth = 8
+
+var errCBOR = errors.New("webauthn: malformed cbor")
+
+// decodeCBOR Decode the first CBOR item of b and return it with the number of bytes it used.
+// Only the definite-length subset CTAP2 authenticators emit is supported; integers decode
+// to int64, maps to map[any]any
+func decodeCBOR(b []byte) (any, int, error) {
+	d := &cborDecoder{b: b}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, 0, err
+	}
+	return v, d.off, nil
+}
+
+type cborDecoder struct {
+	b   []byte
+	off int
+}
+
+func (d *cborDecoder) value(depth int) (any, error) {
+	if depth > maxCBORDepth {
+		return nil, fmt.Errorf("%w: nested too deep", errCBOR)
+	}
+	major, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, fmt.Errorf("%w: integer overflow", errCBOR)
+		}
+		return int64(arg), nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, fmt.Errorf("%w: integer overflow", errCBOR)
+		}
+		return -1 - int64(arg), nil
+	case 2, 3:
+		raw, err := d.take(arg)
+		if err != nil {
+			return nil, err
+		}
+		if major == 3 {
+			return string(raw), nil
+		}
+		return append([]byte(nil), raw...), nil
+	case 4:
+		if arg > uint64(len(d.b)-d.off) {
+			return nil, fmt.Errorf("%w: array too long", errCBOR)
+		}
+		out := make([]any, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	case 5:
+		if arg > uint64(len(d.b)-d.off) {
+			return nil, fmt.Errorf("%w: map too long", errCBOR)
+		}
+		out := make(map[any]any, arg)
+		for i := uint64(0); i < arg; i++ {
+			k, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, fmt.Errorf("%w: unsupported map key", errCBOR)
+			}
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if _, dup := out[k]; dup {
+				return nil, fmt.Errorf("%w: duplicate map key", errCBOR)
+			}
+			out[k] = v
+		}
+		return out, nil
+	case 6:
+		// Tags carry no meaning for WebAuthn, decode the tagged item
+		return d.value(depth + 1)
+	default:
+		return d.simple(arg)
+	}
+}
+
+// head Read an item's major type and argument
+func (d *cborDecoder) head() (byte, uint64, error) {
+	if d.off >= len(d.b) {
+		return 0, 0, fmt.Errorf("%w: unexpected end", errCBOR)
+	}
+	ib := d.b[d.off]
+	d.off++
+	major, info := ib>>5, ib&0x1f
+	if major == 7 {
+		return major, uint64(info), nil
+	}
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info <= 27:
+		n := 1 << (info - 24)
+		raw, err := d.take(uint64(n))
+		if err != nil {
+			return 0, 0, err
+		}
+		var arg uint64
+		for _, c := range raw {
+			arg = arg<<8 | uint64(c)
+		}
+		return major, arg, nil
+	default:
+		return 0, 0, fmt.Errorf("%w: indefinite length not supported", errCBOR)
+	}
+}
+
+// simple Major type 7: booleans, null, undefined and floats
+func (d *cborDecoder) simple(info uint64) (any, error) {
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		raw, err := d.take(2)
+		if err != nil {
+			return nil, err
+		}
+		return float64(halfToFloat(binary.BigEndian.Uint16(raw))), nil
+	case 26:
+		raw, err := d.take(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(raw))), nil
+	case 27:
+		raw, err := d.take(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(raw)), nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported simple value %d", errCBOR, info)
+	}
+}
+
+func (d *cborDecoder) take(n uint64) ([]byte, error) {
+	if n > uint64(len(d.b)-d.off) {
+		return nil, fmt.Errorf("%w: unexpected end", errCBOR)
+	}
+	raw := d.b[d.off : d.off+int(n)]
+	d.off += int(n)
+	return raw, nil
+}
+
+func halfToFloat(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1f
+	frac := uint32(h) & 0x3ff
+	switch exp {
+	case 0:
+		f := float32(frac) / 1024 * float32(math.Pow(2, -14))
+		if sign != 0 {
+			return -f
+		}
+		return f
+	case 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | frac<<13)
+	default:
+		return math.Float32frombits(sign | (exp+112)<<23 | frac<<13)
+	}
+}
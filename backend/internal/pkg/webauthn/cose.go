@@ -0,0 +1,106 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithms offered to authenticators, in order of preference
+const (
+	AlgES256 int64 = -7
+	AlgEdDSA int64 = -8
+	AlgRS256 int64 = -257
+)
+
+// SupportedAlgs pubKeyCredParams of a registration
+var SupportedAlgs = []int64{AlgES256, AlgEdDSA, AlgRS256}
+
+var ErrUnsupportedKey = errors.New("webauthn: unsupported public key")
+
+// coseKey A parsed COSE_Key able to check assertion signatures
+type coseKey struct {
+	alg   int64
+	ec    *ecdsa.PublicKey
+	ed    ed25519.PublicKey
+	rsa   *rsa.PublicKey
+	bytes []byte
+}
+
+// parseCOSEKey Decode a credential public key as stored, see RFC 9053
+func parseCOSEKey(raw []byte) (*coseKey, error) {
+	v, n, err := decodeCBOR(raw)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(raw) {
+		return nil, fmt.Errorf("%w: trailing bytes", ErrUnsupportedKey)
+	}
+	m, ok := v.(map[any]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: not a map", ErrUnsupportedKey)
+	}
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+	k := &coseKey{alg: alg, bytes: raw}
+
+	switch {
+	case kty == 2 && alg == AlgES256:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		y, _ := m[int64(-3)].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("%w: bad P-256 key", ErrUnsupportedKey)
+		}
+		// ecdh rejects points that are not on the curve
+		point := append(append([]byte{4}, x...), y...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnsupportedKey, err)
+		}
+		k.ec = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	case kty == 1 && alg == AlgEdDSA:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: bad Ed25519 key", ErrUnsupportedKey)
+		}
+		k.ed = ed25519.PublicKey(x)
+	case kty == 3 && alg == AlgRS256:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("%w: bad RSA key", ErrUnsupportedKey)
+		}
+		var exp int
+		for _, c := range e {
+			exp = exp<<8 | int(c)
+		}
+		k.rsa = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}
+	default:
+		return nil, fmt.Errorf("%w: kty %d alg %d", ErrUnsupportedKey, kty, alg)
+	}
+	return k, nil
+}
+
+// verify Check sig over msg with the key's algorithm
+func (k *coseKey) verify(msg, sig []byte) bool {
+	switch k.alg {
+	case AlgES256:
+		sum := sha256.Sum256(msg)
+		return ecdsa.VerifyASN1(k.ec, sum[:], sig)
+	case AlgEdDSA:
+		return ed25519.Verify(k.ed, msg, sig)
+	case AlgRS256:
+		sum := sha256.Sum256(msg)
+		return rsa.VerifyPKCS1v15(k.rsa, crypto.SHA256, sum[:], sig) == nil
+	default:
+		return false
+	}
+}
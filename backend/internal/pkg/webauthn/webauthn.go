@@ -0,0 +1,266 @@
+// Package webauthn Server side of the WebAuthn registration and assertion ceremonies,
+// limited to what passkeys need: "none" attestation, ES256, EdDSA and RS256 credentials
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Authenticator data flags
+const (
+	flagUP = 0x01
+	flagUV = 0x04
+	flagBE = 0x08
+	flagBS = 0x10
+	flagAT = 0x40
+	flagED = 0x80
+)
+
+var ErrVerify = errors.New("webauthn: verification failed")
+
+// RelyingParty Identity of this server towards authenticators
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+	// RequireUV Reject ceremonies where the authenticator did not verify the user (PIN, biometrics)
+	RequireUV bool
+}
+
+// Credential A newly registered public key credential
+type Credential struct {
+	ID []byte
+	// PublicKey COSE_Key as sent by the authenticator, stored as is
+	PublicKey      []byte
+	Alg            int64
+	SignCount      uint32
+	AAGUID         []byte
+	UserVerified   bool
+	BackupEligible bool
+	BackedUp       bool
+}
+
+// Assertion Result of a verified login
+type Assertion struct {
+	SignCount    uint32
+	UserVerified bool
+	BackedUp     bool
+}
+
+// NewChallenge 32 random bytes
+func NewChallenge() ([]byte, error) {
+	c := make([]byte, 32)
+	if _, err := rand.Read(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Encode base64url without padding, the encoding of every binary field in the JSON API
+func Encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Decode Accept base64url with or without padding
+func Decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// VerifyRegistration Check a navigator.credentials.create() response against the challenge.
+// Attestation statements are not verified: registration asks for "none", and trust in a
+// passkey comes from the signed-in session that registers it, not from its maker
+func (rp *RelyingParty) VerifyRegistration(challenge, clientDataJSON, attestationObject []byte) (*Credential, error) {
+
+	// 1. Client data
+	if err := rp.checkClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	// 2. Attestation object
+	v, n, err := decodeCBOR(attestationObject)
+	if err != nil || n != len(attestationObject) {
+		return nil, fmt.Errorf("%w: attestation object", ErrVerify)
+	}
+	att, ok := v.(map[any]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: attestation object", ErrVerify)
+	}
+	if _, ok := att["fmt"].(string); !ok {
+		return nil, fmt.Errorf("%w: attestation format", ErrVerify)
+	}
+	rawAuthData, ok := att["authData"].([]byte)
+	if !ok {
+		return nil, fmt.Errorf("%w: authenticator data", ErrVerify)
+	}
+
+	// 3. Authenticator data with the attested credential
+	ad, err := rp.parseAuthData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if ad.flags&flagAT == 0 || ad.credID == nil {
+		return nil, fmt.Errorf("%w: no attested credential", ErrVerify)
+	}
+	key, err := parseCOSEKey(ad.credKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:             ad.credID,
+		PublicKey:      key.bytes,
+		Alg:            key.alg,
+		SignCount:      ad.signCount,
+		AAGUID:         ad.aaguid,
+		UserVerified:   ad.flags&flagUV != 0,
+		BackupEligible: ad.flags&flagBE != 0,
+		BackedUp:       ad.flags&flagBS != 0,
+	}, nil
+}
+
+// VerifyAssertion Check a navigator.credentials.get() response signed by the stored key.
+// The caller compares SignCount with the stored one, see SignCountOK
+func (rp *RelyingParty) VerifyAssertion(challenge, publicKey, clientDataJSON, authData, signature []byte) (*Assertion, error) {
+
+	// 1. Client data
+	if err := rp.checkClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return nil, err
+	}
+
+	// 2. Authenticator data
+	ad, err := rp.parseAuthData(authData)
+	if err != nil {
+		return nil, err
+	}
+
+	// 3. Signature over authenticatorData || SHA-256(clientDataJSON)
+	key, err := parseCOSEKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(clientDataJSON)
+	msg := append(append([]byte(nil), authData...), sum[:]...)
+	if !key.verify(msg, signature) {
+		return nil, fmt.Errorf("%w: signature", ErrVerify)
+	}
+
+	return &Assertion{
+		SignCount:    ad.signCount,
+		UserVerified: ad.flags&flagUV != 0,
+		BackedUp:     ad.flags&flagBS != 0,
+	}, nil
+}
+
+// SignCountOK A counter that does not grow hints at a cloned authenticator; authenticators
+// that do not count (synced passkeys) always report 0
+func SignCountOK(stored, got uint32) bool {
+	if stored == 0 && got == 0 {
+		return true
+	}
+	return got > stored
+}
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+func (rp *RelyingParty) checkClientData(raw []byte, typ string, challenge []byte) error {
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return fmt.Errorf("%w: client data", ErrVerify)
+	}
+	if cd.Type != typ {
+		return fmt.Errorf("%w: client data type %q", ErrVerify, cd.Type)
+	}
+	got, err := Decode(cd.Challenge)
+	if err != nil || subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return fmt.Errorf("%w: challenge", ErrVerify)
+	}
+	if cd.CrossOrigin {
+		return fmt.Errorf("%w: cross-origin", ErrVerify)
+	}
+	for _, o := range rp.Origins {
+		if cd.Origin == o {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: origin %q", ErrVerify, cd.Origin)
+}
+
+type authData struct {
+	flags     byte
+	signCount uint32
+	aaguid    []byte
+	credID    []byte
+	credKey   []byte
+}
+
+// parseAuthData Decode authenticator data and check the RP ID hash and user flags
+func (rp *RelyingParty) parseAuthData(b []byte) (*authData, error) {
+	if len(b) < 37 {
+		return nil, fmt.Errorf("%w: authenticator data too short", ErrVerify)
+	}
+
+	// 1. RP ID hash, flags, counter
+	want := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(b[:32], want[:]) {
+		return nil, fmt.Errorf("%w: rp id", ErrVerify)
+	}
+	ad := &authData{flags: b[32], signCount: binary.BigEndian.Uint32(b[33:37])}
+	if ad.flags&flagUP == 0 {
+		return nil, fmt.Errorf("%w: user not present", ErrVerify)
+	}
+	if rp.RequireUV && ad.flags&flagUV == 0 {
+		return nil, fmt.Errorf("%w: user not verified", ErrVerify)
+	}
+	if ad.flags&flagBS != 0 && ad.flags&flagBE == 0 {
+		return nil, fmt.Errorf("%w: backup state without eligibility", ErrVerify)
+	}
+	rest := b[37:]
+
+	// 2. Attested credential data: aaguid(16) | idLen(2) | id | COSE key
+	if ad.flags&flagAT != 0 {
+		if len(rest) < 18 {
+			return nil, fmt.Errorf("%w: attested credential data", ErrVerify)
+		}
+		ad.aaguid = append([]byte(nil), rest[:16]...)
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLen == 0 || idLen > 1023 || len(rest) < idLen {
+			return nil, fmt.Errorf("%w: credential id", ErrVerify)
+		}
+		ad.credID = append([]byte(nil), rest[:idLen]...)
+		rest = rest[idLen:]
+		_, n, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: credential public key", ErrVerify)
+		}
+		ad.credKey = append([]byte(nil), rest[:n]...)
+		rest = rest[n:]
+	}
+
+	// 3. Extensions are ignored but must be well formed
+	if ad.flags&flagED != 0 {
+		_, n, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: extensions", ErrVerify)
+		}
+		rest = rest[n:]
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: trailing authenticator data", ErrVerify)
+	}
+	return ad, nil
+}
@@ -0,0 +1,390 @@
+package repos
+
+import (
+	"backend/internal/config"
+	"backend/internal/models"
+	"backend/internal/pkg/ctx_util"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type AccountRepo interface {
+	MarkDeleted(ctx context.Context, userID uint64) (time.Time, error)
+	ListDevices(ctx context.Context, userID uint64) ([]models.Device, error)
+	ListSessions(ctx context.Context, userID uint64) ([]models.Session, error)
+	ListAdminActions(ctx context.Context, userID uint64) ([]models.AdminAudit, error)
+	PurgeDeleted(ctx context.Context, deletedBefore time.Time, limit int) (int, error)
+	StoreEmailChange(ctx context.Context, userID uint64, codeID, newEmail string, ttl time.Duration) error
+	GetEmailChange(ctx context.Context, userID uint64, codeID string) (string, error)
+	DeleteEmailChange(ctx context.Context, userID uint64, codeID string)
+	UpdateEmail(ctx context.Context, userID uint64, oldEmail, newEmail string) error
+	StoreEmailRevert(ctx context.Context, tokenHash string, rev models.EmailRevert, ttl time.Duration) error
+	TakeEmailRevert(ctx context.Context, tokenHash string) (*models.EmailRevert, error)
+	RevertEmail(ctx context.Context, rev models.EmailRevert) error
+	SetLocale(ctx context.Context, userID uint64, locale *string) error
+}
+
+// MarkDeleted Soft delete, invalidate every token and revoke all sessions; returns deleted_at
+func (r *accountRepo) MarkDeleted(ctx context.Context, userID uint64) (time.Time, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return time.Time{}, ctx.Err()
+		}
+		return time.Time{}, fmt.Errorf("%w: begin tx: %v", ErrUnexpectedSQL, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// 1. Mark user
+	res, err := tx.ExecContext(ctx, `
+		UPDATE users SET is_deleted = 1, deleted_at = NOW(), token_version = token_version + 1
+		WHERE id = ? AND is_deleted = 0`, userID)
+	if err != nil {
+		if ctx.Err() != nil {
+			return time.Time{}, ctx.Err()
+		}
+		return time.Time{}, fmt.Errorf("%w: mark deleted: %v", ErrUnexpectedSQL, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return time.Time{}, ErrNotFound
+	}
+
+	// 2. Revoke sessions
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE sessions SET revoked_at = NOW() WHERE user_id = ? AND revoked_at IS NULL`, userID); err != nil {
+		if ctx.Err() != nil {
+			return time.Time{}, ctx.Err()
+		}
+		return time.Time{}, fmt.Errorf("%w: revoke sessions: %v", ErrUnexpectedSQL, err)
+	}
+
+	// 3. Read deleted_at
+	var deletedAt time.Time
+	if err := tx.QueryRowContext(ctx, `SELECT deleted_at FROM users WHERE id = ?`, userID).Scan(&deletedAt); err != nil {
+		if ctx.Err() != nil {
+			return time.Time{}, ctx.Err()
+		}
+		return time.Time{}, fmt.Errorf("%w: read deleted_at: %v", ErrUnexpectedSQL, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		if ctx.Err() != nil {
+			return time.Time{}, ctx.Err()
+		}
+		return time.Time{}, fmt.Errorf("%w: commit: %v", ErrUnexpectedSQL, err)
+	}
+	return deletedAt, nil
+}
+
+func (r *accountRepo) ListDevices(ctx context.Context, userID uint64) ([]models.Device, error) {
+	const query = `
+		SELECT device_id, user_id, push_token, last_seen_at, revoked_at, created_at
+		FROM user_devices
+		WHERE user_id = ?
+		ORDER BY created_at DESC
+	`
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var out []models.Device
+	for rows.Next() {
+		var d models.Device
+		if err := rows.Scan(&d.DeviceID, &d.UserID, &d.PushToken, &d.LastSeenAt, &d.RevokedAt, &d.CreatedAt); err != nil {
+			return nil, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+		}
+		out = append(out, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	return out, nil
+}
+
+func (r *accountRepo) ListSessions(ctx context.Context, userID uint64) ([]models.Session, error) {
+	return listSessions(ctx, r.db, userID)
+}
+
+// ListAdminActions Staff actions that targeted the user, oldest first
+func (r *accountRepo) ListAdminActions(ctx context.Context, userID uint64) ([]models.AdminAudit, error) {
+	const query = `
+		SELECT id, action, reason, created_at
+		FROM admin_audit
+		WHERE target_user_id = ?
+		ORDER BY id
+	`
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var out []models.AdminAudit
+	for rows.Next() {
+		var a models.AdminAudit
+		if err := rows.Scan(&a.ID, &a.Action, &a.Reason, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+		}
+		out = append(out, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	return out, nil
+}
+
+// PurgeDeleted Hard delete up to limit users soft-deleted before deletedBefore.
+// Devices and sessions go with the user (ON DELETE CASCADE), auth events are deleted
+// by id and email, and audit rows that name the user are anonymized.
+func (r *accountRepo) PurgeDeleted(ctx context.Context, deletedBefore time.Time, limit int) (int, error) {
+
+	// 1. Pick candidates
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, email FROM users WHERE is_deleted = 1 AND deleted_at < ? ORDER BY deleted_at LIMIT ?`,
+		deletedBefore.UTC(), limit)
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return 0, ctx.Err()
+		}
+		return 0, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	type victim struct {
+		id    uint64
+		email string
+	}
+	var victims []victim
+	for rows.Next() {
+		var v victim
+		if err := rows.Scan(&v.id, &v.email); err != nil {
+			_ = rows.Close()
+			return 0, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+		}
+		victims = append(victims, v)
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+
+	// 2. Purge one user per transaction
+	purged := 0
+	for _, v := range victims {
+		if err := r.purgeUser(ctx, v.id, v.email, deletedBefore); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+func (r *accountRepo) purgeUser(ctx context.Context, userID uint64, email string, deletedBefore time.Time) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: begin tx: %v", ErrUnexpectedSQL, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// 1. Delete the user, re-checking the state in case it was restored meanwhile
+	res, err := tx.ExecContext(ctx,
+		`DELETE FROM users WHERE id = ? AND is_deleted = 1 AND deleted_at < ?`, userID, deletedBefore.UTC())
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: delete user %d: %v", ErrUnexpectedSQL, userID, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+
+	// 2. Events and audit trail
+	for _, stmt := range []struct {
+		query string
+		args  []any
+	}{
+		{`DELETE FROM auth_events WHERE user_id = ? OR email = ?`, []any{userID, email}},
+		{`UPDATE admin_audit SET reason = '', detail = NULL WHERE target_user_id = ?`, []any{userID}},
+		{`UPDATE admin_audit SET detail = NULL WHERE detail LIKE ?`, []any{"%" + escapeLike(email) + "%"}},
+	} {
+		if _, err := tx.ExecContext(ctx, stmt.query, stmt.args...); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("%w: purge user %d: %v", ErrUnexpectedSQL, userID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: commit: %v", ErrUnexpectedSQL, err)
+	}
+	return nil
+}
+
+// StoreEmailChange Remember which address a change_email code was sent to
+func (r *accountRepo) StoreEmailChange(ctx context.Context, userID uint64, codeID, newEmail string, ttl time.Duration) error {
+	if err := r.rdb.Set(ctx, config.RedisKeyEmailChange(userID, codeID), newEmail, ttl).Err(); err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w:%s", ErrUnexpectedRedis, err)
+	}
+	return nil
+}
+
+func (r *accountRepo) GetEmailChange(ctx context.Context, userID uint64, codeID string) (string, error) {
+	email, err := r.rdb.Get(ctx, config.RedisKeyEmailChange(userID, codeID)).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return "", ErrNotFound
+		}
+		if ctx_util.IsCtxDone(ctx, err) {
+			return "", ctx.Err()
+		}
+		return "", fmt.Errorf("%w:%s", ErrUnexpectedRedis, err)
+	}
+	return email, nil
+}
+
+func (r *accountRepo) DeleteEmailChange(ctx context.Context, userID uint64, codeID string) {
+	_ = r.rdb.Del(ctx, config.RedisKeyEmailChange(userID, codeID)).Err()
+}
+
+// UpdateEmail Swap the address only if it is still oldEmail; uk_users_email decides races
+func (r *accountRepo) UpdateEmail(ctx context.Context, userID uint64, oldEmail, newEmail string) error {
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE users SET email = ? WHERE id = ? AND email = ? AND is_deleted = 0`, newEmail, userID, oldEmail)
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return ctx.Err()
+		}
+		if isDuplicateEntry(err) {
+			return ErrEmailAlreadyExists
+		}
+		return fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *accountRepo) StoreEmailRevert(ctx context.Context, tokenHash string, rev models.EmailRevert, ttl time.Duration) error {
+	data, err := json.Marshal(rev)
+	if err != nil {
+		return err
+	}
+	if err := r.rdb.Set(ctx, config.RedisKeyEmailRevert(tokenHash), data, ttl).Err(); err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w:%s", ErrUnexpectedRedis, err)
+	}
+	return nil
+}
+
+// TakeEmailRevert Read and delete a revert record so a link works once
+func (r *accountRepo) TakeEmailRevert(ctx context.Context, tokenHash string) (*models.EmailRevert, error) {
+	data, err := r.rdb.GetDel(ctx, config.RedisKeyEmailRevert(tokenHash)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrNotFound
+		}
+		if ctx_util.IsCtxDone(ctx, err) {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w:%s", ErrUnexpectedRedis, err)
+	}
+	var rev models.EmailRevert
+	if err := json.Unmarshal(data, &rev); err != nil {
+		return nil, fmt.Errorf("%w:%s", ErrUnexpectedReply, err)
+	}
+	return &rev, nil
+}
+
+// RevertEmail Restore the old address and, since the change may have been hostile,
+// log the user out everywhere
+func (r *accountRepo) RevertEmail(ctx context.Context, rev models.EmailRevert) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: begin tx: %v", ErrUnexpectedSQL, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// 1. Swap back, only if nothing changed since
+	res, err := tx.ExecContext(ctx,
+		`UPDATE users SET email = ?, token_version = token_version + 1 WHERE id = ? AND email = ?`,
+		rev.OldEmail, rev.UserID, rev.NewEmail)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if isDuplicateEntry(err) {
+			return ErrEmailAlreadyExists
+		}
+		return fmt.Errorf("%w: revert email: %v", ErrUnexpectedSQL, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+
+	// 2. Revoke sessions
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE sessions SET revoked_at = NOW() WHERE user_id = ? AND revoked_at IS NULL`, rev.UserID); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: revoke sessions: %v", ErrUnexpectedSQL, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: commit: %v", ErrUnexpectedSQL, err)
+	}
+	return nil
+}
+
+// SetLocale Store the user's language, nil to follow Accept-Language again
+func (r *accountRepo) SetLocale(ctx context.Context, userID uint64, locale *string) error {
+	if _, err := r.db.ExecContext(ctx,
+		`UPDATE users SET locale = ? WHERE id = ? AND is_deleted = 0`, locale, userID); err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	return nil
+}
+
+type accountRepo struct {
+	db  *sql.DB
+	rdb *redis.Client
+}
+
+func NewAccountRepo(db *sql.DB, rdb *redis.Client) AccountRepo {
+	return &accountRepo{db: db, rdb: rdb}
+}
@@ -0,0 +1,509 @@
+package repos
+
+import (
+	"backend/internal/config"
+	"backend/internal/models"
+	"backend/internal/pkg/ctx_util"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// AdminRepo Mutations take the audit row and write it in the same transaction
+type AdminRepo interface {
+	FindUserByID(ctx context.Context, userID uint64) (*models.User, error)
+	FindUserByEmail(ctx context.Context, email string) (*models.User, error)
+	SearchUsers(ctx context.Context, query string, offset, limit int) ([]models.User, int, error)
+	BumpTokenVersion(ctx context.Context, userID uint64, audit *models.AdminAudit) (uint, error)
+	RevokeDevice(ctx context.Context, userID uint64, deviceID []byte, audit *models.AdminAudit) error
+	SetDeleted(ctx context.Context, userID uint64, deleted bool, audit *models.AdminAudit) error
+	SetLocked(ctx context.Context, userID uint64, locked bool, reason string, audit *models.AdminAudit) error
+	SetRole(ctx context.Context, userID uint64, role string, audit *models.AdminAudit) error
+	ListSessions(ctx context.Context, userID uint64) ([]models.Session, error)
+	ClearThrottles(ctx context.Context, email string, scenes []string) (int64, error)
+	InsertAudit(ctx context.Context, audit *models.AdminAudit) error
+	ListAudit(ctx context.Context, targetUserID uint64, offset, limit int) ([]models.AdminAudit, int, error)
+	ListAuthEvents(ctx context.Context, userID uint64, limit int) ([]models.AuthEvent, error)
+}
+
+const userColumns = `id, email, password_hash, token_version, username, role, locale, is_deleted, deleted_at, locked_at, locked_reason, created_at, updated_at`
+
+func scanUser(row interface{ Scan(...any) error }) (*models.User, error) {
+	u := &models.User{}
+	err := row.Scan(
+		&u.UserID,
+		&u.Email,
+		&u.PwdHash,
+		&u.TokenV,
+		&u.Username,
+		&u.Role,
+		&u.Locale,
+		&u.IsDeleted,
+		&u.DeletedAt,
+		&u.LockedAt,
+		&u.LockedReason,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// FindUserByID Look up a user including soft-deleted ones
+func (r *adminRepo) FindUserByID(ctx context.Context, userID uint64) (*models.User, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id = ? LIMIT 1`, userID)
+	return r.findUser(ctx, row)
+}
+
+// FindUserByEmail Look up a user including soft-deleted ones
+func (r *adminRepo) FindUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE email = ? LIMIT 1`, email)
+	return r.findUser(ctx, row)
+}
+
+func (r *adminRepo) findUser(ctx context.Context, row *sql.Row) (*models.User, error) {
+	u, err := scanUser(row)
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return nil, ctx.Err()
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	return u, nil
+}
+
+// SearchUsers Match id exactly, email and username by prefix; newest first
+func (r *adminRepo) SearchUsers(ctx context.Context, query string, offset, limit int) ([]models.User, int, error) {
+
+	// 1. Build filter
+	where := `1 = 1`
+	var args []any
+	if query != "" {
+		prefix := escapeLike(query) + "%"
+		where = `(email LIKE ? OR username LIKE ?)`
+		args = append(args, prefix, prefix)
+		if id, err := strconv.ParseUint(query, 10, 64); err == nil {
+			where = `(id = ? OR email LIKE ? OR username LIKE ?)`
+			args = append([]any{id}, args...)
+		}
+	}
+
+	// 2. Count
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE `+where, args...).Scan(&total); err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return nil, 0, ctx.Err()
+		}
+		return nil, 0, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+
+	// 3. Page
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+userColumns+` FROM users WHERE `+where+` ORDER BY id DESC LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...)
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return nil, 0, ctx.Err()
+		}
+		return nil, 0, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var out []models.User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+		}
+		out = append(out, *u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	return out, total, nil
+}
+
+// BumpTokenVersion Invalidate every issued token and revoke all sessions
+func (r *adminRepo) BumpTokenVersion(ctx context.Context, userID uint64, audit *models.AdminAudit) (uint, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return 0, fmt.Errorf("%w: begin tx: %v", ErrUnexpectedSQL, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// 1. Bump version
+	res, err := tx.ExecContext(ctx, `UPDATE users SET token_version = token_version + 1 WHERE id = ?`, userID)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return 0, fmt.Errorf("%w: bump token_version: %v", ErrUnexpectedSQL, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, ErrNotFound
+	}
+
+	// 2. Revoke sessions
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE sessions SET revoked_at = NOW() WHERE user_id = ? AND revoked_at IS NULL`, userID); err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return 0, fmt.Errorf("%w: revoke sessions: %v", ErrUnexpectedSQL, err)
+	}
+
+	// 3. Read new version
+	var tkv uint
+	if err := tx.QueryRowContext(ctx, `SELECT token_version FROM users WHERE id = ?`, userID).Scan(&tkv); err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return 0, fmt.Errorf("%w: read token_version: %v", ErrUnexpectedSQL, err)
+	}
+
+	// 4. Audit
+	if err := insertAudit(ctx, tx, audit); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return 0, fmt.Errorf("%w: commit: %v", ErrUnexpectedSQL, err)
+	}
+	return tkv, nil
+}
+
+// RevokeDevice Revoke one device and its session
+func (r *adminRepo) RevokeDevice(ctx context.Context, userID uint64, deviceID []byte, audit *models.AdminAudit) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: begin tx: %v", ErrUnexpectedSQL, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx,
+		`UPDATE user_devices SET revoked_at = NOW() WHERE user_id = ? AND device_id = ?`, userID, deviceID)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: revoke device: %v", ErrUnexpectedSQL, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE sessions SET revoked_at = NOW() WHERE user_id = ? AND device_id = ? AND revoked_at IS NULL`, userID, deviceID); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: revoke session: %v", ErrUnexpectedSQL, err)
+	}
+	if err := insertAudit(ctx, tx, audit); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: commit: %v", ErrUnexpectedSQL, err)
+	}
+	return nil
+}
+
+// SetDeleted Soft delete or restore; deleting also logs the user out everywhere
+func (r *adminRepo) SetDeleted(ctx context.Context, userID uint64, deleted bool, audit *models.AdminAudit) error {
+	query := `UPDATE users SET is_deleted = 0, deleted_at = NULL WHERE id = ? AND is_deleted = 1`
+	if deleted {
+		query = `UPDATE users SET is_deleted = 1, deleted_at = NOW(), token_version = token_version + 1 WHERE id = ? AND is_deleted = 0`
+	}
+	return r.updateUser(ctx, userID, deleted, audit, query, userID)
+}
+
+// SetLocked Lock or unlock; locking also logs the user out everywhere
+func (r *adminRepo) SetLocked(ctx context.Context, userID uint64, locked bool, reason string, audit *models.AdminAudit) error {
+	if locked {
+		return r.updateUser(ctx, userID, true, audit,
+			`UPDATE users SET locked_at = NOW(), locked_reason = ?, token_version = token_version + 1 WHERE id = ? AND locked_at IS NULL`,
+			reason, userID)
+	}
+	return r.updateUser(ctx, userID, false, audit,
+		`UPDATE users SET locked_at = NULL, locked_reason = NULL WHERE id = ?`, userID)
+}
+
+// SetRole Change a user's role; tokens stay valid, the role is read on every request
+func (r *adminRepo) SetRole(ctx context.Context, userID uint64, role string, audit *models.AdminAudit) error {
+	return r.updateUser(ctx, userID, false, audit, `UPDATE users SET role = ? WHERE id = ?`, role, userID)
+}
+
+// updateUser Run one users UPDATE, optionally revoke sessions, then write the audit row
+func (r *adminRepo) updateUser(ctx context.Context, userID uint64, revoke bool, audit *models.AdminAudit, query string, args ...any) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: begin tx: %v", ErrUnexpectedSQL, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// 1. Update, no rows means no such user or already in that state
+	res, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: update user: %v", ErrUnexpectedSQL, err)
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		var dummy int
+		err := tx.QueryRowContext(ctx, `SELECT 1 FROM users WHERE id = ?`, userID).Scan(&dummy)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("%w: find user: %v", ErrUnexpectedSQL, err)
+		}
+	}
+
+	// 2. Revoke sessions
+	if revoke && n > 0 {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE sessions SET revoked_at = NOW() WHERE user_id = ? AND revoked_at IS NULL`, userID); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("%w: revoke sessions: %v", ErrUnexpectedSQL, err)
+		}
+	}
+
+	// 3. Audit
+	if err := insertAudit(ctx, tx, audit); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: commit: %v", ErrUnexpectedSQL, err)
+	}
+	return nil
+}
+
+// ListSessions Sessions of a user joined with their devices, newest first
+func (r *adminRepo) ListSessions(ctx context.Context, userID uint64) ([]models.Session, error) {
+	return listSessions(ctx, r.db, userID)
+}
+
+func listSessions(ctx context.Context, db *sql.DB, userID uint64) ([]models.Session, error) {
+	const query = `
+		SELECT s.session_id, s.user_id, s.device_id, s.token_version, s.expires_at, s.revoked_at,
+		       s.created_at, s.updated_at, d.last_seen_at, d.push_token, d.revoked_at
+		FROM sessions s
+		JOIN user_devices d ON d.user_id = s.user_id AND d.device_id = s.device_id
+		WHERE s.user_id = ?
+		ORDER BY s.updated_at DESC
+	`
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var out []models.Session
+	for rows.Next() {
+		var s models.Session
+		if err := rows.Scan(
+			&s.SessionID, &s.UserID, &s.DeviceID, &s.TokenV, &s.ExpiresAt, &s.RevokedAt,
+			&s.CreatedAt, &s.UpdatedAt, &s.LastSeenAt, &s.PushToken, &s.DeviceRevokedAt,
+		); err != nil {
+			return nil, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+		}
+		out = append(out, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	return out, nil
+}
+
+// ClearThrottles Delete login locks/counters and OTP/verify throttles of an email
+func (r *adminRepo) ClearThrottles(ctx context.Context, email string, scenes []string) (int64, error) {
+
+	// 1. Fixed keys
+	keys := []string{
+		config.RedisKeyLoginLockEmail(email),
+		config.RedisKeyLoginEmailCnt(email),
+	}
+	for _, scene := range scenes {
+		keys = append(keys, config.RedisKeyThrottle(email, scene), config.RedisKeyVerifyThrottle(email, scene))
+	}
+
+	// 2. Per-IP keys, the IP part is unknown so scan for it
+	for _, pattern := range []string{
+		config.RedisKeyLoginLockIPEmail("*", escapeGlob(email)),
+		config.RedisKeyLoginIPEmailCnt("*", escapeGlob(email)),
+	} {
+		iter := r.rdb.Scan(ctx, 0, pattern, 100).Iterator()
+		for iter.Next(ctx) {
+			keys = append(keys, iter.Val())
+		}
+		if err := iter.Err(); err != nil {
+			if ctx_util.IsCtxDone(ctx, err) {
+				return 0, ctx.Err()
+			}
+			return 0, fmt.Errorf("%w:%s", ErrUnexpectedRedis, err)
+		}
+	}
+
+	// 3. Delete
+	n, err := r.rdb.Del(ctx, keys...).Result()
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return 0, ctx.Err()
+		}
+		return 0, fmt.Errorf("%w:%s", ErrUnexpectedRedis, err)
+	}
+	return n, nil
+}
+
+// InsertAudit Record an action that has no transaction of its own (reads, Redis-only changes)
+func (r *adminRepo) InsertAudit(ctx context.Context, audit *models.AdminAudit) error {
+	return insertAudit(ctx, r.db, audit)
+}
+
+// ListAudit Audit rows, of one target when targetUserID is non-zero, newest first
+func (r *adminRepo) ListAudit(ctx context.Context, targetUserID uint64, offset, limit int) ([]models.AdminAudit, int, error) {
+	where := `1 = 1`
+	var args []any
+	if targetUserID != 0 {
+		where = `target_user_id = ?`
+		args = append(args, targetUserID)
+	}
+
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM admin_audit WHERE `+where, args...).Scan(&total); err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return nil, 0, ctx.Err()
+		}
+		return nil, 0, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, actor_id, actor, action, target_user_id, reason, detail, ip, created_at
+		FROM admin_audit
+		WHERE `+where+`
+		ORDER BY id DESC
+		LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return nil, 0, ctx.Err()
+		}
+		return nil, 0, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var out []models.AdminAudit
+	for rows.Next() {
+		var a models.AdminAudit
+		if err := rows.Scan(
+			&a.ID, &a.ActorID, &a.Actor, &a.Action, &a.TargetUserID, &a.Reason, &a.Detail, &a.IP, &a.CreatedAt,
+		); err != nil {
+			return nil, 0, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+		}
+		out = append(out, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	return out, total, nil
+}
+
+// ListAuthEvents Most recent auth events of a user
+func (r *adminRepo) ListAuthEvents(ctx context.Context, userID uint64, limit int) ([]models.AuthEvent, error) {
+	return listAuthEvents(ctx, r.db, userID, limit)
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func insertAudit(ctx context.Context, db execer, a *models.AdminAudit) error {
+	const query = `
+		INSERT INTO admin_audit (actor_id, actor, action, target_user_id, reason, detail, ip)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+	if _, err := db.ExecContext(ctx, query, a.ActorID, a.Actor, a.Action, a.TargetUserID, a.Reason, a.Detail, a.IP); err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: insert audit: %v", ErrUnexpectedSQL, err)
+	}
+	return nil
+}
+
+func listAuthEvents(ctx context.Context, db *sql.DB, userID uint64, limit int) ([]models.AuthEvent, error) {
+	const query = `
+		SELECT id, user_id, email, event_type, success, ip, device_id, user_agent, request_id, detail, created_at
+		FROM auth_events
+		WHERE user_id = ?
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?
+	`
+	rows, err := db.QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	return scanAuthEvents(rows)
+}
+
+// escapeLike Escape LIKE wildcards so user input only matches literally
+func escapeLike(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(s)
+}
+
+// escapeGlob Escape SCAN MATCH metacharacters
+func escapeGlob(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+	return r.Replace(s)
+}
+
+type adminRepo struct {
+	db  *sql.DB
+	rdb *redis.Client
+}
+
+func NewAdminRepo(db *sql.DB, rdb *redis.Client) AdminRepo {
+	return &adminRepo{db: db, rdb: rdb}
+}
@@ -7,6 +7,7 @@ import (
 	"backend/internal/repos/scripts"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
@@ -17,9 +18,12 @@ import (
 )
 
 type AuthRepo interface {
-	ClearLoginCntLock(ip, email string)
-	UpdateLoginCntLock(ip, email string) error
+	ClearLoginCntLock(ctx context.Context, ip, email string) error
+	UpdateLoginCntLock(ctx context.Context, ip, email string, t config.LoginThrottle) error
 	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	GetUserByID(ctx context.Context, userID uint64) (*models.User, error)
+	GetDeletedUserByEmail(ctx context.Context, email string) (*models.User, error)
+	RestoreUser(ctx context.Context, userID uint64, deletedAfter time.Time) error
 	CheckLoginThrottle(ctx context.Context, ip, email string) (bool, error)
 	StoreDIDAndSession(ctx context.Context, userID uint64, deviceID []byte, pushToken *string, rtkHash []byte, tokenVersion uint, expiresAt time.Time) error
 	UndoOTTMark(ctx context.Context, email, scene, jti string, ttlSec int)
@@ -28,31 +32,64 @@ type AuthRepo interface {
 	CheckEmailExists(ctx context.Context, email string) (bool, error)
 	ThrottleMatchAndConsumeCode(ctx context.Context, email, scene, codeID, code, jti string, limit, window, jtiTTL int) error
 	StoreOTPAndThrottle(ctx context.Context, email, scene, codeID, code string, otpTTL, throttleTTL int) (bool, error)
+	UpdatePassword(ctx context.Context, userID uint64, pwdHash string, revokeOthers bool, keepDeviceID []byte) (uint, error)
+	RehashPassword(ctx context.Context, userID uint64, oldHash, newHash string) error
+	StoreLoginRequest(ctx context.Context, codeID string, req models.LoginRequest, ttl time.Duration) error
+	TakeLoginRequest(ctx context.Context, codeID string) (*models.LoginRequest, error)
 }
 
-func (r *authRepo) ClearLoginCntLock(ip, email string) {
-
+// ClearLoginCntLock Forgive the wrong passwords of email from ip after a right one. The count
+// across IPs only runs out with its window, so guesses spread over many IPs still add up
+func (r *authRepo) ClearLoginCntLock(ctx context.Context, ip, email string) error {
+	if err := r.rdb.Del(ctx, config.RedisKeyLoginIPEmailCnt(ip, email)).Err(); err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w:%s", ErrUnexpectedRedis, err)
+	}
+	return nil
 }
 
-func (r *authRepo) UpdateLoginCntLock(ip, email string) error {
+// UpdateLoginCntLock Count a wrong password of email from ip. Reaching t.IPEmailAttempts locks
+// ip out of email, reaching t.EmailAttempts locks email out, each for t.Lockout and with its
+// count starting over
+func (r *authRepo) UpdateLoginCntLock(ctx context.Context, ip, email string, t config.LoginThrottle) error {
+
+	// 1. Count in the window
+	ipKey, emailKey := config.RedisKeyLoginIPEmailCnt(ip, email), config.RedisKeyLoginEmailCnt(email)
+	counts, err := r.scripts.IncrWindow.Run(ctx, r.rdb, []string{ipKey, emailKey}, t.Window.Milliseconds(), 1, 1).Int64Slice()
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w:%s", ErrUnexpectedRedis, err)
+	}
+
+	// 2. Lock what reached its limit
+	pipe := r.rdb.TxPipeline()
+	if counts[0] >= int64(t.IPEmailAttempts) {
+		pipe.Set(ctx, config.RedisKeyLoginLockIPEmail(ip, email), 1, t.Lockout)
+		pipe.Del(ctx, ipKey)
+	}
+	if counts[1] >= int64(t.EmailAttempts) {
+		pipe.Set(ctx, config.RedisKeyLoginLockEmail(email), 1, t.Lockout)
+		pipe.Del(ctx, emailKey)
+	}
+	if pipe.Len() == 0 {
+		return nil
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w:%s", ErrUnexpectedRedis, err)
+	}
 	return nil
 }
 
 func (r *authRepo) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
-	const query = `
-        SELECT id, email, password_hash, token_version
-        FROM users
-        WHERE email = ?
-        LIMIT 1
-    `
-
-	u := &models.User{}
-	err := r.db.QueryRowContext(ctx, query, email).Scan(
-		&u.UserID,
-		&u.Email,
-		&u.PwdHash,
-		&u.TokenV,
-	)
+	row := r.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE email = ? AND is_deleted = 0 LIMIT 1`, email)
+	u, err := scanUser(row)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNotFound
@@ -63,9 +100,65 @@ func (r *authRepo) GetUserByEmail(ctx context.Context, email string) (*models.Us
 	return u, nil
 }
 
-func (r *authRepo) CheckLoginThrottle(ctx context.Context, ip, email string) (bool, error) {
+// GetDeletedUserByEmail A soft-deleted user that has not been purged yet
+func (r *authRepo) GetDeletedUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE email = ? AND is_deleted = 1 LIMIT 1`, email)
+	u, err := scanUser(row)
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return nil, ctx.Err()
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	return u, nil
+}
 
-	return false, nil
+// RestoreUser Undo a soft delete that happened after deletedAfter
+func (r *authRepo) RestoreUser(ctx context.Context, userID uint64, deletedAfter time.Time) error {
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE users SET is_deleted = 0, deleted_at = NULL WHERE id = ? AND is_deleted = 1 AND deleted_at > ?`,
+		userID, deletedAfter.UTC())
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetUserByID Current row of a token's user, checked on every authenticated request
+func (r *authRepo) GetUserByID(ctx context.Context, userID uint64) (*models.User, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id = ? LIMIT 1`, userID)
+	u, err := scanUser(row)
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return nil, ctx.Err()
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	return u, nil
+}
+
+// CheckLoginThrottle Whether email is locked out of password sign-in, from ip or from anywhere
+func (r *authRepo) CheckLoginThrottle(ctx context.Context, ip, email string) (bool, error) {
+	n, err := r.rdb.Exists(ctx, config.RedisKeyLoginLockIPEmail(ip, email), config.RedisKeyLoginLockEmail(email)).Result()
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return false, ctx.Err()
+		}
+		return false, fmt.Errorf("%w:%s", ErrUnexpectedRedis, err)
+	}
+	return n > 0, nil
 }
 
 // StoreDIDAndSession Store device id and session
@@ -165,8 +258,7 @@ func (r *authRepo) CreateUser(ctx context.Context, email, pwdHash string) (uint6
 		if ctx_util.IsCtxDone(ctx, err) {
 			return 0, 0, ctx.Err()
 		}
-		var me *mysql.MySQLError
-		if errors.As(err, &me) && me.Number == 1062 || strings.Contains(err.Error(), "Duplicate entry") {
+		if isDuplicateEntry(err) {
 			return 0, 0, ErrEmailAlreadyExists
 		}
 		return 0, 0, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
@@ -180,6 +272,78 @@ func (r *authRepo) CreateUser(ctx context.Context, email, pwdHash string) (uint6
 	return uint64(id), 1, nil
 }
 
+// UpdatePassword Store a new hash; with revokeOthers also bump token_version and revoke
+// every session except keepDeviceID's. Returns the token_version after the update
+func (r *authRepo) UpdatePassword(ctx context.Context, userID uint64, pwdHash string, revokeOthers bool, keepDeviceID []byte) (uint, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return 0, fmt.Errorf("%w: begin tx: %v", ErrUnexpectedSQL, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// 1. Update user
+	query := `UPDATE users SET password_hash = ? WHERE id = ? AND is_deleted = 0`
+	if revokeOthers {
+		query = `UPDATE users SET password_hash = ?, token_version = token_version + 1 WHERE id = ? AND is_deleted = 0`
+	}
+	res, err := tx.ExecContext(ctx, query, pwdHash, userID)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return 0, fmt.Errorf("%w: update password: %v", ErrUnexpectedSQL, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, ErrNotFound
+	}
+
+	// 2. Revoke other sessions
+	if revokeOthers {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE sessions SET revoked_at = NOW() WHERE user_id = ? AND device_id <> ? AND revoked_at IS NULL`,
+			userID, keepDeviceID); err != nil {
+			if ctx.Err() != nil {
+				return 0, ctx.Err()
+			}
+			return 0, fmt.Errorf("%w: revoke sessions: %v", ErrUnexpectedSQL, err)
+		}
+	}
+
+	// 3. Read token_version
+	var tkv uint
+	if err := tx.QueryRowContext(ctx, `SELECT token_version FROM users WHERE id = ?`, userID).Scan(&tkv); err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return 0, fmt.Errorf("%w: read token_version: %v", ErrUnexpectedSQL, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return 0, fmt.Errorf("%w: commit: %v", ErrUnexpectedSQL, err)
+	}
+	return tkv, nil
+}
+
+// RehashPassword Swap in a hash of the same password with current params; a no-op when
+// the password changed since oldHash was read
+func (r *authRepo) RehashPassword(ctx context.Context, userID uint64, oldHash, newHash string) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE users SET password_hash = ? WHERE id = ? AND password_hash = ?`,
+		newHash, userID, oldHash)
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	return nil
+}
+
 // ConsumeOTTJTI Consume one time token jti
 func (r *authRepo) ConsumeOTTJTI(ctx context.Context, email, scene, jti string, newTTL int) error {
 	keyStr := config.RedisKeyOTTJTIUsed(email, scene, jti)
@@ -199,7 +363,8 @@ func (r *authRepo) ConsumeOTTJTI(ctx context.Context, email, scene, jti string,
 	return nil
 }
 
-// CheckEmailExists Check if email exists
+// CheckEmailExists Check if email exists; accounts pending deletion still hold theirs
+// (uk_users_email) until purged, so they count as existing
 func (r *authRepo) CheckEmailExists(ctx context.Context, email string) (bool, error) {
 	var dummy int
 	err := r.db.QueryRowContext(ctx,
@@ -256,6 +421,40 @@ func (r *authRepo) ThrottleMatchAndConsumeCode(ctx context.Context, email, scene
 	}
 }
 
+// StoreLoginRequest Bind a login code id to its email and the device that asked for it
+func (r *authRepo) StoreLoginRequest(ctx context.Context, codeID string, req models.LoginRequest, ttl time.Duration) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if err := r.rdb.Set(ctx, config.RedisKeyLoginRequest(codeID), data, ttl).Err(); err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w:%s", ErrUnexpectedRedis, err)
+	}
+	return nil
+}
+
+// TakeLoginRequest Read and delete a login request, so the code and the link together work once
+func (r *authRepo) TakeLoginRequest(ctx context.Context, codeID string) (*models.LoginRequest, error) {
+	data, err := r.rdb.GetDel(ctx, config.RedisKeyLoginRequest(codeID)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrNotFound
+		}
+		if ctx_util.IsCtxDone(ctx, err) {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w:%s", ErrUnexpectedRedis, err)
+	}
+	var req models.LoginRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, fmt.Errorf("%w:%s", ErrUnexpectedReply, err)
+	}
+	return &req, nil
+}
+
 // StoreOTPAndThrottle Check throttle -> Set throttle -> Store code
 func (r *authRepo) StoreOTPAndThrottle(ctx context.Context, email, scene, codeID, code string, otpTTL, throttleTTL int) (bool, error) {
 
@@ -289,6 +488,12 @@ func (r *authRepo) StoreOTPAndThrottle(ctx context.Context, email, scene, codeID
 	return false, nil
 }
 
+// isDuplicateEntry MySQL 1062, a unique key (uk_users_email) was violated
+func isDuplicateEntry(err error) bool {
+	var me *mysql.MySQLError
+	return errors.As(err, &me) && me.Number == 1062 || strings.Contains(err.Error(), "Duplicate entry")
+}
+
 type authRepo struct {
 	db      *sql.DB
 	rdb     *redis.Client
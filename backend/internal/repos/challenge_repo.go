@@ -0,0 +1,67 @@
+package repos
+
+import (
+	"backend/internal/config"
+	"backend/internal/pkg/ctx_util"
+	"backend/internal/repos/scripts"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type ChallengeRepo interface {
+	CountIssued(ctx context.Context, ip string, window time.Duration) (int64, int64, error)
+	Penalize(ctx context.Context, ip string, n int, window time.Duration) error
+	MarkUsed(ctx context.Context, challengeID string, ttl time.Duration) (bool, error)
+}
+
+// CountIssued Count one more challenge for ip and in total; returns both counts of the window
+func (r *challengeRepo) CountIssued(ctx context.Context, ip string, window time.Duration) (int64, int64, error) {
+	keys := []string{config.RedisKeyChallengeIP(ip), config.RedisKeyChallengeLoad()}
+	counts, err := r.scripts.IncrWindow.Run(ctx, r.rdb, keys, window.Milliseconds(), 1, 1).Int64Slice()
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return 0, 0, ctx.Err()
+		}
+		return 0, 0, fmt.Errorf("%w:%s", ErrUnexpectedRedis, err)
+	}
+	if len(counts) != 2 {
+		return 0, 0, fmt.Errorf("%w:unexpected reply %v", ErrUnexpectedRedis, counts)
+	}
+	return counts[0], counts[1], nil
+}
+
+// Penalize Count n challenges against ip, raising the difficulty of its next ones
+func (r *challengeRepo) Penalize(ctx context.Context, ip string, n int, window time.Duration) error {
+	keys := []string{config.RedisKeyChallengeIP(ip)}
+	if err := r.scripts.IncrWindow.Run(ctx, r.rdb, keys, window.Milliseconds(), n).Err(); err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w:%s", ErrUnexpectedRedis, err)
+	}
+	return nil
+}
+
+// MarkUsed Remember a solved challenge until it expires; false when it was solved before
+func (r *challengeRepo) MarkUsed(ctx context.Context, challengeID string, ttl time.Duration) (bool, error) {
+	ok, err := r.rdb.SetNX(ctx, config.RedisKeyChallengeUsed(challengeID), 1, ttl).Result()
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return false, ctx.Err()
+		}
+		return false, fmt.Errorf("%w:%s", ErrUnexpectedRedis, err)
+	}
+	return ok, nil
+}
+
+type challengeRepo struct {
+	rdb     *redis.Client
+	scripts *scripts.Registry
+}
+
+func NewChallengeRepo(rdb *redis.Client) ChallengeRepo {
+	return &challengeRepo{rdb: rdb, scripts: scripts.NewRegistry()}
+}
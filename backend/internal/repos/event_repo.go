@@ -0,0 +1,117 @@
+package repos
+
+import (
+	"backend/internal/models"
+	"backend/internal/pkg/ctx_util"
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type EventRepo interface {
+	InsertAuthEvents(ctx context.Context, events []models.AuthEvent) error
+	PruneAuthEvents(ctx context.Context, before time.Time, limit int) (int64, error)
+	ListUserAuthEvents(ctx context.Context, userID uint64, email string, beforeID uint64, limit int) ([]models.AuthEvent, error)
+}
+
+// InsertAuthEvents Write a batch in one multi-row INSERT
+func (r *eventRepo) InsertAuthEvents(ctx context.Context, events []models.AuthEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	// 1. Build VALUES
+	const cols = 10
+	var b strings.Builder
+	b.WriteString(`INSERT INTO auth_events
+		(user_id, email, event_type, success, ip, device_id, user_agent, request_id, detail, created_at) VALUES `)
+	args := make([]any, 0, len(events)*cols)
+	for i, e := range events {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString("(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+		args = append(args, e.UserID, e.Email, e.Type, e.Success, e.IP, nullBytes(e.DeviceID),
+			e.UserAgent, e.RequestID, e.Detail, e.CreatedAt.UTC())
+	}
+
+	// 2. Insert
+	if _, err := r.db.ExecContext(ctx, b.String(), args...); err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: insert auth_events: %v", ErrUnexpectedSQL, err)
+	}
+	return nil
+}
+
+// PruneAuthEvents Delete at most limit events older than before
+func (r *eventRepo) PruneAuthEvents(ctx context.Context, before time.Time, limit int) (int64, error) {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM auth_events WHERE created_at < ? LIMIT ?`, before.UTC(), limit)
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return 0, ctx.Err()
+		}
+		return 0, fmt.Errorf("%w: prune auth_events: %v", ErrUnexpectedSQL, err)
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
+// ListUserAuthEvents Events of a user plus those recorded against their email before
+// the user was known (code requests), newest first; beforeID 0 starts from the newest
+func (r *eventRepo) ListUserAuthEvents(ctx context.Context, userID uint64, email string, beforeID uint64, limit int) ([]models.AuthEvent, error) {
+	const query = `
+		SELECT id, user_id, email, event_type, success, ip, device_id, user_agent, request_id, detail, created_at
+		FROM auth_events
+		WHERE (user_id = ? OR (user_id IS NULL AND email = ?))
+		  AND (? = 0 OR id < ?)
+		ORDER BY id DESC
+		LIMIT ?
+	`
+	rows, err := r.db.QueryContext(ctx, query, userID, email, beforeID, beforeID, limit)
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	return scanAuthEvents(rows)
+}
+
+func scanAuthEvents(rows *sql.Rows) ([]models.AuthEvent, error) {
+	defer func() { _ = rows.Close() }()
+
+	var out []models.AuthEvent
+	for rows.Next() {
+		var e models.AuthEvent
+		if err := rows.Scan(
+			&e.ID, &e.UserID, &e.Email, &e.Type, &e.Success, &e.IP, &e.DeviceID, &e.UserAgent, &e.RequestID, &e.Detail, &e.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+		}
+		out = append(out, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	return out, nil
+}
+
+// nullBytes Store an empty device id as NULL rather than a zero-length BINARY(16)
+func nullBytes(b []byte) any {
+	if len(b) == 0 {
+		return nil
+	}
+	return b
+}
+
+type eventRepo struct {
+	db *sql.DB
+}
+
+func NewEventRepo(db *sql.DB) EventRepo {
+	return &eventRepo{db: db}
+}
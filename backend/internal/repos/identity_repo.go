@@ -0,0 +1,197 @@
+package repos
+
+import (
+	"backend/internal/config"
+	"backend/internal/models"
+	"backend/internal/pkg/ctx_util"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type IdentityRepo interface {
+	FindIdentity(ctx context.Context, issuer, subject string) (*models.UserIdentity, error)
+	ListIdentities(ctx context.Context, userID uint64) ([]models.UserIdentity, error)
+	CreateUserWithIdentity(ctx context.Context, email string, id models.UserIdentity) (uint64, uint, error)
+	InsertIdentity(ctx context.Context, id models.UserIdentity) error
+	TouchIdentity(ctx context.Context, id uint64, email *string, isPrivateEmail bool) error
+	DeleteIdentity(ctx context.Context, userID uint64, provider string) error
+	MarkTokenUsed(ctx context.Context, tokenHash string, ttl time.Duration) (bool, error)
+}
+
+const identityColumns = `id, user_id, provider, issuer, subject, email, is_private_email, last_login_at, created_at`
+
+func scanIdentity(row interface{ Scan(...any) error }) (*models.UserIdentity, error) {
+	var i models.UserIdentity
+	if err := row.Scan(&i.ID, &i.UserID, &i.Provider, &i.Issuer, &i.Subject, &i.Email, &i.IsPrivateEmail,
+		&i.LastLoginAt, &i.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+func (r *identityRepo) FindIdentity(ctx context.Context, issuer, subject string) (*models.UserIdentity, error) {
+	row := r.db.QueryRowContext(ctx,
+		`SELECT `+identityColumns+` FROM user_identities WHERE issuer = ? AND subject = ?`, issuer, subject)
+	i, err := scanIdentity(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		if ctx_util.IsCtxDone(ctx, err) {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	return i, nil
+}
+
+func (r *identityRepo) ListIdentities(ctx context.Context, userID uint64) ([]models.UserIdentity, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+identityColumns+` FROM user_identities WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var out []models.UserIdentity
+	for rows.Next() {
+		i, err := scanIdentity(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+		}
+		out = append(out, *i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	return out, nil
+}
+
+// CreateUserWithIdentity Sign up through a provider: a user without a password and its
+// identity, in one transaction. ErrEmailAlreadyExists when the email is taken,
+// ErrIdentityExists when a concurrent sign-in created the identity first
+func (r *identityRepo) CreateUserWithIdentity(ctx context.Context, email string, id models.UserIdentity) (uint64, uint, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, 0, ctx.Err()
+		}
+		return 0, 0, fmt.Errorf("%w: begin tx: %v", ErrUnexpectedSQL, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// 1. User, an empty hash never matches a password
+	res, err := tx.ExecContext(ctx, `INSERT INTO users (email, password_hash) VALUES (?, '')`, email)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, 0, ctx.Err()
+		}
+		if isDuplicateEntry(err) {
+			return 0, 0, ErrEmailAlreadyExists
+		}
+		return 0, 0, fmt.Errorf("%w: insert user: %v", ErrUnexpectedSQL, err)
+	}
+	uid, err := res.LastInsertId()
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+
+	// 2. Identity
+	id.UserID = uint64(uid)
+	if err := insertIdentity(ctx, tx, id); err != nil {
+		return 0, 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if ctx.Err() != nil {
+			return 0, 0, ctx.Err()
+		}
+		return 0, 0, fmt.Errorf("%w: commit: %v", ErrUnexpectedSQL, err)
+	}
+	return uint64(uid), 1, nil
+}
+
+// InsertIdentity Link an identity to an existing user; ErrIdentityExists when the identity
+// is linked already or the user has one from this provider
+func (r *identityRepo) InsertIdentity(ctx context.Context, id models.UserIdentity) error {
+	return insertIdentity(ctx, r.db, id)
+}
+
+func insertIdentity(ctx context.Context, db interface {
+	ExecContext(context.Context, string, ...any) (sql.Result, error)
+}, id models.UserIdentity) error {
+	const query = `
+		INSERT INTO user_identities (user_id, provider, issuer, subject, email, is_private_email, last_login_at)
+		VALUES (?, ?, ?, ?, ?, ?, NOW())
+	`
+	if _, err := db.ExecContext(ctx, query, id.UserID, id.Provider, id.Issuer, id.Subject, id.Email, id.IsPrivateEmail); err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return ctx.Err()
+		}
+		if isDuplicateEntry(err) {
+			return ErrIdentityExists
+		}
+		return fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	return nil
+}
+
+// TouchIdentity Record a sign-in and the email the provider reports now; a nil email keeps the old one
+func (r *identityRepo) TouchIdentity(ctx context.Context, id uint64, email *string, isPrivateEmail bool) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE user_identities
+		SET last_login_at = NOW(),
+		    email = COALESCE(?, email),
+		    is_private_email = IF(? IS NULL, is_private_email, ?)
+		WHERE id = ?`, email, email, isPrivateEmail, id)
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	return nil
+}
+
+func (r *identityRepo) DeleteIdentity(ctx context.Context, userID uint64, provider string) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM user_identities WHERE user_id = ? AND provider = ?`, userID, provider)
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// MarkTokenUsed Remember an ID token until it expires; false when it was seen before, i.e. a replay
+func (r *identityRepo) MarkTokenUsed(ctx context.Context, tokenHash string, ttl time.Duration) (bool, error) {
+	ok, err := r.rdb.SetNX(ctx, config.RedisKeyOIDCTokenUsed(tokenHash), 1, ttl).Result()
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return false, ctx.Err()
+		}
+		return false, fmt.Errorf("%w:%s", ErrUnexpectedRedis, err)
+	}
+	return ok, nil
+}
+
+type identityRepo struct {
+	db  *sql.DB
+	rdb *redis.Client
+}
+
+func NewIdentityRepo(db *sql.DB, rdb *redis.Client) IdentityRepo {
+	return &identityRepo{db: db, rdb: rdb}
+}
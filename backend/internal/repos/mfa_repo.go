@@ -0,0 +1,346 @@
+package repos
+
+import (
+	"backend/internal/config"
+	"backend/internal/models"
+	"backend/internal/pkg/ctx_util"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type MFARepo interface {
+	GetTOTP(ctx context.Context, userID uint64) (*models.UserMFA, error)
+	StorePendingTOTP(ctx context.Context, userID uint64, secretEnc []byte) error
+	EnableTOTP(ctx context.Context, userID uint64, codeHashes [][]byte) error
+	DeleteTOTP(ctx context.Context, userID uint64) error
+	ReplaceRecoveryCodes(ctx context.Context, userID uint64, codeHashes [][]byte) error
+	UseRecoveryCode(ctx context.Context, userID uint64, codeHash []byte) error
+	CountRecoveryCodes(ctx context.Context, userID uint64) (int, error)
+	MarkTOTPStep(ctx context.Context, userID uint64, step int64, ttl time.Duration) (bool, error)
+	StoreChallenge(ctx context.Context, tokenHash string, ch models.MFAChallenge, ttl time.Duration) error
+	GetChallenge(ctx context.Context, tokenHash string) (*models.MFAChallenge, error)
+	FailChallenge(ctx context.Context, tokenHash string, limit int, ttl time.Duration) (bool, error)
+	DeleteChallenge(ctx context.Context, tokenHash string) (bool, error)
+	CountUserFails(ctx context.Context, userID uint64) (int64, error)
+	FailUser(ctx context.Context, userID uint64, window time.Duration) (int64, error)
+	ClearUserFails(ctx context.Context, userID uint64) error
+}
+
+func (r *mfaRepo) GetTOTP(ctx context.Context, userID uint64) (*models.UserMFA, error) {
+	var m models.UserMFA
+	err := r.db.QueryRowContext(ctx,
+		`SELECT user_id, secret_enc, enabled_at, created_at, updated_at FROM user_mfa WHERE user_id = ?`, userID).
+		Scan(&m.UserID, &m.SecretEnc, &m.EnabledAt, &m.CreatedAt, &m.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		if ctx_util.IsCtxDone(ctx, err) {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	return &m, nil
+}
+
+// StorePendingTOTP Start or restart enrollment; an enabled secret is never overwritten
+func (r *mfaRepo) StorePendingTOTP(ctx context.Context, userID uint64, secretEnc []byte) error {
+	const query = `
+		INSERT INTO user_mfa (user_id, secret_enc) VALUES (?, ?)
+		ON DUPLICATE KEY UPDATE
+			secret_enc = IF(enabled_at IS NULL, VALUES(secret_enc), secret_enc)
+	`
+	if _, err := r.db.ExecContext(ctx, query, userID, secretEnc); err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	return nil
+}
+
+// EnableTOTP Finish enrollment and issue the first recovery codes; ErrNotFound when
+// there is no pending secret
+func (r *mfaRepo) EnableTOTP(ctx context.Context, userID uint64, codeHashes [][]byte) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: begin tx: %v", ErrUnexpectedSQL, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// 1. Enable
+	res, err := tx.ExecContext(ctx,
+		`UPDATE user_mfa SET enabled_at = NOW() WHERE user_id = ? AND enabled_at IS NULL`, userID)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: enable totp: %v", ErrUnexpectedSQL, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+
+	// 2. Recovery codes
+	if err := replaceRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: commit: %v", ErrUnexpectedSQL, err)
+	}
+	return nil
+}
+
+// DeleteTOTP Turn the second factor off, dropping the secret and all recovery codes
+func (r *mfaRepo) DeleteTOTP(ctx context.Context, userID uint64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: begin tx: %v", ErrUnexpectedSQL, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, query := range []string{
+		`DELETE FROM mfa_recovery_codes WHERE user_id = ?`,
+		`DELETE FROM user_mfa WHERE user_id = ?`,
+	} {
+		if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("%w: delete totp: %v", ErrUnexpectedSQL, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: commit: %v", ErrUnexpectedSQL, err)
+	}
+	return nil
+}
+
+// ReplaceRecoveryCodes Invalidate every old code, used or not, and store the new set
+func (r *mfaRepo) ReplaceRecoveryCodes(ctx context.Context, userID uint64, codeHashes [][]byte) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: begin tx: %v", ErrUnexpectedSQL, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: commit: %v", ErrUnexpectedSQL, err)
+	}
+	return nil
+}
+
+// UseRecoveryCode Burn a code; ErrNotFound when it does not exist or was used already
+func (r *mfaRepo) UseRecoveryCode(ctx context.Context, userID uint64, codeHash []byte) error {
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE mfa_recovery_codes SET used_at = NOW() WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`,
+		userID, codeHash)
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// CountRecoveryCodes Unused codes left
+func (r *mfaRepo) CountRecoveryCodes(ctx context.Context, userID uint64) (int, error) {
+	var n int
+	err := r.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_id = ? AND used_at IS NULL`, userID).Scan(&n)
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return 0, ctx.Err()
+		}
+		return 0, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	return n, nil
+}
+
+// MarkTOTPStep Remember a time step as used; false when it already was, i.e. a replay
+func (r *mfaRepo) MarkTOTPStep(ctx context.Context, userID uint64, step int64, ttl time.Duration) (bool, error) {
+	ok, err := r.rdb.SetNX(ctx, config.RedisKeyTOTPUsed(userID, step), 1, ttl).Result()
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return false, ctx.Err()
+		}
+		return false, fmt.Errorf("%w:%s", ErrUnexpectedRedis, err)
+	}
+	return ok, nil
+}
+
+func (r *mfaRepo) StoreChallenge(ctx context.Context, tokenHash string, ch models.MFAChallenge, ttl time.Duration) error {
+	data, err := json.Marshal(ch)
+	if err != nil {
+		return err
+	}
+	if err := r.rdb.Set(ctx, config.RedisKeyMFAChallenge(tokenHash), data, ttl).Err(); err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w:%s", ErrUnexpectedRedis, err)
+	}
+	return nil
+}
+
+func (r *mfaRepo) GetChallenge(ctx context.Context, tokenHash string) (*models.MFAChallenge, error) {
+	data, err := r.rdb.Get(ctx, config.RedisKeyMFAChallenge(tokenHash)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrNotFound
+		}
+		if ctx_util.IsCtxDone(ctx, err) {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w:%s", ErrUnexpectedRedis, err)
+	}
+	var ch models.MFAChallenge
+	if err := json.Unmarshal(data, &ch); err != nil {
+		return nil, fmt.Errorf("%w:%s", ErrUnexpectedReply, err)
+	}
+	return &ch, nil
+}
+
+// FailChallenge Count a wrong code; once limit is reached the challenge is dropped and true returned
+func (r *mfaRepo) FailChallenge(ctx context.Context, tokenHash string, limit int, ttl time.Duration) (bool, error) {
+	key := config.RedisKeyMFAChallengeFails(tokenHash)
+	pipe := r.rdb.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return false, ctx.Err()
+		}
+		return false, fmt.Errorf("%w:%s", ErrUnexpectedRedis, err)
+	}
+	if incr.Val() < int64(limit) {
+		return false, nil
+	}
+	_ = r.rdb.Del(ctx, config.RedisKeyMFAChallenge(tokenHash), key).Err()
+	return true, nil
+}
+
+// DeleteChallenge Consume a challenge; false when another request consumed it first
+func (r *mfaRepo) DeleteChallenge(ctx context.Context, tokenHash string) (bool, error) {
+	n, err := r.rdb.Del(ctx, config.RedisKeyMFAChallenge(tokenHash), config.RedisKeyMFAChallengeFails(tokenHash)).Result()
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return false, ctx.Err()
+		}
+		return false, fmt.Errorf("%w:%s", ErrUnexpectedRedis, err)
+	}
+	return n > 0, nil
+}
+
+// CountUserFails Wrong codes of the user in the current window
+func (r *mfaRepo) CountUserFails(ctx context.Context, userID uint64) (int64, error) {
+	n, err := r.rdb.Get(ctx, config.RedisKeyMFAUserFails(userID)).Int64()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return 0, nil
+		}
+		if ctx_util.IsCtxDone(ctx, err) {
+			return 0, ctx.Err()
+		}
+		return 0, fmt.Errorf("%w:%s", ErrUnexpectedRedis, err)
+	}
+	return n, nil
+}
+
+// FailUser Count a wrong code of the user; the window starts at the first one. Returns the count
+func (r *mfaRepo) FailUser(ctx context.Context, userID uint64, window time.Duration) (int64, error) {
+	key := config.RedisKeyMFAUserFails(userID)
+	pipe := r.rdb.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.ExpireNX(ctx, key, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return 0, ctx.Err()
+		}
+		return 0, fmt.Errorf("%w:%s", ErrUnexpectedRedis, err)
+	}
+	return incr.Val(), nil
+}
+
+func (r *mfaRepo) ClearUserFails(ctx context.Context, userID uint64) error {
+	if err := r.rdb.Del(ctx, config.RedisKeyMFAUserFails(userID)).Err(); err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w:%s", ErrUnexpectedRedis, err)
+	}
+	return nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID uint64, codeHashes [][]byte) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: delete recovery codes: %v", ErrUnexpectedSQL, err)
+	}
+	if len(codeHashes) == 0 {
+		return nil
+	}
+	var b strings.Builder
+	b.WriteString(`INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES `)
+	args := make([]any, 0, 2*len(codeHashes))
+	for i, h := range codeHashes {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString("(?, ?)")
+		args = append(args, userID, h)
+	}
+	if _, err := tx.ExecContext(ctx, b.String(), args...); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: insert recovery codes: %v", ErrUnexpectedSQL, err)
+	}
+	return nil
+}
+
+type mfaRepo struct {
+	db  *sql.DB
+	rdb *redis.Client
+}
+
+func NewMFARepo(db *sql.DB, rdb *redis.Client) MFARepo {
+	return &mfaRepo{db: db, rdb: rdb}
+}
@@ -0,0 +1,177 @@
+package repos
+
+import (
+	"backend/internal/config"
+	"backend/internal/models"
+	"backend/internal/pkg/ctx_util"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type PasskeyRepo interface {
+	StoreChallenge(ctx context.Context, challengeID string, ch models.WebAuthnChallenge, ttl time.Duration) error
+	TakeChallenge(ctx context.Context, challengeID string) (*models.WebAuthnChallenge, error)
+	InsertCredential(ctx context.Context, c models.PasskeyCredential) (uint64, error)
+	ListCredentials(ctx context.Context, userID uint64) ([]models.PasskeyCredential, error)
+	GetCredential(ctx context.Context, credentialID []byte) (*models.PasskeyCredential, error)
+	UpdateSignCount(ctx context.Context, id uint64, oldCount, newCount uint32, backedUp bool) error
+	DeleteCredential(ctx context.Context, userID, id uint64) error
+}
+
+const passkeyColumns = `id, user_id, credential_id, public_key, alg, aaguid, name, transports,
+	sign_count, backup_eligible, backed_up, last_used_at, created_at`
+
+func scanPasskey(row interface{ Scan(...any) error }) (*models.PasskeyCredential, error) {
+	var c models.PasskeyCredential
+	if err := row.Scan(&c.ID, &c.UserID, &c.CredentialID, &c.PublicKey, &c.Alg, &c.AAGUID, &c.Name, &c.Transports,
+		&c.SignCount, &c.BackupEligible, &c.BackedUp, &c.LastUsedAt, &c.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (r *passkeyRepo) StoreChallenge(ctx context.Context, challengeID string, ch models.WebAuthnChallenge, ttl time.Duration) error {
+	data, err := json.Marshal(ch)
+	if err != nil {
+		return err
+	}
+	if err := r.rdb.Set(ctx, config.RedisKeyWebAuthnChallenge(challengeID), data, ttl).Err(); err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w:%s", ErrUnexpectedRedis, err)
+	}
+	return nil
+}
+
+// TakeChallenge Read and delete a challenge so each one is answered once
+func (r *passkeyRepo) TakeChallenge(ctx context.Context, challengeID string) (*models.WebAuthnChallenge, error) {
+	data, err := r.rdb.GetDel(ctx, config.RedisKeyWebAuthnChallenge(challengeID)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrNotFound
+		}
+		if ctx_util.IsCtxDone(ctx, err) {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w:%s", ErrUnexpectedRedis, err)
+	}
+	var ch models.WebAuthnChallenge
+	if err := json.Unmarshal(data, &ch); err != nil {
+		return nil, fmt.Errorf("%w:%s", ErrUnexpectedReply, err)
+	}
+	return &ch, nil
+}
+
+// InsertCredential Store a new passkey; a credential id is globally unique
+func (r *passkeyRepo) InsertCredential(ctx context.Context, c models.PasskeyCredential) (uint64, error) {
+	const query = `
+		INSERT INTO webauthn_credentials
+			(user_id, credential_id, public_key, alg, aaguid, name, transports, sign_count, backup_eligible, backed_up)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	res, err := r.db.ExecContext(ctx, query, c.UserID, c.CredentialID, c.PublicKey, c.Alg, nullBytes(c.AAGUID),
+		c.Name, c.Transports, c.SignCount, c.BackupEligible, c.BackedUp)
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return 0, ctx.Err()
+		}
+		if isDuplicateEntry(err) {
+			return 0, ErrCredentialExists
+		}
+		return 0, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	return uint64(id), nil
+}
+
+func (r *passkeyRepo) ListCredentials(ctx context.Context, userID uint64) ([]models.PasskeyCredential, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+passkeyColumns+` FROM webauthn_credentials WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var out []models.PasskeyCredential
+	for rows.Next() {
+		c, err := scanPasskey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+		}
+		out = append(out, *c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	return out, nil
+}
+
+func (r *passkeyRepo) GetCredential(ctx context.Context, credentialID []byte) (*models.PasskeyCredential, error) {
+	row := r.db.QueryRowContext(ctx,
+		`SELECT `+passkeyColumns+` FROM webauthn_credentials WHERE credential_id = ?`, credentialID)
+	c, err := scanPasskey(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		if ctx_util.IsCtxDone(ctx, err) {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	return c, nil
+}
+
+// UpdateSignCount Record a login, only if the counter is still oldCount; ErrNotFound means
+// a concurrent login with the same credential got there first
+func (r *passkeyRepo) UpdateSignCount(ctx context.Context, id uint64, oldCount, newCount uint32, backedUp bool) error {
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE webauthn_credentials SET sign_count = ?, backed_up = ?, last_used_at = NOW()
+		WHERE id = ? AND sign_count = ?`, newCount, backedUp, id, oldCount)
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 && newCount != 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *passkeyRepo) DeleteCredential(ctx context.Context, userID, id uint64) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM webauthn_credentials WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type passkeyRepo struct {
+	db  *sql.DB
+	rdb *redis.Client
+}
+
+func NewPasskeyRepo(db *sql.DB, rdb *redis.Client) PasskeyRepo {
+	return &passkeyRepo{db: db, rdb: rdb}
+}
@@ -15,6 +15,15 @@ var (
 	// ErrEmailAlreadyExists 409
 	ErrEmailAlreadyExists = errors.New("email already exists")
 
+	// ErrCredentialExists 409: passkey already registered
+	ErrCredentialExists = errors.New("credential already exists")
+
+	// ErrIdentityExists 409: external identity linked already, to this or another user
+	ErrIdentityExists = errors.New("identity already linked")
+
+	// ErrReputationEntryExists 409: IP range or domain listed already
+	ErrReputationEntryExists = errors.New("reputation entry exists")
+
 	// ErrRateLimited 429
 	ErrRateLimited = errors.New("throttle")
 
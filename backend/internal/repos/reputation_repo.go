@@ -0,0 +1,211 @@
+package repos
+
+import (
+	"backend/internal/config"
+	"backend/internal/models"
+	"backend/internal/pkg/ctx_util"
+	"backend/internal/repos/scripts"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type ReputationRepo interface {
+	ListEntries(ctx context.Context) ([]models.ReputationEntry, error)
+	CachedEntries(ctx context.Context) ([]models.ReputationEntry, bool, error)
+	CacheEntries(ctx context.Context, entries []models.ReputationEntry, ttl time.Duration) error
+	InsertEntry(ctx context.Context, e models.ReputationEntry, audit *models.AdminAudit) (uint64, error)
+	DeleteEntry(ctx context.Context, id uint64, audit *models.AdminAudit) error
+	IsBanned(ctx context.Context, ip string) (bool, error)
+	Trip(ctx context.Context, ip string, window time.Duration) (int64, error)
+	Ban(ctx context.Context, ip string, d time.Duration) error
+}
+
+// ListEntries Every managed entry that has not expired, from MySQL
+func (r *reputationRepo) ListEntries(ctx context.Context) ([]models.ReputationEntry, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, kind, value, action, reason, created_by, expires_at, created_at
+		FROM reputation_entries
+		WHERE expires_at IS NULL OR expires_at > NOW()
+		ORDER BY id
+	`)
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	out := []models.ReputationEntry{}
+	for rows.Next() {
+		var e models.ReputationEntry
+		if err := rows.Scan(&e.ID, &e.Kind, &e.Value, &e.Action, &e.Reason, &e.CreatedBy, &e.ExpiresAt, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+		}
+		out = append(out, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	return out, nil
+}
+
+// CachedEntries Entries cached in Redis by CacheEntries; false when the cache is empty
+func (r *reputationRepo) CachedEntries(ctx context.Context) ([]models.ReputationEntry, bool, error) {
+	raw, err := r.rdb.Get(ctx, config.RedisKeyReputationEntries()).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, false, nil
+		}
+		if ctx_util.IsCtxDone(ctx, err) {
+			return nil, false, ctx.Err()
+		}
+		return nil, false, fmt.Errorf("%w:%s", ErrUnexpectedRedis, err)
+	}
+	var out []models.ReputationEntry
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, false, fmt.Errorf("%w:%s", ErrUnexpectedReply, err)
+	}
+	return out, true, nil
+}
+
+func (r *reputationRepo) CacheEntries(ctx context.Context, entries []models.ReputationEntry, ttl time.Duration) error {
+	raw, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	if err := r.rdb.Set(ctx, config.RedisKeyReputationEntries(), raw, ttl).Err(); err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w:%s", ErrUnexpectedRedis, err)
+	}
+	return nil
+}
+
+// InsertEntry Add an entry with its audit row, then drop the cache so every instance reloads.
+// ErrReputationEntryExists when the value is listed already
+func (r *reputationRepo) InsertEntry(ctx context.Context, e models.ReputationEntry, audit *models.AdminAudit) (uint64, error) {
+	var id uint64
+	err := r.withAudit(ctx, audit, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `
+			INSERT INTO reputation_entries (kind, value, action, reason, created_by, expires_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, e.Kind, e.Value, e.Action, e.Reason, e.CreatedBy, e.ExpiresAt)
+		if err != nil {
+			if isDuplicateEntry(err) {
+				return ErrReputationEntryExists
+			}
+			return err
+		}
+		n, err := res.LastInsertId()
+		id = uint64(n)
+		return err
+	})
+	return id, err
+}
+
+// DeleteEntry Remove an entry with its audit row, then drop the cache
+func (r *reputationRepo) DeleteEntry(ctx context.Context, id uint64, audit *models.AdminAudit) error {
+	return r.withAudit(ctx, audit, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `DELETE FROM reputation_entries WHERE id = ?`, id)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// withAudit Run change and insert audit in one transaction; the cache is dropped after commit
+func (r *reputationRepo) withAudit(ctx context.Context, audit *models.AdminAudit, change func(tx *sql.Tx) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: begin tx: %v", ErrUnexpectedSQL, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := change(tx); err != nil {
+		if errors.Is(err, ErrNotFound) || errors.Is(err, ErrReputationEntryExists) {
+			return err
+		}
+		if ctx_util.IsCtxDone(ctx, err) {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w:%s", ErrUnexpectedSQL, err)
+	}
+	if err := insertAudit(ctx, tx, audit); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%w: commit: %v", ErrUnexpectedSQL, err)
+	}
+	if err := r.rdb.Del(ctx, config.RedisKeyReputationEntries()).Err(); err != nil {
+		return fmt.Errorf("%w:%s", ErrUnexpectedRedis, err)
+	}
+	return nil
+}
+
+func (r *reputationRepo) IsBanned(ctx context.Context, ip string) (bool, error) {
+	n, err := r.rdb.Exists(ctx, config.RedisKeyReputationBan(ip)).Result()
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return false, ctx.Err()
+		}
+		return false, fmt.Errorf("%w:%s", ErrUnexpectedRedis, err)
+	}
+	return n > 0, nil
+}
+
+// Trip Count one throttle hit of ip; returns the hits of the current window
+func (r *reputationRepo) Trip(ctx context.Context, ip string, window time.Duration) (int64, error) {
+	keys := []string{config.RedisKeyReputationTrips(ip)}
+	counts, err := r.scripts.IncrWindow.Run(ctx, r.rdb, keys, window.Milliseconds(), 1).Int64Slice()
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return 0, ctx.Err()
+		}
+		return 0, fmt.Errorf("%w:%s", ErrUnexpectedRedis, err)
+	}
+	if len(counts) != 1 {
+		return 0, fmt.Errorf("%w:%v", ErrUnexpectedReply, counts)
+	}
+	return counts[0], nil
+}
+
+// Ban Refuse ip for d, counting its throttle hits from zero again
+func (r *reputationRepo) Ban(ctx context.Context, ip string, d time.Duration) error {
+	_, err := r.rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, config.RedisKeyReputationBan(ip), 1, d)
+		p.Del(ctx, config.RedisKeyReputationTrips(ip))
+		return nil
+	})
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w:%s", ErrUnexpectedRedis, err)
+	}
+	return nil
+}
+
+type reputationRepo struct {
+	db      *sql.DB
+	rdb     *redis.Client
+	scripts *scripts.Registry
+}
+
+func NewReputationRepo(db *sql.DB, rdb *redis.Client) ReputationRepo {
+	return &reputationRepo{db: db, rdb: rdb, scripts: scripts.NewRegistry()}
+}
@@ -0,0 +1,14 @@
+-- KEYS[i]=counter
+-- ARGV[1]=window(ms), ARGV[1+i]=increment of KEYS[i]
+
+-- 1. Fixed window: the first increment of a window starts its expiry
+local counts = {}
+for i, key in ipairs(KEYS) do
+    local n = redis.call("INCRBY", key, ARGV[i + 1])
+    if n == tonumber(ARGV[i + 1]) then
+        redis.call("PEXPIRE", key, ARGV[1])
+    end
+    counts[i] = n
+end
+
+return counts
@@ -10,6 +10,7 @@ type Registry struct {
 	StoreOTPAndThrottle         *redis.Script
 	ThrottleMatchAndConsumeCode *redis.Script
 	FindAdnMarkOTTJTI           *redis.Script
+	IncrWindow                  *redis.Script
 }
 
 func NewRegistry() *Registry {
@@ -17,6 +18,7 @@ func NewRegistry() *Registry {
 		StoreOTPAndThrottle:         redis.NewScript(storeOTPAndThrottleLua),
 		ThrottleMatchAndConsumeCode: redis.NewScript(throttleMatchAndConsumeCodeLua),
 		FindAdnMarkOTTJTI:           redis.NewScript(findAndMarkOTTJTI),
+		IncrWindow:                  redis.NewScript(incrWindowLua),
 	}
 }
 
@@ -28,3 +30,6 @@ var throttleMatchAndConsumeCodeLua string
 
 //go:embed store_otp_and_throttle.lua
 var storeOTPAndThrottleLua string
+
+//go:embed incr_window.lua
+var incrWindowLua string
@@ -4,47 +4,176 @@ import (
 	"backend/internal/config"
 	"backend/internal/handlers"
 	"backend/internal/middlewares"
+	"backend/internal/models"
+	"backend/internal/pkg/challenge"
+	"backend/internal/pkg/idempotency"
+	"backend/internal/pkg/jwtx"
+	"backend/internal/pkg/mailx"
+	"backend/internal/pkg/oidc"
+	"backend/internal/pkg/openapi"
+	"backend/internal/pkg/passwordhash"
+	"backend/internal/pkg/pwpolicy"
+	"backend/internal/pkg/ratelimit"
+	"backend/internal/pkg/secretbox"
 	"backend/internal/repos"
 	"backend/internal/services"
+	"backend/internal/settings"
 	"database/sql"
+	"io"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/redis/go-redis/v9"
 )
 
 type Deps struct {
-	DB  *sql.DB
-	RDB *redis.Client
+	Config   *config.Config
+	Settings settings.Provider
+	Keyring  *jwtx.Keyring
+	DB       *sql.DB
+	RDB      *redis.Client
+	// Events Background auth event writer, started by the caller
+	Events services.EventRecorder
+	// Mailer Delivery of codes and security notices
+	Mailer mailx.Mailer
+	// SecretBox Seals TOTP secrets, built from MFA_ENCRYPTION_KEY
+	SecretBox *secretbox.Box
+	// OIDC ID token verifier of the configured sign-in providers
+	OIDC *oidc.Verifier
+	// Hasher Password hashing pool shared by every service
+	Hasher *passwordhash.Hasher
+	// PasswordPolicy Rules new passwords must pass
+	PasswordPolicy *pwpolicy.Policy
+	// Reputation IP and email-domain lists and bans, refreshed by the caller
+	Reputation services.ReputationService
+	// CAPTCHA Verifier of captcha responses, nil unless CHALLENGE_MODE=captcha
+	CAPTCHA challenge.CAPTCHA
+	// AccessLogOut Access log destination, nil means stdout
+	AccessLogOut io.Writer
+	// OpenAPI docs/api.yaml to check traffic against, nil when OPENAPI_MODE=off
+	OpenAPI *openapi.Spec
 }
 
 func SetupRouter(d Deps) *gin.Engine {
 	// 1. Set Up Engine
-	r := gin.Default()
+	//    gin.New: gin's own logger is replaced by middlewares.AccessLog
+	//    binding errors name fields as clients send them
+	//    the client IP that rate limits and bans key on comes from X-Forwarded-For only when
+	//    a TRUSTED_PROXIES peer sent it, gin.New would trust any peer; the list is validated
+	r := gin.New()
+	if err := r.SetTrustedProxies(d.Config.Server.TrustedProxies); err != nil {
+		panic(err)
+	}
+	handlers.UseJSONFieldNames()
 
 	// 2. User Middlewares
-	r.Use(gin.Recovery())
+	//    AccessLog sits outside Recovery so a panic is logged with the 500 it became
 	r.Use(middlewares.RequestID())
-	r.Use(middlewares.Timeout(config.C.Timeouts.Request))
-	r.Use(middlewares.AccessLog())
+	r.Use(middlewares.ClientInfo())
+	r.Use(middlewares.Locale())
+	r.Use(middlewares.AccessLog(middlewares.AccessLogConfig{
+		Out:           d.AccessLogOut,
+		SkipPaths:     d.Config.AccessLog.SkipPaths,
+		SlowThreshold: d.Config.AccessLog.SlowThreshold,
+	}))
+	r.Use(gin.Recovery())
+	r.Use(middlewares.Timeout(func() time.Duration {
+		return d.Settings.Current().Timeouts.Request
+	}))
+	if d.OpenAPI != nil {
+		r.Use(middlewares.OpenAPI(d.OpenAPI, d.Config.OpenAPI.Mode == "strict"))
+	}
 
 	// 3. Dependencies Injection
+	tokens := jwtx.NewManager(d.Config.JWT, d.Keyring)
 	authRepo := repos.NewAuthRepo(d.DB, d.RDB)
-	authSvc := services.NewAuthService(authRepo)
-	authH := handlers.NewAuthHandler(authSvc)
+	mfaRepo := repos.NewMFARepo(d.DB, d.RDB)
+	passkeyRepo := repos.NewPasskeyRepo(d.DB, d.RDB)
+	identityRepo := repos.NewIdentityRepo(d.DB, d.RDB)
+	authSvc := services.NewAuthService(authRepo, mfaRepo, passkeyRepo, identityRepo, d.SecretBox, d.OIDC, d.Hasher, d.PasswordPolicy, d.Reputation, d.Config, d.Settings, tokens, d.Events, d.Mailer)
+	adminSvc := services.NewAdminService(repos.NewAdminRepo(d.DB, d.RDB), authSvc, d.Events)
+	accountSvc := services.NewAccountService(repos.NewAccountRepo(d.DB, d.RDB), authRepo, repos.NewEventRepo(d.DB), mfaRepo, passkeyRepo, identityRepo, d.SecretBox, d.OIDC, d.Hasher, d.Reputation, d.Events, d.Mailer, d.Config, d.Settings)
+	challengeSvc := services.NewChallengeService(repos.NewChallengeRepo(d.RDB), d.CAPTCHA, d.Config, d.Settings)
+	authH := handlers.NewAuthHandler(authSvc, challengeSvc)
+	adminH := handlers.NewAdminHandler(adminSvc, d.Reputation)
+	meH := handlers.NewMeHandler(accountSvc, authSvc)
+	jwksH := handlers.NewJWKSHandler(tokens)
+	linkH := handlers.NewLinkHandler()
+	idem := middlewares.NewIdempotency(idempotency.New(d.RDB), d.Config.Idempotency)
+	rl := middlewares.NewRateLimiter(ratelimit.New(d.RDB), d.Config.RateLimit, func(c *gin.Context) {
+		d.Reputation.Trip(c.Request.Context(), c.ClientIP())
+	})
 
 	// 4. Register Router
+	r.GET("/.well-known/jwks.json", jwksH.HandleJWKS)
+	r.GET("/revert-email", linkH.HandleRevertEmailPage)
+	r.GET("/magic-login", linkH.HandleMagicLoginPage)
 	apiGroup := r.Group("/api")
 	{
 		// a. Health Check
 		apiGroup.GET("/ping", checkHealth)
 
 		// b. Auth
-		authGroup := apiGroup.Group("/auth")
+		//    Idempotency-Key only where no credential is answered: a stored login would be a
+		//    session for whoever replays it. create-account is stored without its tokens
+		authGroup := apiGroup.Group("/auth", middlewares.IPReputation(d.Reputation), rl.Limit(limitAuthIP))
 		{
-			authGroup.POST("/request-code", authH.HandleRequestCode)
-			authGroup.POST("/verify-code", authH.HandleVerifyCode)
-			authGroup.POST("/create-account", middlewares.OneTimeToken(), authH.HandleCreateAccount)
-			authGroup.POST("/login", authH.HandleLogin)
+			authGroup.POST("/challenge", authH.HandleIssueChallenge)
+			authGroup.POST("/request-code", rl.Limit(limitSendEmail), idem.Handle(), authH.HandleRequestCode)
+			authGroup.POST("/verify-code", rl.Limit(limitGuess), authH.HandleVerifyCode)
+			authGroup.POST("/create-account", idem.Handle(), middlewares.OneTimeToken(tokens), authH.HandleCreateAccount)
+			authGroup.POST("/login", rl.Limit(limitGuess), authH.HandleLogin)
+			authGroup.POST("/login/email", rl.Limit(limitSendEmail), idem.Handle(), authH.HandleRequestLoginCode)
+			authGroup.POST("/login/email/verify", rl.Limit(limitGuess), authH.HandleLoginWithCode)
+			authGroup.POST("/login/magic", authH.HandleLoginWithMagicLink)
+			authGroup.POST("/restore-account", rl.Limit(limitGuess), authH.HandleRestoreAccount)
+			authGroup.POST("/revert-email", idem.Handle(), meH.HandleRevertEmail)
+			authGroup.POST("/mfa/verify", rl.Limit(limitGuess), authH.HandleVerifyMFA)
+			authGroup.POST("/passkey/begin", authH.HandleBeginPasskeyLogin)
+			authGroup.POST("/passkey/finish", authH.HandleFinishPasskeyLogin)
+			authGroup.POST("/oidc/:provider", authH.HandleOIDCLogin)
+		}
+
+		// c. Signed-in user
+		meGroup := apiGroup.Group("/me", middlewares.AccessToken(authSvc), rl.Limit(limitUser), idem.Handle())
+		{
+			meGroup.DELETE("", meH.HandleDeleteAccount)
+			meGroup.GET("/export", meH.HandleExport)
+			meGroup.GET("/security-events", meH.HandleSecurityEvents)
+			meGroup.POST("/email", rl.Limit(limitSendEmailUser), meH.HandleRequestEmailChange)
+			meGroup.POST("/email/verify", meH.HandleConfirmEmailChange)
+			meGroup.POST("/password", rl.Limit(limitGuess), meH.HandleChangePassword)
+			meGroup.PUT("/locale", meH.HandleSetLocale)
+			meGroup.GET("/mfa", meH.HandleMFAStatus)
+			meGroup.POST("/mfa/totp/setup", meH.HandleSetupTOTP)
+			meGroup.POST("/mfa/totp/confirm", meH.HandleConfirmTOTP)
+			meGroup.POST("/mfa/totp/disable", meH.HandleDisableTOTP)
+			meGroup.POST("/mfa/recovery-codes", meH.HandleRegenerateRecoveryCodes)
+			meGroup.GET("/passkeys", meH.HandleListPasskeys)
+			meGroup.POST("/passkeys/register/begin", meH.HandleBeginPasskeyRegistration)
+			meGroup.POST("/passkeys/register/finish", meH.HandleFinishPasskeyRegistration)
+			meGroup.DELETE("/passkeys/:id", meH.HandleDeletePasskey)
+			meGroup.GET("/identities", meH.HandleListIdentities)
+			meGroup.POST("/identities/:provider", meH.HandleLinkIdentity)
+			meGroup.DELETE("/identities/:provider", meH.HandleUnlinkIdentity)
+		}
+
+		// d. Admin: staff roles only, every call is audited
+		adminGroup := apiGroup.Group("/admin", middlewares.AccessToken(authSvc), rl.Limit(limitAdmin), idem.Handle())
+		{
+			read := middlewares.RequireScope(models.ScopeUsersRead)
+			write := middlewares.RequireScope(models.ScopeUsersWrite)
+			adminGroup.GET("/users", read, adminH.HandleSearchUsers)
+			adminGroup.GET("/users/:id", read, adminH.HandleGetUser)
+			adminGroup.GET("/users/:id/sessions", read, adminH.HandleListSessions)
+			adminGroup.GET("/users/:id/events", middlewares.RequireScope(models.ScopeEventsRead), adminH.HandleAuthEvents)
+			adminGroup.POST("/users/:id/lock", write, adminH.HandleLockUser)
+			adminGroup.POST("/users/:id/unlock", write, adminH.HandleUnlockUser)
+			adminGroup.GET("/audit", middlewares.RequireScope(models.ScopeAuditRead), adminH.HandleListAudit)
+			reputation := middlewares.RequireScope(models.ScopeReputationWrite)
+			adminGroup.GET("/reputation", reputation, adminH.HandleListReputation)
+			adminGroup.POST("/reputation", reputation, adminH.HandleAddReputation)
+			adminGroup.DELETE("/reputation/:id", reputation, adminH.HandleRemoveReputation)
 		}
 	}
 
@@ -52,6 +181,24 @@ func SetupRouter(d Deps) *gin.Engine {
 	return r
 }
 
+// Rate limit policies. Public auth routes count per IP, tighter where a request sends email
+// or checks a secret; routes behind a token count per user
+var (
+	limitAuthIP        = limitRule("auth_ip", ratelimit.SlidingWindow, 60, time.Minute, 0, middlewares.ByIP)
+	limitSendEmail     = limitRule("send_email_ip", ratelimit.SlidingWindow, 10, 10*time.Minute, 0, middlewares.ByIP)
+	limitSendEmailUser = limitRule("send_email_user", ratelimit.SlidingWindow, 5, 10*time.Minute, 0, middlewares.ByUser)
+	limitGuess         = limitRule("guess_ip_route", ratelimit.TokenBucket, 10, time.Minute, 20, middlewares.KeyBy(middlewares.ByIP, middlewares.ByRoute))
+	limitUser          = limitRule("user", ratelimit.TokenBucket, 120, time.Minute, 60, middlewares.ByUser)
+	limitAdmin         = limitRule("admin_user", ratelimit.TokenBucket, 300, time.Minute, 100, middlewares.ByUser)
+)
+
+func limitRule(name string, alg ratelimit.Algorithm, limit int, window time.Duration, burst int, key middlewares.RateLimitKey) middlewares.RateLimitRule {
+	return middlewares.RateLimitRule{
+		Policy: ratelimit.Policy{Name: name, Algorithm: alg, Limit: limit, Window: window, Burst: burst},
+		Key:    key,
+	}
+}
+
 func checkHealth(c *gin.Context) {
 	c.String(200, "pong")
 }
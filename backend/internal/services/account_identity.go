@@ -0,0 +1,122 @@
+package services
+
+import (
+	"backend/internal/models"
+	"backend/internal/pkg/i18n"
+	"backend/internal/repos"
+	"context"
+	"errors"
+	"slices"
+)
+
+func (s *accountService) ListIdentities(ctx context.Context, p Principal) ([]IdentityInfo, error) {
+	ids, err := s.federation.repo.ListIdentities(ctx, p.UserID)
+	if err != nil {
+		return nil, s.mapErr(ctx, "AccountSvc.ListIdentities", err)
+	}
+	out := make([]IdentityInfo, 0, len(ids))
+	for _, i := range ids {
+		out = append(out, toIdentityInfo(i))
+	}
+	return out, nil
+}
+
+// LinkIdentity Let a provider account sign in as the caller. Linking hands out a way in,
+// so the caller re-authenticates first: a token alone would let its thief stay signed in
+func (s *accountService) LinkIdentity(ctx context.Context, p Principal, provider, idToken, nonce, password string) (IdentityInfo, error) {
+
+	// 1. Re-authenticate
+	if err := s.reauth(ctx, p, password, "identity_link:bad_password"); err != nil {
+		return IdentityInfo{}, err
+	}
+
+	// 2. Verify token
+	id, err := s.federation.verify(ctx, "AccountSvc.LinkIdentity", provider, idToken, nonce)
+	if err != nil {
+		return IdentityInfo{}, err
+	}
+
+	// 3. Already linked, here or to someone else
+	existing, err := s.federation.repo.FindIdentity(ctx, id.Issuer, id.Subject)
+	switch {
+	case err == nil && existing.UserID == p.UserID:
+		return toIdentityInfo(*existing), nil
+	case err == nil:
+		return IdentityInfo{}, ErrConflict
+	case !errors.Is(err, repos.ErrNotFound):
+		return IdentityInfo{}, s.mapErr(ctx, "AccountSvc.LinkIdentity.FindIdentity", err)
+	}
+
+	// 4. Link, one identity per provider
+	row := identityRow(p.UserID, id)
+	if err := s.federation.repo.InsertIdentity(ctx, row); err != nil {
+		if errors.Is(err, repos.ErrIdentityExists) {
+			return IdentityInfo{}, ErrConflict
+		}
+		return IdentityInfo{}, s.mapErr(ctx, "AccountSvc.LinkIdentity.InsertIdentity", err)
+	}
+	s.events.Record(ctx, Event(models.EventIdentityLinked, true, p.UserID, p.Email, p.DeviceID, id.Provider))
+	s.notify(ctx, identityMail(i18n.From(ctx), p.Email, id.Provider, true))
+
+	return IdentityInfo{Provider: row.Provider, Email: row.Email, IsPrivateEmail: row.IsPrivateEmail}, nil
+}
+
+// UnlinkIdentity Remove a provider, unless it is the caller's only way to sign in
+func (s *accountService) UnlinkIdentity(ctx context.Context, p Principal, provider, password string) error {
+
+	// 1. Re-authenticate
+	if err := s.reauth(ctx, p, password, "identity_unlink:bad_password"); err != nil {
+		return err
+	}
+
+	// 2. Linked, and not the only way left to sign in
+	m, err := s.signInMethods(ctx, p.UserID)
+	if err != nil {
+		return s.mapErr(ctx, "AccountSvc.UnlinkIdentity.SignInMethods", err)
+	}
+	if !slices.ContainsFunc(m.identities, func(i models.UserIdentity) bool { return i.Provider == provider }) {
+		return ErrNotFound
+	}
+	if m.count() <= 1 {
+		return ErrConflict
+	}
+
+	// 3. Unlink
+	if err := s.federation.repo.DeleteIdentity(ctx, p.UserID, provider); err != nil {
+		return s.mapErr(ctx, "AccountSvc.UnlinkIdentity.DeleteIdentity", err)
+	}
+	s.events.Record(ctx, Event(models.EventIdentityUnlinked, true, p.UserID, p.Email, p.DeviceID, provider))
+	s.notify(ctx, identityMail(i18n.From(ctx), p.Email, provider, false))
+	return nil
+}
+
+// signIn The ways a user has to sign in; removing the last one would lock the account
+type signIn struct {
+	password   bool
+	passkeys   []models.PasskeyCredential
+	identities []models.UserIdentity
+}
+
+func (m signIn) count() int {
+	n := len(m.passkeys) + len(m.identities)
+	if m.password {
+		n++
+	}
+	return n
+}
+
+func (s *accountService) signInMethods(ctx context.Context, userID uint64) (signIn, error) {
+	user, err := s.authRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return signIn{}, err
+	}
+	creds, err := s.passkeys.ListCredentials(ctx, userID)
+	if err != nil {
+		return signIn{}, err
+	}
+	ids, err := s.federation.repo.ListIdentities(ctx, userID)
+	if err != nil {
+		return signIn{}, err
+	}
+	return signIn{password: user.PwdHash != "", passkeys: creds, identities: ids}, nil
+}
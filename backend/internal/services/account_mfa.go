@@ -0,0 +1,182 @@
+package services
+
+import (
+	"backend/internal/models"
+	"backend/internal/pkg/i18n"
+	"backend/internal/pkg/logx"
+	"backend/internal/pkg/totp"
+	"backend/internal/repos"
+	"context"
+	"errors"
+	"time"
+)
+
+type MFAStatus struct {
+	TOTPEnabled       bool       `json:"totp_enabled"`
+	EnabledAt         *time.Time `json:"enabled_at"`
+	RecoveryCodesLeft int        `json:"recovery_codes_left"`
+}
+
+// TOTPSetup Secret for manual entry and the otpauth URI to render as a QR code
+type TOTPSetup struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+func (s *accountService) MFAStatus(ctx context.Context, p Principal) (MFAStatus, error) {
+	m, err := s.mfa.repo.GetTOTP(ctx, p.UserID)
+	if err != nil {
+		if errors.Is(err, repos.ErrNotFound) {
+			return MFAStatus{}, nil
+		}
+		return MFAStatus{}, s.mapErr(ctx, "AccountSvc.MFAStatus.GetTOTP", err)
+	}
+	if m.EnabledAt == nil {
+		return MFAStatus{}, nil
+	}
+	left, err := s.mfa.repo.CountRecoveryCodes(ctx, p.UserID)
+	if err != nil {
+		return MFAStatus{}, s.mapErr(ctx, "AccountSvc.MFAStatus.CountRecoveryCodes", err)
+	}
+	return MFAStatus{TOTPEnabled: true, EnabledAt: m.EnabledAt, RecoveryCodesLeft: left}, nil
+}
+
+// SetupTOTP Start enrollment with a new secret; it only takes effect once ConfirmTOTP
+// sees a code from it. Calling again before confirming replaces the secret
+func (s *accountService) SetupTOTP(ctx context.Context, p Principal, password string) (TOTPSetup, error) {
+
+	// 1. Re-authenticate
+	if err := s.reauth(ctx, p, password, "mfa_setup:bad_password"); err != nil {
+		return TOTPSetup{}, err
+	}
+
+	// 2. Already enabled?
+	on, err := s.mfa.enabled(ctx, p.UserID)
+	if err != nil {
+		return TOTPSetup{}, s.mapErr(ctx, "AccountSvc.SetupTOTP.Enabled", err)
+	}
+	if on {
+		return TOTPSetup{}, ErrConflict
+	}
+
+	// 3. Generate and store a pending secret
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		logx.LogError(ctx, "AccountSvc.SetupTOTP.GenerateSecret", err)
+		return TOTPSetup{}, ErrInternalServer
+	}
+	sealed, err := s.mfa.sealSecret(p.UserID, secret)
+	if err != nil {
+		logx.LogError(ctx, "AccountSvc.SetupTOTP.Seal", err)
+		return TOTPSetup{}, ErrInternalServer
+	}
+	if err := s.mfa.repo.StorePendingTOTP(ctx, p.UserID, sealed); err != nil {
+		return TOTPSetup{}, s.mapErr(ctx, "AccountSvc.SetupTOTP.StorePendingTOTP", err)
+	}
+
+	return TOTPSetup{Secret: secret, OTPAuthURI: totp.URI(s.cfg.MFA.Issuer, p.Email, secret)}, nil
+}
+
+// ConfirmTOTP Enable the pending secret with its first code; returns the recovery codes,
+// which are shown this once
+func (s *accountService) ConfirmTOTP(ctx context.Context, p Principal, code string) ([]string, error) {
+
+	// 1. Check input
+	if len(code) != totp.Digits {
+		return nil, ErrBadRequest
+	}
+
+	// 2. Load pending secret
+	m, err := s.mfa.repo.GetTOTP(ctx, p.UserID)
+	if err != nil {
+		return nil, s.mapErr(ctx, "AccountSvc.ConfirmTOTP.GetTOTP", err)
+	}
+	if m.EnabledAt != nil {
+		return nil, ErrConflict
+	}
+
+	// 3. Check code
+	if err := s.mfa.checkTOTP(ctx, m, code); err != nil {
+		if errors.Is(err, ErrUnauthorized) {
+			s.events.Record(ctx, Event(models.EventMFAFailed, false, p.UserID, p.Email, p.DeviceID, "confirm"))
+			return nil, ErrUnauthorized
+		}
+		return nil, s.mapErr(ctx, "AccountSvc.ConfirmTOTP.Check", err)
+	}
+
+	// 4. Enable with fresh recovery codes
+	codes, hashes, err := s.mfa.newRecoveryCodes()
+	if err != nil {
+		logx.LogError(ctx, "AccountSvc.ConfirmTOTP.NewRecoveryCodes", err)
+		return nil, ErrInternalServer
+	}
+	if err := s.mfa.repo.EnableTOTP(ctx, p.UserID, hashes); err != nil {
+		if errors.Is(err, repos.ErrNotFound) {
+			return nil, ErrConflict
+		}
+		return nil, s.mapErr(ctx, "AccountSvc.ConfirmTOTP.EnableTOTP", err)
+	}
+	s.events.Record(ctx, Event(models.EventMFAEnabled, true, p.UserID, p.Email, p.DeviceID, mfaMethodTOTP))
+	s.notify(ctx, mfaMail(i18n.From(ctx), p.Email, true))
+	return codes, nil
+}
+
+// DisableTOTP Turn the second factor off; needs re-authentication and a current code
+func (s *accountService) DisableTOTP(ctx context.Context, p Principal, password, code string) error {
+
+	// 1. Re-authenticate with both factors
+	if err := s.reauth(ctx, p, password, "mfa_disable:bad_password"); err != nil {
+		return err
+	}
+	if err := s.checkSecondFactor(ctx, p, code, "disable"); err != nil {
+		return err
+	}
+
+	// 2. Delete secret and recovery codes
+	if err := s.mfa.repo.DeleteTOTP(ctx, p.UserID); err != nil {
+		return s.mapErr(ctx, "AccountSvc.DisableTOTP.DeleteTOTP", err)
+	}
+	s.events.Record(ctx, Event(models.EventMFADisabled, true, p.UserID, p.Email, p.DeviceID, mfaMethodTOTP))
+	s.notify(ctx, mfaMail(i18n.From(ctx), p.Email, false))
+	return nil
+}
+
+// RegenerateRecoveryCodes Replace every recovery code; needs re-authentication and a current code
+func (s *accountService) RegenerateRecoveryCodes(ctx context.Context, p Principal, password, code string) ([]string, error) {
+
+	// 1. Re-authenticate with both factors
+	if err := s.reauth(ctx, p, password, "mfa_recovery:bad_password"); err != nil {
+		return nil, err
+	}
+	if err := s.checkSecondFactor(ctx, p, code, "regenerate"); err != nil {
+		return nil, err
+	}
+
+	// 2. Replace codes
+	codes, hashes, err := s.mfa.newRecoveryCodes()
+	if err != nil {
+		logx.LogError(ctx, "AccountSvc.RegenerateRecoveryCodes.NewRecoveryCodes", err)
+		return nil, ErrInternalServer
+	}
+	if err := s.mfa.repo.ReplaceRecoveryCodes(ctx, p.UserID, hashes); err != nil {
+		return nil, s.mapErr(ctx, "AccountSvc.RegenerateRecoveryCodes.ReplaceRecoveryCodes", err)
+	}
+	s.events.Record(ctx, Event(models.EventMFARecoveryReset, true, p.UserID, p.Email, p.DeviceID, ""))
+	return codes, nil
+}
+
+// checkSecondFactor TOTP or recovery code of an enabled factor, recording failures
+func (s *accountService) checkSecondFactor(ctx context.Context, p Principal, code, detail string) error {
+	method, err := s.mfa.check(ctx, p.UserID, code)
+	if err != nil {
+		if errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrTooManyRequest) {
+			s.events.Record(ctx, Event(models.EventMFAFailed, false, p.UserID, p.Email, p.DeviceID, detail))
+			return err
+		}
+		return s.mapErr(ctx, "AccountSvc.CheckSecondFactor", err)
+	}
+	if method == mfaMethodRecovery {
+		s.events.Record(ctx, Event(models.EventMFARecoveryUsed, true, p.UserID, p.Email, p.DeviceID, detail))
+	}
+	return nil
+}
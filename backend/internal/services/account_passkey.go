@@ -0,0 +1,182 @@
+package services
+
+import (
+	"backend/internal/models"
+	"backend/internal/pkg/i18n"
+	"backend/internal/pkg/logx"
+	"backend/internal/pkg/webauthn"
+	"backend/internal/repos"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// BeginPasskeyRegistration Options for navigator.credentials.create(), once the caller has
+// re-authenticated
+func (s *accountService) BeginPasskeyRegistration(ctx context.Context, p Principal, password string) (PasskeyOptions, error) {
+
+	// 1. Re-authenticate
+	if err := s.reauth(ctx, p, password, "passkey_register:bad_password"); err != nil {
+		return PasskeyOptions{}, err
+	}
+
+	// 2. Existing credentials, excluded so an authenticator is not registered twice
+	creds, err := s.passkeys.ListCredentials(ctx, p.UserID)
+	if err != nil {
+		return PasskeyOptions{}, s.mapErr(ctx, "AccountSvc.BeginPasskeyRegistration.ListCredentials", err)
+	}
+	cfg := s.cfg.WebAuthn
+	if len(creds) >= cfg.MaxCredentials {
+		return PasskeyOptions{}, ErrConflict
+	}
+
+	// 3. Store challenge
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		logx.LogError(ctx, "AccountSvc.BeginPasskeyRegistration.NewChallenge", err)
+		return PasskeyOptions{}, ErrInternalServer
+	}
+	id := newChallengeID()
+	ch := models.WebAuthnChallenge{Challenge: challenge, UserID: p.UserID, Type: ceremonyRegister}
+	if err := s.passkeys.StoreChallenge(ctx, id, ch, cfg.ChallengeTTL); err != nil {
+		return PasskeyOptions{}, s.mapErr(ctx, "AccountSvc.BeginPasskeyRegistration.StoreChallenge", err)
+	}
+
+	// 4. Build options
+	var opts creationOptions
+	opts.RP.ID, opts.RP.Name = cfg.RPID, cfg.RPName
+	opts.User.ID = webauthn.Encode(passkeyUserHandle(p.UserID))
+	opts.User.Name, opts.User.DisplayName = p.Email, p.Email
+	opts.Challenge = webauthn.Encode(challenge)
+	for _, alg := range webauthn.SupportedAlgs {
+		opts.PubKeyCredParams = append(opts.PubKeyCredParams, credentialParam{Type: "public-key", Alg: alg})
+	}
+	opts.Timeout = cfg.ChallengeTTL.Milliseconds()
+	opts.Attestation = "none"
+	opts.ExcludeCredentials = []credentialDescriptor{}
+	for _, c := range creds {
+		opts.ExcludeCredentials = append(opts.ExcludeCredentials, credentialDescriptor{
+			Type: "public-key", ID: webauthn.Encode(c.CredentialID), Transports: splitTransports(c.Transports),
+		})
+	}
+	opts.AuthenticatorSelection.ResidentKey = "required"
+	opts.AuthenticatorSelection.UserVerification = cfg.UserVerification
+
+	return PasskeyOptions{ChallengeID: id, PublicKey: opts}, nil
+}
+
+// FinishPasskeyRegistration Verify the authenticator's response and store the credential
+func (s *accountService) FinishPasskeyRegistration(ctx context.Context, p Principal, challengeID, name string, cred PasskeyCredential) (PasskeyInfo, error) {
+
+	// 1. Check input
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "Passkey"
+	}
+	if utf8.RuneCountInString(name) > 64 || !isUUID(challengeID) {
+		return PasskeyInfo{}, ErrBadRequest
+	}
+	clientData, err1 := webauthn.Decode(cred.ClientDataJSON)
+	attObj, err2 := webauthn.Decode(cred.AttestationObject)
+	if err1 != nil || err2 != nil {
+		return PasskeyInfo{}, ErrBadRequest
+	}
+
+	// 2. Take challenge, it must be this user's registration
+	ch, err := s.passkeys.TakeChallenge(ctx, challengeID)
+	if err != nil {
+		if errors.Is(err, repos.ErrNotFound) {
+			return PasskeyInfo{}, ErrUnauthorized
+		}
+		return PasskeyInfo{}, s.mapErr(ctx, "AccountSvc.FinishPasskeyRegistration.TakeChallenge", err)
+	}
+	if ch.Type != ceremonyRegister || ch.UserID != p.UserID {
+		return PasskeyInfo{}, ErrUnauthorized
+	}
+
+	// 3. Verify
+	c, err := s.rp.VerifyRegistration(ch.Challenge, clientData, attObj)
+	if err != nil {
+		logx.LogWarn(ctx, "AccountSvc.FinishPasskeyRegistration.Verify", err.Error())
+		return PasskeyInfo{}, ErrUnauthorized
+	}
+
+	// 4. Store
+	row := models.PasskeyCredential{
+		UserID:         p.UserID,
+		CredentialID:   c.ID,
+		PublicKey:      c.PublicKey,
+		Alg:            c.Alg,
+		AAGUID:         c.AAGUID,
+		Name:           name,
+		Transports:     validTransports(cred.Transports),
+		SignCount:      c.SignCount,
+		BackupEligible: c.BackupEligible,
+		BackedUp:       c.BackedUp,
+	}
+	id, err := s.passkeys.InsertCredential(ctx, row)
+	if err != nil {
+		if errors.Is(err, repos.ErrCredentialExists) {
+			return PasskeyInfo{}, ErrConflict
+		}
+		return PasskeyInfo{}, s.mapErr(ctx, "AccountSvc.FinishPasskeyRegistration.InsertCredential", err)
+	}
+	s.events.Record(ctx, Event(models.EventPasskeyAdded, true, p.UserID, p.Email, p.DeviceID, name))
+	s.notify(ctx, passkeyMail(i18n.From(ctx), p.Email, name))
+
+	return PasskeyInfo{ID: id, Name: name, BackedUp: c.BackedUp, Transports: splitTransports(row.Transports)}, nil
+}
+
+func (s *accountService) ListPasskeys(ctx context.Context, p Principal) ([]PasskeyInfo, error) {
+	creds, err := s.passkeys.ListCredentials(ctx, p.UserID)
+	if err != nil {
+		return nil, s.mapErr(ctx, "AccountSvc.ListPasskeys", err)
+	}
+	out := make([]PasskeyInfo, 0, len(creds))
+	for _, c := range creds {
+		out = append(out, toPasskeyInfo(c))
+	}
+	return out, nil
+}
+
+func toPasskeyInfo(c models.PasskeyCredential) PasskeyInfo {
+	return PasskeyInfo{
+		ID:         c.ID,
+		Name:       c.Name,
+		BackedUp:   c.BackedUp,
+		Transports: splitTransports(c.Transports),
+		LastUsedAt: c.LastUsedAt,
+		CreatedAt:  c.CreatedAt,
+	}
+}
+
+// DeletePasskey Remove a passkey after re-authentication, unless it is the caller's only way
+// to sign in
+func (s *accountService) DeletePasskey(ctx context.Context, p Principal, id uint64, password string) error {
+
+	// 1. Re-authenticate
+	if err := s.reauth(ctx, p, password, "passkey_remove:bad_password"); err != nil {
+		return err
+	}
+
+	// 2. Registered, and not the only way left to sign in
+	m, err := s.signInMethods(ctx, p.UserID)
+	if err != nil {
+		return s.mapErr(ctx, "AccountSvc.DeletePasskey.SignInMethods", err)
+	}
+	if !slices.ContainsFunc(m.passkeys, func(c models.PasskeyCredential) bool { return c.ID == id }) {
+		return ErrNotFound
+	}
+	if m.count() <= 1 {
+		return ErrConflict
+	}
+
+	// 3. Delete
+	if err := s.passkeys.DeleteCredential(ctx, p.UserID, id); err != nil {
+		return s.mapErr(ctx, "AccountSvc.DeletePasskey", err)
+	}
+	s.events.Record(ctx, Event(models.EventPasskeyRemoved, true, p.UserID, p.Email, p.DeviceID, ""))
+	return nil
+}
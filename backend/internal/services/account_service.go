@@ -0,0 +1,467 @@
+package services
+
+import (
+	"backend/internal/config"
+	"backend/internal/models"
+	"backend/internal/pkg/ctx_util"
+	"backend/internal/pkg/i18n"
+	"backend/internal/pkg/logx"
+	"backend/internal/pkg/mailx"
+	"backend/internal/pkg/oidc"
+	"backend/internal/pkg/passwordhash"
+	"backend/internal/pkg/secretbox"
+	"backend/internal/pkg/webauthn"
+	"backend/internal/repos"
+	"backend/internal/settings"
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AccountService Self-service endpoints of the signed-in user
+type AccountService interface {
+	SecurityEvents(ctx context.Context, p Principal, beforeID uint64, limit int) ([]AuthEventInfo, error)
+	DeleteAccount(ctx context.Context, p Principal, password string) (DeletionInfo, error)
+	Export(ctx context.Context, p Principal) (AccountExport, error)
+	RequestEmailChange(ctx context.Context, p Principal, newEmail, password string) (string, error)
+	ConfirmEmailChange(ctx context.Context, p Principal, codeID, code string) (string, error)
+	RevertEmailChange(ctx context.Context, token string) error
+	SetLocale(ctx context.Context, p Principal, locale string) (string, error)
+	MFAStatus(ctx context.Context, p Principal) (MFAStatus, error)
+	SetupTOTP(ctx context.Context, p Principal, password string) (TOTPSetup, error)
+	ConfirmTOTP(ctx context.Context, p Principal, code string) ([]string, error)
+	DisableTOTP(ctx context.Context, p Principal, password, code string) error
+	RegenerateRecoveryCodes(ctx context.Context, p Principal, password, code string) ([]string, error)
+	BeginPasskeyRegistration(ctx context.Context, p Principal, password string) (PasskeyOptions, error)
+	FinishPasskeyRegistration(ctx context.Context, p Principal, challengeID, name string, cred PasskeyCredential) (PasskeyInfo, error)
+	ListPasskeys(ctx context.Context, p Principal) ([]PasskeyInfo, error)
+	DeletePasskey(ctx context.Context, p Principal, id uint64, password string) error
+	ListIdentities(ctx context.Context, p Principal) ([]IdentityInfo, error)
+	LinkIdentity(ctx context.Context, p Principal, provider, idToken, nonce, password string) (IdentityInfo, error)
+	UnlinkIdentity(ctx context.Context, p Principal, provider, password string) error
+}
+
+// freshAuthWindow How recent an access token must be to stand in for re-authentication on an
+// account without a password
+const freshAuthWindow = 5 * time.Minute
+
+// maxExportEvents Upper bound of auth events in one export, retention keeps the table far smaller
+const maxExportEvents = 10000
+
+type DeletionInfo struct {
+	DeletedAt     time.Time `json:"deleted_at"`
+	RestoreBefore time.Time `json:"restore_before"`
+}
+
+// AccountExport Everything stored about a user, minus secrets (password hash, refresh token
+// hashes, TOTP secret, recovery code hashes, passkey public keys)
+type AccountExport struct {
+	ExportedAt     time.Time         `json:"exported_at"`
+	Profile        UserInfo          `json:"profile"`
+	Devices        []DeviceInfo      `json:"devices"`
+	Sessions       []SessionInfo     `json:"sessions"`
+	Identities     []IdentityInfo    `json:"identities"`
+	Passkeys       []ExportedPasskey `json:"passkeys"`
+	MFA            MFAStatus         `json:"mfa"`
+	SecurityEvents []AuthEventInfo   `json:"security_events"`
+	AdminActions   []AdminAction     `json:"admin_actions"`
+}
+
+// ExportedPasskey A passkey as listed, plus the base64url credential id its authenticator knows it by
+type ExportedPasskey struct {
+	PasskeyInfo
+	CredentialID string `json:"credential_id"`
+}
+
+type DeviceInfo struct {
+	DeviceID   string     `json:"device_id"`
+	PushToken  *string    `json:"push_token"`
+	LastSeenAt *time.Time `json:"last_seen_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// AdminAction A staff action on the account, without the staff member's identity
+type AdminAction struct {
+	Action    string    `json:"action"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SecurityEvents The caller's own auth events, newest first; pass the last id seen as beforeID for the next page
+func (s *accountService) SecurityEvents(ctx context.Context, p Principal, beforeID uint64, limit int) ([]AuthEventInfo, error) {
+	if limit <= 0 || limit > maxEventLimit {
+		limit = defaultPageSize
+	}
+	events, err := s.eventRepo.ListUserAuthEvents(ctx, p.UserID, p.Email, beforeID, limit)
+	if err != nil {
+		return nil, s.mapErr(ctx, "AccountSvc.SecurityEvents", err)
+	}
+	out := make([]AuthEventInfo, 0, len(events))
+	for _, e := range events {
+		out = append(out, toAuthEventInfo(e))
+	}
+	return out, nil
+}
+
+// DeleteAccount Re-authenticate, then soft delete; the account can be restored through
+// /auth/restore-account until the grace period ends
+func (s *accountService) DeleteAccount(ctx context.Context, p Principal, password string) (DeletionInfo, error) {
+
+	// 1. Re-authenticate
+	if err := s.reauth(ctx, p, password, "delete_account:bad_password"); err != nil {
+		return DeletionInfo{}, err
+	}
+
+	// 2. Soft delete, logs out everywhere
+	deletedAt, err := s.repo.MarkDeleted(ctx, p.UserID)
+	if err != nil {
+		return DeletionInfo{}, s.mapErr(ctx, "AccountSvc.DeleteAccount.MarkDeleted", err)
+	}
+	s.events.Record(ctx, Event(models.EventAccountDeleted, true, p.UserID, p.Email, p.DeviceID, ""))
+	s.events.Record(ctx, Event(models.EventSessionRevoked, true, p.UserID, p.Email, "", "delete_account"))
+
+	return DeletionInfo{
+		DeletedAt:     deletedAt,
+		RestoreBefore: deletedAt.Add(s.cfg.Account.DeletionGrace),
+	}, nil
+}
+
+// Export Collect everything stored about the caller
+func (s *accountService) Export(ctx context.Context, p Principal) (AccountExport, error) {
+
+	// 1. Profile
+	user, err := s.authRepo.GetUserByID(ctx, p.UserID)
+	if err != nil {
+		return AccountExport{}, s.mapErr(ctx, "AccountSvc.Export.GetUserByID", err)
+	}
+	out := AccountExport{
+		ExportedAt:     time.Now().UTC(),
+		Profile:        toUserInfo(user),
+		Devices:        []DeviceInfo{},
+		Sessions:       []SessionInfo{},
+		Identities:     []IdentityInfo{},
+		Passkeys:       []ExportedPasskey{},
+		SecurityEvents: []AuthEventInfo{},
+		AdminActions:   []AdminAction{},
+	}
+
+	// 2. Devices and sessions
+	devices, err := s.repo.ListDevices(ctx, user.UserID)
+	if err != nil {
+		return AccountExport{}, s.mapErr(ctx, "AccountSvc.Export.ListDevices", err)
+	}
+	for _, d := range devices {
+		info := DeviceInfo{PushToken: d.PushToken, LastSeenAt: d.LastSeenAt, RevokedAt: d.RevokedAt, CreatedAt: d.CreatedAt}
+		if u, err := uuid.FromBytes(d.DeviceID); err == nil {
+			info.DeviceID = u.String()
+		}
+		out.Devices = append(out.Devices, info)
+	}
+	sessions, err := s.repo.ListSessions(ctx, user.UserID)
+	if err != nil {
+		return AccountExport{}, s.mapErr(ctx, "AccountSvc.Export.ListSessions", err)
+	}
+	for _, ss := range sessions {
+		out.Sessions = append(out.Sessions, toSessionInfo(ss, out.ExportedAt))
+	}
+
+	// 3. Linked identities
+	ids, err := s.federation.repo.ListIdentities(ctx, user.UserID)
+	if err != nil {
+		return AccountExport{}, s.mapErr(ctx, "AccountSvc.Export.ListIdentities", err)
+	}
+	for _, i := range ids {
+		out.Identities = append(out.Identities, toIdentityInfo(i))
+	}
+
+	// 4. Passkeys and second factor
+	creds, err := s.passkeys.ListCredentials(ctx, user.UserID)
+	if err != nil {
+		return AccountExport{}, s.mapErr(ctx, "AccountSvc.Export.ListCredentials", err)
+	}
+	for _, c := range creds {
+		out.Passkeys = append(out.Passkeys, ExportedPasskey{
+			PasskeyInfo:  toPasskeyInfo(c),
+			CredentialID: base64.RawURLEncoding.EncodeToString(c.CredentialID),
+		})
+	}
+	if out.MFA, err = s.MFAStatus(ctx, p); err != nil {
+		return AccountExport{}, err
+	}
+
+	// 5. Auth events and staff actions
+	events, err := s.eventRepo.ListUserAuthEvents(ctx, user.UserID, user.Email, 0, maxExportEvents)
+	if err != nil {
+		return AccountExport{}, s.mapErr(ctx, "AccountSvc.Export.ListUserAuthEvents", err)
+	}
+	for _, e := range events {
+		out.SecurityEvents = append(out.SecurityEvents, toAuthEventInfo(e))
+	}
+	actions, err := s.repo.ListAdminActions(ctx, user.UserID)
+	if err != nil {
+		return AccountExport{}, s.mapErr(ctx, "AccountSvc.Export.ListAdminActions", err)
+	}
+	for _, a := range actions {
+		out.AdminActions = append(out.AdminActions, AdminAction{Action: a.Action, Reason: a.Reason, CreatedAt: a.CreatedAt})
+	}
+	return out, nil
+}
+
+// RequestEmailChange Send a change_email code to the new address; the caller proves
+// it is them with the password or an access token issued within freshAuthWindow
+func (s *accountService) RequestEmailChange(ctx context.Context, p Principal, newEmail, password string) (string, error) {
+
+	// 0. Create sub context
+	cctx, cancel := context.WithTimeout(ctx, s.settings.Current().Timeouts.RequestCode)
+	defer cancel()
+
+	// 1. Check input
+	newEmail = strings.ToLower(strings.TrimSpace(newEmail))
+	if !isValidEmail(newEmail, s.reputation.Lists(), emailNew) || newEmail == p.Email {
+		return "", ErrBadRequest
+	}
+
+	// 2. Re-authenticate
+	if err := s.reauth(ctx, p, password, "change_email:bad_password"); err != nil {
+		return "", err
+	}
+
+	// 3. Early conflict check, UpdateEmail re-checks under the unique key
+	exists, err := s.authRepo.CheckEmailExists(cctx, newEmail)
+	if err != nil {
+		return "", s.mapErr(cctx, "AccountSvc.RequestEmailChange.CheckEmailExists", err)
+	}
+	if exists {
+		return "", ErrConflict
+	}
+
+	// 4. Store code under the new address and bind it to this user
+	code, err := generateCode()
+	if err != nil {
+		logx.LogError(ctx, "AccountSvc.RequestEmailChange.GenerateCode", err)
+		return "", ErrInternalServer
+	}
+	codeID := uuid.NewString()
+	rt := s.settings.Current().RedisTTL
+	throttled, err := s.authRepo.StoreOTPAndThrottle(cctx, newEmail, sceneChangeEmail, codeID, code, rt.OTP, rt.OTPThrottle)
+	if err != nil {
+		return "", s.mapErr(cctx, "AccountSvc.RequestEmailChange.StoreOTPAndThrottle", err)
+	}
+	if throttled {
+		s.events.Record(ctx, Event(models.EventThrottled, false, p.UserID, newEmail, p.DeviceID, "request_code:"+sceneChangeEmail))
+		return "", ErrTooManyRequest
+	}
+	if err := s.repo.StoreEmailChange(cctx, p.UserID, codeID, newEmail, time.Duration(rt.OTP)*time.Second); err != nil {
+		return "", s.mapErr(cctx, "AccountSvc.RequestEmailChange.StoreEmailChange", err)
+	}
+	s.events.Record(ctx, Event(models.EventCodeRequested, true, p.UserID, newEmail, p.DeviceID, sceneChangeEmail))
+
+	// 5. Send code
+	if err := s.mailer.Send(ctx, codeMail(i18n.From(ctx), newEmail, sceneChangeEmail, code, rt.OTP)); err != nil {
+		logx.LogError(ctx, "AccountSvc.RequestEmailChange.Send", err)
+		return "", ErrInternalServer
+	}
+	return codeID, nil
+}
+
+// ConfirmEmailChange Check the code sent to the new address, switch to it and mail
+// the old address a link that undoes the change; returns the new address
+func (s *accountService) ConfirmEmailChange(ctx context.Context, p Principal, codeID, code string) (string, error) {
+
+	// 0. Create sub context
+	cctx, cancel := context.WithTimeout(ctx, s.settings.Current().Timeouts.VerifyCode)
+	defer cancel()
+
+	// 1. Check input
+	if len(code) != 6 || !isUUID(codeID) {
+		return "", ErrBadRequest
+	}
+
+	// 2. Find the address the code was sent to
+	newEmail, err := s.repo.GetEmailChange(cctx, p.UserID, codeID)
+	if err != nil {
+		if errors.Is(err, repos.ErrNotFound) {
+			return "", ErrUnauthorized
+		}
+		return "", s.mapErr(cctx, "AccountSvc.ConfirmEmailChange.GetEmailChange", err)
+	}
+
+	// 3. Check throttle -> Match code -> Consume code
+	rt := s.settings.Current().RedisTTL
+	if err := s.authRepo.ThrottleMatchAndConsumeCode(cctx, newEmail, sceneChangeEmail, codeID, code, uuid.NewString(),
+		rt.VerifyWindowLimit, rt.VerifyWindow, 1); err != nil {
+		switch {
+		case errors.Is(err, repos.ErrOTPInvalid) || errors.Is(err, repos.ErrOTPExpired):
+			s.events.Record(ctx, Event(models.EventCodeVerifyFailed, false, p.UserID, newEmail, p.DeviceID, sceneChangeEmail))
+			return "", ErrUnauthorized
+		case errors.Is(err, repos.ErrRateLimited):
+			s.events.Record(ctx, Event(models.EventThrottled, false, p.UserID, newEmail, p.DeviceID, "verify_code:"+sceneChangeEmail))
+			return "", ErrTooManyRequest
+		default:
+			return "", s.mapErr(cctx, "AccountSvc.ConfirmEmailChange.ThrottleMatchAndConsumeCode", err)
+		}
+	}
+	s.repo.DeleteEmailChange(cctx, p.UserID, codeID)
+
+	// 4. Switch address; losing the race to a signup or another change is a conflict
+	if err := s.repo.UpdateEmail(cctx, p.UserID, p.Email, newEmail); err != nil {
+		switch {
+		case errors.Is(err, repos.ErrEmailAlreadyExists), errors.Is(err, repos.ErrNotFound):
+			return "", ErrConflict
+		default:
+			return "", s.mapErr(cctx, "AccountSvc.ConfirmEmailChange.UpdateEmail", err)
+		}
+	}
+	s.events.Record(ctx, Event(models.EventEmailChanged, true, p.UserID, p.Email, p.DeviceID, "to:"+newEmail))
+
+	// 5. Mail the old address a revert link; the change stands if this fails
+	token, _, err := generateRTK()
+	if err != nil {
+		logx.LogError(ctx, "AccountSvc.ConfirmEmailChange.GenerateToken", err)
+		return newEmail, nil
+	}
+	ttl := s.cfg.Account.EmailRevertTTL
+	rev := models.EmailRevert{UserID: p.UserID, OldEmail: p.Email, NewEmail: newEmail}
+	if err := s.repo.StoreEmailRevert(ctx, hashToken(token), rev, ttl); err != nil {
+		logx.LogError(ctx, "AccountSvc.ConfirmEmailChange.StoreEmailRevert", err)
+		return newEmail, nil
+	}
+	link := emailLink(s.cfg, "/revert-email", token)
+	if err := s.mailer.Send(ctx, emailChangedMail(i18n.From(ctx), p.Email, newEmail, link, ttl)); err != nil {
+		logx.LogError(ctx, "AccountSvc.ConfirmEmailChange.Send", err)
+	}
+	return newEmail, nil
+}
+
+// RevertEmailChange Undo an email change from the link sent to the old address,
+// logging the account out everywhere; each link works once
+func (s *accountService) RevertEmailChange(ctx context.Context, token string) error {
+
+	// 1. Check input
+	if token == "" || len(token) > 128 {
+		return ErrBadRequest
+	}
+
+	// 2. Take the revert record
+	rev, err := s.repo.TakeEmailRevert(ctx, hashToken(token))
+	if err != nil {
+		if errors.Is(err, repos.ErrNotFound) {
+			return ErrUnauthorized
+		}
+		return s.mapErr(ctx, "AccountSvc.RevertEmailChange.TakeEmailRevert", err)
+	}
+
+	// 3. Swap back
+	if err := s.repo.RevertEmail(ctx, *rev); err != nil {
+		switch {
+		case errors.Is(err, repos.ErrEmailAlreadyExists), errors.Is(err, repos.ErrNotFound):
+			return ErrConflict
+		default:
+			return s.mapErr(ctx, "AccountSvc.RevertEmailChange.RevertEmail", err)
+		}
+	}
+	s.events.Record(ctx, Event(models.EventEmailReverted, true, rev.UserID, rev.OldEmail, "", "from:"+rev.NewEmail))
+	s.events.Record(ctx, Event(models.EventSessionRevoked, true, rev.UserID, rev.OldEmail, "", "revert_email"))
+	return nil
+}
+
+// SetLocale Answer p and write their emails in locale from now on, whatever Accept-Language
+// says; "" goes back to Accept-Language. Returns the supported tag stored, such as zh for zh-CN
+func (s *accountService) SetLocale(ctx context.Context, p Principal, locale string) (string, error) {
+
+	// 1. Resolve to a catalog we have
+	var stored *string
+	if locale != "" {
+		tag := i18n.Match(locale)
+		if tag == "" {
+			return "", ErrBadRequest
+		}
+		stored = &tag
+	}
+
+	// 2. Store
+	if err := s.repo.SetLocale(ctx, p.UserID, stored); err != nil {
+		return "", s.mapErr(ctx, "AccountSvc.SetLocale", err)
+	}
+	if stored == nil {
+		return "", nil
+	}
+	return *stored, nil
+}
+
+// reauth Re-check the password, recording a failed attempt. An account without one, such as
+// one created by a provider, re-authenticates with an access token issued within freshAuthWindow
+func (s *accountService) reauth(ctx context.Context, p Principal, password, detail string) error {
+	user, err := s.authRepo.GetUserByID(ctx, p.UserID)
+	if err != nil {
+		if errors.Is(err, repos.ErrNotFound) {
+			return ErrUnauthorized
+		}
+		return s.mapErr(ctx, "AccountSvc.Reauth.GetUserByID", err)
+	}
+	if user.PwdHash == "" {
+		if time.Since(p.IssuedAt) > freshAuthWindow {
+			return ErrUnauthorized
+		}
+		return nil
+	}
+	if password == "" {
+		return ErrUnauthorized
+	}
+	if err := s.passwords.check(ctx, "AccountSvc.Reauth", user, password); err != nil {
+		if errors.Is(err, ErrUnauthorized) {
+			s.events.Record(ctx, Event(models.EventLoginFailure, false, user.UserID, user.Email, p.DeviceID, detail))
+		}
+		return err
+	}
+	return nil
+}
+
+// notify Send a security notice; failures are only logged
+func (s *accountService) notify(ctx context.Context, msg mailx.Message) {
+	if err := s.mailer.Send(ctx, msg); err != nil {
+		logx.LogError(ctx, "AccountSvc.Notify", err)
+	}
+}
+
+// mapErr Translate repo errors, logging unexpected ones
+func (s *accountService) mapErr(ctx context.Context, op string, err error) error {
+	if ctx_util.IsCtxDone(ctx, err) {
+		return ErrCtxError
+	}
+	if errors.Is(err, repos.ErrNotFound) {
+		return ErrNotFound
+	}
+	logx.LogError(ctx, op, err)
+	return ErrInternalServer
+}
+
+type accountService struct {
+	repo       repos.AccountRepo
+	authRepo   repos.AuthRepo
+	eventRepo  repos.EventRepo
+	events     EventRecorder
+	mailer     mailx.Mailer
+	cfg        *config.Config
+	settings   settings.Provider
+	mfa        *mfaVerifier
+	passkeys   repos.PasskeyRepo
+	rp         *webauthn.RelyingParty
+	federation *federation
+	passwords  *passwordChecker
+	reputation ReputationService
+}
+
+func NewAccountService(repo repos.AccountRepo, authRepo repos.AuthRepo, eventRepo repos.EventRepo, mfaRepo repos.MFARepo,
+	passkeyRepo repos.PasskeyRepo, identityRepo repos.IdentityRepo, box *secretbox.Box, verifier *oidc.Verifier,
+	hasher *passwordhash.Hasher, reputation ReputationService, events EventRecorder, mailer mailx.Mailer, cfg *config.Config, rt settings.Provider) AccountService {
+	return &accountService{repo: repo, authRepo: authRepo, eventRepo: eventRepo, events: events, mailer: mailer, cfg: cfg, settings: rt,
+		mfa: newMFAVerifier(mfaRepo, box, cfg.MFA), passkeys: passkeyRepo, rp: newRelyingParty(cfg.WebAuthn),
+		federation: &federation{verifier: verifier, repo: identityRepo}, passwords: &passwordChecker{hasher: hasher, repo: authRepo},
+		reputation: reputation}
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"backend/internal/config"
+	"backend/internal/models"
+	"backend/internal/repos"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// stubAuthRepo Serves one user; methods a test does not expect panic on the nil AuthRepo
+type stubAuthRepo struct {
+	repos.AuthRepo
+	user *models.User
+}
+
+func (r *stubAuthRepo) GetUserByID(ctx context.Context, userID uint64) (*models.User, error) {
+	if r.user == nil || r.user.UserID != userID {
+		return nil, repos.ErrNotFound
+	}
+	return r.user, nil
+}
+
+type stubAccountRepo struct {
+	repos.AccountRepo
+	deleted []uint64
+}
+
+func (r *stubAccountRepo) MarkDeleted(ctx context.Context, userID uint64) (time.Time, error) {
+	r.deleted = append(r.deleted, userID)
+	return time.Now(), nil
+}
+
+type stubEvents struct {
+	events []models.AuthEvent
+}
+
+func (r *stubEvents) Record(ctx context.Context, e models.AuthEvent) {
+	r.events = append(r.events, e)
+}
+
+func (r *stubEvents) Run(ctx context.Context) {}
+
+func TestDeleteAccountReauth(t *testing.T) {
+	fresh := time.Now()
+	stale := time.Now().Add(-2 * freshAuthWindow)
+	tests := []struct {
+		name     string
+		pwdHash  string
+		issuedAt time.Time
+		want     error
+	}{
+		{"password account with a fresh token and no password", "$argon2id$stored", fresh, ErrUnauthorized},
+		{"password-less account with a fresh token", "", fresh, nil},
+		{"password-less account with a stale token", "", stale, ErrUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &stubAccountRepo{}
+			s := &accountService{
+				repo:     repo,
+				authRepo: &stubAuthRepo{user: &models.User{UserID: 7, Email: "a@example.com", PwdHash: tt.pwdHash}},
+				events:   &stubEvents{},
+				cfg:      &config.Config{},
+			}
+			p := Principal{UserID: 7, Email: "a@example.com", IssuedAt: tt.issuedAt}
+
+			_, err := s.DeleteAccount(context.Background(), p, "")
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("DeleteAccount = %v, want %v", err, tt.want)
+			}
+			if deleted := len(repo.deleted) > 0; deleted != (tt.want == nil) {
+				t.Fatalf("deleted = %v, want %v", deleted, tt.want == nil)
+			}
+		})
+	}
+}
+
+// TestReauthNeedsPassword A fresh access token alone does not get a password account past
+// re-authentication
+func TestReauthNeedsPassword(t *testing.T) {
+	calls := map[string]func(s *accountService, p Principal) error{
+		"SetupTOTP": func(s *accountService, p Principal) error {
+			_, err := s.SetupTOTP(context.Background(), p, "")
+			return err
+		},
+		"DisableTOTP": func(s *accountService, p Principal) error {
+			return s.DisableTOTP(context.Background(), p, "", "000000")
+		},
+		"RegenerateRecoveryCodes": func(s *accountService, p Principal) error {
+			_, err := s.RegenerateRecoveryCodes(context.Background(), p, "", "000000")
+			return err
+		},
+		"BeginPasskeyRegistration": func(s *accountService, p Principal) error {
+			_, err := s.BeginPasskeyRegistration(context.Background(), p, "")
+			return err
+		},
+		"DeletePasskey": func(s *accountService, p Principal) error {
+			return s.DeletePasskey(context.Background(), p, 1, "")
+		},
+		"LinkIdentity": func(s *accountService, p Principal) error {
+			_, err := s.LinkIdentity(context.Background(), p, "apple", "id-token", "", "")
+			return err
+		},
+		"UnlinkIdentity": func(s *accountService, p Principal) error {
+			return s.UnlinkIdentity(context.Background(), p, "apple", "")
+		},
+	}
+	for name, call := range calls {
+		t.Run(name, func(t *testing.T) {
+			s := &accountService{
+				authRepo: &stubAuthRepo{user: &models.User{UserID: 7, Email: "a@example.com", PwdHash: "$argon2id$stored"}},
+				events:   &stubEvents{},
+				cfg:      &config.Config{},
+			}
+			err := call(s, Principal{UserID: 7, Email: "a@example.com", IssuedAt: time.Now()})
+			if !errors.Is(err, ErrUnauthorized) {
+				t.Fatalf("%s = %v, want %v", name, err, ErrUnauthorized)
+			}
+		})
+	}
+}
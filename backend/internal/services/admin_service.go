@@ -0,0 +1,454 @@
+package services
+
+import (
+	"backend/internal/models"
+	"backend/internal/pkg/ctx_util"
+	"backend/internal/pkg/logx"
+	"backend/internal/repos"
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+type AdminService interface {
+	LookupUser(ctx context.Context, actor Actor, userID uint64, email string) (UserInfo, error)
+	SearchUsers(ctx context.Context, actor Actor, query string, page, pageSize int) (Page[UserInfo], error)
+	ForceLogout(ctx context.Context, actor Actor, userID uint64, reason string) (uint, error)
+	RevokeDevice(ctx context.Context, actor Actor, userID uint64, deviceID, reason string) error
+	ClearThrottles(ctx context.Context, actor Actor, email, reason string) (int64, error)
+	ResendCode(ctx context.Context, actor Actor, email, scene, reason string) (string, error)
+	SetDeleted(ctx context.Context, actor Actor, userID uint64, deleted bool, reason string) error
+	SetLocked(ctx context.Context, actor Actor, userID uint64, locked bool, reason string) error
+	SetRole(ctx context.Context, actor Actor, userID uint64, role, reason string) error
+	ListSessions(ctx context.Context, actor Actor, userID uint64) ([]SessionInfo, error)
+	RecentAuthEvents(ctx context.Context, actor Actor, userID uint64, limit int) ([]AuthEventInfo, error)
+	ListAudit(ctx context.Context, actor Actor, targetUserID uint64, page, pageSize int) (Page[AuditInfo], error)
+}
+
+// Actor Who performs an admin action, recorded in the audit trail
+type Actor struct {
+	// UserID Staff account id, 0 for the CLI
+	UserID uint64
+	// Label Staff email, or cli:<os user>
+	Label string
+	IP    string
+	// Reason Optional justification for read-only actions
+	Reason string
+}
+
+// Audit actions
+const (
+	auditUserView      = "user.view"
+	auditUserSearch    = "user.search"
+	auditForceLogout   = "user.force_logout"
+	auditRevokeDevice  = "user.revoke_device"
+	auditClearThrottle = "user.clear_throttle"
+	auditResendCode    = "user.resend_code"
+	auditDelete        = "user.delete"
+	auditRestore       = "user.restore"
+	auditLock          = "user.lock"
+	auditUnlock        = "user.unlock"
+	auditSetRole       = "user.set_role"
+	auditSessionsView  = "sessions.view"
+	auditEventsView    = "events.view"
+	auditAuditView     = "audit.view"
+	auditReputationAdd = "reputation.add"
+	auditReputationDel = "reputation.remove"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+	maxEventLimit   = 200
+)
+
+// Page One page of a paginated listing, Page is 1-based
+type Page[T any] struct {
+	Items    []T `json:"items"`
+	Page     int `json:"page"`
+	PageSize int `json:"page_size"`
+	Total    int `json:"total"`
+}
+
+type UserInfo struct {
+	UserID       uint64     `json:"user_id"`
+	Email        string     `json:"email"`
+	Username     string     `json:"username"`
+	Role         string     `json:"role"`
+	Locale       *string    `json:"locale"`
+	TokenVersion uint       `json:"token_version"`
+	IsDeleted    bool       `json:"is_deleted"`
+	DeletedAt    *time.Time `json:"deleted_at"`
+	LockedAt     *time.Time `json:"locked_at"`
+	LockedReason *string    `json:"locked_reason"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+type SessionInfo struct {
+	SessionID       uint64     `json:"session_id"`
+	DeviceID        string     `json:"device_id"`
+	TokenVersion    uint       `json:"token_version"`
+	Active          bool       `json:"active"`
+	ExpiresAt       time.Time  `json:"expires_at"`
+	RevokedAt       *time.Time `json:"revoked_at"`
+	LastSeenAt      *time.Time `json:"last_seen_at"`
+	DeviceRevokedAt *time.Time `json:"device_revoked_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+type AuthEventInfo struct {
+	ID        uint64    `json:"id"`
+	Type      string    `json:"type"`
+	Success   bool      `json:"success"`
+	IP        *string   `json:"ip"`
+	DeviceID  string    `json:"device_id,omitempty"`
+	UserAgent *string   `json:"user_agent"`
+	Detail    *string   `json:"detail"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type AuditInfo struct {
+	ID           uint64    `json:"id"`
+	ActorID      *uint64   `json:"actor_id"`
+	Actor        string    `json:"actor"`
+	Action       string    `json:"action"`
+	TargetUserID *uint64   `json:"target_user_id"`
+	Reason       string    `json:"reason"`
+	Detail       *string   `json:"detail"`
+	IP           *string   `json:"ip"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// LookupUser Find by id when non-zero, otherwise by email
+func (s *adminService) LookupUser(ctx context.Context, actor Actor, userID uint64, email string) (UserInfo, error) {
+	var (
+		u   *models.User
+		err error
+	)
+	email = strings.ToLower(strings.TrimSpace(email))
+	switch {
+	case userID != 0:
+		u, err = s.repo.FindUserByID(ctx, userID)
+	case email != "":
+		u, err = s.repo.FindUserByEmail(ctx, email)
+	default:
+		return UserInfo{}, ErrBadRequest
+	}
+	if err != nil {
+		return UserInfo{}, s.mapErr(ctx, "AdminSvc.LookupUser", err)
+	}
+	if err := s.repo.InsertAudit(ctx, actor.audit(auditUserView, u.UserID, actor.Reason, "")); err != nil {
+		return UserInfo{}, s.mapErr(ctx, "AdminSvc.LookupUser.Audit", err)
+	}
+	return toUserInfo(u), nil
+}
+
+// SearchUsers Match id, email prefix or username prefix; empty query lists everyone
+func (s *adminService) SearchUsers(ctx context.Context, actor Actor, query string, page, pageSize int) (Page[UserInfo], error) {
+	query = strings.TrimSpace(query)
+	page, pageSize = normalizePage(page, pageSize)
+	if err := s.repo.InsertAudit(ctx, actor.audit(auditUserSearch, 0, actor.Reason, "q="+query)); err != nil {
+		return Page[UserInfo]{}, s.mapErr(ctx, "AdminSvc.SearchUsers.Audit", err)
+	}
+	users, total, err := s.repo.SearchUsers(ctx, strings.ToLower(query), (page-1)*pageSize, pageSize)
+	if err != nil {
+		return Page[UserInfo]{}, s.mapErr(ctx, "AdminSvc.SearchUsers", err)
+	}
+	items := make([]UserInfo, 0, len(users))
+	for i := range users {
+		items = append(items, toUserInfo(&users[i]))
+	}
+	return Page[UserInfo]{Items: items, Page: page, PageSize: pageSize, Total: total}, nil
+}
+
+// ForceLogout Bump token_version and revoke every session
+func (s *adminService) ForceLogout(ctx context.Context, actor Actor, userID uint64, reason string) (uint, error) {
+	if !hasReason(reason) {
+		return 0, ErrBadRequest
+	}
+	tkv, err := s.repo.BumpTokenVersion(ctx, userID, actor.audit(auditForceLogout, userID, reason, ""))
+	if err != nil {
+		return 0, s.mapErr(ctx, "AdminSvc.ForceLogout", err)
+	}
+	s.events.Record(ctx, Event(models.EventSessionRevoked, true, userID, "", "", "admin:"+auditForceLogout))
+	return tkv, nil
+}
+
+func (s *adminService) RevokeDevice(ctx context.Context, actor Actor, userID uint64, deviceID, reason string) error {
+	u, err := uuid.Parse(deviceID)
+	if err != nil || !hasReason(reason) {
+		return ErrBadRequest
+	}
+	if err := s.repo.RevokeDevice(ctx, userID, u[:], actor.audit(auditRevokeDevice, userID, reason, "device="+u.String())); err != nil {
+		return s.mapErr(ctx, "AdminSvc.RevokeDevice", err)
+	}
+	s.events.Record(ctx, Event(models.EventSessionRevoked, true, userID, "", deviceID, "admin:"+auditRevokeDevice))
+	return nil
+}
+
+// ClearThrottles Lift login locks and OTP throttles of every scene
+func (s *adminService) ClearThrottles(ctx context.Context, actor Actor, email, reason string) (int64, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if !isValidEmail(email, nil, emailExisting) || !hasReason(reason) {
+		return 0, ErrBadRequest
+	}
+	if err := s.repo.InsertAudit(ctx, actor.audit(auditClearThrottle, 0, reason, "email="+email)); err != nil {
+		return 0, s.mapErr(ctx, "AdminSvc.ClearThrottles.Audit", err)
+	}
+	n, err := s.repo.ClearThrottles(ctx, email, otpScenes)
+	if err != nil {
+		return 0, s.mapErr(ctx, "AdminSvc.ClearThrottles", err)
+	}
+	return n, nil
+}
+
+// ResendCode Clear the OTP throttle then run the normal request-code flow
+func (s *adminService) ResendCode(ctx context.Context, actor Actor, email, scene, reason string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if !isValidEmail(email, nil, emailExisting) || !isValidScene(scene) || !hasReason(reason) {
+		return "", ErrBadRequest
+	}
+	if err := s.repo.InsertAudit(ctx, actor.audit(auditResendCode, 0, reason, "email="+email+" scene="+scene)); err != nil {
+		return "", s.mapErr(ctx, "AdminSvc.ResendCode.Audit", err)
+	}
+	if _, err := s.repo.ClearThrottles(ctx, email, []string{scene}); err != nil {
+		return "", s.mapErr(ctx, "AdminSvc.ResendCode", err)
+	}
+	return s.auth.RequestCode(ctx, "", email, scene)
+}
+
+func (s *adminService) SetDeleted(ctx context.Context, actor Actor, userID uint64, deleted bool, reason string) error {
+	if !hasReason(reason) {
+		return ErrBadRequest
+	}
+	action := auditRestore
+	if deleted {
+		action = auditDelete
+	}
+	if err := s.repo.SetDeleted(ctx, userID, deleted, actor.audit(action, userID, reason, "")); err != nil {
+		return s.mapErr(ctx, "AdminSvc.SetDeleted", err)
+	}
+	if deleted {
+		s.events.Record(ctx, Event(models.EventSessionRevoked, true, userID, "", "", "admin:"+auditDelete))
+	}
+	return nil
+}
+
+// SetLocked Locked users cannot log in and their tokens stop working immediately
+func (s *adminService) SetLocked(ctx context.Context, actor Actor, userID uint64, locked bool, reason string) error {
+	reason = strings.TrimSpace(reason)
+	if !hasReason(reason) || (locked && userID == actor.UserID) {
+		return ErrBadRequest
+	}
+	action := auditUnlock
+	if locked {
+		action = auditLock
+	}
+	if err := s.repo.SetLocked(ctx, userID, locked, reason, actor.audit(action, userID, reason, "")); err != nil {
+		return s.mapErr(ctx, "AdminSvc.SetLocked", err)
+	}
+	if locked {
+		s.events.Record(ctx, Event(models.EventSessionRevoked, true, userID, "", "", "admin:"+auditLock))
+	}
+	return nil
+}
+
+// SetRole Grant or revoke staff access
+func (s *adminService) SetRole(ctx context.Context, actor Actor, userID uint64, role, reason string) error {
+	if !hasReason(reason) || !models.IsRole(role) {
+		return ErrBadRequest
+	}
+	if err := s.repo.SetRole(ctx, userID, role, actor.audit(auditSetRole, userID, reason, "role="+role)); err != nil {
+		return s.mapErr(ctx, "AdminSvc.SetRole", err)
+	}
+	return nil
+}
+
+func (s *adminService) ListSessions(ctx context.Context, actor Actor, userID uint64) ([]SessionInfo, error) {
+	if _, err := s.repo.FindUserByID(ctx, userID); err != nil {
+		return nil, s.mapErr(ctx, "AdminSvc.ListSessions", err)
+	}
+	if err := s.repo.InsertAudit(ctx, actor.audit(auditSessionsView, userID, actor.Reason, "")); err != nil {
+		return nil, s.mapErr(ctx, "AdminSvc.ListSessions.Audit", err)
+	}
+	sessions, err := s.repo.ListSessions(ctx, userID)
+	if err != nil {
+		return nil, s.mapErr(ctx, "AdminSvc.ListSessions", err)
+	}
+	now := time.Now()
+	out := make([]SessionInfo, 0, len(sessions))
+	for _, ss := range sessions {
+		out = append(out, toSessionInfo(ss, now))
+	}
+	return out, nil
+}
+
+// RecentAuthEvents Newest auth events of a user
+func (s *adminService) RecentAuthEvents(ctx context.Context, actor Actor, userID uint64, limit int) ([]AuthEventInfo, error) {
+	if limit <= 0 || limit > maxEventLimit {
+		limit = defaultPageSize
+	}
+	if _, err := s.repo.FindUserByID(ctx, userID); err != nil {
+		return nil, s.mapErr(ctx, "AdminSvc.RecentAuthEvents", err)
+	}
+	if err := s.repo.InsertAudit(ctx, actor.audit(auditEventsView, userID, actor.Reason, "")); err != nil {
+		return nil, s.mapErr(ctx, "AdminSvc.RecentAuthEvents.Audit", err)
+	}
+	events, err := s.repo.ListAuthEvents(ctx, userID, limit)
+	if err != nil {
+		return nil, s.mapErr(ctx, "AdminSvc.RecentAuthEvents", err)
+	}
+	out := make([]AuthEventInfo, 0, len(events))
+	for _, e := range events {
+		out = append(out, toAuthEventInfo(e))
+	}
+	return out, nil
+}
+
+// ListAudit Audit trail, of one target when targetUserID is non-zero
+func (s *adminService) ListAudit(ctx context.Context, actor Actor, targetUserID uint64, page, pageSize int) (Page[AuditInfo], error) {
+	page, pageSize = normalizePage(page, pageSize)
+	if err := s.repo.InsertAudit(ctx, actor.audit(auditAuditView, targetUserID, actor.Reason, "")); err != nil {
+		return Page[AuditInfo]{}, s.mapErr(ctx, "AdminSvc.ListAudit.Audit", err)
+	}
+	rows, total, err := s.repo.ListAudit(ctx, targetUserID, (page-1)*pageSize, pageSize)
+	if err != nil {
+		return Page[AuditInfo]{}, s.mapErr(ctx, "AdminSvc.ListAudit", err)
+	}
+	items := make([]AuditInfo, 0, len(rows))
+	for _, a := range rows {
+		items = append(items, AuditInfo{
+			ID:           a.ID,
+			ActorID:      a.ActorID,
+			Actor:        a.Actor,
+			Action:       a.Action,
+			TargetUserID: a.TargetUserID,
+			Reason:       a.Reason,
+			Detail:       a.Detail,
+			IP:           a.IP,
+			CreatedAt:    a.CreatedAt,
+		})
+	}
+	return Page[AuditInfo]{Items: items, Page: page, PageSize: pageSize, Total: total}, nil
+}
+
+// audit Build the audit row of an action, zero target means none
+func (a Actor) audit(action string, target uint64, reason, detail string) *models.AdminAudit {
+	row := &models.AdminAudit{
+		Actor:  a.Label,
+		Action: action,
+		Reason: strings.TrimSpace(reason),
+	}
+	if a.UserID != 0 {
+		id := a.UserID
+		row.ActorID = &id
+	}
+	if target != 0 {
+		row.TargetUserID = &target
+	}
+	if detail != "" {
+		row.Detail = &detail
+	}
+	if a.IP != "" {
+		ip := a.IP
+		row.IP = &ip
+	}
+	return row
+}
+
+// hasReason Every state-changing admin action must say why
+func hasReason(reason string) bool {
+	reason = strings.TrimSpace(reason)
+	return reason != "" && utf8.RuneCountInString(reason) <= 512
+}
+
+func normalizePage(page, pageSize int) (int, int) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	return page, pageSize
+}
+
+// mapErr Translate repo errors, logging unexpected ones
+func (s *adminService) mapErr(ctx context.Context, op string, err error) error {
+	if ctx_util.IsCtxDone(ctx, err) {
+		return ErrCtxError
+	}
+	if errors.Is(err, repos.ErrNotFound) {
+		return ErrNotFound
+	}
+	logx.LogError(ctx, op, err)
+	return ErrInternalServer
+}
+
+func toUserInfo(u *models.User) UserInfo {
+	return UserInfo{
+		UserID:       u.UserID,
+		Email:        u.Email,
+		Username:     u.Username,
+		Role:         u.Role,
+		Locale:       u.Locale,
+		TokenVersion: u.TokenV,
+		IsDeleted:    u.IsDeleted,
+		DeletedAt:    u.DeletedAt,
+		LockedAt:     u.LockedAt,
+		LockedReason: u.LockedReason,
+		CreatedAt:    u.CreatedAt,
+		UpdatedAt:    u.UpdatedAt,
+	}
+}
+
+func toSessionInfo(ss models.Session, now time.Time) SessionInfo {
+	did := ""
+	if u, err := uuid.FromBytes(ss.DeviceID); err == nil {
+		did = u.String()
+	}
+	return SessionInfo{
+		SessionID:       ss.SessionID,
+		DeviceID:        did,
+		TokenVersion:    ss.TokenV,
+		Active:          ss.RevokedAt == nil && ss.DeviceRevokedAt == nil && ss.ExpiresAt.After(now),
+		ExpiresAt:       ss.ExpiresAt,
+		RevokedAt:       ss.RevokedAt,
+		LastSeenAt:      ss.LastSeenAt,
+		DeviceRevokedAt: ss.DeviceRevokedAt,
+		CreatedAt:       ss.CreatedAt,
+	}
+}
+
+func toAuthEventInfo(e models.AuthEvent) AuthEventInfo {
+	info := AuthEventInfo{
+		ID:        e.ID,
+		Type:      e.Type,
+		Success:   e.Success,
+		IP:        e.IP,
+		UserAgent: e.UserAgent,
+		Detail:    e.Detail,
+		CreatedAt: e.CreatedAt,
+	}
+	if u, err := uuid.FromBytes(e.DeviceID); err == nil {
+		info.DeviceID = u.String()
+	}
+	return info
+}
+
+type adminService struct {
+	repo   repos.AdminRepo
+	auth   AuthService
+	events EventRecorder
+}
+
+func NewAdminService(repo repos.AdminRepo, auth AuthService, events EventRecorder) AdminService {
+	return &adminService{repo: repo, auth: auth, events: events}
+}
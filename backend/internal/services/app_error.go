@@ -0,0 +1,129 @@
+package services
+
+import (
+	"backend/internal/pkg/pwpolicy"
+	"context"
+	"errors"
+	"net/http"
+)
+
+// AppError An error as the API answers it: HTTP status, a stable code clients can switch on,
+// the catalog key of a message fit for the user and optional details
+type AppError struct {
+	Status int
+	Code   string
+	// Key i18n catalog key of the message, worded in the caller's language when written
+	Key string
+	// Args Name and value pairs filling the message's placeholders
+	Args []any
+	// Details Extra data such as []FieldError, left out when nil
+	Details any
+	// Err Sentinel or cause, matched by errors.Is and never shown to the client
+	Err error
+}
+
+func (e *AppError) Error() string {
+	if e.Err != nil {
+		return e.Code + ": " + e.Err.Error()
+	}
+	return e.Code + ": " + e.Key
+}
+
+func (e *AppError) Unwrap() error {
+	return e.Err
+}
+
+// HTTPStatus, ErrorCode, MessageKey, MessageArgs and ErrorDetails make AppError an httpx.Coded
+func (e *AppError) HTTPStatus() int    { return e.Status }
+func (e *AppError) ErrorCode() string  { return e.Code }
+func (e *AppError) MessageKey() string { return e.Key }
+func (e *AppError) MessageArgs() []any { return e.Args }
+func (e *AppError) ErrorDetails() any  { return e.Details }
+
+// FieldError One request field that failed validation
+type FieldError struct {
+	Field string `json:"field"`
+	Rule  string `json:"rule"`
+	Param string `json:"param,omitempty"`
+}
+
+// Messages Catalog keys wording sentinel errors for one endpoint, in place of their codes
+type Messages map[error]string
+
+// With A copy of m that words err with key
+func (m Messages) With(err error, key string) Messages {
+	out := make(Messages, len(m)+1)
+	for k, v := range m {
+		out[k] = v
+	}
+	out[err] = key
+	return out
+}
+
+// Invalid 400 for a request that failed binding, worded by key, with the fields at fault
+func Invalid(key string, fields []FieldError) *AppError {
+	e := &AppError{Status: http.StatusBadRequest, Code: "BAD_REQUEST", Key: key, Err: ErrBadRequest}
+	if len(fields) > 0 {
+		e.Details = fields
+	}
+	return e
+}
+
+// errKinds Status and code of each sentinel; codes stay stable across releases and are also
+// the catalog keys of the default messages
+var errKinds = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{ErrBadRequest, http.StatusBadRequest, "BAD_REQUEST"},
+	{ErrUnauthorized, http.StatusUnauthorized, "UNAUTHORIZED"},
+	{ErrChallengeRequired, http.StatusForbidden, "CHALLENGE_REQUIRED"},
+	{ErrForbidden, http.StatusForbidden, "FORBIDDEN"},
+	{ErrNotFound, http.StatusNotFound, "NOT_FOUND"},
+	{ErrConflict, http.StatusConflict, "CONFLICT"},
+	{ErrTooManyRequest, http.StatusTooManyRequests, "TOO_MANY_REQUEST"},
+}
+
+// AsAppError err as the API answers it. An AppError in the chain is used as is; a rejected
+// password keeps the policy's code; timeouts become 504, or 499 once the client went away;
+// sentinels get their status and code, worded by msgs when it has an entry; anything else
+// is a 500 that tells nothing
+func AsAppError(ctx context.Context, err error, msgs Messages) *AppError {
+
+	// 1. Already one
+	var ae *AppError
+	if errors.As(err, &ae) {
+		return ae
+	}
+
+	// 2. Rejected password
+	var v *pwpolicy.Violation
+	if errors.As(err, &v) {
+		return &AppError{Status: http.StatusBadRequest, Code: v.Code, Key: v.Code, Args: v.Args, Err: err}
+	}
+
+	// 3. Context
+	if errors.Is(err, ErrCtxError) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		if errors.Is(err, context.Canceled) || errors.Is(ctx.Err(), context.Canceled) {
+			return &AppError{Status: 499, Code: "REQUEST_CANCELED", Key: "REQUEST_CANCELED", Err: err}
+		}
+		return &AppError{Status: http.StatusGatewayTimeout, Code: "REQUEST_TIMEOUT", Key: "REQUEST_TIMEOUT", Err: err}
+	}
+
+	// 4. Sentinels
+	for _, k := range errKinds {
+		if !errors.Is(err, k.err) {
+			continue
+		}
+		key := k.code
+		if m, ok := msgs[k.err]; ok {
+			key = m
+		}
+		return &AppError{Status: k.status, Code: k.code, Key: key, Err: err}
+	}
+
+	// 5. Anything else
+	return &AppError{Status: http.StatusInternalServerError, Code: "INTERNAL_SERVER_ERROR",
+		Key: "INTERNAL_SERVER_ERROR", Err: err}
+}
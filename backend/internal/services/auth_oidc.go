@@ -0,0 +1,127 @@
+package services
+
+import (
+	"backend/internal/models"
+	"backend/internal/pkg/ctx_util"
+	"backend/internal/pkg/logx"
+	"backend/internal/repos"
+	"context"
+	"errors"
+)
+
+// LoginWithOIDC Sign in with an ID token from a configured provider. A new identity signs
+// up with the token's verified email; if that email already belongs to an account the login
+// is refused with ErrConflict, the owner has to sign in and link the provider from settings
+func (s *authService) LoginWithOIDC(ctx context.Context, provider, idToken, nonce, deviceID string) (LoginResult, error) {
+
+	// 0. Create sub context
+	cctx, cancel := context.WithTimeout(ctx, s.settings.Current().Timeouts.Login)
+	defer cancel()
+
+	// 1. Check input
+	if !isUUID(deviceID) {
+		return LoginResult{}, ErrBadRequest
+	}
+
+	// 2. Verify token, outside the login timeout as a cold JWKS cache means a fetch
+	id, err := s.federation.verify(ctx, "AuthSvc.LoginWithOIDC", provider, idToken, nonce)
+	if err != nil {
+		if errors.Is(err, ErrUnauthorized) {
+			s.events.Record(ctx, Event(models.EventLoginFailure, false, 0, "", deviceID, "oidc:"+provider))
+		}
+		return LoginResult{}, err
+	}
+	email := identityEmail(id)
+
+	// 3. Find the linked user, or sign up
+	var user *models.User
+	linked, err := s.federation.repo.FindIdentity(cctx, id.Issuer, id.Subject)
+	switch {
+	case err == nil:
+		if user, err = s.oidcUser(ctx, cctx, linked, email, id.PrivateEmail, deviceID); err != nil {
+			return LoginResult{}, err
+		}
+	case errors.Is(err, repos.ErrNotFound):
+		if user, err = s.oidcSignup(ctx, cctx, id.Provider, email, id.EmailVerified, identityRow(0, id), deviceID); err != nil {
+			return LoginResult{}, err
+		}
+	case ctx_util.IsCtxDone(cctx, err):
+		return LoginResult{}, ErrCtxError
+	default:
+		logx.LogError(ctx, "AuthSvc.LoginWithOIDC.FindIdentity", err)
+		return LoginResult{}, ErrInternalServer
+	}
+
+	// 4. Second factor, the provider's login does not stand in for ours
+	if ch, err := s.mfaChallenge(ctx, cctx, "AuthSvc.LoginWithOIDC", user, deviceID, "login"); err != nil || ch != nil {
+		return LoginResult{Challenge: ch}, err
+	}
+
+	// 5. Sign tokens and store device id and session
+	resp, err := s.issueSession(ctx, cctx, "AuthSvc.LoginWithOIDC", user.UserID, user.Email, user.TokenV, deviceID, "oidc")
+	if err != nil {
+		return LoginResult{}, err
+	}
+	s.events.Record(ctx, Event(models.EventLoginSuccess, true, user.UserID, user.Email, deviceID, "oidc:"+id.Provider))
+	return LoginResult{Tokens: &resp}, nil
+}
+
+// oidcUser Load the user behind a linked identity and record the sign-in
+func (s *authService) oidcUser(ctx, cctx context.Context, linked *models.UserIdentity, email *string, private bool, deviceID string) (*models.User, error) {
+	user, err := s.repo.GetUserByID(cctx, linked.UserID)
+	if err != nil {
+		if ctx_util.IsCtxDone(cctx, err) {
+			return nil, ErrCtxError
+		}
+		if errors.Is(err, repos.ErrNotFound) {
+			return nil, ErrUnauthorized
+		}
+		logx.LogError(ctx, "AuthSvc.LoginWithOIDC.GetUserByID", err)
+		return nil, ErrInternalServer
+	}
+	if user.IsDeleted {
+		return nil, ErrUnauthorized
+	}
+	if user.LockedAt != nil {
+		s.events.Record(ctx, Event(models.EventLoginFailure, false, user.UserID, user.Email, deviceID, "locked"))
+		return nil, ErrForbidden
+	}
+	if err := s.federation.repo.TouchIdentity(cctx, linked.ID, email, private); err != nil {
+		if ctx_util.IsCtxDone(cctx, err) {
+			return nil, ErrCtxError
+		}
+		logx.LogError(ctx, "AuthSvc.LoginWithOIDC.TouchIdentity", err)
+		return nil, ErrInternalServer
+	}
+	return user, nil
+}
+
+// oidcSignup Create a password-less user for a first-time identity. Apple private relay
+// addresses are verified and deliverable, so they become the account email like any other
+func (s *authService) oidcSignup(ctx, cctx context.Context, provider string, email *string, verified bool, row models.UserIdentity, deviceID string) (*models.User, error) {
+	if email == nil || !isValidEmail(*email, s.reputation.Lists(), emailNew) {
+		return nil, ErrBadRequest
+	}
+	if !verified {
+		s.events.Record(ctx, Event(models.EventLoginFailure, false, 0, *email, deviceID, "oidc:email_unverified"))
+		return nil, ErrForbidden
+	}
+	uid, tkv, err := s.federation.repo.CreateUserWithIdentity(cctx, *email, row)
+	if err != nil {
+		if ctx_util.IsCtxDone(cctx, err) {
+			return nil, ErrCtxError
+		}
+		if errors.Is(err, repos.ErrEmailAlreadyExists) {
+			s.events.Record(ctx, Event(models.EventLoginFailure, false, 0, *email, deviceID, "oidc:email_taken"))
+			return nil, ErrConflict
+		}
+		if errors.Is(err, repos.ErrIdentityExists) {
+			// A concurrent first sign-in with the same identity won
+			return nil, ErrConflict
+		}
+		logx.LogError(ctx, "AuthSvc.LoginWithOIDC.CreateUserWithIdentity", err)
+		return nil, ErrInternalServer
+	}
+	s.events.Record(ctx, Event(models.EventAccountCreated, true, uid, *email, deviceID, "oidc:"+provider))
+	return &models.User{UserID: uid, Email: *email, TokenV: tkv}, nil
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"backend/internal/models"
+	"backend/internal/pkg/ctx_util"
+	"backend/internal/pkg/logx"
+	"backend/internal/pkg/webauthn"
+	"backend/internal/repos"
+	"bytes"
+	"context"
+	"errors"
+)
+
+// BeginPasskeyLogin Options for navigator.credentials.get(); allowCredentials is empty
+// so the authenticator offers any discoverable passkey for this site
+func (s *authService) BeginPasskeyLogin(ctx context.Context) (PasskeyOptions, error) {
+	cfg := s.cfg.WebAuthn
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		logx.LogError(ctx, "AuthSvc.BeginPasskeyLogin.NewChallenge", err)
+		return PasskeyOptions{}, ErrInternalServer
+	}
+	id := newChallengeID()
+	ch := models.WebAuthnChallenge{Challenge: challenge, Type: ceremonyLogin}
+	if err := s.passkeys.StoreChallenge(ctx, id, ch, cfg.ChallengeTTL); err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return PasskeyOptions{}, ErrCtxError
+		}
+		logx.LogError(ctx, "AuthSvc.BeginPasskeyLogin.StoreChallenge", err)
+		return PasskeyOptions{}, ErrInternalServer
+	}
+	return PasskeyOptions{ChallengeID: id, PublicKey: requestOptions{
+		Challenge:        webauthn.Encode(challenge),
+		RPID:             cfg.RPID,
+		Timeout:          cfg.ChallengeTTL.Milliseconds(),
+		UserVerification: cfg.UserVerification,
+		AllowCredentials: []credentialDescriptor{},
+	}}, nil
+}
+
+// FinishPasskeyLogin Verify an assertion and log in. A passkey with user verification counts
+// as both factors; without it, TOTP is still asked for when enabled
+func (s *authService) FinishPasskeyLogin(ctx context.Context, challengeID, deviceID string, cred PasskeyCredential) (LoginResult, error) {
+
+	// 0. Create sub context
+	cctx, cancel := context.WithTimeout(ctx, s.settings.Current().Timeouts.Login)
+	defer cancel()
+
+	// 1. Check input
+	if !isUUID(challengeID) || !isUUID(deviceID) {
+		return LoginResult{}, ErrBadRequest
+	}
+	credID, err1 := webauthn.Decode(cred.ID)
+	clientData, err2 := webauthn.Decode(cred.ClientDataJSON)
+	authData, err3 := webauthn.Decode(cred.AuthenticatorData)
+	sig, err4 := webauthn.Decode(cred.Signature)
+	userHandle, err5 := webauthn.Decode(cred.UserHandle)
+	if err := errors.Join(err1, err2, err3, err4, err5); err != nil || len(credID) == 0 {
+		return LoginResult{}, ErrBadRequest
+	}
+
+	// 2. Take challenge
+	ch, err := s.passkeys.TakeChallenge(cctx, challengeID)
+	if err != nil {
+		if ctx_util.IsCtxDone(cctx, err) {
+			return LoginResult{}, ErrCtxError
+		}
+		if errors.Is(err, repos.ErrNotFound) {
+			return LoginResult{}, ErrUnauthorized
+		}
+		logx.LogError(ctx, "AuthSvc.FinishPasskeyLogin.TakeChallenge", err)
+		return LoginResult{}, ErrInternalServer
+	}
+	if ch.Type != ceremonyLogin {
+		return LoginResult{}, ErrUnauthorized
+	}
+
+	// 3. Find credential, the user handle must agree when the authenticator sends one
+	c, err := s.passkeys.GetCredential(cctx, credID)
+	if err != nil {
+		if ctx_util.IsCtxDone(cctx, err) {
+			return LoginResult{}, ErrCtxError
+		}
+		if errors.Is(err, repos.ErrNotFound) {
+			s.events.Record(ctx, Event(models.EventLoginFailure, false, 0, "", deviceID, "passkey:unknown_credential"))
+			return LoginResult{}, ErrUnauthorized
+		}
+		logx.LogError(ctx, "AuthSvc.FinishPasskeyLogin.GetCredential", err)
+		return LoginResult{}, ErrInternalServer
+	}
+	if len(userHandle) > 0 && !bytes.Equal(userHandle, passkeyUserHandle(c.UserID)) {
+		return LoginResult{}, ErrUnauthorized
+	}
+
+	// 4. Verify assertion and counter
+	a, err := s.rp.VerifyAssertion(ch.Challenge, c.PublicKey, clientData, authData, sig)
+	if err != nil {
+		s.events.Record(ctx, Event(models.EventLoginFailure, false, c.UserID, "", deviceID, "passkey:"+err.Error()))
+		return LoginResult{}, ErrUnauthorized
+	}
+	if !webauthn.SignCountOK(c.SignCount, a.SignCount) {
+		s.events.Record(ctx, Event(models.EventLoginFailure, false, c.UserID, "", deviceID, "passkey:sign_count"))
+		return LoginResult{}, ErrUnauthorized
+	}
+	if err := s.passkeys.UpdateSignCount(cctx, c.ID, c.SignCount, a.SignCount, a.BackedUp); err != nil {
+		if ctx_util.IsCtxDone(cctx, err) {
+			return LoginResult{}, ErrCtxError
+		}
+		if errors.Is(err, repos.ErrNotFound) {
+			return LoginResult{}, ErrUnauthorized
+		}
+		logx.LogError(ctx, "AuthSvc.FinishPasskeyLogin.UpdateSignCount", err)
+		return LoginResult{}, ErrInternalServer
+	}
+
+	// 5. Load user
+	user, err := s.repo.GetUserByID(cctx, c.UserID)
+	if err != nil {
+		if ctx_util.IsCtxDone(cctx, err) {
+			return LoginResult{}, ErrCtxError
+		}
+		if errors.Is(err, repos.ErrNotFound) {
+			return LoginResult{}, ErrUnauthorized
+		}
+		logx.LogError(ctx, "AuthSvc.FinishPasskeyLogin.GetUserByID", err)
+		return LoginResult{}, ErrInternalServer
+	}
+	if user.IsDeleted {
+		return LoginResult{}, ErrUnauthorized
+	}
+	if user.LockedAt != nil {
+		s.events.Record(ctx, Event(models.EventLoginFailure, false, user.UserID, user.Email, deviceID, "locked"))
+		return LoginResult{}, ErrForbidden
+	}
+
+	// 6. Second factor unless the authenticator verified the user
+	if !a.UserVerified {
+		if mc, err := s.mfaChallenge(ctx, cctx, "AuthSvc.FinishPasskeyLogin", user, deviceID, "login"); err != nil || mc != nil {
+			return LoginResult{Challenge: mc}, err
+		}
+	}
+
+	// 7. Sign tokens and store device id and session
+	resp, err := s.issueSession(ctx, cctx, "AuthSvc.FinishPasskeyLogin", user.UserID, user.Email, user.TokenV, deviceID, "passkey")
+	if err != nil {
+		return LoginResult{}, err
+	}
+	s.events.Record(ctx, Event(models.EventLoginSuccess, true, user.UserID, user.Email, deviceID, "passkey"))
+	return LoginResult{Tokens: &resp}, nil
+}
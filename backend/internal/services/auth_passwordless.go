@@ -0,0 +1,207 @@
+package services
+
+import (
+	"backend/internal/models"
+	"backend/internal/pkg/ctx_util"
+	"backend/internal/pkg/logx"
+	"backend/internal/repos"
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RequestLoginCode Email a sign-in code and a magic link, both bound to deviceID. Unknown
+// emails get the same answer and no email, so the endpoint does not reveal who has an account
+func (s *authService) RequestLoginCode(ctx context.Context, email, deviceID string) (string, error) {
+
+	// 0. Create sub context
+	cctx, cancel := context.WithTimeout(ctx, s.settings.Current().Timeouts.RequestCode)
+	defer cancel()
+
+	// 1. Check input
+	email = strings.ToLower(strings.TrimSpace(email))
+	if !isValidEmail(email, s.reputation.Lists(), emailExisting) || !isUUID(deviceID) {
+		return "", ErrBadRequest
+	}
+
+	// 2. Generate code & code id
+	code, err := generateCode()
+	if err != nil {
+		logx.LogError(ctx, "AuthSvc.RequestLoginCode.GenerateCode", err)
+		return "", ErrInternalServer
+	}
+	codeID := uuid.NewString()
+
+	// 3. Call repo: Check throttle -> Set throttle -> Store code, then bind it to the device
+	rt := s.settings.Current().RedisTTL
+	throttled, err := s.repo.StoreOTPAndThrottle(cctx, email, sceneLogin, codeID, code, rt.OTP, rt.OTPThrottle)
+	if err != nil {
+		if ctx_util.IsCtxDone(cctx, err) {
+			return "", ErrCtxError
+		}
+		logx.LogError(ctx, "AuthSvc.RequestLoginCode.StoreOTPAndThrottle", err)
+		return "", ErrInternalServer
+	}
+	if throttled {
+		s.events.Record(ctx, Event(models.EventThrottled, false, 0, email, deviceID, "request_code:"+sceneLogin))
+		return "", ErrTooManyRequest
+	}
+	ttl := time.Duration(rt.OTP) * time.Second
+	req := models.LoginRequest{Email: email, DeviceID: deviceID}
+	if err := s.repo.StoreLoginRequest(cctx, codeID, req, ttl); err != nil {
+		if ctx_util.IsCtxDone(cctx, err) {
+			return "", ErrCtxError
+		}
+		logx.LogError(ctx, "AuthSvc.RequestLoginCode.StoreLoginRequest", err)
+		return "", ErrInternalServer
+	}
+
+	// 4. Only existing accounts get an email
+	user, err := s.repo.GetUserByEmail(cctx, email)
+	if err != nil {
+		if ctx_util.IsCtxDone(cctx, err) {
+			return "", ErrCtxError
+		}
+		if errors.Is(err, repos.ErrNotFound) {
+			s.events.Record(ctx, Event(models.EventCodeRequested, false, 0, email, deviceID, sceneLogin+":unknown_email"))
+			return codeID, nil
+		}
+		logx.LogError(ctx, "AuthSvc.RequestLoginCode.GetUserByEmail", err)
+		return "", ErrInternalServer
+	}
+	s.events.Record(ctx, Event(models.EventCodeRequested, true, user.UserID, email, deviceID, sceneLogin))
+
+	// 5. Sign the link, its jti is the code id so both redeem the same request
+	token, err := s.tokens.SignOTT(email, sceneLogin, codeID, ttl)
+	if err != nil {
+		logx.LogError(ctx, "AuthSvc.RequestLoginCode.SignOTT", err)
+		return "", ErrInternalServer
+	}
+	link := emailLink(s.cfg, "/magic-login", token)
+
+	// 6. Send code and link
+	if err := s.mailer.Send(ctx, loginMail(mailLocale(ctx, user), email, code, link, rt.OTP)); err != nil {
+		logx.LogError(ctx, "AuthSvc.RequestLoginCode.Send", err)
+		return "", ErrInternalServer
+	}
+	return codeID, nil
+}
+
+// LoginWithCode Redeem the emailed code, with the same verify throttle as every other scene
+func (s *authService) LoginWithCode(ctx context.Context, email, codeID, code, deviceID string) (LoginResult, error) {
+
+	// 0. Create sub context
+	cctx, cancel := context.WithTimeout(ctx, s.settings.Current().Timeouts.Login)
+	defer cancel()
+
+	// 1. Check input
+	email = strings.ToLower(strings.TrimSpace(email))
+	if !isValidEmail(email, s.reputation.Lists(), emailExisting) || len(code) != 6 || !isUUID(codeID) || !isUUID(deviceID) {
+		return LoginResult{}, ErrBadRequest
+	}
+
+	// 2. Check throttle -> Match code -> Consume code
+	rt := s.settings.Current().RedisTTL
+	if err := s.repo.ThrottleMatchAndConsumeCode(cctx, email, sceneLogin, codeID, code, uuid.NewString(),
+		rt.VerifyWindowLimit, rt.VerifyWindow, 1); err != nil {
+		if ctx_util.IsCtxDone(cctx, err) {
+			return LoginResult{}, ErrCtxError
+		}
+		switch {
+		case errors.Is(err, repos.ErrOTPInvalid) || errors.Is(err, repos.ErrOTPExpired):
+			s.events.Record(ctx, Event(models.EventCodeVerifyFailed, false, 0, email, deviceID, sceneLogin))
+			return LoginResult{}, ErrUnauthorized
+		case errors.Is(err, repos.ErrRateLimited):
+			s.events.Record(ctx, Event(models.EventThrottled, false, 0, email, deviceID, "verify_code:"+sceneLogin))
+			return LoginResult{}, ErrTooManyRequest
+		default:
+			logx.LogError(ctx, "AuthSvc.LoginWithCode.ThrottleMatchAndConsumeCode", err)
+			return LoginResult{}, ErrInternalServer
+		}
+	}
+
+	// 3. Redeem the request and log in
+	return s.redeemLoginRequest(ctx, cctx, "AuthSvc.LoginWithCode", codeID, email, deviceID, "email_code")
+}
+
+// LoginWithMagicLink Redeem the emailed link; the app forwards the token it was opened with
+func (s *authService) LoginWithMagicLink(ctx context.Context, token, deviceID string) (LoginResult, error) {
+
+	// 0. Create sub context
+	cctx, cancel := context.WithTimeout(ctx, s.settings.Current().Timeouts.Login)
+	defer cancel()
+
+	// 1. Check input
+	if token == "" || !isUUID(deviceID) {
+		return LoginResult{}, ErrBadRequest
+	}
+
+	// 2. Verify link
+	claims, err := s.tokens.ParseOTT(token)
+	if err != nil || claims.Scene != sceneLogin || !isUUID(claims.ID) {
+		s.events.Record(ctx, Event(models.EventLoginFailure, false, 0, "", deviceID, "magic_link:invalid"))
+		return LoginResult{}, ErrUnauthorized
+	}
+
+	// 3. Redeem the request and log in
+	return s.redeemLoginRequest(ctx, cctx, "AuthSvc.LoginWithMagicLink", claims.ID, claims.Email, deviceID, "magic_link")
+}
+
+// redeemLoginRequest Take the request behind a code or link, which must have been made for
+// this email on this device, then log in like any other method
+func (s *authService) redeemLoginRequest(ctx, cctx context.Context, op, codeID, email, deviceID, via string) (LoginResult, error) {
+
+	// 1. One-time use: whichever of code and link comes first takes the request
+	req, err := s.repo.TakeLoginRequest(cctx, codeID)
+	if err != nil {
+		if ctx_util.IsCtxDone(cctx, err) {
+			return LoginResult{}, ErrCtxError
+		}
+		if errors.Is(err, repos.ErrNotFound) {
+			s.events.Record(ctx, Event(models.EventLoginFailure, false, 0, email, deviceID, via+":used"))
+			return LoginResult{}, ErrUnauthorized
+		}
+		logx.LogError(ctx, op+".TakeLoginRequest", err)
+		return LoginResult{}, ErrInternalServer
+	}
+
+	// 2. Device binding, a forwarded code or link does not work elsewhere
+	if req.Email != email || req.DeviceID != deviceID {
+		s.events.Record(ctx, Event(models.EventLoginFailure, false, 0, email, deviceID, via+":device_mismatch"))
+		return LoginResult{}, ErrUnauthorized
+	}
+
+	// 3. Load user
+	user, err := s.repo.GetUserByEmail(cctx, email)
+	if err != nil {
+		if ctx_util.IsCtxDone(cctx, err) {
+			return LoginResult{}, ErrCtxError
+		}
+		if errors.Is(err, repos.ErrNotFound) {
+			s.events.Record(ctx, Event(models.EventLoginFailure, false, 0, email, deviceID, "unknown_email"))
+			return LoginResult{}, ErrUnauthorized
+		}
+		logx.LogError(ctx, op+".GetUserByEmail", err)
+		return LoginResult{}, ErrInternalServer
+	}
+	if user.LockedAt != nil {
+		s.events.Record(ctx, Event(models.EventLoginFailure, false, user.UserID, email, deviceID, "locked"))
+		return LoginResult{}, ErrForbidden
+	}
+
+	// 4. Second factor: the mailbox is one factor only
+	if ch, err := s.mfaChallenge(ctx, cctx, op, user, deviceID, "login"); err != nil || ch != nil {
+		return LoginResult{Challenge: ch}, err
+	}
+
+	// 5. Sign tokens and store device id and session
+	resp, err := s.issueSession(ctx, cctx, op, user.UserID, email, user.TokenV, deviceID, via)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	s.events.Record(ctx, Event(models.EventLoginSuccess, true, user.UserID, email, deviceID, via))
+	return LoginResult{Tokens: &resp}, nil
+}
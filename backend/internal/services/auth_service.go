@@ -2,36 +2,76 @@ package services
 
 import (
 	"backend/internal/config"
+	"backend/internal/models"
 	"backend/internal/pkg/ctx_util"
+	"backend/internal/pkg/i18n"
 	"backend/internal/pkg/jwtx"
 	"backend/internal/pkg/logx"
+	"backend/internal/pkg/mailx"
+	"backend/internal/pkg/oidc"
+	"backend/internal/pkg/passwordhash"
+	"backend/internal/pkg/pwpolicy"
+	"backend/internal/pkg/reputation"
+	"backend/internal/pkg/secretbox"
+	"backend/internal/pkg/webauthn"
 	"backend/internal/repos"
+	"backend/internal/settings"
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"log"
 	"math/big"
 	"net"
 	"net/mail"
 	"regexp"
 	"strings"
 	"time"
-	"unicode"
 	"unicode/utf8"
 
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type AuthService interface {
-	Login(ctx context.Context, ip, email, password, deviceID string) (AuthResponse, error)
+	Login(ctx context.Context, ip, email, password, deviceID string) (LoginResult, error)
 	CreateAccount(ctx context.Context, email, scene, jti, pwd, deviceID string) (AuthResponse, error)
 	VerifyCodeAndGenToken(ctx context.Context, email, scene, codeID, code string) (string, error)
-	RequestCode(ctx context.Context, email, scene string) (string, error)
+	RequestCode(ctx context.Context, ip, email, scene string) (string, error)
+	Authenticate(ctx context.Context, atk string) (Principal, error)
+	RestoreAccount(ctx context.Context, email string, proof RestoreProof, deviceID string) (LoginResult, error)
+	VerifyMFA(ctx context.Context, ip, mfaToken, code string) (AuthResponse, error)
+	BeginPasskeyLogin(ctx context.Context) (PasskeyOptions, error)
+	FinishPasskeyLogin(ctx context.Context, challengeID, deviceID string, cred PasskeyCredential) (LoginResult, error)
+	ChangePassword(ctx context.Context, ip string, p Principal, current, next string, revokeOthers bool) (*AuthResponse, error)
+	LoginWithOIDC(ctx context.Context, provider, idToken, nonce, deviceID string) (LoginResult, error)
+	RequestLoginCode(ctx context.Context, email, deviceID string) (string, error)
+	LoginWithCode(ctx context.Context, email, codeID, code, deviceID string) (LoginResult, error)
+	LoginWithMagicLink(ctx context.Context, token, deviceID string) (LoginResult, error)
 }
+
+// Principal The caller behind a verified access token
+type Principal struct {
+	UserID   uint64
+	Email    string
+	Role     string
+	DeviceID string
+	IssuedAt time.Time
+	// Locale The user's chosen language, "" to follow Accept-Language
+	Locale string
+}
+
+// RestoreProof How the owner of a deleted account proves it is them: exactly one of the
+// password, an ID token of a linked identity, or a restore_account email code
+type RestoreProof struct {
+	Password string
+	Provider string
+	IDToken  string
+	Nonce    string
+	CodeID   string
+	Code     string
+}
+
 type AuthResponse struct {
 	ATK       string `json:"access_token"`
 	TokenType string `json:"token_type"`
@@ -40,103 +80,90 @@ type AuthResponse struct {
 	UserID    uint64 `json:"user_id"`
 }
 
-func (s *authService) Login(ctx context.Context, ip, email, password, deviceID string) (AuthResponse, error) {
+func (s *authService) Login(ctx context.Context, ip, email, password, deviceID string) (LoginResult, error) {
 
 	// 0. Create sub context
-	cctx, cancel := context.WithTimeout(ctx, config.C.Timeouts.Login)
+	cctx, cancel := context.WithTimeout(ctx, s.settings.Current().Timeouts.Login)
 	defer cancel()
 
 	// 1. Check input
-	if !isValidEmail(email) || !isValidPassword(password) || !isUUID(deviceID) {
-		return AuthResponse{}, ErrBadRequest
+	if !isValidEmail(email, s.reputation.Lists(), emailExisting) || !isValidPassword(password) || !isUUID(deviceID) {
+		return LoginResult{}, ErrBadRequest
 	}
 
 	// 2. Check login throttle
 	rl, err := s.repo.CheckLoginThrottle(cctx, ip, email)
 	if err != nil {
 		if ctx_util.IsCtxDone(cctx, err) {
-			return AuthResponse{}, ErrCtxError
+			return LoginResult{}, ErrCtxError
 		}
 		logx.LogError(ctx, "AuthSvc.CheckLoginThrottle", err)
-		return AuthResponse{}, ErrInternalServer
+		return LoginResult{}, ErrInternalServer
 	}
 	if rl {
-		return AuthResponse{}, ErrTooManyRequest
+		s.events.Record(ctx, Event(models.EventThrottled, false, 0, email, deviceID, "login"))
+		s.reputation.Trip(ctx, ip)
+		return LoginResult{}, ErrTooManyRequest
 	}
 
 	// 3. Check password
 	user, err := s.repo.GetUserByEmail(ctx, email)
 	if err != nil {
 		if ctx_util.IsCtxDone(cctx, err) {
-			return AuthResponse{}, ErrCtxError
+			return LoginResult{}, ErrCtxError
 		}
 		switch {
+		case errors.Is(err, repos.ErrNotFound):
+			s.events.Record(ctx, Event(models.EventLoginFailure, false, 0, email, deviceID, "unknown_email"))
+			s.countLoginFail(ctx, "AuthSvc.Login", ip, email)
+			return LoginResult{}, s.passwords.miss(cctx, "AuthSvc.Login", password)
 		default:
 			logx.LogError(ctx, "AuthSvc.CreateAccount.Login", err)
-			return AuthResponse{}, ErrInternalServer
+			return LoginResult{}, ErrInternalServer
 		}
 	}
-	err = bcrypt.CompareHashAndPassword([]byte(user.PwdHash), []byte(password))
-	if err != nil {
-		return AuthResponse{}, ErrUnauthorized
-	}
-
-	// 4. Update redis cnt/lock
-	s.repo.ClearLoginCntLock(ip, email)
-
-	// 5. Sign tokens
-	ttl := config.C.JWT.ATK
-	ttlDur := time.Duration(ttl) * time.Second
-	var atk string
-	for i := 0; i < 3; i++ {
-		atk, err = jwtx.SignATK(user.UserID, user.TokenV, ttlDur)
-		if err == nil {
-			break
+	if err := s.passwords.check(cctx, "AuthSvc.Login", user, password); err != nil {
+		if errors.Is(err, ErrUnauthorized) {
+			s.events.Record(ctx, Event(models.EventLoginFailure, false, user.UserID, email, deviceID, "bad_password"))
+			s.countLoginFail(ctx, "AuthSvc.Login", ip, email)
 		}
+		return LoginResult{}, err
 	}
-	if err != nil {
-		logx.LogError(ctx, "AuthSvc.CreateAccount.SignATK", err)
-		return AuthResponse{}, ErrInternalServer
+	if user.LockedAt != nil {
+		s.events.Record(ctx, Event(models.EventLoginFailure, false, user.UserID, email, deviceID, "locked"))
+		return LoginResult{}, ErrForbidden
 	}
-	rtk, rtkHash, err := generateRTK()
-	if err != nil {
-		logx.LogError(ctx, "AuthSvc.CreateAccount.GenerateRTK", err)
-		return AuthResponse{}, ErrInternalServer
+
+	// 4. Forgive earlier wrong passwords from this IP
+	s.clearLoginFails(ctx, "AuthSvc.Login", ip, email)
+
+	// 5. Second factor: hand out a challenge instead of tokens
+	if ch, err := s.mfaChallenge(ctx, cctx, "AuthSvc.Login", user, deviceID, "login"); err != nil || ch != nil {
+		return LoginResult{Challenge: ch}, err
 	}
 
-	// 6. Store device id and session
-	u, err := uuid.Parse(deviceID)
+	// 6. Sign tokens and store device id and session
+	resp, err := s.issueSession(ctx, cctx, "AuthSvc.Login", user.UserID, email, user.TokenV, deviceID, "login")
 	if err != nil {
-		logx.LogError(ctx, "AuthSvc.CreateAccount.ParseUUID", err)
-		return AuthResponse{}, ErrInternalServer
-	}
-	didByte := u[:]
-	exp := time.Now().Add(config.C.JWT.RTK)
-	if err := s.repo.StoreDIDAndSession(cctx, user.UserID, didByte, nil, rtkHash, user.TokenV, exp); err != nil {
-		if ctx_util.IsCtxDone(ctx, err) {
-			return AuthResponse{}, ErrCtxError
-		}
+		return LoginResult{}, err
 	}
-
-	return AuthResponse{
-		ATK:       atk,
-		TokenType: "Bearer",
-		ExpiresIn: 3600,
-		RTK:       rtk,
-		UserID:    0,
-	}, nil
+	s.events.Record(ctx, Event(models.EventLoginSuccess, true, user.UserID, email, deviceID, ""))
+	return LoginResult{Tokens: &resp}, nil
 }
 
 func (s *authService) CreateAccount(ctx context.Context, email, scene, jti, pwd, deviceID string) (AuthResponse, error) {
 
 	// 0. Create sub context
-	cctx, cancel := context.WithTimeout(ctx, config.C.Timeouts.CreateAccount)
+	cctx, cancel := context.WithTimeout(ctx, s.settings.Current().Timeouts.CreateAccount)
 	defer cancel()
 
 	// 1. Check input
-	if !isValidPassword(pwd) || scene != "signup" {
+	if scene != "signup" || !isValidEmail(email, s.reputation.Lists(), emailNew) {
 		return AuthResponse{}, ErrBadRequest
 	}
+	if err := s.passwords.accept(ctx, "AuthSvc.CreateAccount", pwd, email, ""); err != nil {
+		return AuthResponse{}, err
+	}
 
 	// 2. Check conflict
 	exist, err := s.repo.CheckEmailExists(cctx, email)
@@ -152,7 +179,7 @@ func (s *authService) CreateAccount(ctx context.Context, email, scene, jti, pwd,
 	}
 
 	// 3. Check and mark jti
-	newTTL := int(config.C.JWT.OTT.Seconds())
+	newTTL := int(s.cfg.JWT.OTT.Seconds())
 	if err := s.repo.ConsumeOTTJTI(cctx, email, scene, jti, newTTL); err != nil {
 		if ctx_util.IsCtxDone(ctx, err) {
 			return AuthResponse{}, ErrCtxError
@@ -165,13 +192,12 @@ func (s *authService) CreateAccount(ctx context.Context, email, scene, jti, pwd,
 	}
 
 	// 4. Create user
-	pwdHash, err := bcrypt.GenerateFromPassword([]byte(pwd), bcrypt.DefaultCost)
+	pwdHash, err := s.passwords.hash(cctx, "AuthSvc.CreateAccount", pwd)
 	if err != nil {
-		logx.LogError(ctx, "AuthSvc.CreateAccount.Hash", err)
-		return AuthResponse{}, ErrInternalServer
+		s.repo.UndoOTTMark(cctx, email, scene, jti, newTTL)
+		return AuthResponse{}, err
 	}
-	pwdHashStr := string(pwdHash)
-	uid, tkv, err := s.repo.CreateUser(cctx, email, pwdHashStr)
+	uid, tkv, err := s.repo.CreateUser(cctx, email, pwdHash)
 	if err != nil {
 		// Rollback
 		s.repo.UndoOTTMark(cctx, email, scene, jti, newTTL)
@@ -184,60 +210,351 @@ func (s *authService) CreateAccount(ctx context.Context, email, scene, jti, pwd,
 		logx.LogError(ctx, "AuthSvc.CreateAccount.CreateUser", err)
 		return AuthResponse{}, ErrInternalServer
 	}
+	s.events.Record(ctx, Event(models.EventAccountCreated, true, uid, email, deviceID, ""))
+
+	// 5. Sign tokens and store device id and session
+	return s.issueSession(ctx, cctx, "AuthSvc.CreateAccount", uid, email, tkv, deviceID, "signup")
+}
+
+// RestoreAccount Undo a self-service deletion within the grace period and log in. Accounts
+// without a password prove ownership with a linked identity or an emailed code
+func (s *authService) RestoreAccount(ctx context.Context, email string, proof RestoreProof, deviceID string) (LoginResult, error) {
+
+	// 0. Create sub context
+	cctx, cancel := context.WithTimeout(ctx, s.settings.Current().Timeouts.Login)
+	defer cancel()
+
+	// 1. Check input
+	email = strings.ToLower(strings.TrimSpace(email))
+	if !isValidEmail(email, s.reputation.Lists(), emailExisting) || !isUUID(deviceID) {
+		return LoginResult{}, ErrBadRequest
+	}
+	given := 0
+	for _, v := range []string{proof.Password, proof.IDToken, proof.Code} {
+		if v != "" {
+			given++
+		}
+	}
+	if given != 1 {
+		return LoginResult{}, ErrBadRequest
+	}
+
+	// 2. Find the deleted account and check the proof
+	user, err := s.repo.GetDeletedUserByEmail(cctx, email)
+	if err != nil {
+		if ctx_util.IsCtxDone(cctx, err) {
+			return LoginResult{}, ErrCtxError
+		}
+		if errors.Is(err, repos.ErrNotFound) {
+			if proof.Password != "" {
+				return LoginResult{}, s.passwords.miss(cctx, "AuthSvc.RestoreAccount", proof.Password)
+			}
+			return LoginResult{}, ErrUnauthorized
+		}
+		logx.LogError(ctx, "AuthSvc.RestoreAccount.GetDeletedUserByEmail", err)
+		return LoginResult{}, ErrInternalServer
+	}
+	if err := s.checkRestoreProof(ctx, cctx, user, proof, deviceID); err != nil {
+		return LoginResult{}, err
+	}
+	if user.LockedAt != nil {
+		return LoginResult{}, ErrForbidden
+	}
+
+	// 3. Second factor: the account stays deleted until the code is verified
+	if ch, err := s.mfaChallenge(ctx, cctx, "AuthSvc.RestoreAccount", user, deviceID, "restore"); err != nil || ch != nil {
+		return LoginResult{Challenge: ch}, err
+	}
+
+	// 4. Restore and sign tokens
+	resp, err := s.restoreAndIssue(ctx, cctx, "AuthSvc.RestoreAccount", user, deviceID)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	return LoginResult{Tokens: &resp}, nil
+}
+
+// checkRestoreProof Password, an ID token of an identity linked to user, or a restore_account
+// code sent to its email; failures are recorded against user
+func (s *authService) checkRestoreProof(ctx, cctx context.Context, user *models.User, proof RestoreProof, deviceID string) error {
+	switch {
+	case proof.Password != "":
+		err := s.passwords.check(cctx, "AuthSvc.RestoreAccount", user, proof.Password)
+		if errors.Is(err, ErrUnauthorized) {
+			s.events.Record(ctx, Event(models.EventLoginFailure, false, user.UserID, user.Email, deviceID, "restore:bad_password"))
+		}
+		return err
+
+	case proof.IDToken != "":
+		id, err := s.federation.verify(ctx, "AuthSvc.RestoreAccount", proof.Provider, proof.IDToken, proof.Nonce)
+		if err != nil {
+			if errors.Is(err, ErrUnauthorized) {
+				s.events.Record(ctx, Event(models.EventLoginFailure, false, user.UserID, user.Email, deviceID, "restore:oidc:"+proof.Provider))
+			}
+			return err
+		}
+		linked, err := s.federation.repo.FindIdentity(cctx, id.Issuer, id.Subject)
+		if err != nil {
+			if ctx_util.IsCtxDone(cctx, err) {
+				return ErrCtxError
+			}
+			if !errors.Is(err, repos.ErrNotFound) {
+				logx.LogError(ctx, "AuthSvc.RestoreAccount.FindIdentity", err)
+				return ErrInternalServer
+			}
+		}
+		if linked == nil || linked.UserID != user.UserID {
+			s.events.Record(ctx, Event(models.EventLoginFailure, false, user.UserID, user.Email, deviceID, "restore:oidc_not_linked"))
+			return ErrUnauthorized
+		}
+		return nil
+
+	default:
+		if !isUUID(proof.CodeID) || len(proof.Code) != 6 {
+			return ErrBadRequest
+		}
+		rt := s.settings.Current().RedisTTL
+		err := s.repo.ThrottleMatchAndConsumeCode(cctx, user.Email, sceneRestore, proof.CodeID, proof.Code, uuid.NewString(),
+			rt.VerifyWindowLimit, rt.VerifyWindow, 1)
+		switch {
+		case err == nil:
+			return nil
+		case ctx_util.IsCtxDone(cctx, err):
+			return ErrCtxError
+		case errors.Is(err, repos.ErrOTPInvalid) || errors.Is(err, repos.ErrOTPExpired):
+			s.events.Record(ctx, Event(models.EventCodeVerifyFailed, false, user.UserID, user.Email, deviceID, sceneRestore))
+			return ErrUnauthorized
+		case errors.Is(err, repos.ErrRateLimited):
+			s.events.Record(ctx, Event(models.EventThrottled, false, user.UserID, user.Email, deviceID, "verify_code:"+sceneRestore))
+			return ErrTooManyRequest
+		default:
+			logx.LogError(ctx, "AuthSvc.RestoreAccount.ThrottleMatchAndConsumeCode", err)
+			return ErrInternalServer
+		}
+	}
+}
+
+// restoreAndIssue Undo the deletion, only while the grace period lasts, then log in
+func (s *authService) restoreAndIssue(ctx, cctx context.Context, op string, user *models.User, deviceID string) (AuthResponse, error) {
+	if err := s.repo.RestoreUser(cctx, user.UserID, time.Now().Add(-s.cfg.Account.DeletionGrace)); err != nil {
+		if ctx_util.IsCtxDone(cctx, err) {
+			return AuthResponse{}, ErrCtxError
+		}
+		if errors.Is(err, repos.ErrNotFound) {
+			return AuthResponse{}, ErrUnauthorized
+		}
+		logx.LogError(ctx, op+".RestoreUser", err)
+		return AuthResponse{}, ErrInternalServer
+	}
+	s.events.Record(ctx, Event(models.EventAccountRestored, true, user.UserID, user.Email, deviceID, ""))
+	return s.issueSession(ctx, cctx, op, user.UserID, user.Email, user.TokenV, deviceID, "restore")
+}
+
+// mfaChallenge Park a login whose password step passed when the user has a second factor;
+// nil when there is none and tokens can be issued right away
+func (s *authService) mfaChallenge(ctx, cctx context.Context, op string, user *models.User, deviceID, via string) (*MFAChallengeResponse, error) {
+
+	// 1. Second factor enabled?
+	on, err := s.mfa.enabled(cctx, user.UserID)
+	if err != nil {
+		if ctx_util.IsCtxDone(cctx, err) {
+			return nil, ErrCtxError
+		}
+		logx.LogError(ctx, op+".MFAEnabled", err)
+		return nil, ErrInternalServer
+	}
+	if !on {
+		return nil, nil
+	}
+
+	// 2. Store challenge under the hash of an opaque token
+	token, _, err := generateRTK()
+	if err != nil {
+		logx.LogError(ctx, op+".GenerateMFAToken", err)
+		return nil, ErrInternalServer
+	}
+	ttl := s.cfg.MFA.ChallengeTTL
+	ch := models.MFAChallenge{UserID: user.UserID, Email: user.Email, DeviceID: deviceID, Via: via}
+	if err := s.mfa.repo.StoreChallenge(cctx, hashToken(token), ch, ttl); err != nil {
+		if ctx_util.IsCtxDone(cctx, err) {
+			return nil, ErrCtxError
+		}
+		logx.LogError(ctx, op+".StoreChallenge", err)
+		return nil, ErrInternalServer
+	}
+	return &MFAChallengeResponse{
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresIn:   int(ttl.Seconds()),
+		Methods:     []string{mfaMethodTOTP, mfaMethodRecovery},
+	}, nil
+}
+
+// VerifyMFA Finish a login parked by mfaChallenge with a TOTP or recovery code. Every wrong
+// code trips ip and counts against the user, who is locked out of the second factor across
+// challenges once MFA_USER_ATTEMPTS is reached
+func (s *authService) VerifyMFA(ctx context.Context, ip, mfaToken, code string) (AuthResponse, error) {
+
+	// 0. Create sub context
+	cctx, cancel := context.WithTimeout(ctx, s.settings.Current().Timeouts.Login)
+	defer cancel()
+
+	// 1. Check input
+	if mfaToken == "" || len(mfaToken) > 128 || code == "" || len(code) > 32 {
+		return AuthResponse{}, ErrBadRequest
+	}
+
+	// 2. Load challenge
+	tokenHash := hashToken(mfaToken)
+	ch, err := s.mfa.repo.GetChallenge(cctx, tokenHash)
+	if err != nil {
+		if ctx_util.IsCtxDone(cctx, err) {
+			return AuthResponse{}, ErrCtxError
+		}
+		if errors.Is(err, repos.ErrNotFound) {
+			return AuthResponse{}, ErrUnauthorized
+		}
+		logx.LogError(ctx, "AuthSvc.VerifyMFA.GetChallenge", err)
+		return AuthResponse{}, ErrInternalServer
+	}
 
-	// 5. Sign token
-	ttl := config.C.JWT.ATK
+	// 3. Check code, a challenge only takes a few wrong ones and the user a few more
+	method, err := s.mfa.check(cctx, ch.UserID, code)
+	if err != nil {
+		if ctx_util.IsCtxDone(cctx, err) {
+			return AuthResponse{}, ErrCtxError
+		}
+		if !errors.Is(err, ErrUnauthorized) && !errors.Is(err, ErrTooManyRequest) {
+			logx.LogError(ctx, "AuthSvc.VerifyMFA.Check", err)
+			return AuthResponse{}, ErrInternalServer
+		}
+		s.events.Record(ctx, Event(models.EventMFAFailed, false, ch.UserID, ch.Email, ch.DeviceID, ch.Via))
+		s.reputation.Trip(ctx, ip)
+		exhausted := errors.Is(err, ErrTooManyRequest)
+		if exhausted {
+			if _, err := s.mfa.repo.DeleteChallenge(cctx, tokenHash); err != nil {
+				logx.LogError(ctx, "AuthSvc.VerifyMFA.DeleteChallenge", err)
+			}
+		} else if exhausted, err = s.mfa.repo.FailChallenge(cctx, tokenHash, s.cfg.MFA.ChallengeAttempts, s.cfg.MFA.ChallengeTTL); err != nil {
+			logx.LogError(ctx, "AuthSvc.VerifyMFA.FailChallenge", err)
+		}
+		if exhausted {
+			s.events.Record(ctx, Event(models.EventThrottled, false, ch.UserID, ch.Email, ch.DeviceID, "mfa"))
+			return AuthResponse{}, ErrTooManyRequest
+		}
+		return AuthResponse{}, ErrUnauthorized
+	}
+
+	// 4. Consume challenge, a concurrent request may have won
+	ok, err := s.mfa.repo.DeleteChallenge(cctx, tokenHash)
+	if err != nil {
+		if ctx_util.IsCtxDone(cctx, err) {
+			return AuthResponse{}, ErrCtxError
+		}
+		logx.LogError(ctx, "AuthSvc.VerifyMFA.DeleteChallenge", err)
+		return AuthResponse{}, ErrInternalServer
+	}
+	if !ok {
+		return AuthResponse{}, ErrUnauthorized
+	}
+	if method == mfaMethodRecovery {
+		s.events.Record(ctx, Event(models.EventMFARecoveryUsed, true, ch.UserID, ch.Email, ch.DeviceID, ""))
+	}
+
+	// 5. Re-read the user, it may have been locked or changed meanwhile
+	user, err := s.repo.GetUserByID(cctx, ch.UserID)
+	if err != nil {
+		if ctx_util.IsCtxDone(cctx, err) {
+			return AuthResponse{}, ErrCtxError
+		}
+		if errors.Is(err, repos.ErrNotFound) {
+			return AuthResponse{}, ErrUnauthorized
+		}
+		logx.LogError(ctx, "AuthSvc.VerifyMFA.GetUserByID", err)
+		return AuthResponse{}, ErrInternalServer
+	}
+	if user.LockedAt != nil {
+		return AuthResponse{}, ErrForbidden
+	}
+	if user.IsDeleted != (ch.Via == "restore") {
+		return AuthResponse{}, ErrUnauthorized
+	}
+
+	// 6. Sign tokens and store device id and session
+	if ch.Via == "restore" {
+		return s.restoreAndIssue(ctx, cctx, "AuthSvc.VerifyMFA", user, ch.DeviceID)
+	}
+	resp, err := s.issueSession(ctx, cctx, "AuthSvc.VerifyMFA", user.UserID, user.Email, user.TokenV, ch.DeviceID, "login")
+	if err != nil {
+		return AuthResponse{}, err
+	}
+	s.events.Record(ctx, Event(models.EventLoginSuccess, true, user.UserID, user.Email, ch.DeviceID, "mfa:"+method))
+	return resp, nil
+}
+
+// issueSession Sign an access token, then store the device and a fresh refresh-token session
+func (s *authService) issueSession(ctx, cctx context.Context, op string, uid uint64, email string, tkv uint, deviceID, via string) (AuthResponse, error) {
+
+	// 1. Sign tokens
+	ttl := s.cfg.JWT.ATK
 	ttlDur := time.Duration(ttl) * time.Second
-	var atk string
+	var (
+		atk string
+		err error
+	)
 	for i := 0; i < 3; i++ {
-		atk, err = jwtx.SignATK(uid, tkv, ttlDur)
+		atk, err = s.tokens.SignATK(uid, tkv, deviceID, ttlDur)
 		if err == nil {
 			break
 		}
 	}
 	if err != nil {
-		logx.LogError(ctx, "AuthSvc.CreateAccount.SignATK", err)
+		logx.LogError(ctx, op+".SignATK", err)
 		return AuthResponse{}, ErrInternalServer
 	}
 	rtk, rtkHash, err := generateRTK()
 	if err != nil {
-		logx.LogError(ctx, "AuthSvc.CreateAccount.GenerateRTK", err)
+		logx.LogError(ctx, op+".GenerateRTK", err)
 		return AuthResponse{}, ErrInternalServer
 	}
 
-	// 6. Store device id and session
+	// 2. Store device id and session
 	u, err := uuid.Parse(deviceID)
 	if err != nil {
-		logx.LogError(ctx, "AuthSvc.CreateAccount.ParseUUID", err)
+		logx.LogError(ctx, op+".ParseUUID", err)
 		return AuthResponse{}, ErrInternalServer
 	}
 	didByte := u[:]
-	exp := time.Now().Add(config.C.JWT.RTK)
+	exp := time.Now().Add(s.cfg.JWT.RTK)
 	if err := s.repo.StoreDIDAndSession(cctx, uid, didByte, nil, rtkHash, tkv, exp); err != nil {
 		if ctx_util.IsCtxDone(ctx, err) {
 			return AuthResponse{}, ErrCtxError
 		}
+		logx.LogError(ctx, op+".StoreDIDAndSession", err)
+		return AuthResponse{}, ErrInternalServer
 	}
+	s.events.Record(ctx, Event(models.EventSessionCreated, true, uid, email, deviceID, via))
 
 	return AuthResponse{
-		atk,
-		"Bearer",
-		config.C.JWT.ATK,
-		rtk,
-		uid,
+		ATK:       atk,
+		TokenType: "Bearer",
+		ExpiresIn: ttl,
+		RTK:       rtk,
+		UserID:    uid,
 	}, nil
 }
 
 func (s *authService) VerifyCodeAndGenToken(ctx context.Context, email, scene, codeID, code string) (string, error) {
 
 	// 0. Create sub context
-	cctx, cancel := context.WithTimeout(ctx, config.C.Timeouts.VerifyCode)
+	cctx, cancel := context.WithTimeout(ctx, s.settings.Current().Timeouts.VerifyCode)
 	defer cancel()
 
 	// 1. Check email & scene
 	email = strings.ToLower(strings.TrimSpace(email))
 	scene = strings.TrimSpace(scene)
-	if !isValidEmail(email) || !isValidScene(scene) || len(code) != 6 || !isUUID(codeID) {
+	if !isValidEmail(email, s.reputation.Lists(), emailUseOf(scene)) || !isValidScene(scene) || scene == sceneRestore ||
+		len(code) != 6 || !isUUID(codeID) {
 		return "", ErrBadRequest
 	}
 
@@ -245,25 +562,28 @@ func (s *authService) VerifyCodeAndGenToken(ctx context.Context, email, scene, c
 	jti := uuid.NewString()
 
 	// 3. Sign token
-	ttl := config.C.JWT.OTT
-	token, err := jwtx.SignOTT(email, scene, jti, ttl)
+	ttl := s.cfg.JWT.OTT
+	token, err := s.tokens.SignOTT(email, scene, jti, ttl)
 	if err != nil {
 		logx.LogError(ctx, "AuthSvc.VerifyCodeAndGenToken.SignOTT", err)
 		return "", ErrInternalServer
 	}
 
 	// 4. Call repo: Check throttle -> Match code -> Consume code -> Set jti unused
-	verifyLimit := config.C.RedisTTL.VerifyWindowLimit
-	window := config.C.RedisTTL.VerifyWindow
-	jtiUsedTTL := int(config.C.JWT.OTT.Seconds())
-	if s.repo.ThrottleMatchAndConsumeCode(cctx, email, scene, codeID, code, jti, verifyLimit, window, jtiUsedTTL) != nil {
+	rt := s.settings.Current().RedisTTL
+	verifyLimit := rt.VerifyWindowLimit
+	window := rt.VerifyWindow
+	jtiUsedTTL := int(s.cfg.JWT.OTT.Seconds())
+	if err := s.repo.ThrottleMatchAndConsumeCode(cctx, email, scene, codeID, code, jti, verifyLimit, window, jtiUsedTTL); err != nil {
 		if ctx_util.IsCtxDone(cctx, err) {
 			return "", ErrCtxError
 		}
 		switch {
 		case errors.Is(err, repos.ErrOTPInvalid) || errors.Is(err, repos.ErrOTPExpired):
+			s.events.Record(ctx, Event(models.EventCodeVerifyFailed, false, 0, email, "", scene))
 			return "", ErrUnauthorized
 		case errors.Is(err, repos.ErrRateLimited):
+			s.events.Record(ctx, Event(models.EventThrottled, false, 0, email, "", "verify_code:"+scene))
 			return "", ErrTooManyRequest
 		default:
 			logx.LogError(ctx, "AuthSvc.VerifyCodeAndGenToken.ThrottleMatchAndConsumeCode", err)
@@ -274,16 +594,16 @@ func (s *authService) VerifyCodeAndGenToken(ctx context.Context, email, scene, c
 	return token, nil
 }
 
-func (s *authService) RequestCode(ctx context.Context, email, scene string) (string, error) {
+func (s *authService) RequestCode(ctx context.Context, ip, email, scene string) (string, error) {
 
 	// 0. Create sub context
-	cctx, cancel := context.WithTimeout(ctx, config.C.Timeouts.RequestCode)
+	cctx, cancel := context.WithTimeout(ctx, s.settings.Current().Timeouts.RequestCode)
 	defer cancel()
 
 	// 1. Check email & scene
 	email = strings.ToLower(strings.TrimSpace(email))
 	scene = strings.TrimSpace(scene)
-	if !isValidEmail(email) || !isValidScene(scene) {
+	if !isValidEmail(email, s.reputation.Lists(), emailUseOf(scene)) || !isValidScene(scene) {
 		return "", ErrBadRequest
 	}
 
@@ -296,8 +616,9 @@ func (s *authService) RequestCode(ctx context.Context, email, scene string) (str
 	codeID := uuid.NewString()
 
 	// 3. Call repo: Check throttle -> Set throttle -> Store code
-	otpTTL := config.C.RedisTTL.OTP
-	throttleTTL := config.C.RedisTTL.OTPThrottle
+	rt := s.settings.Current().RedisTTL
+	otpTTL := rt.OTP
+	throttleTTL := rt.OTPThrottle
 	throttled, err := s.repo.StoreOTPAndThrottle(cctx, email, scene, codeID, code, otpTTL, throttleTTL)
 	if err != nil {
 		if ctx_util.IsCtxDone(cctx, err) {
@@ -307,14 +628,171 @@ func (s *authService) RequestCode(ctx context.Context, email, scene string) (str
 		return "", ErrInternalServer
 	}
 	if throttled {
+		s.events.Record(ctx, Event(models.EventThrottled, false, 0, email, "", "request_code:"+scene))
+		s.reputation.Trip(ctx, ip)
 		return "", ErrTooManyRequest
 	}
+	s.events.Record(ctx, Event(models.EventCodeRequested, true, 0, email, "", scene))
 
 	// 4. Send code
-	log.Printf("[DEV] verification code for %s: %s", email, code)
+	if err := s.mailer.Send(ctx, codeMail(i18n.From(ctx), email, scene, code, otpTTL)); err != nil {
+		logx.LogError(ctx, "AuthSvc.RequestCode.Send", err)
+		return "", ErrInternalServer
+	}
 	return codeID, nil
 }
 
+// ChangePassword Replace a known password. With revokeOthers every other session is
+// logged out and fresh tokens for the caller's device are returned, otherwise nil
+func (s *authService) ChangePassword(ctx context.Context, ip string, p Principal, current, next string, revokeOthers bool) (*AuthResponse, error) {
+
+	// 0. Create sub context
+	cctx, cancel := context.WithTimeout(ctx, s.settings.Current().Timeouts.Login)
+	defer cancel()
+
+	// 1. Check input
+	if !isValidPassword(current) || !isValidPassword(next) || current == next || (revokeOthers && !isUUID(p.DeviceID)) {
+		return nil, ErrBadRequest
+	}
+
+	// 2. Check login throttle
+	rl, err := s.repo.CheckLoginThrottle(cctx, ip, p.Email)
+	if err != nil {
+		if ctx_util.IsCtxDone(cctx, err) {
+			return nil, ErrCtxError
+		}
+		logx.LogError(ctx, "AuthSvc.ChangePassword.CheckLoginThrottle", err)
+		return nil, ErrInternalServer
+	}
+	if rl {
+		s.events.Record(ctx, Event(models.EventThrottled, false, p.UserID, p.Email, p.DeviceID, "change_password"))
+		return nil, ErrTooManyRequest
+	}
+
+	// 3. Check current password
+	user, err := s.repo.GetUserByID(cctx, p.UserID)
+	if err != nil {
+		if ctx_util.IsCtxDone(cctx, err) {
+			return nil, ErrCtxError
+		}
+		if errors.Is(err, repos.ErrNotFound) {
+			return nil, ErrUnauthorized
+		}
+		logx.LogError(ctx, "AuthSvc.ChangePassword.GetUserByID", err)
+		return nil, ErrInternalServer
+	}
+	if err := s.passwords.check(cctx, "AuthSvc.ChangePassword", user, current); err != nil {
+		if !errors.Is(err, ErrUnauthorized) {
+			return nil, err
+		}
+		s.countLoginFail(ctx, "AuthSvc.ChangePassword", ip, p.Email)
+		s.events.Record(ctx, Event(models.EventLoginFailure, false, p.UserID, p.Email, p.DeviceID, "change_password:bad_password"))
+		return nil, ErrUnauthorized
+	}
+	s.clearLoginFails(ctx, "AuthSvc.ChangePassword", ip, p.Email)
+
+	// 4. Check new password against the policy
+	if err := s.passwords.accept(ctx, "AuthSvc.ChangePassword", next, p.Email, user.Username); err != nil {
+		return nil, err
+	}
+
+	// 5. Store new hash, revoking other sessions if asked
+	pwdHash, err := s.passwords.hash(cctx, "AuthSvc.ChangePassword", next)
+	if err != nil {
+		return nil, err
+	}
+	var keep []byte
+	if revokeOthers {
+		u, _ := uuid.Parse(p.DeviceID)
+		keep = u[:]
+	}
+	tkv, err := s.repo.UpdatePassword(cctx, p.UserID, pwdHash, revokeOthers, keep)
+	if err != nil {
+		if ctx_util.IsCtxDone(cctx, err) {
+			return nil, ErrCtxError
+		}
+		if errors.Is(err, repos.ErrNotFound) {
+			return nil, ErrUnauthorized
+		}
+		logx.LogError(ctx, "AuthSvc.ChangePassword.UpdatePassword", err)
+		return nil, ErrInternalServer
+	}
+	s.events.Record(ctx, Event(models.EventPasswordChanged, true, p.UserID, p.Email, p.DeviceID, ""))
+
+	// 6. Notify; a failed mail does not undo the change
+	if err := s.mailer.Send(ctx, passwordChangedMail(mailLocale(ctx, user), p.Email, time.Now(), revokeOthers)); err != nil {
+		logx.LogError(ctx, "AuthSvc.ChangePassword.Send", err)
+	}
+	if !revokeOthers {
+		return nil, nil
+	}
+
+	// 7. The bump invalidated the caller's tokens too, re-issue them for this device
+	s.events.Record(ctx, Event(models.EventSessionRevoked, true, p.UserID, p.Email, "", "change_password"))
+	resp, err := s.issueSession(ctx, cctx, "AuthSvc.ChangePassword", p.UserID, p.Email, tkv, p.DeviceID, "change_password")
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// countLoginFail Count a wrong password of email from ip towards a lockout
+func (s *authService) countLoginFail(ctx context.Context, op, ip, email string) {
+	if err := s.repo.UpdateLoginCntLock(ctx, ip, email, s.cfg.LoginThrottle); err != nil {
+		logx.LogError(ctx, op+".UpdateLoginCntLock", err)
+	}
+}
+
+// clearLoginFails Forget the wrong passwords of email from ip after a right one
+func (s *authService) clearLoginFails(ctx context.Context, op, ip, email string) {
+	if err := s.repo.ClearLoginCntLock(ctx, ip, email); err != nil {
+		logx.LogError(ctx, op+".ClearLoginCntLock", err)
+	}
+}
+
+// Authenticate Verify an access token and check it against the current user row,
+// so logout-everywhere, deletion and locking take effect before the token expires
+func (s *authService) Authenticate(ctx context.Context, atk string) (Principal, error) {
+
+	// 1. Parse token
+	claims, err := s.tokens.ParseATK(atk)
+	if err != nil {
+		return Principal{}, ErrUnauthorized
+	}
+
+	// 2. Load user
+	user, err := s.repo.GetUserByID(ctx, claims.UserID)
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return Principal{}, ErrCtxError
+		}
+		if errors.Is(err, repos.ErrNotFound) {
+			return Principal{}, ErrUnauthorized
+		}
+		logx.LogError(ctx, "AuthSvc.Authenticate.GetUserByID", err)
+		return Principal{}, ErrInternalServer
+	}
+
+	// 3. Token must match the user's current state
+	if user.TokenV != claims.TokenV || user.IsDeleted || user.LockedAt != nil {
+		return Principal{}, ErrUnauthorized
+	}
+
+	p := Principal{
+		UserID:   user.UserID,
+		Email:    user.Email,
+		Role:     user.Role,
+		DeviceID: claims.DeviceID,
+	}
+	if claims.IssuedAt != nil {
+		p.IssuedAt = claims.IssuedAt.Time
+	}
+	if user.Locale != nil {
+		p.Locale = *user.Locale
+	}
+	return p, nil
+}
+
 func generateCode() (string, error) {
 	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
 	if err != nil {
@@ -323,26 +801,34 @@ func generateCode() (string, error) {
 
 	return fmt.Sprintf("%06d", n.Int64()), nil
 }
+
+// isValidPassword Shape of a password checked against a stored hash; a new password has
+// to pass the policy instead
 func isValidPassword(pwd string) bool {
-	if len(pwd) < 8 || len(pwd) > 20 {
-		return false
-	}
-	var hasLower, hasUpper, hasDigit, hasSpecial bool
-	for _, c := range pwd {
-		switch {
-		case unicode.IsLower(c):
-			hasLower = true
-		case unicode.IsUpper(c):
-			hasUpper = true
-		case unicode.IsDigit(c):
-			hasDigit = true
-		case unicode.IsPunct(c) || unicode.IsSymbol(c):
-			hasSpecial = true
-		}
+	return pwd != "" && utf8.RuneCountInString(pwd) <= pwpolicy.MaxLength
+}
+
+// emailUse Whether an address is being signed in with or newly attached to an account
+type emailUse int
+
+const (
+	// emailExisting Sign-in and recovery: disposable domains still work for accounts that have them
+	emailExisting emailUse = iota
+	// emailNew Signup and email change: disposable domains are refused
+	emailNew
+)
+
+// emailUseOf Signup and email change attach the address to an account, other scenes use one
+func emailUseOf(scene string) emailUse {
+	if scene == "signup" || scene == sceneChangeEmail {
+		return emailNew
 	}
-	return hasLower && hasUpper && hasDigit && hasSpecial
+	return emailExisting
 }
-func isValidEmail(email string) bool {
+
+// isValidEmail Syntax, then the domain against rep: denied domains are refused for any use,
+// disposable ones for a new address. A nil rep checks syntax only
+func isValidEmail(email string, rep *reputation.Lists, use emailUse) bool {
 	var (
 		localPartRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+\-]+$`)
 		domainRegex    = regexp.MustCompile(`^[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
@@ -375,11 +861,39 @@ func isValidEmail(email string) bool {
 	if len(domain) > 255 {
 		return false
 	}
+	// 4. Reputation
+	switch rep.Domain(domain) {
+	case reputation.Deny:
+		return false
+	case reputation.Disposable:
+		return use != emailNew
+	}
 
 	return true
 }
+
+// sceneChangeEmail Code sent to the new address, only requested through /me/email
+const sceneChangeEmail = "change_email"
+
+// sceneLogin Passwordless sign-in, requested through /auth/login/email with a device id
+const sceneLogin = "login"
+
+// sceneRestore Code that restores a deleted account without a password, redeemed at /auth/restore-account
+const sceneRestore = "restore_account"
+
+// publicScenes Purposes an email code can be requested for without signing in
+var publicScenes = []string{"signup", "reset_password", sceneRestore}
+
+// otpScenes Every scene that has OTP and throttle keys
+var otpScenes = append(append([]string{}, publicScenes...), sceneChangeEmail, sceneLogin)
+
 func isValidScene(scene string) bool {
-	return scene == "signup" || scene == "reset_password"
+	for _, s := range publicScenes {
+		if s == scene {
+			return true
+		}
+	}
+	return false
 }
 func isValidUsername(s string) bool {
 	var usernameRe = regexp.MustCompile(`^[A-Za-z0-9 ]+$`)
@@ -416,9 +930,25 @@ func generateRTK() (rtk string, rtkHash []byte, err error) {
 }
 
 type authService struct {
-	repo repos.AuthRepo
+	repo       repos.AuthRepo
+	cfg        *config.Config
+	settings   settings.Provider
+	tokens     *jwtx.Manager
+	events     EventRecorder
+	mailer     mailx.Mailer
+	mfa        *mfaVerifier
+	passkeys   repos.PasskeyRepo
+	rp         *webauthn.RelyingParty
+	federation *federation
+	passwords  *passwordChecker
+	reputation ReputationService
 }
 
-func NewAuthService(repo repos.AuthRepo) AuthService {
-	return &authService{repo: repo}
+func NewAuthService(repo repos.AuthRepo, mfaRepo repos.MFARepo, passkeyRepo repos.PasskeyRepo, identityRepo repos.IdentityRepo,
+	box *secretbox.Box, verifier *oidc.Verifier, hasher *passwordhash.Hasher, policy *pwpolicy.Policy, reputation ReputationService,
+	cfg *config.Config, rt settings.Provider, tokens *jwtx.Manager, events EventRecorder, mailer mailx.Mailer) AuthService {
+	return &authService{repo: repo, cfg: cfg, settings: rt, tokens: tokens, events: events, mailer: mailer,
+		mfa: newMFAVerifier(mfaRepo, box, cfg.MFA), passkeys: passkeyRepo, rp: newRelyingParty(cfg.WebAuthn),
+		federation: &federation{verifier: verifier, repo: identityRepo}, passwords: &passwordChecker{hasher: hasher, policy: policy, repo: repo},
+		reputation: reputation}
 }
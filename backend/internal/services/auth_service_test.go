@@ -0,0 +1,117 @@
+package services
+
+import (
+	"backend/internal/config"
+	"backend/internal/models"
+	"backend/internal/pkg/passwordhash"
+	"backend/internal/pkg/reputation"
+	"backend/internal/repos"
+	"backend/internal/settings"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// throttleRepo Keeps the login counters in memory, locking per IP and email only
+type throttleRepo struct {
+	stubAuthRepo
+	fails  map[string]int
+	locked map[string]bool
+}
+
+func (r *throttleRepo) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	if r.user == nil || r.user.Email != email {
+		return nil, repos.ErrNotFound
+	}
+	return r.user, nil
+}
+
+func (r *throttleRepo) CheckLoginThrottle(ctx context.Context, ip, email string) (bool, error) {
+	return r.locked[ip+" "+email], nil
+}
+
+func (r *throttleRepo) UpdateLoginCntLock(ctx context.Context, ip, email string, t config.LoginThrottle) error {
+	r.fails[ip+" "+email]++
+	if r.fails[ip+" "+email] >= t.IPEmailAttempts {
+		r.locked[ip+" "+email] = true
+		delete(r.fails, ip+" "+email)
+	}
+	return nil
+}
+
+func (r *throttleRepo) ClearLoginCntLock(ctx context.Context, ip, email string) error {
+	delete(r.fails, ip+" "+email)
+	return nil
+}
+
+type stubReputation struct {
+	ReputationService
+	tripped []string
+}
+
+func (r *stubReputation) Trip(ctx context.Context, ip string) {
+	r.tripped = append(r.tripped, ip)
+}
+
+func (r *stubReputation) Lists() *reputation.Lists {
+	return nil
+}
+
+type stubSettings struct{}
+
+func (stubSettings) Current() *settings.Settings {
+	return &settings.Settings{Timeouts: config.Timeouts{Login: 5 * time.Second}}
+}
+
+// TestLoginLocksOutAndTrips Wrong passwords, for an account or an unknown email, lock the IP
+// out of the email; the next attempt is refused and trips the IP's reputation
+func TestLoginLocksOutAndTrips(t *testing.T) {
+	const (
+		ip       = "203.0.113.7"
+		deviceID = "9c2d6f1e-3a4b-4c5d-8e7f-6a5b4c3d2e1f"
+		password = "Right-Password-1"
+	)
+	hasher := passwordhash.New(config.PasswordHash{MemoryKiB: 64, Iterations: 1, Parallelism: 1, Workers: 1, MaxQueue: 4})
+	pwdHash, err := hasher.Hash(context.Background(), password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, email := range []string{"a@example.com", "nobody@example.com"} {
+		t.Run(email, func(t *testing.T) {
+			repo := &throttleRepo{
+				stubAuthRepo: stubAuthRepo{user: &models.User{UserID: 7, Email: "a@example.com", PwdHash: pwdHash}},
+				fails:        map[string]int{},
+				locked:       map[string]bool{},
+			}
+			rep := &stubReputation{}
+			cfg := &config.Config{LoginThrottle: config.LoginThrottle{IPEmailAttempts: 3, EmailAttempts: 10, Window: time.Minute, Lockout: time.Minute}}
+			s := &authService{
+				repo:       repo,
+				cfg:        cfg,
+				settings:   stubSettings{},
+				events:     &stubEvents{},
+				passwords:  &passwordChecker{hasher: hasher, repo: repo},
+				reputation: rep,
+			}
+			ctx := context.Background()
+
+			for i := 0; i < cfg.LoginThrottle.IPEmailAttempts; i++ {
+				if _, err := s.Login(ctx, ip, email, "Wrong-Password-1", deviceID); !errors.Is(err, ErrUnauthorized) {
+					t.Fatalf("wrong password %d: Login = %v, want %v", i+1, err, ErrUnauthorized)
+				}
+			}
+			if len(rep.tripped) != 0 {
+				t.Fatalf("tripped before the lock: %v", rep.tripped)
+			}
+
+			if _, err := s.Login(ctx, ip, email, password, deviceID); !errors.Is(err, ErrTooManyRequest) {
+				t.Fatalf("locked: Login = %v, want %v", err, ErrTooManyRequest)
+			}
+			if len(rep.tripped) != 1 || rep.tripped[0] != ip {
+				t.Fatalf("tripped = %v, want [%s]", rep.tripped, ip)
+			}
+		})
+	}
+}
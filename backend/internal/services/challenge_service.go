@@ -0,0 +1,136 @@
+package services
+
+import (
+	"backend/internal/config"
+	"backend/internal/pkg/challenge"
+	"backend/internal/pkg/ctx_util"
+	"backend/internal/pkg/logx"
+	"backend/internal/repos"
+	"backend/internal/settings"
+	"context"
+	"errors"
+	"math/bits"
+	"time"
+)
+
+type ChallengeService interface {
+	Issue(ctx context.Context, ip string) (ChallengeResponse, error)
+	Verify(ctx context.Context, ip, token, solution string) error
+}
+
+// ChallengeResponse What the client has to do before requesting a code: solve the pow
+// token, show the captcha widget of SiteKey, or nothing
+type ChallengeResponse struct {
+	Type       string `json:"type"`
+	Token      string `json:"token,omitempty"`
+	Difficulty int    `json:"difficulty,omitempty"`
+	ExpiresIn  int    `json:"expires_in,omitempty"`
+	SiteKey    string `json:"site_key,omitempty"`
+}
+
+// Issue A proof-of-work challenge whose difficulty rises with the challenges this IP, and
+// everyone, asked for in the current window
+func (s *challengeService) Issue(ctx context.Context, ip string) (ChallengeResponse, error) {
+	switch s.cfg.Mode {
+	case "off":
+		return ChallengeResponse{Type: "none"}, nil
+	case "captcha":
+		return ChallengeResponse{Type: "captcha", SiteKey: s.cfg.CaptchaSiteKey}, nil
+	}
+
+	// 0. Create sub context
+	cctx, cancel := context.WithTimeout(ctx, s.settings.Current().Timeouts.RequestCode)
+	defer cancel()
+
+	// 1. Count, per IP and in total
+	byIP, total, err := s.repo.CountIssued(cctx, ip, s.cfg.Window)
+	if err != nil {
+		if ctx_util.IsCtxDone(cctx, err) {
+			return ChallengeResponse{}, ErrCtxError
+		}
+		logx.LogError(ctx, "ChallengeSvc.Issue.CountIssued", err)
+		return ChallengeResponse{}, ErrInternalServer
+	}
+
+	// 2. Issue, one more bit per doubling over the free counts
+	d := min(s.cfg.Difficulty+extraBits(byIP, s.cfg.IPFree)+extraBits(total, s.cfg.LoadFree), s.cfg.MaxDifficulty)
+	p, err := s.pow.Issue(d, time.Now())
+	if err != nil {
+		logx.LogError(ctx, "ChallengeSvc.Issue", err)
+		return ChallengeResponse{}, ErrInternalServer
+	}
+	return ChallengeResponse{Type: "pow", Token: p.Token, Difficulty: p.Difficulty, ExpiresIn: int(s.cfg.TTL.Seconds())}, nil
+}
+
+// Verify nil when the solution passes; ErrChallengeRequired when it is missing, wrong or
+// already used. token is empty in captcha mode, solution is the nonce or the captcha response
+func (s *challengeService) Verify(ctx context.Context, ip, token, solution string) error {
+	if s.cfg.Mode == "off" {
+		return nil
+	}
+
+	// 0. Create sub context
+	cctx, cancel := context.WithTimeout(ctx, s.settings.Current().Timeouts.RequestCode)
+	defer cancel()
+
+	// 1. Captcha, asked of the provider
+	if s.cfg.Mode == "captcha" {
+		err := s.captcha.Verify(cctx, solution, ip)
+		switch {
+		case err == nil:
+			return nil
+		case errors.Is(err, challenge.ErrRejected):
+			return ErrChallengeRequired
+		case ctx_util.IsCtxDone(cctx, err):
+			return ErrCtxError
+		default:
+			logx.LogError(ctx, "ChallengeSvc.Verify.CAPTCHA", err)
+			return ErrInternalServer
+		}
+	}
+
+	// 2. Proof of work; a wrong one makes the next challenges of this IP harder
+	id, exp, err := s.pow.Check(token, solution, time.Now())
+	if err != nil {
+		if err := s.repo.Penalize(cctx, ip, s.cfg.IPFree, s.cfg.Window); err != nil && !ctx_util.IsCtxDone(cctx, err) {
+			logx.LogError(ctx, "ChallengeSvc.Verify.Penalize", err)
+		}
+		return ErrChallengeRequired
+	}
+
+	// 3. One request per solution
+	fresh, err := s.repo.MarkUsed(cctx, id, time.Until(exp)+time.Second)
+	if err != nil {
+		if ctx_util.IsCtxDone(cctx, err) {
+			return ErrCtxError
+		}
+		logx.LogError(ctx, "ChallengeSvc.Verify.MarkUsed", err)
+		return ErrInternalServer
+	}
+	if !fresh {
+		return ErrChallengeRequired
+	}
+	return nil
+}
+
+// extraBits ceil(log2(n / free)) once n is over free
+func extraBits(n int64, free int) int {
+	if n <= int64(free) {
+		return 0
+	}
+	return bits.Len64(uint64((n - 1) / int64(free)))
+}
+
+type challengeService struct {
+	cfg      config.Challenge
+	pow      *challenge.PoW
+	captcha  challenge.CAPTCHA
+	repo     repos.ChallengeRepo
+	settings settings.Provider
+}
+
+// NewChallengeService captcha may be nil unless cfg.Mode is captcha
+func NewChallengeService(repo repos.ChallengeRepo, captcha challenge.CAPTCHA, cfg *config.Config, rt settings.Provider) ChallengeService {
+	return &challengeService{cfg: cfg.Challenge, pow: challenge.NewPoW(cfg.Challenge.Key, cfg.Challenge.TTL),
+		captcha: captcha, repo: repo, settings: rt}
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"backend/internal/config"
+	"backend/internal/models"
+	"backend/internal/pkg/client_info"
+	"backend/internal/pkg/logx"
+	"backend/internal/pkg/request_id"
+	"backend/internal/repos"
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// EventRecorder Queue auth events for a background writer so recording never adds latency
+type EventRecorder interface {
+	// Record Enqueue an event, IP, User-Agent and request id are taken from ctx when unset;
+	// the event is dropped when the queue is full
+	Record(ctx context.Context, e models.AuthEvent)
+	// Run Write batches and prune old rows until ctx is done, then flush what is queued
+	Run(ctx context.Context)
+}
+
+// Event Build an auth event; userID 0 and empty strings mean unknown
+func Event(eventType string, success bool, userID uint64, email, deviceID, detail string) models.AuthEvent {
+	e := models.AuthEvent{Type: eventType, Success: success}
+	if userID != 0 {
+		e.UserID = &userID
+	}
+	if email != "" {
+		e.Email = &email
+	}
+	if u, err := uuid.Parse(deviceID); err == nil {
+		e.DeviceID = u[:]
+	}
+	if detail != "" {
+		detail = truncate(detail, 255)
+		e.Detail = &detail
+	}
+	return e
+}
+
+func (r *eventRecorder) Record(ctx context.Context, e models.AuthEvent) {
+
+	// 1. Fill request metadata
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	if info, ok := client_info.From(ctx); ok {
+		if e.IP == nil && info.IP != "" {
+			ip := info.IP
+			e.IP = &ip
+		}
+		if e.UserAgent == nil && info.UserAgent != "" {
+			ua := truncate(info.UserAgent, 512)
+			e.UserAgent = &ua
+		}
+	}
+	if e.RequestID == nil {
+		if rid, ok := request_id.From(ctx); ok {
+			e.RequestID = &rid
+		}
+	}
+
+	// 2. Enqueue without blocking
+	select {
+	case r.queue <- e:
+	default:
+		if r.dropped.Add(1)%100 == 1 {
+			logx.LogWarn(ctx, "EventRecorder.Record", fmt.Sprintf("queue full, %d events dropped so far", r.dropped.Load()))
+		}
+	}
+}
+
+func (r *eventRecorder) Run(ctx context.Context) {
+	flush := time.NewTicker(r.cfg.FlushInterval)
+	defer flush.Stop()
+	prune := time.NewTicker(r.cfg.PruneInterval)
+	defer prune.Stop()
+
+	batch := make([]models.AuthEvent, 0, r.cfg.BatchSize)
+	for {
+		select {
+		case e := <-r.queue:
+			batch = append(batch, e)
+			if len(batch) >= r.cfg.BatchSize {
+				batch = r.write(batch)
+			}
+		case <-flush.C:
+			batch = r.write(batch)
+		case <-prune.C:
+			r.prune()
+		case <-ctx.Done():
+			r.drain(batch)
+			return
+		}
+	}
+}
+
+// write Insert the batch and return it emptied; a failed batch is logged and dropped
+func (r *eventRecorder) write(batch []models.AuthEvent) []models.AuthEvent {
+	if len(batch) == 0 {
+		return batch
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := r.repo.InsertAuthEvents(ctx, batch); err != nil {
+		logx.LogError(ctx, "EventRecorder.Write", fmt.Errorf("%d events lost: %w", len(batch), err))
+	}
+	return batch[:0]
+}
+
+// drain Flush the pending batch and everything still queued
+func (r *eventRecorder) drain(batch []models.AuthEvent) {
+	for {
+		select {
+		case e := <-r.queue:
+			batch = append(batch, e)
+			if len(batch) >= r.cfg.BatchSize {
+				batch = r.write(batch)
+			}
+		default:
+			r.write(batch)
+			return
+		}
+	}
+}
+
+// prune Delete expired events in small chunks so no long lock is held
+func (r *eventRecorder) prune() {
+	const chunk = 5000
+	before := time.Now().Add(-r.cfg.Retention)
+	var total int64
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		n, err := r.repo.PruneAuthEvents(ctx, before, chunk)
+		cancel()
+		if err != nil {
+			logx.LogError(context.Background(), "EventRecorder.Prune", err)
+			return
+		}
+		total += n
+		if n < chunk {
+			break
+		}
+	}
+	if total > 0 {
+		logx.LogInfo(context.Background(), "EventRecorder.Prune", fmt.Sprintf("deleted %d events older than %s", total, before.UTC().Format(time.RFC3339)))
+	}
+}
+
+// truncate Cut s to at most n runes
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+type eventRecorder struct {
+	repo    repos.EventRepo
+	cfg     config.AuthEvents
+	queue   chan models.AuthEvent
+	dropped atomic.Int64
+}
+
+func NewEventRecorder(repo repos.EventRepo, cfg config.AuthEvents) EventRecorder {
+	return &eventRecorder{repo: repo, cfg: cfg, queue: make(chan models.AuthEvent, cfg.Buffer)}
+}
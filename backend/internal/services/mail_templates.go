@@ -0,0 +1,89 @@
+package services
+
+import (
+	"backend/internal/config"
+	"backend/internal/models"
+	"backend/internal/pkg/i18n"
+	"backend/internal/pkg/mailx"
+	"context"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Every template takes the locale to write in, see mailLocale; the wording lives in the
+// i18n catalogs under mail.*
+
+// mailLocale The user's own language when they chose one, else that of the request
+func mailLocale(ctx context.Context, user *models.User) string {
+	if user != nil && user.Locale != nil && *user.Locale != "" {
+		return *user.Locale
+	}
+	return i18n.From(ctx)
+}
+
+// emailLink Link to path carrying token: under LINK_BASE_URL when the app handles it, else
+// a page of the server that finishes the step in the browser
+func emailLink(cfg *config.Config, path, token string) string {
+	base := cfg.Server.LinkBase
+	if base == "" {
+		base = cfg.Server.PublicURL
+	}
+	return strings.TrimRight(base, "/") + path + "?token=" + url.QueryEscape(token)
+}
+
+func codeMail(loc, to, scene, code string, ttlSec int) mailx.Message {
+	return mailx.Message{
+		To:      to,
+		Subject: i18n.T(loc, "mail.code.subject."+scene),
+		Body:    i18n.T(loc, "mail.code.body", "code", code, "minutes", ttlSec/60),
+	}
+}
+
+func passwordChangedMail(loc, to string, at time.Time, revokedOthers bool) mailx.Message {
+	body := i18n.T(loc, "mail.password_changed.body", "at", at.UTC().Format(time.RFC1123))
+	if revokedOthers {
+		body += i18n.T(loc, "mail.password_changed.revoked")
+	}
+	body += i18n.T(loc, "mail.password_changed.footer")
+	return mailx.Message{To: to, Subject: i18n.T(loc, "mail.password_changed.subject"), Body: body}
+}
+
+func mfaMail(loc, to string, enabled bool) mailx.Message {
+	key := "mail.mfa_off"
+	if enabled {
+		key = "mail.mfa_on"
+	}
+	return mailx.Message{To: to, Subject: i18n.T(loc, key+".subject"), Body: i18n.T(loc, key+".body")}
+}
+
+func passkeyMail(loc, to, name string) mailx.Message {
+	return mailx.Message{To: to, Subject: i18n.T(loc, "mail.passkey.subject"),
+		Body: i18n.T(loc, "mail.passkey.body", "name", name)}
+}
+
+func emailChangedMail(loc, to, newEmail, revertURL string, valid time.Duration) mailx.Message {
+	return mailx.Message{
+		To:      to,
+		Subject: i18n.T(loc, "mail.email_changed.subject"),
+		Body: i18n.T(loc, "mail.email_changed.body",
+			"email", newEmail, "days", int(valid.Hours()/24), "link", revertURL),
+	}
+}
+
+func identityMail(loc, to, provider string, linked bool) mailx.Message {
+	key := "mail.identity_unlinked"
+	if linked {
+		key = "mail.identity_linked"
+	}
+	return mailx.Message{To: to, Subject: i18n.T(loc, key+".subject"),
+		Body: i18n.T(loc, key+".body", "provider", provider)}
+}
+
+func loginMail(loc, to, code, link string, ttlSec int) mailx.Message {
+	return mailx.Message{
+		To:      to,
+		Subject: i18n.T(loc, "mail.login.subject"),
+		Body:    i18n.T(loc, "mail.login.body", "code", code, "link", link, "minutes", ttlSec/60),
+	}
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"backend/internal/config"
+	"backend/internal/models"
+	"backend/internal/pkg/secretbox"
+	"backend/internal/pkg/totp"
+	"backend/internal/repos"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Second factor methods, also used as auth event details
+const (
+	mfaMethodTOTP     = "totp"
+	mfaMethodRecovery = "recovery_code"
+)
+
+// totpSkew Steps accepted either side of now, absorbs clock drift on the phone
+const totpSkew = 1
+
+// MFAChallengeResponse Returned by a login whose password step passed when the account has
+// a second factor; exchange mfa_token and a code at /auth/mfa/verify for the tokens
+type MFAChallengeResponse struct {
+	MFARequired bool     `json:"mfa_required"`
+	MFAToken    string   `json:"mfa_token"`
+	ExpiresIn   int      `json:"expires_in"`
+	Methods     []string `json:"methods"`
+}
+
+// LoginResult Exactly one of Tokens and Challenge is set
+type LoginResult struct {
+	Tokens    *AuthResponse
+	Challenge *MFAChallengeResponse
+}
+
+// mfaVerifier Second factor checks shared by login and the self-service MFA endpoints
+type mfaVerifier struct {
+	repo repos.MFARepo
+	box  *secretbox.Box
+	cfg  config.MFA
+}
+
+// enabled Whether the user finished TOTP enrollment
+func (v *mfaVerifier) enabled(ctx context.Context, userID uint64) (bool, error) {
+	m, err := v.repo.GetTOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repos.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return m.EnabledAt != nil, nil
+}
+
+// sealSecret Encrypt a TOTP secret bound to its owner
+func (v *mfaVerifier) sealSecret(userID uint64, secret string) ([]byte, error) {
+	return v.box.Seal([]byte(secret), ownerAD(userID))
+}
+
+func (v *mfaVerifier) openSecret(m *models.UserMFA) (string, error) {
+	secret, err := v.box.Open(m.SecretEnc, ownerAD(m.UserID))
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// checkTOTP Match a code against the secret and burn its time step so it cannot be replayed
+func (v *mfaVerifier) checkTOTP(ctx context.Context, m *models.UserMFA, code string) error {
+	secret, err := v.openSecret(m)
+	if err != nil {
+		return err
+	}
+	step, ok := totp.Validate(secret, code, time.Now(), totpSkew)
+	if !ok {
+		return ErrUnauthorized
+	}
+	fresh, err := v.repo.MarkTOTPStep(ctx, m.UserID, step, (2*totpSkew+2)*totp.Period*time.Second)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// check Accept a TOTP code or an unused recovery code of an enabled second factor;
+// returns the method used. ErrUnauthorized means a wrong code, ErrTooManyRequest that the
+// user's wrong codes reached UserAttempts within LockoutWindow, whichever challenge or
+// endpoint they came through; other errors are the repo's
+func (v *mfaVerifier) check(ctx context.Context, userID uint64, code string) (string, error) {
+
+	// 1. Refuse any code while locked out, so guessing on gains nothing
+	fails, err := v.repo.CountUserFails(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if fails >= int64(v.cfg.UserAttempts) {
+		return "", ErrTooManyRequest
+	}
+
+	// 2. Check the code, a wrong one counts against the user
+	method, err := v.checkCode(ctx, userID, code)
+	if err == nil {
+		_ = v.repo.ClearUserFails(ctx, userID)
+		return method, nil
+	}
+	if !errors.Is(err, ErrUnauthorized) {
+		return "", err
+	}
+	if fails, err = v.repo.FailUser(ctx, userID, v.cfg.LockoutWindow); err != nil {
+		return "", err
+	}
+	if fails >= int64(v.cfg.UserAttempts) {
+		return "", ErrTooManyRequest
+	}
+	return "", ErrUnauthorized
+}
+
+// checkCode The code alone, without the user's lockout
+func (v *mfaVerifier) checkCode(ctx context.Context, userID uint64, code string) (string, error) {
+
+	// 1. Load factor
+	m, err := v.repo.GetTOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repos.ErrNotFound) {
+			return "", ErrUnauthorized
+		}
+		return "", err
+	}
+	if m.EnabledAt == nil {
+		return "", ErrUnauthorized
+	}
+
+	// 2. Six digits is a TOTP code, anything else a recovery code
+	code = strings.TrimSpace(code)
+	if len(code) == totp.Digits {
+		return mfaMethodTOTP, v.checkTOTP(ctx, m, code)
+	}
+	if err := v.repo.UseRecoveryCode(ctx, userID, hashRecoveryCode(code)); err != nil {
+		if errors.Is(err, repos.ErrNotFound) {
+			return "", ErrUnauthorized
+		}
+		return "", err
+	}
+	return mfaMethodRecovery, nil
+}
+
+// newRecoveryCodes Plain codes for the user, formatted xxxxx-xxxxx, and their hashes for storage
+func (v *mfaVerifier) newRecoveryCodes() ([]string, [][]byte, error) {
+	codes := make([]string, 0, v.cfg.RecoveryCodes)
+	hashes := make([][]byte, 0, v.cfg.RecoveryCodes)
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for len(codes) < v.cfg.RecoveryCodes {
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		s := strings.ToLower(enc.EncodeToString(raw))[:10]
+		code := s[:5] + "-" + s[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode SHA-256 of the code ignoring case, spaces and dashes
+func hashRecoveryCode(code string) []byte {
+	norm := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(norm))
+	return sum[:]
+}
+
+// hashToken Hex SHA-256 of an opaque token, the form it is stored under
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ownerAD Additional data tying a sealed secret to its user row
+func ownerAD(userID uint64) []byte {
+	return binary.BigEndian.AppendUint64([]byte("user_mfa:"), userID)
+}
+
+func newMFAVerifier(repo repos.MFARepo, box *secretbox.Box, cfg config.MFA) *mfaVerifier {
+	return &mfaVerifier{repo: repo, box: box, cfg: cfg}
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"backend/internal/models"
+	"backend/internal/pkg/ctx_util"
+	"backend/internal/pkg/logx"
+	"backend/internal/pkg/oidc"
+	"backend/internal/repos"
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// maxIDTokenLen Generous upper bound, ID tokens are a few KB at most
+const maxIDTokenLen = 16 << 10
+
+// IdentityInfo A linked provider account as shown to its owner
+type IdentityInfo struct {
+	Provider       string     `json:"provider"`
+	Email          *string    `json:"email"`
+	IsPrivateEmail bool       `json:"is_private_email"`
+	LastLoginAt    *time.Time `json:"last_login_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// federation ID token checks shared by OIDC login and identity linking
+type federation struct {
+	verifier *oidc.Verifier
+	repo     repos.IdentityRepo
+}
+
+// verify Check the token and burn it, so a leaked token cannot be replayed while it is valid
+func (f *federation) verify(ctx context.Context, op, provider, idToken, nonce string) (*oidc.Identity, error) {
+
+	// 1. Check input
+	if !f.verifier.Has(provider) {
+		return nil, ErrNotFound
+	}
+	if idToken == "" || len(idToken) > maxIDTokenLen || len(nonce) > 256 {
+		return nil, ErrBadRequest
+	}
+
+	// 2. Verify
+	id, err := f.verifier.Verify(ctx, provider, idToken, nonce)
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return nil, ErrCtxError
+		}
+		if errors.Is(err, oidc.ErrInvalidToken) {
+			logx.LogWarn(ctx, op+".Verify", err.Error())
+			return nil, ErrUnauthorized
+		}
+		logx.LogError(ctx, op+".Verify", err)
+		return nil, ErrInternalServer
+	}
+
+	// 3. Mark used until it expires, plus the most leeway the config allows
+	ttl := max(time.Until(id.ExpiresAt), 0) + 5*time.Minute
+	first, err := f.repo.MarkTokenUsed(ctx, hashToken(idToken), ttl)
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return nil, ErrCtxError
+		}
+		logx.LogError(ctx, op+".MarkTokenUsed", err)
+		return nil, ErrInternalServer
+	}
+	if !first {
+		return nil, ErrUnauthorized
+	}
+	return id, nil
+}
+
+// identityRow New user_identities row for a verified token
+func identityRow(userID uint64, id *oidc.Identity) models.UserIdentity {
+	return models.UserIdentity{
+		UserID:         userID,
+		Provider:       id.Provider,
+		Issuer:         id.Issuer,
+		Subject:        id.Subject,
+		Email:          identityEmail(id),
+		IsPrivateEmail: id.PrivateEmail,
+	}
+}
+
+// identityEmail Normalized email of the token, nil when it has none
+func identityEmail(id *oidc.Identity) *string {
+	email := strings.ToLower(strings.TrimSpace(id.Email))
+	if email == "" {
+		return nil
+	}
+	return &email
+}
+
+func toIdentityInfo(i models.UserIdentity) IdentityInfo {
+	return IdentityInfo{
+		Provider:       i.Provider,
+		Email:          i.Email,
+		IsPrivateEmail: i.IsPrivateEmail,
+		LastLoginAt:    i.LastLoginAt,
+		CreatedAt:      i.CreatedAt,
+	}
+}
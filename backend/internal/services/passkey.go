@@ -0,0 +1,123 @@
+package services
+
+import (
+	"backend/internal/config"
+	"backend/internal/pkg/webauthn"
+	"encoding/binary"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Passkey ceremony types, stored with the challenge so one cannot answer the other
+const (
+	ceremonyRegister = "register"
+	ceremonyLogin    = "login"
+)
+
+// PasskeyOptions Challenge id to send back with the result, and the options to pass to
+// navigator.credentials.create() or .get() once the base64url fields are decoded
+type PasskeyOptions struct {
+	ChallengeID string `json:"challenge_id"`
+	PublicKey   any    `json:"public_key"`
+}
+
+type credentialParam struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+type credentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+type creationOptions struct {
+	RP struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+	} `json:"user"`
+	Challenge              string                 `json:"challenge"`
+	PubKeyCredParams       []credentialParam      `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	Attestation            string                 `json:"attestation"`
+	ExcludeCredentials     []credentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+}
+
+type requestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int64                  `json:"timeout"`
+	UserVerification string                 `json:"userVerification"`
+	AllowCredentials []credentialDescriptor `json:"allowCredentials"`
+}
+
+// PasskeyInfo A registered passkey as shown to its owner
+type PasskeyInfo struct {
+	ID         uint64     `json:"id"`
+	Name       string     `json:"name"`
+	BackedUp   bool       `json:"backed_up"`
+	Transports []string   `json:"transports"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// PasskeyCredential A PublicKeyCredential serialized by the client, binary fields base64url
+type PasskeyCredential struct {
+	ID                string   `json:"id"`
+	ClientDataJSON    string   `json:"client_data_json"`
+	AttestationObject string   `json:"attestation_object,omitempty"`
+	Transports        []string `json:"transports,omitempty"`
+	AuthenticatorData string   `json:"authenticator_data,omitempty"`
+	Signature         string   `json:"signature,omitempty"`
+	UserHandle        string   `json:"user_handle,omitempty"`
+}
+
+func newRelyingParty(cfg config.WebAuthn) *webauthn.RelyingParty {
+	return &webauthn.RelyingParty{
+		ID:        cfg.RPID,
+		Name:      cfg.RPName,
+		Origins:   cfg.Origins,
+		RequireUV: cfg.UserVerification == "required",
+	}
+}
+
+// passkeyUserHandle user.id of the WebAuthn user entity: the numeric id, big-endian,
+// so a discoverable credential tells which account it belongs to
+func passkeyUserHandle(userID uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, userID)
+}
+
+func newChallengeID() string {
+	return uuid.NewString()
+}
+
+// validTransports Known AuthenticatorTransport values, others are dropped
+func validTransports(in []string) string {
+	var out []string
+	for _, t := range in {
+		switch t {
+		case "usb", "nfc", "ble", "internal", "hybrid", "smart-card":
+			out = append(out, t)
+		}
+	}
+	return strings.Join(out, ",")
+}
+
+func splitTransports(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"backend/internal/models"
+	"backend/internal/pkg/ctx_util"
+	"backend/internal/pkg/logx"
+	"backend/internal/pkg/passwordhash"
+	"backend/internal/pkg/pwpolicy"
+	"backend/internal/repos"
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// rehashTimeout Budget of the background rehash after a login, outside the request's timeout
+const rehashTimeout = 5 * time.Second
+
+// passwordChecker Hashing and verification shared by login and re-authentication; a match
+// on an outdated hash is rehashed in the background
+type passwordChecker struct {
+	hasher *passwordhash.Hasher
+	policy *pwpolicy.Policy
+	repo   repos.AuthRepo
+	// dummy Hash with the current params, verified when there is no real one
+	dummy atomic.Pointer[string]
+}
+
+// check nil on a match; ErrUnauthorized on a mismatch or an account without a password,
+// which costs a verification too so timing does not tell it apart
+func (c *passwordChecker) check(ctx context.Context, op string, user *models.User, password string) error {
+	if user.PwdHash == "" {
+		return c.miss(ctx, op, password)
+	}
+	ok, rehash, err := c.hasher.Verify(ctx, password, user.PwdHash)
+	if err != nil {
+		return c.mapErr(ctx, op+".Verify", err)
+	}
+	if !ok {
+		return ErrUnauthorized
+	}
+	if rehash {
+		go c.rehash(context.WithoutCancel(ctx), op, user.UserID, user.PwdHash, password)
+	}
+	return nil
+}
+
+// miss ErrUnauthorized after verifying password against a dummy hash, for a login whose
+// email has no account: it takes as long as a wrong password, so timing does not tell
+// which emails exist
+func (c *passwordChecker) miss(ctx context.Context, op, password string) error {
+	dummy := c.dummy.Load()
+	if dummy == nil {
+		h, err := c.hasher.Hash(ctx, "no account behind this email")
+		if err != nil {
+			return c.mapErr(ctx, op+".DummyHash", err)
+		}
+		c.dummy.Store(&h)
+		dummy = &h
+	}
+	if _, _, err := c.hasher.Verify(ctx, password, *dummy); err != nil {
+		return c.mapErr(ctx, op+".Verify", err)
+	}
+	return ErrUnauthorized
+}
+
+// accept nil when password may be set; a policy violation is ErrBadRequest wrapping the
+// *pwpolicy.Violation, whose code the handler passes on
+func (c *passwordChecker) accept(ctx context.Context, op, password, email, username string) error {
+	err := c.policy.Check(password, email, username)
+	var v *pwpolicy.Violation
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &v):
+		return fmt.Errorf("%w: %w", ErrBadRequest, v)
+	default:
+		logx.LogError(ctx, op+".Policy", err)
+		return ErrInternalServer
+	}
+}
+
+// hash Hash a new password
+func (c *passwordChecker) hash(ctx context.Context, op, password string) (string, error) {
+	h, err := c.hasher.Hash(ctx, password)
+	if err != nil {
+		return "", c.mapErr(ctx, op+".Hash", err)
+	}
+	return h, nil
+}
+
+func (c *passwordChecker) rehash(ctx context.Context, op string, userID uint64, oldHash, password string) {
+	ctx, cancel := context.WithTimeout(ctx, rehashTimeout)
+	defer cancel()
+	h, err := c.hasher.Hash(ctx, password)
+	if err == nil {
+		err = c.repo.RehashPassword(ctx, userID, oldHash, h)
+	}
+	if err != nil && !errors.Is(err, passwordhash.ErrBusy) {
+		logx.LogError(ctx, op+".Rehash", err)
+	}
+}
+
+// mapErr A full worker queue sheds load as 429, waiting past the deadline is a timeout
+func (c *passwordChecker) mapErr(ctx context.Context, op string, err error) error {
+	if ctx_util.IsCtxDone(ctx, err) {
+		return ErrCtxError
+	}
+	if errors.Is(err, passwordhash.ErrBusy) {
+		logx.LogWarn(ctx, op, err.Error())
+		return ErrTooManyRequest
+	}
+	logx.LogError(ctx, op, err)
+	return ErrInternalServer
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"backend/internal/config"
+	"backend/internal/pkg/logx"
+	"backend/internal/repos"
+	"context"
+	"fmt"
+	"time"
+)
+
+// PurgeJob Hard delete accounts whose deletion grace period has ended
+type PurgeJob interface {
+	Run(ctx context.Context)
+}
+
+func (j *purgeJob) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.cfg.PurgeInterval)
+	defer ticker.Stop()
+	for {
+		j.purge(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purge Work in small batches until nothing is left
+func (j *purgeJob) purge(ctx context.Context) {
+	const batch = 100
+	before := time.Now().Add(-j.cfg.DeletionGrace)
+	total := 0
+	for ctx.Err() == nil {
+		cctx, cancel := context.WithTimeout(ctx, time.Minute)
+		n, err := j.repo.PurgeDeleted(cctx, before, batch)
+		cancel()
+		total += n
+		if err != nil {
+			logx.LogError(ctx, "PurgeJob.Purge", err)
+			break
+		}
+		if n < batch {
+			break
+		}
+	}
+	if total > 0 {
+		logx.LogInfo(ctx, "PurgeJob.Purge", fmt.Sprintf("purged %d accounts deleted before %s", total, before.UTC().Format(time.RFC3339)))
+	}
+}
+
+type purgeJob struct {
+	repo repos.AccountRepo
+	cfg  config.Account
+}
+
+func NewPurgeJob(repo repos.AccountRepo, cfg config.Account) PurgeJob {
+	return &purgeJob{repo: repo, cfg: cfg}
+}
@@ -0,0 +1,217 @@
+package services
+
+import (
+	"backend/internal/config"
+	"backend/internal/models"
+	"backend/internal/pkg/ctx_util"
+	"backend/internal/pkg/logx"
+	"backend/internal/pkg/reputation"
+	"backend/internal/repos"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type ReputationService interface {
+	Lists() *reputation.Lists
+	CheckIP(ctx context.Context, ip string) error
+	Trip(ctx context.Context, ip string)
+	Run(ctx context.Context)
+	ListEntries(ctx context.Context) ([]models.ReputationEntry, error)
+	AddEntry(ctx context.Context, actor Actor, e models.ReputationEntry, reason string) (models.ReputationEntry, error)
+	RemoveEntry(ctx context.Context, actor Actor, id uint64, reason string) error
+}
+
+// Lists Compiled lists in effect, static ones only until the first refresh
+func (s *reputationService) Lists() *reputation.Lists {
+	return s.lists.Load()
+}
+
+// CheckIP ErrForbidden when ip is denied or banned. Allowed ranges skip the ban lookup; if
+// Redis cannot be asked the request goes through
+func (s *reputationService) CheckIP(ctx context.Context, ip string) error {
+	switch s.Lists().IP(ip) {
+	case reputation.Allow:
+		return nil
+	case reputation.Deny:
+		return ErrForbidden
+	}
+	if ip == "" || s.cfg.BanThreshold == 0 {
+		return nil
+	}
+	banned, err := s.repo.IsBanned(ctx, ip)
+	if err != nil {
+		if ctx_util.IsCtxDone(ctx, err) {
+			return ErrCtxError
+		}
+		logx.LogError(ctx, "ReputationSvc.CheckIP.IsBanned", err)
+		return nil
+	}
+	if banned {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// Trip Count a throttle ip ran into; at BanThreshold within BanWindow it is banned for BanDuration
+func (s *reputationService) Trip(ctx context.Context, ip string) {
+	if ip == "" || s.cfg.BanThreshold == 0 || s.Lists().IP(ip) == reputation.Allow {
+		return
+	}
+	n, err := s.repo.Trip(ctx, ip, s.cfg.BanWindow)
+	if err != nil {
+		if !ctx_util.IsCtxDone(ctx, err) {
+			logx.LogError(ctx, "ReputationSvc.Trip", err)
+		}
+		return
+	}
+	if n < int64(s.cfg.BanThreshold) {
+		return
+	}
+	if err := s.repo.Ban(ctx, ip, s.cfg.BanDuration); err != nil {
+		logx.LogError(ctx, "ReputationSvc.Trip.Ban", err)
+		return
+	}
+	logx.LogWarn(ctx, "ReputationSvc.Trip", fmt.Sprintf("banned %s for %s after %d throttles", ip, s.cfg.BanDuration, n))
+	s.events.Record(ctx, Event(models.EventIPBanned, false, 0, "", "", fmt.Sprintf("throttles=%d", n)))
+}
+
+// Run Reload the managed entries every Refresh until ctx ends
+func (s *reputationService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.Refresh)
+	defer ticker.Stop()
+	for {
+		cctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		if err := s.reload(cctx); err != nil && ctx.Err() == nil {
+			logx.LogError(ctx, "ReputationSvc.Reload", err)
+		}
+		cancel()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reload From the Redis cache, or from MySQL when it is empty, filling it for other instances
+func (s *reputationService) reload(ctx context.Context) error {
+	entries, ok, err := s.repo.CachedEntries(ctx)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if entries, err = s.repo.ListEntries(ctx); err != nil {
+			return err
+		}
+		if err := s.repo.CacheEntries(ctx, entries, 10*s.cfg.Refresh); err != nil {
+			logx.LogError(ctx, "ReputationSvc.Reload.CacheEntries", err)
+		}
+	}
+	list := make([]reputation.Entry, 0, len(entries))
+	for _, e := range entries {
+		re := reputation.Entry{Kind: e.Kind, Value: e.Value, Action: e.Action}
+		if e.ExpiresAt != nil {
+			re.ExpiresAt = *e.ExpiresAt
+		}
+		list = append(list, re)
+	}
+	s.lists.Store(reputation.Compile(s.static, list, time.Now()))
+	return nil
+}
+
+func (s *reputationService) ListEntries(ctx context.Context) ([]models.ReputationEntry, error) {
+	entries, err := s.repo.ListEntries(ctx)
+	if err != nil {
+		return nil, s.mapErr(ctx, "ReputationSvc.ListEntries", err)
+	}
+	return entries, nil
+}
+
+// AddEntry List an IP range or domain; applies here at once, elsewhere on the next refresh
+func (s *reputationService) AddEntry(ctx context.Context, actor Actor, e models.ReputationEntry, reason string) (models.ReputationEntry, error) {
+
+	// 1. Check input
+	if !hasReason(reason) || (e.Action != "allow" && e.Action != "deny") {
+		return models.ReputationEntry{}, ErrBadRequest
+	}
+	switch e.Kind {
+	case "ip":
+		p, err := reputation.ParsePrefix(e.Value)
+		if err != nil {
+			return models.ReputationEntry{}, ErrBadRequest
+		}
+		e.Value = p.String()
+	case "domain":
+		e.Value = reputation.NormalizeDomain(e.Value)
+		if !isValidEmail("x@"+e.Value, nil, emailExisting) {
+			return models.ReputationEntry{}, ErrBadRequest
+		}
+	default:
+		return models.ReputationEntry{}, ErrBadRequest
+	}
+	if e.ExpiresAt != nil && !e.ExpiresAt.After(time.Now()) {
+		return models.ReputationEntry{}, ErrBadRequest
+	}
+	e.Reason = strings.TrimSpace(reason)
+	e.CreatedBy = actor.Label
+
+	// 2. Store with audit
+	id, err := s.repo.InsertEntry(ctx, e, actor.audit(auditReputationAdd, 0, reason, e.Action+" "+e.Kind+"="+e.Value))
+	if err != nil {
+		if errors.Is(err, repos.ErrReputationEntryExists) {
+			return models.ReputationEntry{}, ErrConflict
+		}
+		return models.ReputationEntry{}, s.mapErr(ctx, "ReputationSvc.AddEntry", err)
+	}
+	e.ID = id
+	e.CreatedAt = time.Now()
+
+	// 3. Apply here
+	if err := s.reload(ctx); err != nil {
+		logx.LogError(ctx, "ReputationSvc.AddEntry.Reload", err)
+	}
+	return e, nil
+}
+
+func (s *reputationService) RemoveEntry(ctx context.Context, actor Actor, id uint64, reason string) error {
+	if !hasReason(reason) {
+		return ErrBadRequest
+	}
+	if err := s.repo.DeleteEntry(ctx, id, actor.audit(auditReputationDel, 0, reason, fmt.Sprintf("id=%d", id))); err != nil {
+		return s.mapErr(ctx, "ReputationSvc.RemoveEntry", err)
+	}
+	if err := s.reload(ctx); err != nil {
+		logx.LogError(ctx, "ReputationSvc.RemoveEntry.Reload", err)
+	}
+	return nil
+}
+
+func (s *reputationService) mapErr(ctx context.Context, op string, err error) error {
+	if ctx_util.IsCtxDone(ctx, err) {
+		return ErrCtxError
+	}
+	if errors.Is(err, repos.ErrNotFound) {
+		return ErrNotFound
+	}
+	logx.LogError(ctx, op, err)
+	return ErrInternalServer
+}
+
+type reputationService struct {
+	repo   repos.ReputationRepo
+	static *reputation.Static
+	cfg    config.Reputation
+	events EventRecorder
+	lists  atomic.Pointer[reputation.Lists]
+}
+
+// NewReputationService Managed entries apply once Run has loaded them
+func NewReputationService(repo repos.ReputationRepo, static *reputation.Static, cfg config.Reputation, events EventRecorder) ReputationService {
+	s := &reputationService{repo: repo, static: static, cfg: cfg, events: events}
+	s.lists.Store(reputation.Compile(static, nil, time.Now()))
+	return s
+}
@@ -5,11 +5,16 @@ import "errors"
 var (
 	ErrBadRequest     = errors.New("bad request")
 	ErrUnauthorized   = errors.New("unauthorized")
+	ErrForbidden      = errors.New("forbidden")
 	ErrConflict       = errors.New("conflict")
+	ErrNotFound       = errors.New("not found")
 	ErrTooManyRequest = errors.New("too many requests")
 	ErrInternalServer = errors.New("internal server error")
 
 	ErrCtxError = errors.New("timeout")
 
+	// ErrChallengeRequired The request needs a solved challenge, and had none that passed
+	ErrChallengeRequired = errors.New("challenge required")
+
 	ErrCreateInternal = errors.New("internal server error")
 )
@@ -0,0 +1,218 @@
+package settings
+
+import (
+	"backend/internal/config"
+	"backend/internal/pkg/logx"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Settings Values that may change without a deploy
+type Settings struct {
+	Timeouts config.Timeouts
+	RedisTTL config.RedisTTL
+	LogLevel string
+}
+
+// Provider Read the settings in effect, never cache the returned pointer across requests
+type Provider interface {
+	Current() *Settings
+}
+
+// Overridable Env names the shared Redis hash may set
+var Overridable = map[string]struct{}{
+	"REQUEST_TIMEOUT":              {},
+	"REQUEST_CODE":                 {},
+	"VERIFY_CODE":                  {},
+	"CREATE_ACCOUNT":               {},
+	"LOGIN":                        {},
+	"SET_USERNAME":                 {},
+	"OTP_TTL":                      {},
+	"OTP_THROTTLE_TTL":             {},
+	"VERIFY_THROTTLE_WINDOW":       {},
+	"VERIFY_THROTTLE_WINDOW_LIMIT": {},
+	"LOG_LEVEL":                    {},
+}
+
+// FromConfig Extract runtime settings from a loaded config
+func FromConfig(cfg *config.Config) *Settings {
+	return &Settings{
+		Timeouts: cfg.Timeouts,
+		RedisTTL: cfg.RedisTTL,
+		LogLevel: cfg.Log.Level,
+	}
+}
+
+// Static Provider that never changes, for tools and tests
+type Static struct {
+	S *Settings
+}
+
+func (s Static) Current() *Settings {
+	return s.S
+}
+
+// Store Hold the current settings and swap them atomically on reload
+type Store struct {
+	cur      atomic.Pointer[Settings]
+	mu       sync.Mutex
+	loader   *config.Loader
+	rdb      *redis.Client
+	redisKey string
+	interval time.Duration
+
+	lastMod   map[string]time.Time
+	lastRedis map[string]string
+}
+
+// NewStore rdb may be nil, the Redis hash is only read when cfg.Runtime.RedisKey is set
+func NewStore(loader *config.Loader, cfg *config.Config, rdb *redis.Client) *Store {
+	s := &Store{
+		loader:   loader,
+		rdb:      rdb,
+		redisKey: cfg.Runtime.RedisKey,
+		interval: cfg.Runtime.ReloadInterval,
+		lastMod:  map[string]time.Time{},
+	}
+	s.swap(FromConfig(cfg))
+	s.changed()
+	return s
+}
+
+func (s *Store) Current() *Settings {
+	return s.cur.Load()
+}
+
+// Reload Rebuild settings from file, env, flags and the Redis hash; keep the old ones if invalid
+func (s *Store) Reload(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 1. Read Redis overrides
+	overrides, err := s.readRedis(ctx)
+	if err != nil {
+		return err
+	}
+	s.lastRedis = overrides
+
+	// 2. Rebuild and validate config
+	cfg, err := s.loader.ReloadWith(overrides)
+	if err != nil {
+		return fmt.Errorf("reload settings: %w", err)
+	}
+	next := FromConfig(cfg)
+
+	// 3. Swap
+	prev := s.Current()
+	if reflect.DeepEqual(prev, next) {
+		return nil
+	}
+	s.swap(next)
+	logx.LogInfo(ctx, "Settings.Reload", fmt.Sprintf("runtime settings changed: %+v", *next))
+	return nil
+}
+
+// Watch Reload on SIGHUP, config file change or Redis hash change until ctx is done
+func (s *Store) Watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			s.reloadAndLog(ctx, "SIGHUP")
+		case <-ticker.C:
+			if s.changed() {
+				s.reloadAndLog(ctx, "file change")
+				continue
+			}
+			if s.redisChanged(ctx) {
+				s.reloadAndLog(ctx, "redis change")
+			}
+		}
+	}
+}
+
+func (s *Store) reloadAndLog(ctx context.Context, reason string) {
+	if err := s.Reload(ctx); err != nil {
+		logx.LogError(ctx, "Settings.Reload("+reason+")", err)
+	}
+}
+
+func (s *Store) swap(next *Settings) {
+	s.cur.Store(next)
+	if lvl, err := logx.ParseLevel(next.LogLevel); err == nil {
+		logx.SetLevel(lvl)
+	}
+}
+
+// changed Report whether the config or env file mtime moved since the last call
+func (s *Store) changed() bool {
+	changed := false
+	for _, path := range []string{s.loader.File(), s.loader.EnvFile()} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if last, ok := s.lastMod[path]; ok && !info.ModTime().Equal(last) {
+			changed = true
+		}
+		s.lastMod[path] = info.ModTime()
+	}
+	return changed
+}
+
+func (s *Store) redisChanged(ctx context.Context) bool {
+	if s.rdb == nil || s.redisKey == "" {
+		return false
+	}
+	overrides, err := s.readRedis(ctx)
+	if err != nil {
+		logx.LogError(ctx, "Settings.ReadRedis", err)
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !reflect.DeepEqual(overrides, s.lastRedis)
+}
+
+// readRedis HGETALL the shared hash keeping only overridable fields
+func (s *Store) readRedis(ctx context.Context) (map[string]string, error) {
+	if s.rdb == nil || s.redisKey == "" {
+		return nil, nil
+	}
+	cctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	vals, err := s.rdb.HGetAll(cctx, s.redisKey).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("read runtime settings hash: %w", err)
+	}
+	out := make(map[string]string, len(vals))
+	for k, v := range vals {
+		if _, ok := Overridable[k]; !ok {
+			logx.LogWarn(ctx, "Settings.ReadRedis", fmt.Sprintf("ignoring non-runtime field %s in %s", k, s.redisKey))
+			continue
+		}
+		out[k] = v
+	}
+	return out, nil
+}